@@ -0,0 +1,131 @@
+package congestion
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+// BandwidthSample is a delivery rate sample computed when a packet is acked.
+type BandwidthSample struct {
+	Bandwidth Bandwidth
+	RTT       time.Duration
+}
+
+// bandwidthSamplerPacket is the state of the connection recorded when a packet is sent.
+type bandwidthSamplerPacket struct {
+	sentTime      time.Time
+	bytes         protocol.QuicByteCount
+	delivered     protocol.QuicByteCount // total bytes delivered when the packet was sent
+	deliveredTime time.Time              // time of the last delivery when the packet was sent
+	firstSentTime time.Time              // sent time of the last delivered packet when the packet was sent
+}
+
+// BandwidthSampler estimates the delivery rate of the connection from the ACKs (see draft-cheng-iccrg-delivery-rate-estimation).
+//
+// Each sample is the minimum of the send rate and of the ACK rate observed over the flight of the acked packet,
+// so that ACK compression does not overestimate the bandwidth.
+type BandwidthSampler struct {
+	delivered     protocol.QuicByteCount
+	deliveredTime time.Time
+	firstSentTime time.Time
+	packets       map[protocol.QuicPacketSequenceNumber]*bandwidthSamplerPacket
+}
+
+// NewBandwidthSampler is a BandwidthSampler factory.
+func NewBandwidthSampler() *BandwidthSampler {
+	return &BandwidthSampler{packets: make(map[protocol.QuicPacketSequenceNumber]*bandwidthSamplerPacket)}
+}
+
+// OnPacketSent records the state of the connection when the packet 'seqnum' is sent, 'bytesInFlight' is the number of bytes in flight before sending this packet.
+func (this *BandwidthSampler) OnPacketSent(sentTime time.Time, bytesInFlight protocol.QuicByteCount, seqnum protocol.QuicPacketSequenceNumber, bytes protocol.QuicByteCount) {
+	// Restart the sampling intervals when nothing is in flight
+	if bytesInFlight == 0 {
+		this.firstSentTime = sentTime
+		this.deliveredTime = sentTime
+	}
+	this.packets[seqnum] = &bandwidthSamplerPacket{
+		sentTime:      sentTime,
+		bytes:         bytes,
+		delivered:     this.delivered,
+		deliveredTime: this.deliveredTime,
+		firstSentTime: this.firstSentTime}
+}
+
+// OnPacketAcked returns the delivery rate sample of the acked packet 'seqnum', the sample is empty if the packet is unknown.
+func (this *BandwidthSampler) OnPacketAcked(ackTime time.Time, seqnum protocol.QuicPacketSequenceNumber) (sample BandwidthSample) {
+	p, ok := this.packets[seqnum]
+	if !ok {
+		return
+	}
+	delete(this.packets, seqnum)
+	this.delivered += p.bytes
+	this.deliveredTime = ackTime
+	this.firstSentTime = p.sentTime
+
+	sample.RTT = ackTime.Sub(p.sentTime)
+	sendElapsed := p.sentTime.Sub(p.firstSentTime)
+	ackElapsed := ackTime.Sub(p.deliveredTime)
+	interval := sendElapsed
+	if ackElapsed > interval {
+		interval = ackElapsed
+	}
+	sample.Bandwidth = BandwidthFromDelta(this.delivered-p.delivered, interval)
+	return
+}
+
+// OnPacketLost forgets the lost packet 'seqnum'.
+func (this *BandwidthSampler) OnPacketLost(seqnum protocol.QuicPacketSequenceNumber) {
+	delete(this.packets, seqnum)
+}
+
+// OnPacketNeutered forgets the packet 'seqnum' that left the bytes in flight without being acknowledged or lost.
+func (this *BandwidthSampler) OnPacketNeutered(seqnum protocol.QuicPacketSequenceNumber) {
+	delete(this.packets, seqnum)
+}
+
+// OnRetransmissionTimeout forgets all the packets in flight: they are dropped on a retransmission timeout without being acknowledged or lost.
+func (this *BandwidthSampler) OnRetransmissionTimeout() {
+	this.packets = make(map[protocol.QuicPacketSequenceNumber]*bandwidthSamplerPacket)
+}
+
+// GetReceiveRate returns the rate at which the peer received the packets of an inter-arrival CONGESTION_FEEDBACK frame, or zero if it is unknown.
+//
+// Only the packets not yet acked are known by the sampler. As for the delivery rate samples, the receive rate is limited by the send rate of the same packets,
+// and the bytes of the first packet received (or sent) are not counted.
+func (this *BandwidthSampler) GetReceiveRate(packets []protocol.QuicReceivedPacket) Bandwidth {
+	var bytes, firstReceivedBytes, firstSentBytes protocol.QuicByteCount
+	var firstReceived, lastReceived, firstSent, lastSent time.Time
+
+	n := 0
+	for _, r := range packets {
+		p, ok := this.packets[r.SequenceNumber]
+		if !ok {
+			continue
+		}
+		bytes += p.bytes
+		if (n == 0) || r.ReceiveTime.Before(firstReceived) {
+			firstReceived, firstReceivedBytes = r.ReceiveTime, p.bytes
+		}
+		if (n == 0) || r.ReceiveTime.After(lastReceived) {
+			lastReceived = r.ReceiveTime
+		}
+		if (n == 0) || p.sentTime.Before(firstSent) {
+			firstSent, firstSentBytes = p.sentTime, p.bytes
+		}
+		if (n == 0) || p.sentTime.After(lastSent) {
+			lastSent = p.sentTime
+		}
+		n++
+	}
+	if n < 2 {
+		return 0
+	}
+	receiveRate := BandwidthFromDelta(bytes-firstReceivedBytes, lastReceived.Sub(firstReceived))
+	if sendRate := BandwidthFromDelta(bytes-firstSentBytes, lastSent.Sub(firstSent)); sendRate < receiveRate {
+		return sendRate
+	}
+	return receiveRate
+}
+
+// GetTotalBytesAcked returns the total number of bytes delivered.
+func (this *BandwidthSampler) GetTotalBytesAcked() protocol.QuicByteCount {
+	return this.delivered
+}
@@ -0,0 +1,472 @@
+package congestion
+
+import "math/rand"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+// BbrMode is the state of the BBR state machine.
+type BbrMode int
+
+const (
+	// BBR_STARTUP is the exponential growth phase used to find the bottleneck bandwidth
+	BBR_STARTUP BbrMode = iota
+	// BBR_DRAIN drains the queue created during BBR_STARTUP
+	BBR_DRAIN
+	// BBR_PROBE_BW is the steady state: the pacing gain cycles to probe for more bandwidth and to drain the resulting queue
+	BBR_PROBE_BW
+	// BBR_PROBE_RTT reduces the bytes in flight to measure the round trip propagation time again
+	BBR_PROBE_RTT
+)
+
+// bbrRecoveryState is the loss recovery state of BBR.
+type bbrRecoveryState int
+
+const (
+	bbrNotInRecovery bbrRecoveryState = iota
+	bbrConservation                   // packet conservation during the first round trip of recovery
+	bbrGrowth                         // the recovery window grows as in slow start
+)
+
+const (
+	// bbrHighGain is the pacing and congestion window gain of BBR_STARTUP: 2/ln(2)
+	bbrHighGain = 2.885
+	// bbrDrainGain is the pacing gain of BBR_DRAIN
+	bbrDrainGain = 1 / bbrHighGain
+	// bbrCongestionWindowGain is the congestion window gain of BBR_PROBE_BW
+	bbrCongestionWindowGain = 2.0
+	// bbrBandwidthWindowRounds is the length in round trips of the bottleneck bandwidth max filter
+	bbrBandwidthWindowRounds = 10
+	// bbrMinRTTExpiry is the validity of the min RTT estimate, BBR_PROBE_RTT is entered when it expires
+	bbrMinRTTExpiry = 10 * time.Second
+	// bbrProbeRTTTime is the minimum duration of BBR_PROBE_RTT
+	bbrProbeRTTTime = 200 * time.Millisecond
+	// bbrStartupGrowthTarget is the bandwidth growth per round trip below which the pipe is considered full
+	bbrStartupGrowthTarget = 1.25
+	// bbrStartupFullBandwidthRounds is the number of round trips without growth needed to exit BBR_STARTUP
+	bbrStartupFullBandwidthRounds = 3
+	// bbrMinCongestionWindow is the minimum congestion window in packets, also used in BBR_PROBE_RTT
+	bbrMinCongestionWindow = 4
+)
+
+// bbrPacingGainCycle is the pacing gain cycle of BBR_PROBE_BW, each phase lasts one min RTT.
+var bbrPacingGainCycle = [...]float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// maxBandwidthFilter is a windowed max filter of the bandwidth samples over the last bbrBandwidthWindowRounds round trips.
+type maxBandwidthFilter struct {
+	round   uint64
+	samples [bbrBandwidthWindowRounds]struct {
+		bandwidth Bandwidth
+		round     uint64
+	}
+}
+
+// Update adds a bandwidth sample measured during the round trip 'round'.
+func (this *maxBandwidthFilter) Update(bandwidth Bandwidth, round uint64) {
+	s := &this.samples[round%bbrBandwidthWindowRounds]
+	if (s.round != round) || (bandwidth > s.bandwidth) {
+		s.bandwidth = bandwidth
+		s.round = round
+	}
+	if round > this.round {
+		this.round = round
+	}
+}
+
+// Get returns the maximum bandwidth of the window.
+func (this *maxBandwidthFilter) Get() (bandwidth Bandwidth) {
+	for _, s := range this.samples {
+		if (s.round+bbrBandwidthWindowRounds > this.round) && (s.bandwidth > bandwidth) {
+			bandwidth = s.bandwidth
+		}
+	}
+	return
+}
+
+// BbrSender is a model-based congestion controller (Bottleneck Bandwidth and Round-trip propagation time):
+// it estimates the bottleneck bandwidth from the delivery rate of the ACKs and the round trip propagation time from the min RTT,
+// and derives from this model both the pacing rate and the congestion window. Random losses do not reduce the model.
+type BbrSender struct {
+	rttStats                *RTTStats
+	sampler                 *BandwidthSampler
+	maxBandwidth            maxBandwidthFilter
+	rand                    *rand.Rand
+	mode                    BbrMode
+	roundTripCount          uint64
+	currentRoundTripEnd     protocol.QuicPacketSequenceNumber
+	largestSentSeqNum       protocol.QuicPacketSequenceNumber
+	largestAckedSeqNum      protocol.QuicPacketSequenceNumber
+	minRTT                  time.Duration
+	minRTTTimestamp         time.Time
+	pacingGain              float64
+	congestionWindowGain    float64
+	pacingRate              Bandwidth
+	cycleIndex              int
+	cycleStart              time.Time
+	fullBandwidthReached    bool
+	fullBandwidth           Bandwidth
+	roundsWithoutGrowth     int
+	probeRTTDoneTime        time.Time
+	probeRTTRoundPassed     bool
+	congestionWindow        protocol.QuicByteCount
+	initialCongestionWindow protocol.QuicByteCount
+	minCongestionWindow     protocol.QuicByteCount
+	maxCongestionWindow     protocol.QuicByteCount
+	recoveryState           bbrRecoveryState
+	recoveryWindow          protocol.QuicByteCount
+	endRecoveryAt           protocol.QuicPacketSequenceNumber
+	receiveWindow           ReceiveWindow
+}
+
+// NewBbrSender is a BbrSender factory, 'initialCongestionWindow' and 'maxCongestionWindow' are expressed in packets.
+func NewBbrSender(rttStats *RTTStats, initialCongestionWindow, maxCongestionWindow int) *BbrSender {
+	return &BbrSender{
+		rttStats:                rttStats,
+		sampler:                 NewBandwidthSampler(),
+		rand:                    rand.New(rand.NewSource(time.Now().UnixNano())),
+		mode:                    BBR_STARTUP,
+		pacingGain:              bbrHighGain,
+		congestionWindowGain:    bbrHighGain,
+		congestionWindow:        protocol.QuicByteCount(initialCongestionWindow) * MaxSegmentSize,
+		initialCongestionWindow: protocol.QuicByteCount(initialCongestionWindow) * MaxSegmentSize,
+		minCongestionWindow:     bbrMinCongestionWindow * MaxSegmentSize,
+		maxCongestionWindow:     protocol.QuicByteCount(maxCongestionWindow) * MaxSegmentSize}
+}
+
+// GetMode returns the current state of the BBR state machine.
+func (this *BbrSender) GetMode() BbrMode {
+	return this.mode
+}
+
+// GetBandwidthEstimate returns the estimated bottleneck bandwidth.
+func (this *BbrSender) GetBandwidthEstimate() Bandwidth {
+	return this.maxBandwidth.Get()
+}
+
+// GetMinRTT returns the estimated round trip propagation time.
+func (this *BbrSender) GetMinRTT() time.Duration {
+	if this.minRTT == 0 {
+		return this.rttStats.GetMinRTT()
+	}
+	return this.minRTT
+}
+
+// OnPacketSent
+func (this *BbrSender) OnPacketSent(sentTime time.Time, bytesInFlight protocol.QuicByteCount, seqnum protocol.QuicPacketSequenceNumber, bytes protocol.QuicByteCount, retransmittable bool) {
+	if !retransmittable {
+		return
+	}
+	this.largestSentSeqNum = seqnum
+	this.sampler.OnPacketSent(sentTime, bytesInFlight, seqnum, bytes)
+}
+
+// OnCongestionEvent
+func (this *BbrSender) OnCongestionEvent(eventTime time.Time, priorInFlight protocol.QuicByteCount, ackedPackets, lostPackets []PacketInfo) {
+	var bytesAcked, bytesLost protocol.QuicByteCount
+
+	isRoundStart := false
+	minRTTExpired := false
+	for _, p := range lostPackets {
+		this.sampler.OnPacketLost(p.SequenceNumber)
+		bytesLost += p.Bytes
+	}
+	for _, p := range ackedPackets {
+		if p.SequenceNumber > this.largestAckedSeqNum {
+			this.largestAckedSeqNum = p.SequenceNumber
+		}
+		sample := this.sampler.OnPacketAcked(eventTime, p.SequenceNumber)
+		bytesAcked += p.Bytes
+		if p.SequenceNumber > this.currentRoundTripEnd {
+			this.roundTripCount++
+			this.currentRoundTripEnd = this.largestSentSeqNum
+			isRoundStart = true
+		}
+		if sample.Bandwidth > 0 {
+			this.maxBandwidth.Update(sample.Bandwidth, this.roundTripCount)
+		}
+		if sample.RTT > 0 {
+			minRTTExpired = this.updateMinRTT(eventTime, sample.RTT) || minRTTExpired
+		}
+	}
+	bytesInFlight := protocol.QuicByteCount(0)
+	if priorInFlight > bytesAcked+bytesLost {
+		bytesInFlight = priorInFlight - bytesAcked - bytesLost
+	}
+	this.updateRecoveryState(len(lostPackets) > 0, isRoundStart)
+	if this.mode == BBR_PROBE_BW {
+		this.updateGainCyclePhase(eventTime, priorInFlight, len(lostPackets) > 0)
+	}
+	if isRoundStart && !this.fullBandwidthReached {
+		this.checkIfFullBandwidthReached()
+	}
+	this.maybeExitStartupOrDrain(eventTime, bytesInFlight)
+	this.maybeEnterOrExitProbeRTT(eventTime, isRoundStart, minRTTExpired, bytesInFlight)
+	this.calculatePacingRate()
+	this.calculateCongestionWindow(bytesAcked)
+	this.calculateRecoveryWindow(bytesAcked, bytesLost, bytesInFlight)
+}
+
+// updateMinRTT updates the min RTT filter and returns true if the previous min RTT estimate has expired.
+func (this *BbrSender) updateMinRTT(eventTime time.Time, rtt time.Duration) bool {
+	expired := (this.minRTT != 0) && (eventTime.Sub(this.minRTTTimestamp) > bbrMinRTTExpiry)
+	if expired || (this.minRTT == 0) || (rtt < this.minRTT) {
+		this.minRTT = rtt
+		this.minRTTTimestamp = eventTime
+	}
+	return expired
+}
+
+// getTargetCongestionWindow returns 'gain' times the estimated bandwidth-delay product.
+func (this *BbrSender) getTargetCongestionWindow(gain float64) protocol.QuicByteCount {
+	bw := this.maxBandwidth.Get()
+	if (bw == 0) || (this.minRTT == 0) {
+		return protocol.QuicByteCount(gain * float64(this.initialCongestionWindow))
+	}
+	bdp := float64(bw) / float64(BytesPerSecond) * this.minRTT.Seconds()
+	cwnd := protocol.QuicByteCount(gain * bdp)
+	if cwnd < this.minCongestionWindow {
+		cwnd = this.minCongestionWindow
+	}
+	return cwnd
+}
+
+// updateRecoveryState enters recovery on the first loss, and exits when a packet sent after the last loss is acked.
+func (this *BbrSender) updateRecoveryState(hasLosses, isRoundStart bool) {
+	if hasLosses {
+		this.endRecoveryAt = this.largestSentSeqNum
+	}
+	switch this.recoveryState {
+	case bbrNotInRecovery:
+		if hasLosses {
+			this.recoveryState = bbrConservation
+			this.recoveryWindow = 0
+			// The conservation phase lasts a whole round trip from now
+			this.currentRoundTripEnd = this.largestSentSeqNum
+		}
+	case bbrConservation, bbrGrowth:
+		if (this.recoveryState == bbrConservation) && isRoundStart {
+			this.recoveryState = bbrGrowth
+		}
+		if !hasLosses && (this.largestAckedSeqNum > this.endRecoveryAt) {
+			this.recoveryState = bbrNotInRecovery
+		}
+	}
+}
+
+// updateGainCyclePhase advances the pacing gain cycle of BBR_PROBE_BW.
+func (this *BbrSender) updateGainCyclePhase(eventTime time.Time, priorInFlight protocol.QuicByteCount, hasLosses bool) {
+	// Each phase lasts at least one min RTT
+	shouldAdvance := eventTime.Sub(this.cycleStart) > this.GetMinRTT()
+	// The probing phase lasts until the bytes in flight reach pacing_gain * BDP, or until losses are detected
+	if (this.pacingGain > 1) && !hasLosses && (priorInFlight < this.getTargetCongestionWindow(this.pacingGain)) {
+		shouldAdvance = false
+	}
+	// The draining phase ends as soon as the queue is drained
+	if (this.pacingGain < 1) && (priorInFlight <= this.getTargetCongestionWindow(1)) {
+		shouldAdvance = true
+	}
+	if shouldAdvance {
+		this.cycleIndex = (this.cycleIndex + 1) % len(bbrPacingGainCycle)
+		this.cycleStart = eventTime
+		this.pacingGain = bbrPacingGainCycle[this.cycleIndex]
+	}
+}
+
+// checkIfFullBandwidthReached detects that the pipe is full when the bandwidth estimate did not grow by 25% during 3 round trips.
+func (this *BbrSender) checkIfFullBandwidthReached() {
+	bw := this.maxBandwidth.Get()
+	if float64(bw) >= float64(this.fullBandwidth)*bbrStartupGrowthTarget {
+		this.fullBandwidth = bw
+		this.roundsWithoutGrowth = 0
+		return
+	}
+	this.roundsWithoutGrowth++
+	if this.roundsWithoutGrowth >= bbrStartupFullBandwidthRounds {
+		this.fullBandwidthReached = true
+	}
+}
+
+// maybeExitStartupOrDrain
+func (this *BbrSender) maybeExitStartupOrDrain(eventTime time.Time, bytesInFlight protocol.QuicByteCount) {
+	if (this.mode == BBR_STARTUP) && this.fullBandwidthReached {
+		this.mode = BBR_DRAIN
+		this.pacingGain = bbrDrainGain
+		this.congestionWindowGain = bbrHighGain
+	}
+	if (this.mode == BBR_DRAIN) && (bytesInFlight <= this.getTargetCongestionWindow(1)) {
+		this.enterProbeBandwidthMode(eventTime)
+	}
+}
+
+// enterProbeBandwidthMode starts the pacing gain cycle at a random phase, except the draining phase.
+func (this *BbrSender) enterProbeBandwidthMode(eventTime time.Time) {
+	this.mode = BBR_PROBE_BW
+	this.congestionWindowGain = bbrCongestionWindowGain
+	this.cycleIndex = this.rand.Intn(len(bbrPacingGainCycle) - 1)
+	if this.cycleIndex >= 1 {
+		this.cycleIndex++
+	}
+	this.cycleStart = eventTime
+	this.pacingGain = bbrPacingGainCycle[this.cycleIndex]
+}
+
+// maybeEnterOrExitProbeRTT enters BBR_PROBE_RTT when the min RTT estimate has expired,
+// and exits after bbrProbeRTTTime and one round trip with at most bbrMinCongestionWindow packets in flight.
+func (this *BbrSender) maybeEnterOrExitProbeRTT(eventTime time.Time, isRoundStart, minRTTExpired bool, bytesInFlight protocol.QuicByteCount) {
+	if minRTTExpired && (this.mode != BBR_PROBE_RTT) {
+		this.mode = BBR_PROBE_RTT
+		this.pacingGain = 1
+		this.probeRTTDoneTime = time.Time{}
+	}
+	if this.mode != BBR_PROBE_RTT {
+		return
+	}
+	if this.probeRTTDoneTime.IsZero() {
+		if bytesInFlight < this.minCongestionWindow+MaxSegmentSize {
+			this.probeRTTDoneTime = eventTime.Add(bbrProbeRTTTime)
+			this.probeRTTRoundPassed = false
+			this.currentRoundTripEnd = this.largestSentSeqNum
+		}
+		return
+	}
+	if isRoundStart {
+		this.probeRTTRoundPassed = true
+	}
+	if !eventTime.Before(this.probeRTTDoneTime) && this.probeRTTRoundPassed {
+		this.minRTTTimestamp = eventTime
+		if !this.fullBandwidthReached {
+			this.mode = BBR_STARTUP
+			this.pacingGain = bbrHighGain
+			this.congestionWindowGain = bbrHighGain
+		} else {
+			this.enterProbeBandwidthMode(eventTime)
+		}
+	}
+}
+
+// calculatePacingRate sets the pacing rate to pacing_gain * bandwidth, the pacing rate never decreases before the pipe is full.
+func (this *BbrSender) calculatePacingRate() {
+	bw := this.maxBandwidth.Get()
+	if bw == 0 {
+		return
+	}
+	target := Bandwidth(this.pacingGain * float64(bw))
+	if this.fullBandwidthReached || (target > this.pacingRate) {
+		this.pacingRate = target
+	}
+}
+
+// calculateCongestionWindow sets the congestion window to cwnd_gain * BDP, plus an allowance for ACK aggregation.
+func (this *BbrSender) calculateCongestionWindow(bytesAcked protocol.QuicByteCount) {
+	if this.mode == BBR_PROBE_RTT {
+		return
+	}
+	target := this.getTargetCongestionWindow(this.congestionWindowGain) + 3*MaxSegmentSize
+	if this.fullBandwidthReached {
+		if this.congestionWindow+bytesAcked < target {
+			target = this.congestionWindow + bytesAcked
+		}
+		this.congestionWindow = target
+	} else if (this.congestionWindow < target) || (this.sampler.GetTotalBytesAcked() < this.initialCongestionWindow) {
+		this.congestionWindow += bytesAcked
+	}
+	if this.congestionWindow < this.minCongestionWindow {
+		this.congestionWindow = this.minCongestionWindow
+	}
+	if this.congestionWindow > this.maxCongestionWindow {
+		this.congestionWindow = this.maxCongestionWindow
+	}
+}
+
+// calculateRecoveryWindow applies packet conservation during the first round trip of recovery, and then grows the recovery window as in slow start.
+func (this *BbrSender) calculateRecoveryWindow(bytesAcked, bytesLost, bytesInFlight protocol.QuicByteCount) {
+	if this.recoveryState == bbrNotInRecovery {
+		return
+	}
+	if this.recoveryWindow == 0 {
+		this.recoveryWindow = bytesInFlight + bytesAcked
+		if this.recoveryWindow < this.minCongestionWindow {
+			this.recoveryWindow = this.minCongestionWindow
+		}
+		return
+	}
+	if this.recoveryWindow >= bytesLost {
+		this.recoveryWindow -= bytesLost
+	} else {
+		this.recoveryWindow = MaxSegmentSize
+	}
+	if this.recoveryState == bbrGrowth {
+		this.recoveryWindow += bytesAcked
+	}
+	if (this.recoveryState == bbrConservation) && (this.recoveryWindow < bytesInFlight+bytesAcked) {
+		this.recoveryWindow = bytesInFlight + bytesAcked
+	}
+	if this.recoveryWindow < this.minCongestionWindow {
+		this.recoveryWindow = this.minCongestionWindow
+	}
+}
+
+// OnPacketNeutered forgets the packet in the bandwidth sampler.
+func (this *BbrSender) OnPacketNeutered(seqnum protocol.QuicPacketSequenceNumber) {
+	this.sampler.OnPacketNeutered(seqnum)
+}
+
+// OnRetransmissionTimeout forgets the packets in flight in the bandwidth sampler and exits recovery,
+// the next round trip starts with the next packet sent. The BBR model is not changed.
+func (this *BbrSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	this.sampler.OnRetransmissionTimeout()
+	this.currentRoundTripEnd = this.largestSentSeqNum
+	this.recoveryState = bbrNotInRecovery
+	this.recoveryWindow = 0
+	this.endRecoveryAt = this.largestSentSeqNum
+}
+
+// OnIncomingCongestionFeedback limits the bytes in flight to the receive window of the TCP feedback,
+// and adds the receive rate of the packets of the inter-arrival feedback to the bandwidth samples.
+// The feedback must be given before the ACK frame of its packets, while the bandwidth sampler still tracks them.
+func (this *BbrSender) OnIncomingCongestionFeedback(frame *protocol.QuicFrame, feedbackTime time.Time) {
+	this.receiveWindow.OnCongestionFeedback(frame)
+	if frame.GetCongestionFeedbackType() == protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL {
+		if bandwidth := this.sampler.GetReceiveRate(frame.GetReceivedPackets()); bandwidth > 0 {
+			this.maxBandwidth.Update(bandwidth, this.roundTripCount)
+		}
+	}
+}
+
+// CanSend
+func (this *BbrSender) CanSend(bytesInFlight protocol.QuicByteCount) bool {
+	return (bytesInFlight < this.GetCongestionWindow()) && this.receiveWindow.CanSend(bytesInFlight)
+}
+
+// GetCongestionWindow
+func (this *BbrSender) GetCongestionWindow() protocol.QuicByteCount {
+	if this.mode == BBR_PROBE_RTT {
+		return this.minCongestionWindow
+	}
+	if (this.recoveryState != bbrNotInRecovery) && (this.recoveryWindow < this.congestionWindow) {
+		return this.recoveryWindow
+	}
+	return this.congestionWindow
+}
+
+// GetSlowStartThreshold returns 0, BBR does not use a slow start threshold.
+func (this *BbrSender) GetSlowStartThreshold() protocol.QuicByteCount {
+	return 0
+}
+
+// GetPacingRate returns pacing_gain * bandwidth, or high_gain * initial_cwnd / RTT before the first bandwidth sample.
+func (this *BbrSender) GetPacingRate() Bandwidth {
+	if this.pacingRate == 0 {
+		return Bandwidth(bbrHighGain * float64(BandwidthFromDelta(this.initialCongestionWindow, this.rttStats.GetSmoothedRTT())))
+	}
+	return this.pacingRate
+}
+
+// InSlowStart returns true in BBR_STARTUP.
+func (this *BbrSender) InSlowStart() bool {
+	return this.mode == BBR_STARTUP
+}
+
+// InRecovery
+func (this *BbrSender) InRecovery() bool {
+	return this.recoveryState != bbrNotInRecovery
+}
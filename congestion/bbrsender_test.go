@@ -0,0 +1,313 @@
+package congestion
+
+import "math/rand"
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+// testlossypath emulates a network path with a FIFO bottleneck queue (tail drop), a propagation delay and random losses.
+type testlossypath struct {
+	bandwidth  Bandwidth
+	rtt        time.Duration
+	lossRate   float64
+	bufferSize protocol.QuicByteCount
+	rand       *rand.Rand
+	linkFreeAt time.Time
+}
+
+// testpathpacket is a packet sent on a testlossypath.
+type testpathpacket struct {
+	seqnum   protocol.QuicPacketSequenceNumber
+	sentTime time.Time
+	ackTime  time.Time
+	dropped  bool
+}
+
+// testpathresult is the result of a transfer on a testlossypath.
+type testpathresult struct {
+	bytesAcked protocol.QuicByteCount
+	bytesLost  protocol.QuicByteCount
+	modes      map[BbrMode]bool
+}
+
+// send returns the ACK time of the packet, or dropped if the packet is lost in the bottleneck queue or randomly on the path.
+func (this *testlossypath) send(now time.Time, seqnum protocol.QuicPacketSequenceNumber, bytes protocol.QuicByteCount) (p testpathpacket) {
+	p.seqnum = seqnum
+	p.sentTime = now
+	start := now
+	if this.linkFreeAt.After(now) {
+		start = this.linkFreeAt
+	}
+	if start.Sub(now) > this.bandwidth.TransferTime(this.bufferSize) {
+		p.dropped = true
+		return
+	}
+	this.linkFreeAt = start.Add(this.bandwidth.TransferTime(bytes))
+	if this.rand.Float64() < this.lossRate {
+		p.dropped = true
+		return
+	}
+	p.ackTime = this.linkFreeAt.Add(this.rtt)
+	return
+}
+
+// run transfers data with the 'sender' congestion controller during 'duration', with pacing and a 3 packets reordering threshold for loss detection.
+func (this *testlossypath) run(sender SendAlgorithm, rttStats *RTTStats, duration time.Duration) (result testpathresult) {
+	var outstanding []testpathpacket
+	var bytesInFlight protocol.QuicByteCount
+
+	result.modes = make(map[BbrMode]bool)
+	now := time.Unix(0, 0)
+	end := now.Add(duration)
+	this.linkFreeAt = now
+	nextSend := now
+	seqnum := protocol.QuicPacketSequenceNumber(1)
+	for now.Before(end) {
+		// Send as many packets as allowed by the congestion window and the pacing rate
+		for sender.CanSend(bytesInFlight) && !now.Before(nextSend) {
+			sender.OnPacketSent(now, bytesInFlight, seqnum, MaxSegmentSize, true)
+			outstanding = append(outstanding, this.send(now, seqnum, MaxSegmentSize))
+			bytesInFlight += MaxSegmentSize
+			seqnum++
+			nextSend = now.Add(sender.GetPacingRate().TransferTime(MaxSegmentSize))
+		}
+		// Next event
+		next := end
+		pendingAck := false
+		for _, p := range outstanding {
+			if !p.dropped {
+				pendingAck = true
+				if p.ackTime.Before(next) {
+					next = p.ackTime
+				}
+				break
+			}
+		}
+		if sender.CanSend(bytesInFlight) && nextSend.Before(next) {
+			next = nextSend
+		}
+		if !pendingAck && !sender.CanSend(bytesInFlight) {
+			// Retransmission timeout: all outstanding packets are lost
+			now = now.Add(4 * this.rtt)
+			lost := make([]PacketInfo, 0, len(outstanding))
+			for _, p := range outstanding {
+				lost = append(lost, PacketInfo{p.seqnum, MaxSegmentSize})
+			}
+			sender.OnCongestionEvent(now, bytesInFlight, nil, lost)
+			sender.OnRetransmissionTimeout(true)
+			result.bytesLost += bytesInFlight
+			bytesInFlight = 0
+			outstanding = outstanding[:0]
+			continue
+		}
+		now = next
+		// Process the ACKs received
+		for len(outstanding) > 0 {
+			i := 0
+			for (i < len(outstanding)) && outstanding[i].dropped {
+				i++
+			}
+			if (i == len(outstanding)) || outstanding[i].ackTime.After(now) {
+				break
+			}
+			acked := outstanding[i]
+			var lost []PacketInfo
+			remaining := outstanding[:0]
+			for j, p := range outstanding {
+				switch {
+				case j == i:
+				case p.dropped && (p.seqnum+3 <= acked.seqnum):
+					lost = append(lost, PacketInfo{p.seqnum, MaxSegmentSize})
+				default:
+					remaining = append(remaining, p)
+				}
+			}
+			outstanding = remaining
+			rttStats.UpdateRTT(now.Sub(acked.sentTime), 0)
+			sender.OnCongestionEvent(now, bytesInFlight, []PacketInfo{{acked.seqnum, MaxSegmentSize}}, lost)
+			bytesInFlight -= protocol.QuicByteCount(1+len(lost)) * MaxSegmentSize
+			result.bytesAcked += MaxSegmentSize
+			result.bytesLost += protocol.QuicByteCount(len(lost)) * MaxSegmentSize
+			if bbr, ok := sender.(*BbrSender); ok {
+				result.modes[bbr.GetMode()] = true
+			}
+		}
+	}
+	return
+}
+
+func Test_BbrSender_LossyPath(t *testing.T) {
+	const duration = 25 * time.Second
+
+	// 10 Mbps mobile link with 40 ms of propagation delay, 1% of random losses and a shallow buffer of one bandwidth-delay product
+	newPath := func() *testlossypath {
+		return &testlossypath{
+			bandwidth:  10 * 1000 * 1000 * BitsPerSecond,
+			rtt:        40 * time.Millisecond,
+			lossRate:   0.01,
+			bufferSize: 50000,
+			rand:       rand.New(rand.NewSource(1))}
+	}
+	rttStats := NewRTTStats()
+	bbr := NewBbrSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+	bbr.rand = rand.New(rand.NewSource(1))
+	path := newPath()
+	result := path.run(bbr, rttStats, duration)
+
+	// The model must match the path
+	if bw := bbr.GetBandwidthEstimate(); (bw < path.bandwidth*8/10) || (bw > path.bandwidth*12/10) {
+		t.Errorf("BbrSender : invalid bandwidth estimate %v (%v expected)", bw, path.bandwidth)
+	}
+	if rtt := bbr.GetMinRTT(); (rtt < path.rtt) || (rtt > path.rtt+5*time.Millisecond) {
+		t.Errorf("BbrSender : invalid min RTT estimate %v (%v expected)", rtt, path.rtt)
+	}
+	// All states must be visited: the min RTT estimate expires after 10 seconds
+	for _, mode := range []BbrMode{BBR_STARTUP, BBR_DRAIN, BBR_PROBE_BW, BBR_PROBE_RTT} {
+		if !result.modes[mode] {
+			t.Errorf("BbrSender : state %v never visited", mode)
+		}
+	}
+	// Random losses must not reduce the throughput
+	bbrGoodput := BandwidthFromDelta(result.bytesAcked, duration)
+	if bbrGoodput < path.bandwidth*8/10 {
+		t.Errorf("BbrSender : goodput %v too low on a lossy path of %v", bbrGoodput, path.bandwidth)
+	}
+	if pacing := bbr.GetPacingRate(); (pacing < path.bandwidth*7/10) || (pacing > path.bandwidth*13/10) {
+		t.Errorf("BbrSender : invalid pacing rate %v", pacing)
+	}
+	// Loss-based CUBIC underperforms on the same path
+	rttStats = NewRTTStats()
+	result = newPath().run(NewCubicSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow), rttStats, duration)
+	if cubicGoodput := BandwidthFromDelta(result.bytesAcked, duration); cubicGoodput >= bbrGoodput {
+		t.Errorf("BbrSender : goodput %v must be higher than CUBIC goodput %v on a lossy path", bbrGoodput, cubicGoodput)
+	}
+}
+
+func Test_BbrSender_ProbeRTT(t *testing.T) {
+	now := time.Unix(0, 0)
+	rttStats := NewRTTStats()
+	bbr := NewBbrSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+	bbr.fullBandwidthReached = true
+
+	// Steady flow of 10 packets per round trip of 100ms
+	seqnum := protocol.QuicPacketSequenceNumber(1)
+	for i := 0; i < 10; i++ {
+		bbr.OnPacketSent(now, protocol.QuicByteCount(i)*MaxSegmentSize, seqnum, MaxSegmentSize, true)
+		seqnum++
+	}
+	acked := protocol.QuicPacketSequenceNumber(1)
+	for elapsed := time.Duration(0); elapsed <= bbrMinRTTExpiry+time.Second; elapsed += 10 * time.Millisecond {
+		now = now.Add(10 * time.Millisecond)
+		bbr.OnCongestionEvent(now, 10*MaxSegmentSize, []PacketInfo{{acked, MaxSegmentSize}}, nil)
+		acked++
+		bbr.OnPacketSent(now, 9*MaxSegmentSize, seqnum, MaxSegmentSize, true)
+		seqnum++
+		if bbr.GetMode() == BBR_PROBE_RTT {
+			break
+		}
+	}
+	if bbr.GetMode() != BBR_PROBE_RTT {
+		t.Fatal("BbrSender : must enter PROBE_RTT when the min RTT estimate expires")
+	}
+	if bbr.GetCongestionWindow() != bbrMinCongestionWindow*MaxSegmentSize {
+		t.Errorf("BbrSender : invalid congestion window %v in PROBE_RTT", bbr.GetCongestionWindow())
+	}
+	// Drain the bytes in flight and stay at least 200ms and one round trip in PROBE_RTT
+	inflight := protocol.QuicByteCount(10 * MaxSegmentSize)
+	start := now
+	for bbr.GetMode() == BBR_PROBE_RTT {
+		if now.Sub(start) > time.Second {
+			t.Fatal("BbrSender : must exit PROBE_RTT")
+		}
+		now = now.Add(10 * time.Millisecond)
+		bbr.OnCongestionEvent(now, inflight, []PacketInfo{{acked, MaxSegmentSize}}, nil)
+		acked++
+		inflight -= MaxSegmentSize
+		if bbr.CanSend(inflight) {
+			bbr.OnPacketSent(now, inflight, seqnum, MaxSegmentSize, true)
+			seqnum++
+			inflight += MaxSegmentSize
+		}
+	}
+	if now.Sub(start) < bbrProbeRTTTime {
+		t.Errorf("BbrSender : PROBE_RTT duration %v is shorter than %v", now.Sub(start), bbrProbeRTTTime)
+	}
+	if bbr.GetMode() != BBR_PROBE_BW {
+		t.Errorf("BbrSender : invalid state %v after PROBE_RTT", bbr.GetMode())
+	}
+}
+
+func Test_BbrSender_OnIncomingCongestionFeedback(t *testing.T) {
+	now := time.Unix(0, 0)
+	bbr := NewBbrSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+
+	// 10 packets sent every 10ms and received every 20ms: the receive rate is the bandwidth sample
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_CONGESTION_FEEDBACK)
+	frame.SetCongestionFeedbackType(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL)
+	for i := 0; i < 10; i++ {
+		seqnum := protocol.QuicPacketSequenceNumber(i + 1)
+		bbr.OnPacketSent(now.Add(time.Duration(i)*10*time.Millisecond), protocol.QuicByteCount(i)*MaxSegmentSize, seqnum, MaxSegmentSize, true)
+		frame.AddReceivedPacket(seqnum, now.Add(50*time.Millisecond+time.Duration(i)*20*time.Millisecond))
+	}
+	// A packet unknown to the sender is ignored
+	frame.AddReceivedPacket(100, now.Add(time.Second))
+	bbr.OnIncomingCongestionFeedback(frame, now.Add(time.Second))
+	if bw, expected := bbr.GetBandwidthEstimate(), BandwidthFromDelta(9*MaxSegmentSize, 180*time.Millisecond); bw != expected {
+		t.Errorf("BbrSender : invalid bandwidth estimate %v after an inter-arrival feedback (%v expected)", bw, expected)
+	}
+	// The receive rate is limited by the send rate
+	for i := 0; i < 10; i++ {
+		bbr.OnPacketSent(now.Add(time.Duration(i)*10*time.Millisecond), protocol.QuicByteCount(i)*MaxSegmentSize, protocol.QuicPacketSequenceNumber(i+11), MaxSegmentSize, true)
+	}
+	frame.Erase()
+	frame.SetFrameType(protocol.QUICFRAMETYPE_CONGESTION_FEEDBACK)
+	frame.SetCongestionFeedbackType(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL)
+	for i := 0; i < 10; i++ {
+		frame.AddReceivedPacket(protocol.QuicPacketSequenceNumber(i+11), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if bw, expected := bbr.sampler.GetReceiveRate(frame.GetReceivedPackets()), BandwidthFromDelta(9*MaxSegmentSize, 90*time.Millisecond); bw != expected {
+		t.Errorf("BandwidthSampler.GetReceiveRate : invalid receive rate %v (%v expected)", bw, expected)
+	}
+	// The receive window of the TCP feedback limits the bytes in flight
+	frame.SetCongestionFeedbackType(protocol.QUICCONGESTIONFEEDBACK_TCP)
+	frame.SetReceiveWindow(2 * MaxSegmentSize)
+	bbr.OnIncomingCongestionFeedback(frame, now.Add(time.Second))
+	if bbr.CanSend(2 * MaxSegmentSize) {
+		t.Error("BbrSender.CanSend : must be limited by the receive window of the peer")
+	}
+}
+
+func Test_BbrSender_OnRetransmissionTimeout(t *testing.T) {
+	now := time.Unix(0, 0)
+	bbr := NewBbrSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+
+	for i := 0; i < 10; i++ {
+		bbr.OnPacketSent(now, protocol.QuicByteCount(i)*MaxSegmentSize, protocol.QuicPacketSequenceNumber(i+1), MaxSegmentSize, true)
+	}
+	// The loss of the packet 1 enters recovery
+	now = now.Add(100 * time.Millisecond)
+	bbr.OnCongestionEvent(now, 10*MaxSegmentSize, []PacketInfo{{SequenceNumber: 2, Bytes: MaxSegmentSize}}, []PacketInfo{{SequenceNumber: 1, Bytes: MaxSegmentSize}})
+	if bbr.recoveryState == bbrNotInRecovery {
+		t.Fatal("BbrSender.OnCongestionEvent : must enter recovery on loss")
+	}
+	rounds := bbr.roundTripCount
+	// The packets 3 to 10 are dropped by the retransmission timeout
+	bbr.OnRetransmissionTimeout(true)
+	if len(bbr.sampler.packets) != 0 {
+		t.Errorf("BbrSender.OnRetransmissionTimeout : %v packets still tracked by the bandwidth sampler", len(bbr.sampler.packets))
+	}
+	if bbr.recoveryState != bbrNotInRecovery {
+		t.Error("BbrSender.OnRetransmissionTimeout : must exit recovery")
+	}
+	// The next packet sent starts a new round trip
+	bbr.OnPacketSent(now, 0, 11, MaxSegmentSize, true)
+	now = now.Add(100 * time.Millisecond)
+	bbr.OnCongestionEvent(now, MaxSegmentSize, []PacketInfo{{SequenceNumber: 11, Bytes: MaxSegmentSize}}, nil)
+	if bbr.roundTripCount != rounds+1 {
+		t.Errorf("BbrSender.OnRetransmissionTimeout : %v round trips after the timeout (%v expected)", bbr.roundTripCount, rounds+1)
+	}
+	if bbr.recoveryState != bbrNotInRecovery {
+		t.Error("BbrSender.OnCongestionEvent : must not be in recovery after the timeout")
+	}
+}
@@ -0,0 +1,97 @@
+// Package congestion provides the congestion control algorithms used by a QUIC session to govern packet transmission.
+//
+// See doc/TCPCongestionControl.md, doc/CUBIC.md and doc/TCPProportionalRateReduction.md
+package congestion
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// MaxSegmentSize is the maximum packet size in bytes used for congestion window computations (equivalent of TCP SMSS)
+	MaxSegmentSize protocol.QuicByteCount = 1460
+	// DefaultInitialCongestionWindow is the initial congestion window in packets
+	DefaultInitialCongestionWindow = 10
+	// DefaultMaxCongestionWindow is the maximum congestion window in packets
+	DefaultMaxCongestionWindow = 2000
+	// MinimumCongestionWindow is the minimum congestion window in packets
+	MinimumCongestionWindow = 2
+)
+
+// Bandwidth is a bandwidth value in bits per second.
+type Bandwidth uint64
+
+const (
+	BitsPerSecond  Bandwidth = 1
+	BytesPerSecond Bandwidth = 8 * BitsPerSecond
+)
+
+// BandwidthFromDelta returns the bandwidth needed to transfer 'bytes' bytes in 'delta' duration.
+func BandwidthFromDelta(bytes protocol.QuicByteCount, delta time.Duration) Bandwidth {
+	if delta <= 0 {
+		return 0
+	}
+	return Bandwidth(bytes) * BytesPerSecond * Bandwidth(time.Second) / Bandwidth(delta)
+}
+
+// TransferTime returns the time needed to transfer 'bytes' bytes with this bandwidth.
+func (this Bandwidth) TransferTime(bytes protocol.QuicByteCount) time.Duration {
+	if this == 0 {
+		return 0
+	}
+	return time.Duration(Bandwidth(bytes) * BytesPerSecond * Bandwidth(time.Second) / this)
+}
+
+// PacketInfo describes a packet given to SendAlgorithm.OnCongestionEvent
+type PacketInfo struct {
+	SequenceNumber protocol.QuicPacketSequenceNumber
+	Bytes          protocol.QuicByteCount
+}
+
+// SendAlgorithm is the interface implemented by all congestion control algorithms of a QUIC session.
+type SendAlgorithm interface {
+	// OnPacketSent is called each time a packet is sent, 'bytesInFlight' is the number of bytes in flight before sending this packet.
+	OnPacketSent(sentTime time.Time, bytesInFlight protocol.QuicByteCount, seqnum protocol.QuicPacketSequenceNumber, bytes protocol.QuicByteCount, retransmittable bool)
+	// OnCongestionEvent is called when an ACK frame is received or when a loss is detected by a timer,
+	// the 'lostPackets' are always processed before the 'ackedPackets'.
+	OnCongestionEvent(eventTime time.Time, priorInFlight protocol.QuicByteCount, ackedPackets, lostPackets []PacketInfo)
+	// OnPacketNeutered is called when a packet leaves the bytes in flight without being acknowledged or lost,
+	// like a duplicate of a packet already acknowledged: it is not a congestion signal.
+	OnPacketNeutered(seqnum protocol.QuicPacketSequenceNumber)
+	// OnRetransmissionTimeout is called when the retransmission timer expires.
+	OnRetransmissionTimeout(packetsRetransmitted bool)
+	// OnIncomingCongestionFeedback is called with each CONGESTION_FEEDBACK frame received at 'feedbackTime', before the ACK frames of the same packet.
+	OnIncomingCongestionFeedback(frame *protocol.QuicFrame, feedbackTime time.Time)
+	// CanSend returns true if the congestion controller allows to send a new packet with 'bytesInFlight' bytes already in flight.
+	CanSend(bytesInFlight protocol.QuicByteCount) bool
+	// GetCongestionWindow returns the congestion window in bytes.
+	GetCongestionWindow() protocol.QuicByteCount
+	// GetSlowStartThreshold returns the slow start threshold in bytes.
+	GetSlowStartThreshold() protocol.QuicByteCount
+	// GetPacingRate returns the rate at which packets should be sent on the network.
+	GetPacingRate() Bandwidth
+	// InSlowStart returns true if the congestion controller is in slow start phase.
+	InSlowStart() bool
+	// InRecovery returns true if the congestion controller is in loss recovery phase.
+	InRecovery() bool
+}
+
+// NewSendAlgorithm is a SendAlgorithm factory that returns the congestion control algorithm selected by the connection options (values of TagCOPT) given in input.
+//
+// The first congestion control option found in the list is used:
+//
+//   - TagQBIC: CUBIC (default if no congestion control option is found)
+//   - TagRENO: NewReno
+//   - TagTBBR: BBR
+func NewSendAlgorithm(connectionOptions []protocol.MessageTag, rttStats *RTTStats) SendAlgorithm {
+	for _, tag := range connectionOptions {
+		switch tag {
+		case protocol.TagQBIC: // CUBIC
+			return NewCubicSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+		case protocol.TagRENO: // NewReno
+			return NewRenoSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+		case protocol.TagTBBR: // BBR
+			return NewBbrSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+		}
+	}
+	return NewCubicSender(rttStats, DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+}
@@ -0,0 +1,85 @@
+package congestion
+
+import "math"
+import "time"
+
+const (
+	// CUBIC 'C' constant that determines the aggressiveness of window growth
+	cubicC = 0.4
+	// CUBIC multiplicative decrease factor
+	cubicBeta = 0.2
+	// Standard TCP additive factor in the TCP-friendly region: alpha = 3*beta/(2-beta)
+	cubicAlpha = 3 * cubicBeta / (2 - cubicBeta)
+)
+
+// Cubic implements the CUBIC window growth function of draft-rhee-tcpm-cubic-02 (see doc/CUBIC.md).
+//
+// All windows are expressed in packets.
+type Cubic struct {
+	epoch                   time.Time // start of the current congestion avoidance epoch, zero value if no epoch
+	epochCongestionWindow   float64   // congestion window at the start of the epoch
+	lastMaxCongestionWindow float64   // W_last_max
+	maxCongestionWindow     float64   // W_max
+	k                       float64   // time period in seconds to increase the window to W_max
+}
+
+// Reset clears all the CUBIC state (typically after a retransmission timeout).
+func (this *Cubic) Reset() {
+	this.epoch = time.Time{}
+	this.epochCongestionWindow = 0
+	this.lastMaxCongestionWindow = 0
+	this.maxCongestionWindow = 0
+	this.k = 0
+}
+
+// CongestionWindowAfterPacketLoss applies the multiplicative decrease with fast convergence and returns the new congestion window.
+func (this *Cubic) CongestionWindowAfterPacketLoss(congestionWindow float64) float64 {
+	// Fast convergence
+	this.maxCongestionWindow = congestionWindow
+	if this.maxCongestionWindow < this.lastMaxCongestionWindow {
+		this.lastMaxCongestionWindow = this.maxCongestionWindow
+		this.maxCongestionWindow = this.maxCongestionWindow * (2 - cubicBeta) / 2
+	} else {
+		this.lastMaxCongestionWindow = this.maxCongestionWindow
+	}
+	// A new epoch starts on the next ACK
+	this.epoch = time.Time{}
+	return congestionWindow * (1 - cubicBeta)
+}
+
+// CongestionWindowAfterAck computes the congestion window growth in congestion avoidance for a received ACK at time 'now' and returns the new congestion window.
+func (this *Cubic) CongestionWindowAfterAck(congestionWindow float64, now time.Time, rtt time.Duration) float64 {
+	if rtt <= 0 {
+		rtt = DefaultInitialRTT
+	}
+	if this.epoch.IsZero() {
+		// Start a new epoch
+		this.epoch = now
+		this.epochCongestionWindow = congestionWindow
+		if congestionWindow < this.maxCongestionWindow {
+			// Equation 2: K = cubic_root((W_max - cwnd)/C)
+			this.k = math.Cbrt((this.maxCongestionWindow - congestionWindow) / cubicC)
+		} else {
+			this.k = 0
+			this.maxCongestionWindow = congestionWindow
+		}
+	}
+	elapsed := now.Sub(this.epoch)
+	// Equation 1: W(t+RTT) = C*(t+RTT-K)^3 + W_max
+	t := (elapsed + rtt).Seconds() - this.k
+	target := cubicC*t*t*t + this.maxCongestionWindow
+	// Equation 4: W_tcp(t) = W_epoch + 3 * beta/(2-beta) * t/RTT
+	tcpWindow := this.epochCongestionWindow + cubicAlpha*elapsed.Seconds()/rtt.Seconds()
+	if tcpWindow > target {
+		// TCP-friendly region: cwnd is set to W_tcp(t)
+		if tcpWindow > congestionWindow {
+			return tcpWindow
+		}
+		return congestionWindow
+	}
+	// Concave and Convex regions: cwnd is incremented by (W(t+RTT) - cwnd)/cwnd
+	if target > congestionWindow {
+		congestionWindow += (target - congestionWindow) / congestionWindow
+	}
+	return congestionWindow
+}
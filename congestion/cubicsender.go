@@ -0,0 +1,175 @@
+package congestion
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+// CubicSender is the CUBIC congestion controller, it uses Proportional Rate Reduction during loss recovery.
+type CubicSender struct {
+	rttStats                 *RTTStats
+	cubic                    Cubic
+	prr                      PrrSender
+	congestionWindow         protocol.QuicByteCount
+	slowstartThreshold       protocol.QuicByteCount
+	minCongestionWindow      protocol.QuicByteCount
+	maxCongestionWindow      protocol.QuicByteCount
+	largestSentSeqNum        protocol.QuicPacketSequenceNumber
+	largestAckedSeqNum       protocol.QuicPacketSequenceNumber
+	largestSentAtLastCutback protocol.QuicPacketSequenceNumber
+	receiveWindow            ReceiveWindow
+}
+
+// NewCubicSender is a CubicSender factory, 'initialCongestionWindow' and 'maxCongestionWindow' are expressed in packets.
+func NewCubicSender(rttStats *RTTStats, initialCongestionWindow, maxCongestionWindow int) *CubicSender {
+	return &CubicSender{
+		rttStats:            rttStats,
+		prr:                 PrrSender{mode: PRR_SSRB},
+		congestionWindow:    protocol.QuicByteCount(initialCongestionWindow) * MaxSegmentSize,
+		slowstartThreshold:  protocol.QuicByteCount(maxCongestionWindow) * MaxSegmentSize,
+		minCongestionWindow: MinimumCongestionWindow * MaxSegmentSize,
+		maxCongestionWindow: protocol.QuicByteCount(maxCongestionWindow) * MaxSegmentSize}
+}
+
+// SetPrrMode selects the PRR Reduction Bound (PRR_SSRB or PRR_CRB) used during loss recovery.
+func (this *CubicSender) SetPrrMode(mode PrrMode) {
+	this.prr.SetMode(mode)
+}
+
+// OnPacketSent
+func (this *CubicSender) OnPacketSent(sentTime time.Time, bytesInFlight protocol.QuicByteCount, seqnum protocol.QuicPacketSequenceNumber, bytes protocol.QuicByteCount, retransmittable bool) {
+	if !retransmittable {
+		return
+	}
+	if this.InRecovery() {
+		this.prr.OnPacketSent(bytes)
+	}
+	this.largestSentSeqNum = seqnum
+}
+
+// OnCongestionEvent
+func (this *CubicSender) OnCongestionEvent(eventTime time.Time, priorInFlight protocol.QuicByteCount, ackedPackets, lostPackets []PacketInfo) {
+	for _, p := range lostPackets {
+		this.onPacketLost(p.SequenceNumber, p.Bytes, priorInFlight)
+	}
+	for _, p := range ackedPackets {
+		this.onPacketAcked(p.SequenceNumber, p.Bytes, priorInFlight, eventTime)
+	}
+}
+
+// onPacketLost
+func (this *CubicSender) onPacketLost(seqnum protocol.QuicPacketSequenceNumber, lostBytes, priorInFlight protocol.QuicByteCount) {
+	// Only one window reduction per window of data
+	if seqnum <= this.largestSentAtLastCutback {
+		return
+	}
+	this.prr.OnPacketLost(priorInFlight)
+	this.congestionWindow = protocol.QuicByteCount(this.cubic.CongestionWindowAfterPacketLoss(float64(this.congestionWindow)/float64(MaxSegmentSize)) * float64(MaxSegmentSize))
+	if this.congestionWindow < this.minCongestionWindow {
+		this.congestionWindow = this.minCongestionWindow
+	}
+	this.slowstartThreshold = this.congestionWindow
+	this.largestSentAtLastCutback = this.largestSentSeqNum
+}
+
+// onPacketAcked
+func (this *CubicSender) onPacketAcked(seqnum protocol.QuicPacketSequenceNumber, ackedBytes, priorInFlight protocol.QuicByteCount, eventTime time.Time) {
+	if seqnum > this.largestAckedSeqNum {
+		this.largestAckedSeqNum = seqnum
+	}
+	if this.InRecovery() {
+		// PRR is used during recovery: the congestion window does not grow
+		this.prr.OnPacketAcked(ackedBytes)
+		return
+	}
+	if !this.isCongestionWindowLimited(priorInFlight) {
+		return
+	}
+	if this.congestionWindow >= this.maxCongestionWindow {
+		return
+	}
+	if this.InSlowStart() {
+		// Appropriate Byte Counting: cwnd += min(N, SMSS)
+		if ackedBytes > MaxSegmentSize {
+			ackedBytes = MaxSegmentSize
+		}
+		this.congestionWindow += ackedBytes
+	} else {
+		this.congestionWindow = protocol.QuicByteCount(this.cubic.CongestionWindowAfterAck(float64(this.congestionWindow)/float64(MaxSegmentSize), eventTime, this.rttStats.GetMinRTT()) * float64(MaxSegmentSize))
+	}
+	if this.congestionWindow > this.maxCongestionWindow {
+		this.congestionWindow = this.maxCongestionWindow
+	}
+}
+
+// isCongestionWindowLimited returns false when the sender is application limited, so that the congestion window does not grow without bounds.
+func (this *CubicSender) isCongestionWindowLimited(bytesInFlight protocol.QuicByteCount) bool {
+	if bytesInFlight >= this.congestionWindow {
+		return true
+	}
+	if this.InSlowStart() && (bytesInFlight > this.congestionWindow/2) {
+		return true
+	}
+	return this.congestionWindow-bytesInFlight <= 3*MaxSegmentSize
+}
+
+// OnPacketNeutered does nothing, the congestion window only depends on the acknowledged and lost packets.
+func (this *CubicSender) OnPacketNeutered(seqnum protocol.QuicPacketSequenceNumber) {
+}
+
+// OnRetransmissionTimeout
+func (this *CubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	this.largestSentAtLastCutback = 0
+	if !packetsRetransmitted {
+		return
+	}
+	this.cubic.Reset()
+	this.slowstartThreshold = this.congestionWindow / 2
+	if this.slowstartThreshold < this.minCongestionWindow {
+		this.slowstartThreshold = this.minCongestionWindow
+	}
+	this.congestionWindow = this.minCongestionWindow
+}
+
+// CanSend
+func (this *CubicSender) CanSend(bytesInFlight protocol.QuicByteCount) bool {
+	if !this.receiveWindow.CanSend(bytesInFlight) {
+		return false
+	}
+	if this.InRecovery() {
+		return this.prr.CanSend(bytesInFlight, this.slowstartThreshold)
+	}
+	return bytesInFlight < this.congestionWindow
+}
+
+// OnIncomingCongestionFeedback limits the bytes in flight to the receive window of the TCP feedback, the inter-arrival feedback is not used.
+func (this *CubicSender) OnIncomingCongestionFeedback(frame *protocol.QuicFrame, feedbackTime time.Time) {
+	this.receiveWindow.OnCongestionFeedback(frame)
+}
+
+// GetCongestionWindow
+func (this *CubicSender) GetCongestionWindow() protocol.QuicByteCount {
+	return this.congestionWindow
+}
+
+// GetSlowStartThreshold
+func (this *CubicSender) GetSlowStartThreshold() protocol.QuicByteCount {
+	return this.slowstartThreshold
+}
+
+// GetPacingRate returns twice the estimated bandwidth in slow start and 1.25 times the estimated bandwidth otherwise.
+func (this *CubicSender) GetPacingRate() Bandwidth {
+	bw := BandwidthFromDelta(this.congestionWindow, this.rttStats.GetSmoothedRTT())
+	if this.InSlowStart() {
+		return 2 * bw
+	}
+	return bw * 5 / 4
+}
+
+// InSlowStart
+func (this *CubicSender) InSlowStart() bool {
+	return this.congestionWindow < this.slowstartThreshold
+}
+
+// InRecovery
+func (this *CubicSender) InRecovery() bool {
+	return (this.largestSentAtLastCutback != 0) && (this.largestAckedSeqNum <= this.largestSentAtLastCutback)
+}
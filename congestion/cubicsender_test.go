@@ -0,0 +1,158 @@
+package congestion
+
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+func Test_CubicSender_SlowStart(t *testing.T) {
+	now := time.Now()
+	sender := NewCubicSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+
+	if !sender.InSlowStart() {
+		t.Error("CubicSender : must start in slow start")
+	}
+	// Send a full congestion window
+	inflight := protocol.QuicByteCount(0)
+	seqnum := protocol.QuicPacketSequenceNumber(1)
+	for sender.CanSend(inflight) {
+		sender.OnPacketSent(now, inflight, seqnum, MaxSegmentSize, true)
+		inflight += MaxSegmentSize
+		seqnum++
+	}
+	if inflight != DefaultInitialCongestionWindow*MaxSegmentSize {
+		t.Errorf("CubicSender : invalid bytes in flight %v after sending the initial window", inflight)
+	}
+	// Ack all packets one by one while the sender stays congestion window limited: the congestion window doubles
+	for i := protocol.QuicPacketSequenceNumber(1); i < seqnum; i++ {
+		sender.OnCongestionEvent(now, sender.GetCongestionWindow(), []PacketInfo{{i, MaxSegmentSize}}, nil)
+	}
+	if sender.GetCongestionWindow() != 2*DefaultInitialCongestionWindow*MaxSegmentSize {
+		t.Errorf("CubicSender : invalid congestion window %v after one round trip in slow start", sender.GetCongestionWindow())
+	}
+}
+
+func Test_CubicSender_RecoveryWithPRR(t *testing.T) {
+	now := time.Now()
+	sender := NewCubicSender(NewRTTStats(), 20, DefaultMaxCongestionWindow)
+
+	// Send 20 packets
+	inflight := protocol.QuicByteCount(0)
+	for i := protocol.QuicPacketSequenceNumber(1); i <= 20; i++ {
+		sender.OnPacketSent(now, inflight, i, MaxSegmentSize, true)
+		inflight += MaxSegmentSize
+	}
+	// Packet 1 is lost, packet 2 is acked
+	sender.OnCongestionEvent(now, inflight, []PacketInfo{{2, MaxSegmentSize}}, []PacketInfo{{1, MaxSegmentSize}})
+	inflight -= 2 * MaxSegmentSize
+	if !sender.InRecovery() {
+		t.Error("CubicSender : must be in recovery after a loss")
+	}
+	expected := protocol.QuicByteCount(float64(20*MaxSegmentSize) * (1 - cubicBeta))
+	if sender.GetCongestionWindow() != expected {
+		t.Errorf("CubicSender : invalid congestion window %v after loss (%v expected)", sender.GetCongestionWindow(), expected)
+	}
+	if sender.GetSlowStartThreshold() != expected {
+		t.Errorf("CubicSender : invalid slow start threshold %v after loss (%v expected)", sender.GetSlowStartThreshold(), expected)
+	}
+	// Fast retransmit is always allowed even if bytes in flight exceed the congestion window
+	if !sender.CanSend(inflight) {
+		t.Error("CubicSender : fast retransmit must be allowed when entering recovery")
+	}
+	sender.OnPacketSent(now, inflight, 21, MaxSegmentSize, true)
+	inflight += MaxSegmentSize
+	// Next ACK: PRR forbids sending (proportional reduction)
+	if sender.CanSend(inflight) {
+		t.Error("CubicSender : PRR must forbid sending after the fast retransmit")
+	}
+	sender.OnCongestionEvent(now, inflight, []PacketInfo{{3, MaxSegmentSize}}, nil)
+	inflight -= MaxSegmentSize
+	// A second loss in the same window does not reduce the congestion window again
+	sender.OnCongestionEvent(now, inflight, []PacketInfo{{5, MaxSegmentSize}}, []PacketInfo{{4, MaxSegmentSize}})
+	inflight -= 2 * MaxSegmentSize
+	if sender.GetCongestionWindow() != expected {
+		t.Errorf("CubicSender : invalid congestion window %v after second loss in the same window (%v expected)", sender.GetCongestionWindow(), expected)
+	}
+	// The ACK of a packet sent after the window reduction ends the recovery
+	sender.OnCongestionEvent(now, inflight, []PacketInfo{{21, MaxSegmentSize}}, nil)
+	if sender.InRecovery() {
+		t.Error("CubicSender : recovery must end when a packet sent during recovery is acked")
+	}
+}
+
+func Test_CubicSender_RetransmissionTimeout(t *testing.T) {
+	sender := NewCubicSender(NewRTTStats(), 20, DefaultMaxCongestionWindow)
+
+	sender.OnRetransmissionTimeout(true)
+	if sender.GetCongestionWindow() != MinimumCongestionWindow*MaxSegmentSize {
+		t.Errorf("CubicSender : invalid congestion window %v after RTO", sender.GetCongestionWindow())
+	}
+	if sender.GetSlowStartThreshold() != 10*MaxSegmentSize {
+		t.Errorf("CubicSender : invalid slow start threshold %v after RTO", sender.GetSlowStartThreshold())
+	}
+}
+
+func Test_CubicSender_OnIncomingCongestionFeedback(t *testing.T) {
+	sender := NewCubicSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+
+	// The inter-arrival feedback doesn't limit the sender
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_CONGESTION_FEEDBACK)
+	frame.SetCongestionFeedbackType(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL)
+	sender.OnIncomingCongestionFeedback(frame, time.Now())
+	if !sender.CanSend(3 * MaxSegmentSize) {
+		t.Error("CubicSender : must not be limited by an inter-arrival feedback")
+	}
+	// The receive window of the TCP feedback limits the bytes in flight, but one packet can always be sent
+	frame.SetCongestionFeedbackType(protocol.QUICCONGESTIONFEEDBACK_TCP)
+	frame.SetReceiveWindow(3000)
+	sender.OnIncomingCongestionFeedback(frame, time.Now())
+	for _, v := range []struct {
+		inflight protocol.QuicByteCount
+		canSend  bool
+	}{
+		{0, true},
+		{2 * MaxSegmentSize, true},
+		{3 * MaxSegmentSize, false},
+	} {
+		if sender.CanSend(v.inflight) != v.canSend {
+			t.Errorf("CubicSender.CanSend : must return %v with %v bytes in flight and a receive window of 3000 bytes", v.canSend, v.inflight)
+		}
+	}
+	frame.SetReceiveWindow(0)
+	sender.OnIncomingCongestionFeedback(frame, time.Now())
+	if !sender.CanSend(0) || sender.CanSend(1) {
+		t.Error("CubicSender.CanSend : must send one packet at a time with a receive window of 0 bytes")
+	}
+}
+
+func Test_Cubic_WindowGrowth(t *testing.T) {
+	var cubic Cubic
+
+	now := time.Now()
+	rtt := 100 * time.Millisecond
+	cwnd := cubic.CongestionWindowAfterPacketLoss(100)
+	if cwnd != 80 {
+		t.Errorf("Cubic : invalid congestion window %v after loss (80 expected)", cwnd)
+	}
+	// Concave region: the window grows back toward W_max and stays at the plateau
+	cwnd = cubic.CongestionWindowAfterAck(cwnd, now, rtt)
+	k := time.Duration(cubic.k * float64(time.Second))
+	if k <= 0 {
+		t.Errorf("Cubic : invalid K value %v", k)
+	}
+	for elapsed := time.Duration(0); elapsed < k; elapsed += rtt / 10 {
+		cwnd = cubic.CongestionWindowAfterAck(cwnd, now.Add(elapsed), rtt)
+		if cwnd > 100.5 {
+			t.Errorf("Cubic : congestion window %v exceeds W_max in concave region at t=%v", cwnd, elapsed)
+			break
+		}
+	}
+	if cwnd < 95 {
+		t.Errorf("Cubic : congestion window %v did not reach the plateau at t=K", cwnd)
+	}
+	// Fast convergence: a new loss below W_last_max further reduces W_max
+	cubic.CongestionWindowAfterPacketLoss(90)
+	if cubic.maxCongestionWindow != 90*(2-cubicBeta)/2 {
+		t.Errorf("Cubic : invalid W_max %v after fast convergence", cubic.maxCongestionWindow)
+	}
+}
@@ -0,0 +1,25 @@
+package congestion
+
+import "github.com/romain-jacotin/quic/protocol"
+
+// ReceiveWindow is the receive window of the peer given by the TCP CONGESTION_FEEDBACK frames, it limits the bytes in flight of the senders.
+//
+// The window is unlimited until the first TCP feedback is received, and one packet can always be sent when nothing is in flight
+// so that the peer can acknowledge it with the update of its receive window.
+type ReceiveWindow struct {
+	window protocol.QuicByteCount
+	known  bool
+}
+
+// OnCongestionFeedback records the receive window of a TCP CONGESTION_FEEDBACK frame, the other feedback types are ignored.
+func (this *ReceiveWindow) OnCongestionFeedback(frame *protocol.QuicFrame) {
+	if frame.GetCongestionFeedbackType() == protocol.QUICCONGESTIONFEEDBACK_TCP {
+		this.window = frame.GetReceiveWindow()
+		this.known = true
+	}
+}
+
+// CanSend returns true if the receive window of the peer allows to send a new packet with 'bytesInFlight' bytes already in flight.
+func (this *ReceiveWindow) CanSend(bytesInFlight protocol.QuicByteCount) bool {
+	return !this.known || (bytesInFlight == 0) || (bytesInFlight < this.window)
+}
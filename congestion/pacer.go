@@ -0,0 +1,109 @@
+package congestion
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// DefaultInitialBurst is the number of packets that can be sent without pacing at the start of the session or after a quiescence
+	DefaultInitialBurst = 10
+	// DefaultMaxBurst is the number of packets that can be sent back-to-back after the initial burst
+	DefaultMaxBurst = 2
+)
+
+// Pacer is a token bucket that spreads the packets of a session at the pacing rate of its congestion controller (auto-pacing).
+//
+// Tokens are bytes: the bucket is filled at the pacing rate, up to DefaultMaxBurst packets, and each packet sent consumes its size.
+// A packet is sent only when the bucket holds the tokens of a full-size packet, so that the bucket is never overdrawn.
+// The bucket starts with an initial burst allowance that is refilled each time the bytes in flight fall to zero.
+// An optional minimum gap between two consecutive packets (minimum pacing) is enforced whatever the pacing rate.
+type Pacer struct {
+	sender       SendAlgorithm
+	initialBurst protocol.QuicByteCount
+	maxBurst     protocol.QuicByteCount
+	minimumGap   time.Duration
+	tokens       int64
+	// fraction is the part of a token earned but not yet added to the bucket
+	fraction     float64
+	lastUpdate   time.Time
+	lastSentTime time.Time
+}
+
+// NewPacer is a Pacer factory that paces the packets at the rate given by the 'sender' congestion controller.
+func NewPacer(sender SendAlgorithm) *Pacer {
+	return &Pacer{
+		sender:       sender,
+		initialBurst: DefaultInitialBurst * MaxSegmentSize,
+		maxBurst:     DefaultMaxBurst * MaxSegmentSize,
+		tokens:       DefaultInitialBurst * int64(MaxSegmentSize)}
+}
+
+// SetInitialBurst sets the number of packets that can be sent without pacing at the start of the session or after a quiescence.
+func (this *Pacer) SetInitialBurst(packets int) {
+	this.initialBurst = protocol.QuicByteCount(packets) * MaxSegmentSize
+	if this.lastSentTime.IsZero() {
+		this.tokens = int64(this.initialBurst)
+	}
+}
+
+// SetMinimumGap sets the minimum duration between two consecutive packets, 0 disables the minimum pacing.
+func (this *Pacer) SetMinimumGap(gap time.Duration) {
+	this.minimumGap = gap
+}
+
+// GetMinimumGap returns the minimum duration between two consecutive packets.
+func (this *Pacer) GetMinimumGap() time.Duration {
+	return this.minimumGap
+}
+
+// refill adds the tokens earned at the pacing rate since the last update, the fraction of a token is kept for the next update.
+func (this *Pacer) refill(now time.Time) {
+	if !this.lastUpdate.IsZero() && now.After(this.lastUpdate) {
+		rate := this.sender.GetPacingRate()
+		earned := float64(rate)/float64(BytesPerSecond)*now.Sub(this.lastUpdate).Seconds() + this.fraction
+		limit := int64(this.maxBurst)
+		if this.tokens < limit {
+			this.tokens += int64(earned)
+			this.fraction = earned - float64(int64(earned))
+			if this.tokens >= limit {
+				this.tokens = limit
+				this.fraction = 0
+			}
+		}
+	}
+	if now.After(this.lastUpdate) {
+		this.lastUpdate = now
+	}
+}
+
+// TimeUntilSend returns the delay before the next packet can be sent, 'bytesInFlight' is the number of bytes in flight.
+//
+// The congestion window is not checked here: SendAlgorithm.CanSend must also allow the packet.
+func (this *Pacer) TimeUntilSend(now time.Time, bytesInFlight protocol.QuicByteCount) time.Duration {
+	var delay time.Duration
+
+	this.refill(now)
+	// Quiescence: restore the initial burst allowance
+	if (bytesInFlight == 0) && (this.tokens < int64(this.initialBurst)) {
+		this.tokens = int64(this.initialBurst)
+	}
+	if this.tokens < int64(MaxSegmentSize) {
+		rate := this.sender.GetPacingRate()
+		if rate == 0 {
+			return 0
+		}
+		delay = rate.TransferTime(protocol.QuicByteCount(int64(MaxSegmentSize) - this.tokens))
+	}
+	if (this.minimumGap > 0) && !this.lastSentTime.IsZero() {
+		if gap := this.lastSentTime.Add(this.minimumGap).Sub(now); gap > delay {
+			delay = gap
+		}
+	}
+	return delay
+}
+
+// OnPacketSent consumes the tokens of a packet of 'bytes' bytes sent at 'sentTime'.
+func (this *Pacer) OnPacketSent(sentTime time.Time, bytes protocol.QuicByteCount) {
+	this.refill(sentTime)
+	this.tokens -= int64(bytes)
+	this.lastSentTime = sentTime
+}
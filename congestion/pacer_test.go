@@ -0,0 +1,152 @@
+package congestion
+
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+// testpacingsender is a SendAlgorithm with a constant pacing rate.
+type testpacingsender struct {
+	*CubicSender
+	rate Bandwidth
+}
+
+func (this *testpacingsender) GetPacingRate() Bandwidth {
+	return this.rate
+}
+
+func Test_Pacer_InitialBurstAndPacingRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	// One packet every millisecond
+	sender := &testpacingsender{NewCubicSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow), BandwidthFromDelta(MaxSegmentSize, time.Millisecond)}
+	pacer := NewPacer(sender)
+
+	// The initial burst is sent without delay
+	inflight := protocol.QuicByteCount(0)
+	for i := 0; i < DefaultInitialBurst; i++ {
+		if d := pacer.TimeUntilSend(now, inflight); d != 0 {
+			t.Fatalf("Pacer.TimeUntilSend : invalid delay %v for packet n°%v of the initial burst", d, i+1)
+		}
+		pacer.OnPacketSent(now, MaxSegmentSize)
+		inflight += MaxSegmentSize
+	}
+	// Then the packets are spread at the pacing rate
+	if d := pacer.TimeUntilSend(now, inflight); (d <= 0) || (d > time.Millisecond) {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v after the initial burst", d)
+	}
+	sent := 0
+	for end := now.Add(100 * time.Millisecond); now.Before(end); now = now.Add(100 * time.Microsecond) {
+		if pacer.TimeUntilSend(now, inflight) == 0 {
+			pacer.OnPacketSent(now, MaxSegmentSize)
+			inflight += MaxSegmentSize
+			sent++
+		}
+	}
+	if (sent < 99) || (sent > 101) {
+		t.Errorf("Pacer : %v packets sent in 100ms at 1 packet/ms", sent)
+	}
+	// After an idle period, only a small burst is allowed while packets are in flight
+	now = now.Add(time.Second)
+	burst := 0
+	for pacer.TimeUntilSend(now, inflight) == 0 {
+		pacer.OnPacketSent(now, MaxSegmentSize)
+		burst++
+	}
+	if burst != DefaultMaxBurst {
+		t.Errorf("Pacer : invalid burst %v after idle period (%v expected)", burst, DefaultMaxBurst)
+	}
+	// Quiescence restores the initial burst
+	burst = 0
+	for pacer.TimeUntilSend(now, 0) == 0 {
+		pacer.OnPacketSent(now, MaxSegmentSize)
+		burst++
+		if burst > DefaultInitialBurst {
+			break
+		}
+	}
+	if burst < DefaultInitialBurst {
+		t.Errorf("Pacer : invalid burst %v after quiescence (%v expected)", burst, DefaultInitialBurst)
+	}
+}
+
+func Test_Pacer_MinimumGap(t *testing.T) {
+	now := time.Unix(0, 0)
+	// Pacing rate of 1 packet every 10 microseconds
+	sender := &testpacingsender{NewCubicSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow), BandwidthFromDelta(MaxSegmentSize, 10*time.Microsecond)}
+	pacer := NewPacer(sender)
+	pacer.SetInitialBurst(2)
+	pacer.SetMinimumGap(time.Millisecond)
+
+	if d := pacer.TimeUntilSend(now, 0); d != 0 {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v for the first packet", d)
+	}
+	pacer.OnPacketSent(now, MaxSegmentSize)
+	// The minimum gap applies even within the burst allowance and above the pacing rate
+	if d := pacer.TimeUntilSend(now, MaxSegmentSize); d != time.Millisecond {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v with a minimum gap of 1ms", d)
+	}
+	now = now.Add(400 * time.Microsecond)
+	if d := pacer.TimeUntilSend(now, MaxSegmentSize); d != 600*time.Microsecond {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v with a minimum gap of 1ms", d)
+	}
+	now = now.Add(600 * time.Microsecond)
+	if d := pacer.TimeUntilSend(now, MaxSegmentSize); d != 0 {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v after the minimum gap", d)
+	}
+	pacer.SetMinimumGap(0)
+	pacer.OnPacketSent(now, MaxSegmentSize)
+	if d := pacer.TimeUntilSend(now, 2*MaxSegmentSize); d > 10*time.Microsecond {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v without minimum gap", d)
+	}
+}
+
+func Test_Pacer_SubByteRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	// Pacing rate of 1000 bytes per second polled every 100 microseconds: 0.1 byte per poll
+	sender := &testpacingsender{NewCubicSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow), BandwidthFromDelta(1000, time.Second)}
+	pacer := NewPacer(sender)
+	pacer.SetInitialBurst(1)
+
+	pacer.OnPacketSent(now, MaxSegmentSize)
+	inflight := protocol.QuicByteCount(MaxSegmentSize)
+	sent := 0
+	for end := now.Add(10 * time.Second); now.Before(end); now = now.Add(100 * time.Microsecond) {
+		if pacer.TimeUntilSend(now, inflight) == 0 {
+			pacer.OnPacketSent(now, MaxSegmentSize)
+			inflight += MaxSegmentSize
+			sent++
+		}
+	}
+	// 10000 bytes earned in 10s
+	if expected := int(10000 / MaxSegmentSize); (sent < expected-1) || (sent > expected+1) {
+		t.Errorf("Pacer : %v packets sent in 10s at 1000 bytes/s (%v expected)", sent, expected)
+	}
+}
+
+func Test_Pacer_NoOverdraft(t *testing.T) {
+	now := time.Unix(0, 0)
+	// One packet every millisecond
+	sender := &testpacingsender{NewCubicSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow), BandwidthFromDelta(MaxSegmentSize, time.Millisecond)}
+	pacer := NewPacer(sender)
+	pacer.SetInitialBurst(1)
+
+	pacer.OnPacketSent(now, MaxSegmentSize)
+	// Half of the tokens of a packet are not enough
+	now = now.Add(500 * time.Microsecond)
+	if d := pacer.TimeUntilSend(now, MaxSegmentSize); (d <= 0) || (d > 500*time.Microsecond) {
+		t.Errorf("Pacer.TimeUntilSend : invalid delay %v with half of the tokens of a packet", d)
+	}
+	// Polled every 10 microseconds, the packets are never closer than the pacing rate and the bucket is never overdrawn
+	last := now.Add(-500 * time.Microsecond)
+	for end := now.Add(100 * time.Millisecond); now.Before(end); now = now.Add(10 * time.Microsecond) {
+		if pacer.TimeUntilSend(now, MaxSegmentSize) == 0 {
+			pacer.OnPacketSent(now, MaxSegmentSize)
+			if pacer.tokens < 0 {
+				t.Fatalf("Pacer.OnPacketSent : bucket overdrawn by %v bytes", -pacer.tokens)
+			}
+			if gap := now.Sub(last); gap < time.Millisecond-10*time.Microsecond {
+				t.Fatalf("Pacer.TimeUntilSend : gap of %v between two packets at 1 packet/ms", gap)
+			}
+			last = now
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package congestion
+
+import "github.com/romain-jacotin/quic/protocol"
+
+// PrrMode is the Reduction Bound used by PRR when the pipe is below the slow start threshold.
+type PrrMode int
+
+const (
+	// PRR_SSRB is the Slow Start Reduction Bound: when pipe < ssthresh, PRR sends in slow start up to ssthresh
+	PRR_SSRB PrrMode = iota
+	// PRR_CRB is the Conservative Reduction Bound: when pipe < ssthresh, PRR strictly follows the packet conservation principle
+	PRR_CRB
+)
+
+// PrrSender implements the Proportional Rate Reduction algorithm of RFC6937 (see doc/TCPProportionalRateReduction.md).
+//
+// It determines the amount of data sent during loss recovery, so that the window at the end of recovery is as close as possible to the slow start threshold.
+type PrrSender struct {
+	mode                    PrrMode
+	bytesSentSinceLoss      protocol.QuicByteCount // prr_out
+	bytesDeliveredSinceLoss protocol.QuicByteCount // prr_delivered
+	bytesDeliveredLastAck   protocol.QuicByteCount // DeliveredData
+	bytesSentSinceLastAck   protocol.QuicByteCount // part of prr_out sent in response to the last ACK
+	bytesInFlightBeforeLoss protocol.QuicByteCount // RecoverFS
+}
+
+// NewPrrSender is a PrrSender factory.
+func NewPrrSender(mode PrrMode) *PrrSender {
+	return &PrrSender{mode: mode}
+}
+
+// GetMode returns the Reduction Bound mode.
+func (this *PrrSender) GetMode() PrrMode {
+	return this.mode
+}
+
+// SetMode sets the Reduction Bound mode.
+func (this *PrrSender) SetMode(mode PrrMode) {
+	this.mode = mode
+}
+
+// OnPacketLost initializes the PRR state at the beginning of recovery, 'priorInFlight' is the FlightSize at the start of recovery (RecoverFS).
+func (this *PrrSender) OnPacketLost(priorInFlight protocol.QuicByteCount) {
+	this.bytesSentSinceLoss = 0
+	this.bytesDeliveredSinceLoss = 0
+	this.bytesDeliveredLastAck = 0
+	this.bytesSentSinceLastAck = 0
+	this.bytesInFlightBeforeLoss = priorInFlight
+}
+
+// OnPacketSent must be called on any data transmission or retransmission during recovery.
+func (this *PrrSender) OnPacketSent(sentBytes protocol.QuicByteCount) {
+	this.bytesSentSinceLoss += sentBytes
+	this.bytesSentSinceLastAck += sentBytes
+}
+
+// OnPacketAcked must be called with the DeliveredData of each ACK received during recovery.
+func (this *PrrSender) OnPacketAcked(ackedBytes protocol.QuicByteCount) {
+	this.bytesDeliveredSinceLoss += ackedBytes
+	this.bytesDeliveredLastAck = ackedBytes
+	this.bytesSentSinceLastAck = 0
+}
+
+// GetSendCount returns 'sndcnt', the number of bytes that can be sent in response to the last ACK,
+// 'bytesInFlight' is the current pipe and 'slowstartThreshold' the target congestion window after recovery.
+//
+// The bytes already sent since the last ACK are taken into account, so that 'sndcnt' is computed once per ACK as in RFC6937.
+func (this *PrrSender) GetSendCount(bytesInFlight, slowstartThreshold protocol.QuicByteCount) protocol.QuicByteCount {
+	var sndcnt, limit protocol.QuicByteCount
+
+	if this.bytesInFlightBeforeLoss == 0 {
+		return 0
+	}
+	// Pipe and prr_out values when the last ACK was received
+	pipe := protocol.QuicByteCount(0)
+	if bytesInFlight > this.bytesSentSinceLastAck {
+		pipe = bytesInFlight - this.bytesSentSinceLastAck
+	}
+	prrOut := this.bytesSentSinceLoss - this.bytesSentSinceLastAck
+	if pipe > slowstartThreshold {
+		// Proportional Rate Reduction: sndcnt = CEIL(prr_delivered * ssthresh / RecoverFS) - prr_out
+		target := (this.bytesDeliveredSinceLoss*slowstartThreshold + this.bytesInFlightBeforeLoss - 1) / this.bytesInFlightBeforeLoss
+		if target > prrOut {
+			sndcnt = target - prrOut
+		}
+	} else {
+		// Reduction Bound
+		if this.bytesDeliveredSinceLoss > prrOut {
+			limit = this.bytesDeliveredSinceLoss - prrOut
+		}
+		if this.mode == PRR_SSRB {
+			// limit = MAX(prr_delivered - prr_out, DeliveredData) + MSS
+			if this.bytesDeliveredLastAck > limit {
+				limit = this.bytesDeliveredLastAck
+			}
+			limit += MaxSegmentSize
+		}
+		// Attempt to catch up, as permitted by limit: sndcnt = MIN(ssthresh - pipe, limit)
+		sndcnt = slowstartThreshold - pipe
+		if limit < sndcnt {
+			sndcnt = limit
+		}
+	}
+	if sndcnt > this.bytesSentSinceLastAck {
+		return sndcnt - this.bytesSentSinceLastAck
+	}
+	return 0
+}
+
+// CanSend returns true if a new packet can be sent during recovery.
+func (this *PrrSender) CanSend(bytesInFlight, slowstartThreshold protocol.QuicByteCount) bool {
+	// Always allow the fast retransmit, and never stall when less than one packet is in flight
+	if (this.bytesSentSinceLoss == 0) || (bytesInFlight < MaxSegmentSize) {
+		return true
+	}
+	return this.GetSendCount(bytesInFlight, slowstartThreshold) > 0
+}
@@ -0,0 +1,92 @@
+package congestion
+
+import "testing"
+import "github.com/romain-jacotin/quic/protocol"
+
+// testprrscenario describes one of the RFC6937 example scenarios (section 6): cwnd = FlightSize = 20 segments, so ssthresh = 10 segments.
+type testprrscenario struct {
+	name      string
+	mode      PrrMode
+	lost      int   // number of consecutive lost segments
+	acks      int   // number of ACKs received during recovery
+	sent      []int // expected number of segments sent on each ACK
+	finalPipe int   // expected pipe after the last ACK
+}
+
+var tests_prr = []testprrscenario{
+	// Single loss: PRR spreads the window reduction over the whole recovery, sending one segment every other ACK
+	{"single loss PRR-SSRB", PRR_SSRB, 1, 17,
+		[]int{1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 0}, 10},
+	{"single loss PRR-CRB", PRR_CRB, 1, 17,
+		[]int{1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 0}, 10},
+	// Burst of 15 losses: PRR-CRB strictly follows packet conservation (one segment per ACK)
+	{"burst loss PRR-CRB", PRR_CRB, 15, 10,
+		[]int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 5},
+	// Burst of 15 losses: PRR-SSRB sends two segments per ACK (slow start) until pipe reaches ssthresh
+	{"burst loss PRR-SSRB", PRR_SSRB, 15, 10,
+		[]int{2, 2, 2, 2, 2, 1, 1, 1, 1, 1}, 10},
+}
+
+func Test_PrrSender_RFC6937_Scenarios(t *testing.T) {
+	const cwnd = 20
+	const ssthresh = 10
+	mss := MaxSegmentSize
+
+	for _, v := range tests_prr {
+		prr := NewPrrSender(v.mode)
+		// Two new segments are sent by Limited Transmit on the first two duplicate ACKs,
+		// then the third duplicate ACK marks the 'lost' segments as lost and starts recovery.
+		pipe := protocol.QuicByteCount(cwnd) * mss
+		prr.OnPacketLost(cwnd * mss)
+		for i := 0; i < v.acks; i++ {
+			// Each ACK delivers one segment
+			pipe -= mss
+			if i == 0 {
+				pipe -= protocol.QuicByteCount(v.lost) * mss
+			}
+			prr.OnPacketAcked(mss)
+			sent := 0
+			for prr.CanSend(pipe, ssthresh*mss) && (sent < 3) {
+				prr.OnPacketSent(mss)
+				pipe += mss
+				sent++
+			}
+			if sent != v.sent[i] {
+				t.Errorf("PrrSender : %s, invalid number of segments sent %v on ACK n°%v (%v expected)", v.name, sent, i+3, v.sent[i])
+			}
+		}
+		if pipe != protocol.QuicByteCount(v.finalPipe)*mss {
+			t.Errorf("PrrSender : %s, invalid final pipe %v (%v expected)", v.name, pipe/mss, v.finalPipe)
+		}
+	}
+}
+
+func Test_PrrSender_GetSendCount(t *testing.T) {
+	mss := MaxSegmentSize
+	prr := NewPrrSender(PRR_SSRB)
+	prr.OnPacketLost(20 * mss)
+
+	// Proportional Rate Reduction when pipe > ssthresh
+	prr.OnPacketAcked(mss)
+	if n := prr.GetSendCount(18*mss, 10*mss); n != mss/2 {
+		t.Errorf("PrrSender.GetSendCount : invalid proportional send count %v (%v expected)", n, mss/2)
+	}
+	prr.OnPacketSent(mss)
+	prr.OnPacketAcked(mss)
+	if n := prr.GetSendCount(17*mss, 10*mss); n != 0 {
+		t.Errorf("PrrSender.GetSendCount : invalid proportional send count %v (0 expected)", n)
+	}
+	// Slow Start Reduction Bound when pipe <= ssthresh
+	if n := prr.GetSendCount(5*mss, 10*mss); n != 2*mss {
+		t.Errorf("PrrSender.GetSendCount : invalid PRR-SSRB send count %v (%v expected)", n, 2*mss)
+	}
+	// Conservative Reduction Bound when pipe <= ssthresh
+	prr.SetMode(PRR_CRB)
+	if n := prr.GetSendCount(5*mss, 10*mss); n != mss {
+		t.Errorf("PrrSender.GetSendCount : invalid PRR-CRB send count %v (%v expected)", n, mss)
+	}
+	// Never send more than ssthresh - pipe
+	if n := prr.GetSendCount(10*mss, 10*mss); n != 0 {
+		t.Errorf("PrrSender.GetSendCount : invalid send count %v at ssthresh (0 expected)", n)
+	}
+}
@@ -0,0 +1,166 @@
+package congestion
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+// RenoSender is the NewReno congestion controller of RFC5681 and RFC6582 (see doc/TCPCongestionControl.md): slow start, congestion avoidance and fast recovery.
+//
+// QUIC ACK frames remove acked packets from the bytes in flight, so the "inflation" of the congestion window by duplicate ACKs is not needed during fast recovery:
+// the congestion window is set to ssthresh when the loss is detected, and the recovery ends when a packet sent after the window reduction is acked (partial ACKs keep the sender in recovery as in NewReno).
+type RenoSender struct {
+	rttStats                 *RTTStats
+	congestionWindow         protocol.QuicByteCount
+	slowstartThreshold       protocol.QuicByteCount
+	minCongestionWindow      protocol.QuicByteCount
+	maxCongestionWindow      protocol.QuicByteCount
+	bytesAckedInAvoidance    protocol.QuicByteCount
+	largestSentSeqNum        protocol.QuicPacketSequenceNumber
+	largestAckedSeqNum       protocol.QuicPacketSequenceNumber
+	largestSentAtLastCutback protocol.QuicPacketSequenceNumber
+	receiveWindow            ReceiveWindow
+}
+
+// NewRenoSender is a RenoSender factory, 'initialCongestionWindow' and 'maxCongestionWindow' are expressed in packets.
+func NewRenoSender(rttStats *RTTStats, initialCongestionWindow, maxCongestionWindow int) *RenoSender {
+	return &RenoSender{
+		rttStats:            rttStats,
+		congestionWindow:    protocol.QuicByteCount(initialCongestionWindow) * MaxSegmentSize,
+		slowstartThreshold:  protocol.QuicByteCount(maxCongestionWindow) * MaxSegmentSize,
+		minCongestionWindow: MinimumCongestionWindow * MaxSegmentSize,
+		maxCongestionWindow: protocol.QuicByteCount(maxCongestionWindow) * MaxSegmentSize}
+}
+
+// OnPacketSent
+func (this *RenoSender) OnPacketSent(sentTime time.Time, bytesInFlight protocol.QuicByteCount, seqnum protocol.QuicPacketSequenceNumber, bytes protocol.QuicByteCount, retransmittable bool) {
+	if !retransmittable {
+		return
+	}
+	this.largestSentSeqNum = seqnum
+}
+
+// OnCongestionEvent
+func (this *RenoSender) OnCongestionEvent(eventTime time.Time, priorInFlight protocol.QuicByteCount, ackedPackets, lostPackets []PacketInfo) {
+	for _, p := range lostPackets {
+		this.onPacketLost(p.SequenceNumber, priorInFlight)
+	}
+	for _, p := range ackedPackets {
+		this.onPacketAcked(p.SequenceNumber, p.Bytes, priorInFlight)
+	}
+}
+
+// onPacketLost sets ssthresh = max(FlightSize/2, 2*SMSS) and cwnd = ssthresh.
+func (this *RenoSender) onPacketLost(seqnum protocol.QuicPacketSequenceNumber, priorInFlight protocol.QuicByteCount) {
+	// Only one window reduction per window of data
+	if seqnum <= this.largestSentAtLastCutback {
+		return
+	}
+	this.slowstartThreshold = priorInFlight / 2
+	if this.slowstartThreshold < this.minCongestionWindow {
+		this.slowstartThreshold = this.minCongestionWindow
+	}
+	this.congestionWindow = this.slowstartThreshold
+	this.bytesAckedInAvoidance = 0
+	this.largestSentAtLastCutback = this.largestSentSeqNum
+}
+
+// onPacketAcked
+func (this *RenoSender) onPacketAcked(seqnum protocol.QuicPacketSequenceNumber, ackedBytes, priorInFlight protocol.QuicByteCount) {
+	if seqnum > this.largestAckedSeqNum {
+		this.largestAckedSeqNum = seqnum
+	}
+	if this.InRecovery() {
+		// Fast recovery: the congestion window does not grow
+		return
+	}
+	if !this.isCongestionWindowLimited(priorInFlight) {
+		return
+	}
+	if this.congestionWindow >= this.maxCongestionWindow {
+		return
+	}
+	if this.InSlowStart() {
+		// Appropriate Byte Counting: cwnd += min(N, SMSS)
+		if ackedBytes > MaxSegmentSize {
+			ackedBytes = MaxSegmentSize
+		}
+		this.congestionWindow += ackedBytes
+	} else {
+		// Congestion avoidance: cwnd += SMSS each time a full window of data has been acked
+		this.bytesAckedInAvoidance += ackedBytes
+		if this.bytesAckedInAvoidance >= this.congestionWindow {
+			this.bytesAckedInAvoidance -= this.congestionWindow
+			this.congestionWindow += MaxSegmentSize
+		}
+	}
+	if this.congestionWindow > this.maxCongestionWindow {
+		this.congestionWindow = this.maxCongestionWindow
+	}
+}
+
+// isCongestionWindowLimited returns false when the sender is application limited, so that the congestion window does not grow without bounds.
+func (this *RenoSender) isCongestionWindowLimited(bytesInFlight protocol.QuicByteCount) bool {
+	if bytesInFlight >= this.congestionWindow {
+		return true
+	}
+	if this.InSlowStart() && (bytesInFlight > this.congestionWindow/2) {
+		return true
+	}
+	return this.congestionWindow-bytesInFlight <= 3*MaxSegmentSize
+}
+
+// OnPacketNeutered does nothing, the congestion window only depends on the acknowledged and lost packets.
+func (this *RenoSender) OnPacketNeutered(seqnum protocol.QuicPacketSequenceNumber) {
+}
+
+// OnRetransmissionTimeout sets cwnd to the loss window (one segment) and restarts slow start.
+func (this *RenoSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	this.largestSentAtLastCutback = 0
+	if !packetsRetransmitted {
+		return
+	}
+	this.slowstartThreshold = this.congestionWindow / 2
+	if this.slowstartThreshold < this.minCongestionWindow {
+		this.slowstartThreshold = this.minCongestionWindow
+	}
+	this.congestionWindow = MaxSegmentSize
+	this.bytesAckedInAvoidance = 0
+}
+
+// CanSend
+func (this *RenoSender) CanSend(bytesInFlight protocol.QuicByteCount) bool {
+	return (bytesInFlight < this.congestionWindow) && this.receiveWindow.CanSend(bytesInFlight)
+}
+
+// OnIncomingCongestionFeedback limits the bytes in flight to the receive window of the TCP feedback, the inter-arrival feedback is not used.
+func (this *RenoSender) OnIncomingCongestionFeedback(frame *protocol.QuicFrame, feedbackTime time.Time) {
+	this.receiveWindow.OnCongestionFeedback(frame)
+}
+
+// GetCongestionWindow
+func (this *RenoSender) GetCongestionWindow() protocol.QuicByteCount {
+	return this.congestionWindow
+}
+
+// GetSlowStartThreshold
+func (this *RenoSender) GetSlowStartThreshold() protocol.QuicByteCount {
+	return this.slowstartThreshold
+}
+
+// GetPacingRate returns twice the estimated bandwidth in slow start and 1.25 times the estimated bandwidth otherwise.
+func (this *RenoSender) GetPacingRate() Bandwidth {
+	bw := BandwidthFromDelta(this.congestionWindow, this.rttStats.GetSmoothedRTT())
+	if this.InSlowStart() {
+		return 2 * bw
+	}
+	return bw * 5 / 4
+}
+
+// InSlowStart
+func (this *RenoSender) InSlowStart() bool {
+	return this.congestionWindow < this.slowstartThreshold
+}
+
+// InRecovery
+func (this *RenoSender) InRecovery() bool {
+	return (this.largestSentAtLastCutback != 0) && (this.largestAckedSeqNum <= this.largestSentAtLastCutback)
+}
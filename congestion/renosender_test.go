@@ -0,0 +1,89 @@
+package congestion
+
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+func Test_RenoSender_SlowStartAndCongestionAvoidance(t *testing.T) {
+	now := time.Now()
+	sender := NewRenoSender(NewRTTStats(), DefaultInitialCongestionWindow, DefaultMaxCongestionWindow)
+
+	if !sender.InSlowStart() {
+		t.Error("RenoSender : must start in slow start")
+	}
+	// One round trip in slow start doubles the congestion window
+	for i := protocol.QuicPacketSequenceNumber(1); i <= DefaultInitialCongestionWindow; i++ {
+		sender.OnPacketSent(now, 0, i, MaxSegmentSize, true)
+	}
+	for i := protocol.QuicPacketSequenceNumber(1); i <= DefaultInitialCongestionWindow; i++ {
+		sender.OnCongestionEvent(now, sender.GetCongestionWindow(), []PacketInfo{{i, MaxSegmentSize}}, nil)
+	}
+	if sender.GetCongestionWindow() != 2*DefaultInitialCongestionWindow*MaxSegmentSize {
+		t.Errorf("RenoSender : invalid congestion window %v after one round trip in slow start", sender.GetCongestionWindow())
+	}
+	// Loss of packet 11 while 20 packets are in flight: ssthresh = cwnd = FlightSize / 2
+	for i := protocol.QuicPacketSequenceNumber(11); i <= 30; i++ {
+		sender.OnPacketSent(now, 0, i, MaxSegmentSize, true)
+	}
+	sender.OnCongestionEvent(now, 20*MaxSegmentSize, nil, []PacketInfo{{11, MaxSegmentSize}})
+	if !sender.InRecovery() {
+		t.Error("RenoSender : must be in fast recovery after a loss")
+	}
+	if (sender.GetCongestionWindow() != 10*MaxSegmentSize) || (sender.GetSlowStartThreshold() != 10*MaxSegmentSize) {
+		t.Errorf("RenoSender : invalid cwnd %v or ssthresh %v after loss", sender.GetCongestionWindow(), sender.GetSlowStartThreshold())
+	}
+	// Partial ACKs keep the sender in recovery, and a second loss in the same window is ignored
+	sender.OnCongestionEvent(now, 19*MaxSegmentSize, []PacketInfo{{12, MaxSegmentSize}}, []PacketInfo{{13, MaxSegmentSize}})
+	if !sender.InRecovery() || (sender.GetCongestionWindow() != 10*MaxSegmentSize) {
+		t.Errorf("RenoSender : invalid congestion window %v or state after partial ACK", sender.GetCongestionWindow())
+	}
+	// The ACK of a packet sent after the loss ends the recovery
+	sender.OnPacketSent(now, 0, 31, MaxSegmentSize, true)
+	sender.OnCongestionEvent(now, 10*MaxSegmentSize, []PacketInfo{{31, MaxSegmentSize}}, nil)
+	if sender.InRecovery() || sender.InSlowStart() {
+		t.Error("RenoSender : must be in congestion avoidance after recovery")
+	}
+	// Congestion avoidance: cwnd grows by one segment per window of acked data
+	cwnd := sender.GetCongestionWindow()
+	for i := protocol.QuicPacketSequenceNumber(32); i < 32+10; i++ {
+		sender.OnPacketSent(now, 0, i, MaxSegmentSize, true)
+		sender.OnCongestionEvent(now, sender.GetCongestionWindow(), []PacketInfo{{i, MaxSegmentSize}}, nil)
+	}
+	if sender.GetCongestionWindow() != cwnd+MaxSegmentSize {
+		t.Errorf("RenoSender : invalid congestion window %v after one window acked in congestion avoidance (%v expected)", sender.GetCongestionWindow(), cwnd+MaxSegmentSize)
+	}
+}
+
+func Test_RenoSender_RetransmissionTimeout(t *testing.T) {
+	sender := NewRenoSender(NewRTTStats(), 20, DefaultMaxCongestionWindow)
+
+	sender.OnRetransmissionTimeout(false)
+	if sender.GetCongestionWindow() != 20*MaxSegmentSize {
+		t.Errorf("RenoSender : invalid congestion window %v after spurious RTO", sender.GetCongestionWindow())
+	}
+	// Loss window is one full-sized segment
+	sender.OnRetransmissionTimeout(true)
+	if sender.GetCongestionWindow() != MaxSegmentSize {
+		t.Errorf("RenoSender : invalid congestion window %v after RTO", sender.GetCongestionWindow())
+	}
+	if sender.GetSlowStartThreshold() != 10*MaxSegmentSize {
+		t.Errorf("RenoSender : invalid slow start threshold %v after RTO", sender.GetSlowStartThreshold())
+	}
+	if !sender.InSlowStart() {
+		t.Error("RenoSender : must be in slow start after RTO")
+	}
+}
+
+func Test_NewSendAlgorithm(t *testing.T) {
+	rtt := NewRTTStats()
+
+	if _, ok := NewSendAlgorithm(nil, rtt).(*CubicSender); !ok {
+		t.Error("NewSendAlgorithm : CUBIC must be the default congestion control")
+	}
+	if _, ok := NewSendAlgorithm([]protocol.MessageTag{protocol.TagRENO}, rtt).(*RenoSender); !ok {
+		t.Error("NewSendAlgorithm : TagRENO must select NewReno")
+	}
+	if _, ok := NewSendAlgorithm([]protocol.MessageTag{protocol.TagICSL, protocol.TagQBIC, protocol.TagRENO}, rtt).(*CubicSender); !ok {
+		t.Error("NewSendAlgorithm : the first congestion control option must be used")
+	}
+}
@@ -0,0 +1,89 @@
+package congestion
+
+import "time"
+
+// DefaultInitialRTT is the RTT used before any RTT measurement is made.
+const DefaultInitialRTT = 100 * time.Millisecond
+
+// RTTStats computes the smoothed RTT and its mean deviation as described in RFC6298 (see doc/TCPRetransmissionTimer.md)
+type RTTStats struct {
+	initialRTT    time.Duration
+	latestRTT     time.Duration
+	minRTT        time.Duration
+	smoothedRTT   time.Duration
+	meanDeviation time.Duration
+}
+
+// NewRTTStats is a RTTStats factory.
+func NewRTTStats() *RTTStats {
+	return &RTTStats{initialRTT: DefaultInitialRTT}
+}
+
+// SetInitialRTT sets the RTT used before any RTT measurement is made (see TagIRTT).
+func (this *RTTStats) SetInitialRTT(rtt time.Duration) {
+	if rtt > 0 {
+		this.initialRTT = rtt
+	}
+}
+
+// UpdateRTT updates the RTT statistics with a new RTT sample 'sendDelta' measured between the sending of a packet and the reception of its ACK,
+// the 'ackDelay' reported by the peer is substracted from the sample if possible.
+func (this *RTTStats) UpdateRTT(sendDelta, ackDelay time.Duration) {
+	if sendDelta <= 0 {
+		return
+	}
+	// Min RTT is never corrected by the ack delay
+	if (this.minRTT == 0) || (sendDelta < this.minRTT) {
+		this.minRTT = sendDelta
+	}
+	rtt := sendDelta
+	if rtt > ackDelay {
+		rtt -= ackDelay
+	}
+	this.latestRTT = rtt
+	if this.smoothedRTT == 0 {
+		// First RTT measurement
+		this.smoothedRTT = rtt
+		this.meanDeviation = rtt / 2
+		return
+	}
+	// RTTVAR = (1 - beta) * RTTVAR + beta * |SRTT - R'|  with beta = 1/4
+	delta := this.smoothedRTT - rtt
+	if delta < 0 {
+		delta = -delta
+	}
+	this.meanDeviation = (3*this.meanDeviation + delta) / 4
+	// SRTT = (1 - alpha) * SRTT + alpha * R'  with alpha = 1/8
+	this.smoothedRTT = (7*this.smoothedRTT + rtt) / 8
+}
+
+// HasMeasurement returns true if at least one RTT sample has been taken.
+func (this *RTTStats) HasMeasurement() bool {
+	return this.smoothedRTT != 0
+}
+
+// GetSmoothedRTT returns the smoothed RTT, or the initial RTT if no measurement has been made.
+func (this *RTTStats) GetSmoothedRTT() time.Duration {
+	if this.smoothedRTT == 0 {
+		return this.initialRTT
+	}
+	return this.smoothedRTT
+}
+
+// GetLatestRTT returns the latest RTT sample.
+func (this *RTTStats) GetLatestRTT() time.Duration {
+	return this.latestRTT
+}
+
+// GetMinRTT returns the minimum RTT sample, or the initial RTT if no measurement has been made.
+func (this *RTTStats) GetMinRTT() time.Duration {
+	if this.minRTT == 0 {
+		return this.initialRTT
+	}
+	return this.minRTT
+}
+
+// GetMeanDeviation returns the RTT mean deviation (RTTVAR).
+func (this *RTTStats) GetMeanDeviation() time.Duration {
+	return this.meanDeviation
+}
@@ -0,0 +1,177 @@
+package quic
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// handshakeTimeout is the maximum duration of the crypto handshake
+	handshakeTimeout = 10 * time.Second
+	// handshakeIdleTimeout is the maximum duration without network activity before the crypto handshake is complete
+	handshakeIdleTimeout = 5 * time.Second
+)
+
+// supportedCongestionFeedback are the types of CONGESTION_FEEDBACK frames supported (value of TagCGST), in order of preference:
+// TagQBIC for the TCP feedback of the receive window and TagINAR for the inter-arrival feedback.
+var supportedCongestionFeedback = []protocol.MessageTag{protocol.TagQBIC, protocol.TagINAR}
+
+// selectCongestionFeedback returns the first type of CONGESTION_FEEDBACK frames of the list that is supported, or zero.
+func selectCongestionFeedback(tags []protocol.MessageTag) protocol.MessageTag {
+	for _, tag := range tags {
+		for _, supported := range supportedCongestionFeedback {
+			if tag == supported {
+				return tag
+			}
+		}
+	}
+	return 0
+}
+
+// cryptoStream carries the crypto handshake Messages on the reserved crypto stream: the Messages sent are serialized in the send buffer of the stream,
+// the data received are reassembled in stream order and fed to a MessageDecoder.
+//
+// The crypto stream is not registered with the other streams of the session: it doesn't count in the maximum number of open streams,
+// is never collected and its data are not subject to the connection flow control.
+type cryptoStream struct {
+	*StreamConn
+	decoder *protocol.MessageDecoder
+}
+
+// newCryptoStream is a cryptoStream factory.
+func newCryptoStream(session *QUICSession) *cryptoStream {
+	return &cryptoStream{
+		StreamConn: newStreamConn(session, cryptoStreamID),
+		decoder:    protocol.NewMessageDecoder()}
+}
+
+// writeMessage appends the serialized Message to the send buffer of the crypto stream.
+func (c *cryptoStream) writeMessage(msg *protocol.Message) error {
+	data, err := msg.GetSerialize()
+	if err != nil {
+		return err
+	}
+	c.chunks = append(c.chunks, streamChunk{data, writeStandard})
+	c.buffered += len(data)
+	return nil
+}
+
+// onStreamFrame inserts the data of a STREAM frame of the crypto stream in the receive buffer and returns the Messages completed in stream order.
+// The error is a flow control violation or an invalid Message, the Messages decoded before the error are returned with it.
+func (c *cryptoStream) onStreamFrame(frame *protocol.QuicFrame) (msgs []*protocol.Message, err *protocol.QuicError) {
+	if frame.GetFinFlag() {
+		return nil, protocol.NewQuicError(protocol.QUIC_INVALID_STREAM_DATA, "crypto stream closed")
+	}
+	end := frame.GetByteOffset() + protocol.QuicByteOffset(len(frame.GetData()))
+	if end > c.receiveWindow {
+		return nil, protocol.NewQuicError(protocol.QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "stream flow control window exceeded")
+	}
+	if end > c.highestReceived {
+		c.highestReceived = end
+	}
+	if e := c.StreamConn.onStreamFrame(frame); e != nil {
+		return nil, e.(*protocol.QuicError)
+	}
+	buffer := make([]byte, maxReceivedPacketSize)
+	for {
+		n := c.read(buffer)
+		if n == 0 {
+			return
+		}
+		m, e := c.decoder.Feed(buffer[:n])
+		msgs = append(msgs, m...)
+		if e != nil {
+			return msgs, e.(*protocol.QuicError)
+		}
+	}
+}
+
+// newTransportParameters returns the local values of the parameters negotiated with the peer: ICSL, MSPC and SCLS.
+func (s *QUICSession) newTransportParameters() (icsl, mspc uint32, scls bool) {
+	return uint32(s.idleTimeout / time.Second), uint32(s.maxStreams), s.silentClose
+}
+
+// sendHandshakeMessage queues a handshake Message on the crypto stream.
+func (s *QUICSession) sendHandshakeMessage(msg *protocol.Message) {
+	if err := s.crypto.writeMessage(msg); err != nil {
+		s.connectionError(protocol.QUIC_CRYPTO_INTERNAL_ERROR, err.Error())
+		return
+	}
+	s.signal()
+}
+
+// onCryptoStreamFrame delivers the data of a STREAM frame of the crypto stream, the handshake Messages completed are processed in order.
+func (s *QUICSession) onCryptoStreamFrame(frame *protocol.QuicFrame) {
+	msgs, err := s.crypto.onStreamFrame(frame)
+	for _, msg := range msgs {
+		if s.closed {
+			return
+		}
+		s.onHandshakeMessage(msg)
+	}
+	if err != nil {
+		s.closeWithError(err, true)
+		return
+	}
+	c := s.crypto
+	if readOffset := c.received.GetReadOffset(); c.receiveWindow-readOffset < c.receiveWindowSize/2 {
+		c.receiveWindow = readOffset + c.receiveWindowSize
+		s.queueWindowUpdate(c.id, c.receiveWindow)
+	}
+}
+
+// onHandshakeMessage processes a handshake Message: the server answers the CHLO of the client with a SHLO, and both apply the parameters of the peer.
+// The handshake is complete once the CHLO is received on server side, and once the SHLO is received on client side.
+func (s *QUICSession) onHandshakeMessage(msg *protocol.Message) {
+	if s.handshakeComplete {
+		if s.isClient && msg.IsMessageTag(protocol.TagSCUP) {
+			// Server config updates are not used without encryption
+			return
+		}
+		s.connectionError(protocol.QUIC_CRYPTO_MESSAGE_AFTER_HANDSHAKE_COMPLETE, "handshake message after handshake complete")
+		return
+	}
+	expected := protocol.MessageTag(protocol.TagCHLO)
+	if s.isClient {
+		expected = protocol.TagSHLO
+	}
+	if !msg.IsMessageTag(expected) {
+		s.connectionError(protocol.QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "unexpected handshake message")
+		return
+	}
+	var icsl, mspc uint32
+	var scls bool
+	var err error
+	if s.isClient {
+		var shlo protocol.SHLO
+		err = shlo.Unmarshal(msg)
+		icsl, mspc, scls = shlo.ICSL, shlo.MSPC, shlo.SCLS
+		s.congestionFeedback = selectCongestionFeedback([]protocol.MessageTag{shlo.CGST})
+	} else {
+		var chlo protocol.CHLO
+		if err = chlo.Unmarshal(msg); (err == nil) && (len(chlo.COPT) > 0) {
+			s.setConnectionOptions(chlo.COPT)
+		}
+		icsl, mspc, scls = chlo.ICSL, chlo.MSPC, chlo.SCLS
+		s.congestionFeedback = selectCongestionFeedback(chlo.CGST)
+	}
+	if err != nil {
+		s.closeWithError(err.(*protocol.QuicError), true)
+		return
+	}
+	s.setIdleTimeout(icsl, scls)
+	s.setMaxStreams(mspc)
+	if !s.isClient {
+		shlo := protocol.SHLO{}
+		shlo.ICSL, shlo.MSPC, shlo.SCLS = s.newTransportParameters()
+		shlo.CGST = s.congestionFeedback
+		s.sendHandshakeMessage(shlo.Marshal())
+	}
+	s.handshakeComplete = true
+}
+
+// getHandshakeAlarm returns the time at which the session is closed if the handshake is not complete, or zero time.
+func (s *QUICSession) getHandshakeAlarm() time.Time {
+	if s.handshakeComplete {
+		return time.Time{}
+	}
+	return s.handshakeStart.Add(handshakeTimeout)
+}
@@ -0,0 +1,98 @@
+package quic
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// DefaultDuplicateCount is the default number of copies of the packets sent with StreamConn.WriteDuplicate
+	DefaultDuplicateCount = 2
+	// MaxDuplicateCount is the maximum number of copies of the packets sent with StreamConn.WriteDuplicate
+	MaxDuplicateCount = 8
+	// minDuplicateInterval is the minimum duration between two copies of a packet, so that a burst of losses doesn't drop all the copies
+	minDuplicateInterval = time.Millisecond
+)
+
+// pendingDuplicate is a copy of a packet sent with StreamConn.WriteDuplicate, waiting for its send time.
+type pendingDuplicate struct {
+	sendTime time.Time
+	payload  []byte
+	frames   []*protocol.QuicFrame
+	set      *duplicateSet
+}
+
+// getDuplicateInterval returns the duration between two copies of a packet: the transfer time of a full-sized packet at the pacing rate,
+// but at least the minimum pacing of the session.
+func (s *QUICSession) getDuplicateInterval() time.Duration {
+	interval := s.sendAlgorithm.GetPacingRate().TransferTime(MaxPacketSize)
+	if gap := s.pacer.GetMinimumGap(); interval < gap {
+		interval = gap
+	}
+	if interval < minDuplicateInterval {
+		interval = minDuplicateInterval
+	}
+	return interval
+}
+
+// getDuplicateAlarm returns the send time of the next copy of a packet, or zero time.
+func (s *QUICSession) getDuplicateAlarm() (alarm time.Time) {
+	for _, d := range s.duplicates {
+		if alarm.IsZero() || d.sendTime.Before(alarm) {
+			alarm = d.sendTime
+		}
+	}
+	return
+}
+
+// isDuplicateDue returns true if a copy of a packet must be sent.
+func (s *QUICSession) isDuplicateDue(now time.Time) bool {
+	alarm := s.getDuplicateAlarm()
+	return !alarm.IsZero() && !now.Before(alarm)
+}
+
+// sendDuplicatePacket sends the first copy of a packet of stream data written with StreamConn.WriteDuplicate and schedules the other copies.
+// It returns false if no stream data can be sent.
+func (s *QUICSession) sendDuplicatePacket(now time.Time) bool {
+	var frames []*protocol.QuicFrame
+	var privateHeader protocol.QuicPrivateHeader
+
+	room := s.maxPayloadSize(&privateHeader)
+	s.addStreamFrames(writeDuplicate, &room, func(frame *protocol.QuicFrame) {
+		frames = append(frames, frame)
+		room -= frame.GetSerializedSize()
+	})
+	if len(frames) == 0 {
+		return false
+	}
+	payload := serializeFrames(frames)
+	set := &duplicateSet{outstanding: s.duplicateCount}
+	interval := s.getDuplicateInterval()
+	for i := 1; i < s.duplicateCount; i++ {
+		s.duplicates = append(s.duplicates, &pendingDuplicate{
+			sendTime: now.Add(time.Duration(i) * interval),
+			payload:  payload,
+			frames:   frames,
+			set:      set})
+	}
+	s.writePacket(now, &privateHeader, payload, frames, set)
+	return true
+}
+
+// sendDuplicateCopy sends the earliest copy of a packet waiting for its send time, the copy is dropped if another copy has already been acknowledged.
+func (s *QUICSession) sendDuplicateCopy(now time.Time) {
+	var privateHeader protocol.QuicPrivateHeader
+
+	i := 0
+	for j, d := range s.duplicates {
+		if d.sendTime.Before(s.duplicates[i].sendTime) {
+			i = j
+		}
+	}
+	d := s.duplicates[i]
+	s.duplicates = append(s.duplicates[:i], s.duplicates[i+1:]...)
+	if d.set.acked {
+		d.set.outstanding--
+		return
+	}
+	s.stats.packetsDuplicated++
+	s.writePacket(now, &privateHeader, d.payload, d.frames, d.set)
+}
@@ -0,0 +1,93 @@
+package quic
+
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// DefaultFECGroupSize is the default number of packets protected by a FEC packet
+	DefaultFECGroupSize = 10
+	// MaxFECGroupSize is the maximum number of packets protected by a FEC packet (the FEC Group Number offset is 8-bit)
+	MaxFECGroupSize = 255
+	// maxFECGroupDistance is the distance in sequence numbers after which an incomplete FEC group is forgotten by the receiver
+	maxFECGroupDistance = 2 * MaxFECGroupSize
+)
+
+// fecGroup is a FEC group: the XOR of the payloads (private header excluded) of its protected packets is the redundancy of its FEC packet.
+//
+// On sender side the group is open until the FEC packet is sent, on receiver side one missing protected packet can be revived when the FEC packet is received.
+type fecGroup struct {
+	// first is the sequence number of the first protected packet of the group
+	first protocol.QuicPacketSequenceNumber
+	// last is the sequence number of the last protected packet of the group, known when the FEC packet is received
+	last       protocol.QuicPacketSequenceNumber
+	fecPacket  bool
+	received   map[protocol.QuicPacketSequenceNumber]bool
+	count      int
+	redundancy []byte
+	openTime   time.Time
+}
+
+// newFECGroup is a fecGroup factory.
+func newFECGroup(first protocol.QuicPacketSequenceNumber, now time.Time) *fecGroup {
+	return &fecGroup{
+		first:    first,
+		received: make(map[protocol.QuicPacketSequenceNumber]bool),
+		openTime: now}
+}
+
+// update adds the payload to the redundancy, shorter payloads are padded with zero bytes.
+func (this *fecGroup) update(payload []byte) {
+	if len(payload) > len(this.redundancy) {
+		redundancy := make([]byte, len(payload))
+		copy(redundancy, this.redundancy)
+		this.redundancy = redundancy
+	}
+	for i, b := range payload {
+		this.redundancy[i] ^= b
+	}
+}
+
+// OnProtectedPacket adds a protected packet of the group, it returns false if the packet was already added.
+func (this *fecGroup) OnProtectedPacket(seqnum protocol.QuicPacketSequenceNumber, payload []byte) bool {
+	if this.received[seqnum] {
+		return false
+	}
+	this.received[seqnum] = true
+	this.count++
+	this.update(payload)
+	return true
+}
+
+// OnFECPacket adds the FEC packet of the group.
+func (this *fecGroup) OnFECPacket(seqnum protocol.QuicPacketSequenceNumber, redundancy []byte) {
+	if this.fecPacket {
+		return
+	}
+	this.fecPacket = true
+	this.last = seqnum - 1
+	this.update(redundancy)
+}
+
+// IsComplete returns true if the FEC packet and all the protected packets of the group are received.
+func (this *fecGroup) IsComplete() bool {
+	return this.fecPacket && (this.count == int(this.last-this.first+1))
+}
+
+// CanRevive returns true if the FEC packet is received and exactly one protected packet of the group is missing.
+func (this *fecGroup) CanRevive() bool {
+	return this.fecPacket && (this.count == int(this.last-this.first))
+}
+
+// Revive returns the sequence number and the payload (padded with zero bytes) of the missing protected packet.
+func (this *fecGroup) Revive() (seqnum protocol.QuicPacketSequenceNumber, payload []byte) {
+	for seqnum = this.first; seqnum <= this.last; seqnum++ {
+		if !this.received[seqnum] {
+			break
+		}
+	}
+	payload = make([]byte, len(this.redundancy))
+	copy(payload, this.redundancy)
+	this.received[seqnum] = true
+	this.count++
+	return
+}
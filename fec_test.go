@@ -0,0 +1,63 @@
+package quic
+
+import "bytes"
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+func Test_fecGroup_Revive(t *testing.T) {
+	payloads := [][]byte{
+		{0x80, 0x03, 0x01, 0x00, 0x11, 0x22, 0x33},
+		{0x80, 0x03, 0x05, 0x00, 0xaa, 0xbb, 0xcc, 0xdd, 0xee},
+		{0x07},
+		{0x80, 0x03, 0x0a, 0x00, 0x44, 0x55}}
+
+	// Sender side
+	now := time.Now()
+	sender := newFECGroup(10, now)
+	for _, p := range payloads {
+		sender.update(p)
+	}
+	if len(sender.redundancy) != 9 {
+		t.Fatalf("fecGroup.update : invalid redundancy size %v (9 expected)", len(sender.redundancy))
+	}
+	// Receiver side: each packet of the group can be revived
+	for missing := range payloads {
+		receiver := newFECGroup(10, now)
+		for i, p := range payloads {
+			if i != missing {
+				receiver.OnProtectedPacket(protocol.QuicPacketSequenceNumber(10+i), p)
+			}
+		}
+		if receiver.CanRevive() {
+			t.Errorf("fecGroup.CanRevive : can't revive without FEC packet")
+		}
+		receiver.OnFECPacket(14, sender.redundancy)
+		if !receiver.CanRevive() {
+			t.Fatalf("fecGroup.CanRevive : must revive packet n°%v", 10+missing)
+		}
+		seqnum, payload := receiver.Revive()
+		if seqnum != protocol.QuicPacketSequenceNumber(10+missing) {
+			t.Errorf("fecGroup.Revive : invalid revived packet n°%v (n°%v expected)", seqnum, 10+missing)
+		}
+		expected := make([]byte, len(sender.redundancy))
+		copy(expected, payloads[missing])
+		if !bytes.Equal(payload, expected) {
+			t.Errorf("fecGroup.Revive : invalid revived payload %x (%x expected)", payload, expected)
+		}
+		if !receiver.IsComplete() {
+			t.Errorf("fecGroup.IsComplete : group must be complete after revival")
+		}
+	}
+	// Two missing packets can't be revived
+	receiver := newFECGroup(10, now)
+	receiver.OnProtectedPacket(10, payloads[0])
+	receiver.OnProtectedPacket(12, payloads[2])
+	if receiver.OnProtectedPacket(12, payloads[2]) {
+		t.Errorf("fecGroup.OnProtectedPacket : duplicate packet must be ignored")
+	}
+	receiver.OnFECPacket(14, sender.redundancy)
+	if receiver.CanRevive() || receiver.IsComplete() {
+		t.Errorf("fecGroup.CanRevive : can't revive two missing packets")
+	}
+}
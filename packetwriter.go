@@ -0,0 +1,44 @@
+package quic
+
+import "net"
+import "time"
+import "github.com/romain-jacotin/quic/congestion"
+import "github.com/romain-jacotin/quic/protocol"
+
+// packetConn is the part of the UDP socket used by a session to send its packets.
+type packetConn interface {
+	WriteTo(b []byte, addr net.Addr) (int, error)
+}
+
+// packetWriter writes the packets of a session on the UDP socket at the pace given by the Pacer of the session.
+type packetWriter struct {
+	conn  packetConn
+	raddr net.Addr
+	pacer *congestion.Pacer
+}
+
+// newPacketWriter is a packetWriter factory.
+func newPacketWriter(conn packetConn, raddr net.Addr, pacer *congestion.Pacer) *packetWriter {
+	return &packetWriter{
+		conn:  conn,
+		raddr: raddr,
+		pacer: pacer}
+}
+
+// TimeUntilSend returns the delay before the pacer allows the next packet, 'bytesInFlight' is the number of bytes in flight before sending the packet.
+func (w *packetWriter) TimeUntilSend(now time.Time, bytesInFlight protocol.QuicByteCount) time.Duration {
+	return w.pacer.TimeUntilSend(now, bytesInFlight)
+}
+
+// WritePacket writes the packet on the UDP socket without waiting, 'paced' is true if the packet consumes the pacing budget (packets in flight).
+// It returns the time at which the packet was sent.
+func (w *packetWriter) WritePacket(data []byte, paced bool) (time.Time, error) {
+	if _, err := w.conn.WriteTo(data, w.raddr); err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	if paced {
+		w.pacer.OnPacketSent(now, protocol.QuicByteCount(len(data)))
+	}
+	return now, nil
+}
@@ -0,0 +1,450 @@
+package protocol
+
+import "encoding/binary"
+
+// Size of the client nonce (NONC) in a full CHLO.
+const clientNonceSize = 32
+
+// Size of the server config ID (SCID).
+const serverConfigIDSize = 16
+
+// Size of the server orbit (ORBT).
+const serverOrbitSize = 8
+
+// CHLO is the strongly typed version of a client hello Message.
+//
+// The optional tags are absent from the Message when their field has the zero value.
+// An inchoate CHLO has no SCID, a full CHLO has a SCID and must also contain AEAD, KEXS, NONC and PUBS.
+type CHLO struct {
+	SNI  string       // Server Name Indication
+	STK  []byte       // Source-address token
+	PDMD []MessageTag // Proof demand (TagX509, TagX59R)
+	CCS  []uint64     // Common certificate sets hashes
+	CCRT []uint64     // Cached certificates hashes
+	VERS QuicVersion  // Version used by the client
+	SCID []byte       // Server config ID
+	AEAD MessageTag   // Authenticated encryption algorithm selected from the server config
+	KEXS MessageTag   // Key exchange algorithm selected from the server config
+	NONC []byte       // Client nonce
+	SNO  []byte       // Server nonce echoed by the client
+	PUBS []byte       // Client public value for the selected key exchange algorithm
+	CETV []byte       // Client encrypted tag-values
+	COPT []MessageTag // Connection options
+	CGST []MessageTag // Congestion control feedback types supported, in order of preference (TagQBIC, TagINAR)
+	ICSL uint32       // Idle connection state lifetime in seconds (required)
+	MSPC uint32       // Max streams per connection (required)
+	SCLS bool         // Silently close on timeout
+	IRTT uint32       // Estimated initial RTT in microseconds
+	SFCW uint32       // Initial stream flow control receive window
+	CFCW uint32       // Initial connection flow control receive window
+}
+
+// IsInchoate returns true if the CHLO doesn't contain a server config ID.
+func (this *CHLO) IsInchoate() bool {
+	return len(this.SCID) == 0
+}
+
+// Marshal returns the CHLO Message.
+func (this *CHLO) Marshal() *Message {
+	msg := NewMessage(TagCHLO)
+	setBytes(msg, TagSNI, []byte(this.SNI))
+	setBytes(msg, TagSTK, this.STK)
+	if len(this.PDMD) > 0 {
+		msg.SetTagList(TagPDMD, this.PDMD)
+	}
+	if len(this.CCS) > 0 {
+		msg.SetUint64List(TagCCS, this.CCS)
+	}
+	if len(this.CCRT) > 0 {
+		msg.SetUint64List(TagCCRT, this.CCRT)
+	}
+	if this.VERS != 0 {
+		msg.SetUint32(TagVERS, uint32(this.VERS))
+	}
+	setBytes(msg, TagSCID, this.SCID)
+	if this.AEAD != 0 {
+		msg.SetUint32(TagAEAD, uint32(this.AEAD))
+	}
+	if this.KEXS != 0 {
+		msg.SetUint32(TagKEXS, uint32(this.KEXS))
+	}
+	setBytes(msg, TagNONC, this.NONC)
+	setBytes(msg, TagSNO, this.SNO)
+	if len(this.PUBS) > 0 {
+		msg.SetPUBS([][]byte{this.PUBS})
+	}
+	setBytes(msg, TagCETV, this.CETV)
+	if len(this.COPT) > 0 {
+		msg.SetTagList(TagCOPT, this.COPT)
+	}
+	if len(this.CGST) > 0 {
+		msg.SetTagList(TagCGST, this.CGST)
+	}
+	setTransportParameters(msg, this.ICSL, this.MSPC, this.SCLS, this.SFCW, this.CFCW)
+	if this.IRTT != 0 {
+		msg.SetUint32(TagIRTT, this.IRTT)
+	}
+	return msg
+}
+
+// Unmarshal sets the CHLO fields with the tag-values of the Message.
+// The error is a *QuicError with the code QUIC_INVALID_CRYPTO_MESSAGE_TYPE, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER.
+func (this *CHLO) Unmarshal(msg *Message) (err error) {
+	var v uint32
+
+	if !msg.IsMessageTag(TagCHLO) {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "CHLO.Unmarshal : not a CHLO message")
+	}
+	*this = CHLO{}
+	_, sni := msg.ContainsTag(TagSNI)
+	this.SNI = string(sni)
+	_, this.STK = msg.ContainsTag(TagSTK)
+	if this.PDMD, err = getOptionalTagList(msg, TagPDMD); err != nil {
+		return
+	}
+	if this.CCS, err = getOptionalUint64List(msg, TagCCS); err != nil {
+		return
+	}
+	if this.CCRT, err = getOptionalUint64List(msg, TagCCRT); err != nil {
+		return
+	}
+	if v, err = getOptionalUint32(msg, TagVERS); err != nil {
+		return
+	}
+	this.VERS = QuicVersion(v)
+	_, this.SCID = msg.ContainsTag(TagSCID)
+	_, this.SNO = msg.ContainsTag(TagSNO)
+	_, this.CETV = msg.ContainsTag(TagCETV)
+	if this.COPT, err = getOptionalTagList(msg, TagCOPT); err != nil {
+		return
+	}
+	if this.CGST, err = getOptionalTagList(msg, TagCGST); err != nil {
+		return
+	}
+	if this.ICSL, this.MSPC, this.SCLS, this.SFCW, this.CFCW, err = getTransportParameters(msg); err != nil {
+		return
+	}
+	if this.IRTT, err = getOptionalUint32(msg, TagIRTT); err != nil {
+		return
+	}
+	if this.IsInchoate() {
+		return nil
+	}
+	// Full CHLO
+	if len(this.SCID) != serverConfigIDSize {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "CHLO.Unmarshal : invalid length of tag SCID")
+	}
+	if v, err = msg.GetUint32(TagAEAD); err != nil {
+		return
+	}
+	this.AEAD = MessageTag(v)
+	if v, err = msg.GetUint32(TagKEXS); err != nil {
+		return
+	}
+	this.KEXS = MessageTag(v)
+	if this.NONC, err = msg.getFixedSizeValue(TagNONC, clientNonceSize); err != nil {
+		return
+	}
+	pubs, err := msg.GetPUBS()
+	if err != nil {
+		return
+	}
+	if len(pubs) != 1 {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "CHLO.Unmarshal : PUBS must contain one public value")
+	}
+	this.PUBS = pubs[0]
+	return nil
+}
+
+// SHLO is the strongly typed version of a server hello Message.
+//
+// The optional tags are absent from the Message when their field has the zero value.
+type SHLO struct {
+	PUBS []byte        // Server ephemeral public value
+	STK  []byte        // Source-address token
+	SNO  []byte        // Server nonce
+	VERS []QuicVersion // Versions supported by the server
+	CGST MessageTag    // Congestion control feedback type selected by the server from the CGST of the CHLO
+	ICSL uint32        // Idle connection state lifetime in seconds (required)
+	MSPC uint32        // Max streams per connection (required)
+	SCLS bool          // Silently close on timeout
+	SFCW uint32        // Initial stream flow control receive window
+	CFCW uint32        // Initial connection flow control receive window
+}
+
+// Marshal returns the SHLO Message.
+func (this *SHLO) Marshal() *Message {
+	msg := NewMessage(TagSHLO)
+	if len(this.PUBS) > 0 {
+		msg.SetPUBS([][]byte{this.PUBS})
+	}
+	setBytes(msg, TagSTK, this.STK)
+	setBytes(msg, TagSNO, this.SNO)
+	if len(this.VERS) > 0 {
+		msg.SetTagList(TagVERS, versionsToTags(this.VERS))
+	}
+	if this.CGST != 0 {
+		msg.SetUint32(TagCGST, uint32(this.CGST))
+	}
+	setTransportParameters(msg, this.ICSL, this.MSPC, this.SCLS, this.SFCW, this.CFCW)
+	return msg
+}
+
+// Unmarshal sets the SHLO fields with the tag-values of the Message.
+// The error is a *QuicError with the code QUIC_INVALID_CRYPTO_MESSAGE_TYPE, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER.
+func (this *SHLO) Unmarshal(msg *Message) (err error) {
+	if !msg.IsMessageTag(TagSHLO) {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "SHLO.Unmarshal : not a SHLO message")
+	}
+	*this = SHLO{}
+	if ok, _ := msg.ContainsTag(TagPUBS); ok {
+		pubs, err := msg.GetPUBS()
+		if err != nil {
+			return err
+		}
+		if len(pubs) != 1 {
+			return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "SHLO.Unmarshal : PUBS must contain one public value")
+		}
+		this.PUBS = pubs[0]
+	}
+	_, this.STK = msg.ContainsTag(TagSTK)
+	_, this.SNO = msg.ContainsTag(TagSNO)
+	vers, err := getOptionalTagList(msg, TagVERS)
+	if err != nil {
+		return
+	}
+	this.VERS = tagsToVersions(vers)
+	cgst, err := getOptionalUint32(msg, TagCGST)
+	if err != nil {
+		return
+	}
+	this.CGST = MessageTag(cgst)
+	this.ICSL, this.MSPC, this.SCLS, this.SFCW, this.CFCW, err = getTransportParameters(msg)
+	return
+}
+
+// SCFG is the strongly typed version of a server config, serialized as a Message in the SCFG tag of a REJ.
+//
+// All the tags are required, KEXS and PUBS must have the same number of entries.
+type SCFG struct {
+	SCID []byte        // Server config ID
+	KEXS []MessageTag  // Key exchange algorithms (TagC255, TagP256)
+	AEAD []MessageTag  // Authenticated encryption algorithms (TagAESG, TagS20P, ...)
+	PUBS [][]byte      // Public values, in the same order as KEXS
+	ORBT []byte        // Orbit of the strike-register
+	EXPY uint64        // Expiry time of the server config in UNIX epoch-seconds
+	VERS []QuicVersion // Versions supported by the server
+}
+
+// Marshal returns the SCFG Message.
+// It returns an error if a public value is larger than MaxPUBSValueSize.
+func (this *SCFG) Marshal() (*Message, error) {
+	msg := NewMessage(TagSCFG)
+	msg.SetTagValue(TagSCID, this.SCID)
+	msg.SetTagList(TagKEXS, this.KEXS)
+	msg.SetTagList(TagAEAD, this.AEAD)
+	if err := msg.SetPUBS(this.PUBS); err != nil {
+		return nil, err
+	}
+	msg.SetTagValue(TagORBT, this.ORBT)
+	msg.SetUint64(TagEXPY, this.EXPY)
+	msg.SetTagList(TagVERS, versionsToTags(this.VERS))
+	return msg, nil
+}
+
+// Unmarshal sets the SCFG fields with the tag-values of the Message.
+// The error is a *QuicError with the code QUIC_INVALID_CRYPTO_MESSAGE_TYPE, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER.
+func (this *SCFG) Unmarshal(msg *Message) (err error) {
+	var vers []MessageTag
+
+	if !msg.IsMessageTag(TagSCFG) {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "SCFG.Unmarshal : not a SCFG message")
+	}
+	*this = SCFG{}
+	if this.SCID, err = msg.getFixedSizeValue(TagSCID, serverConfigIDSize); err != nil {
+		return
+	}
+	if this.KEXS, err = msg.GetTagList(TagKEXS); err != nil {
+		return
+	}
+	if this.AEAD, err = msg.GetTagList(TagAEAD); err != nil {
+		return
+	}
+	if this.PUBS, err = msg.GetPUBS(); err != nil {
+		return
+	}
+	if len(this.PUBS) != len(this.KEXS) {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "SCFG.Unmarshal : PUBS and KEXS have different lengths")
+	}
+	if this.ORBT, err = msg.getFixedSizeValue(TagORBT, serverOrbitSize); err != nil {
+		return
+	}
+	if this.EXPY, err = msg.GetUint64(TagEXPY); err != nil {
+		return
+	}
+	if vers, err = msg.GetTagList(TagVERS); err != nil {
+		return
+	}
+	this.VERS = tagsToVersions(vers)
+	return nil
+}
+
+// REJ is the strongly typed version of a server rejection Message.
+//
+// The optional tags are absent from the Message when their field has the zero value.
+type REJ struct {
+	SCFG *SCFG    // Server config
+	STK  []byte   // Source-address token
+	SNO  []byte   // Server nonce
+	CRT  []byte   // Compressed certificate chain
+	PROF []byte   // Signature of the server config
+	RREJ []uint32 // Reasons for the rejection
+}
+
+// Marshal returns the REJ Message.
+// It returns an error if the server config can't be serialized.
+func (this *REJ) Marshal() (*Message, error) {
+	msg := NewMessage(TagREJ)
+	if this.SCFG != nil {
+		scfg, err := this.SCFG.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		data, err := scfg.GetSerialize()
+		if err != nil {
+			return nil, err
+		}
+		msg.SetTagValue(TagSCFG, data)
+	}
+	setBytes(msg, TagSTK, this.STK)
+	setBytes(msg, TagSNO, this.SNO)
+	setBytes(msg, TagCRT, this.CRT)
+	setBytes(msg, TagPROF, this.PROF)
+	if len(this.RREJ) > 0 {
+		v := make([]byte, 4*len(this.RREJ))
+		for i, r := range this.RREJ {
+			binary.LittleEndian.PutUint32(v[4*i:], r)
+		}
+		msg.SetTagValue(TagRREJ, v)
+	}
+	return msg, nil
+}
+
+// Unmarshal sets the REJ fields with the tag-values of the Message, the server config is decoded and verified.
+// The error is a *QuicError with a crypto error code.
+func (this *REJ) Unmarshal(msg *Message) (err error) {
+	if !msg.IsMessageTag(TagREJ) {
+		return NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "REJ.Unmarshal : not a REJ message")
+	}
+	*this = REJ{}
+	if ok, v := msg.ContainsTag(TagSCFG); ok {
+		scfg, err := DecodeMessage(v)
+		if err != nil {
+			return err
+		}
+		this.SCFG = new(SCFG)
+		if err = this.SCFG.Unmarshal(scfg); err != nil {
+			return err
+		}
+	}
+	_, this.STK = msg.ContainsTag(TagSTK)
+	_, this.SNO = msg.ContainsTag(TagSNO)
+	_, this.CRT = msg.ContainsTag(TagCRT)
+	_, this.PROF = msg.ContainsTag(TagPROF)
+	rrej, err := getOptionalTagList(msg, TagRREJ)
+	if err != nil {
+		return
+	}
+	for _, r := range rrej {
+		this.RREJ = append(this.RREJ, uint32(r))
+	}
+	return nil
+}
+
+// setBytes sets the tag value pair in the Message if the value is not empty.
+func setBytes(msg *Message, tag MessageTag, value []byte) {
+	if len(value) > 0 {
+		msg.SetTagValue(tag, value)
+	}
+}
+
+// setTransportParameters sets the connection parameters shared by the CHLO and the SHLO, ICSL and MSPC are always set.
+func setTransportParameters(msg *Message, icsl, mspc uint32, scls bool, sfcw, cfcw uint32) {
+	msg.SetUint32(TagICSL, icsl)
+	msg.SetUint32(TagMSPC, mspc)
+	if scls {
+		msg.SetUint32(TagSCLS, 1)
+	}
+	if sfcw != 0 {
+		msg.SetUint32(TagSFCW, sfcw)
+	}
+	if cfcw != 0 {
+		msg.SetUint32(TagCFCW, cfcw)
+	}
+}
+
+// getTransportParameters returns the connection parameters shared by the CHLO and the SHLO, ICSL and MSPC are required.
+func getTransportParameters(msg *Message) (icsl, mspc uint32, scls bool, sfcw, cfcw uint32, err error) {
+	var v uint32
+
+	if icsl, err = msg.GetUint32(TagICSL); err != nil {
+		return
+	}
+	if mspc, err = msg.GetUint32(TagMSPC); err != nil {
+		return
+	}
+	if v, err = getOptionalUint32(msg, TagSCLS); err != nil {
+		return
+	}
+	scls = v != 0
+	if sfcw, err = getOptionalUint32(msg, TagSFCW); err != nil {
+		return
+	}
+	cfcw, err = getOptionalUint32(msg, TagCFCW)
+	return
+}
+
+// getOptionalUint32 returns the value of the tag as a 32-bit little endian integer, or zero if the tag does not exist.
+func getOptionalUint32(msg *Message, tag MessageTag) (uint32, error) {
+	if ok, _ := msg.ContainsTag(tag); !ok {
+		return 0, nil
+	}
+	return msg.GetUint32(tag)
+}
+
+// getOptionalTagList returns the value of the tag as a list of tags, or nil if the tag does not exist.
+func getOptionalTagList(msg *Message, tag MessageTag) ([]MessageTag, error) {
+	if ok, _ := msg.ContainsTag(tag); !ok {
+		return nil, nil
+	}
+	return msg.GetTagList(tag)
+}
+
+// getOptionalUint64List returns the value of the tag as a list of 64-bit integers, or nil if the tag does not exist.
+func getOptionalUint64List(msg *Message, tag MessageTag) ([]uint64, error) {
+	if ok, _ := msg.ContainsTag(tag); !ok {
+		return nil, nil
+	}
+	return msg.GetUint64List(tag)
+}
+
+// versionsToTags converts a list of QUIC versions to the list of tags of the VERS value.
+func versionsToTags(vers []QuicVersion) []MessageTag {
+	tags := make([]MessageTag, len(vers))
+	for i, v := range vers {
+		tags[i] = MessageTag(v)
+	}
+	return tags
+}
+
+// tagsToVersions converts the list of tags of the VERS value to a list of QUIC versions.
+func tagsToVersions(tags []MessageTag) []QuicVersion {
+	if tags == nil {
+		return nil
+	}
+	vers := make([]QuicVersion, len(tags))
+	for i, t := range tags {
+		vers[i] = QuicVersion(t)
+	}
+	return vers
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import "testing"
+import "bytes"
+import "errors"
+
+func Test_CHLO_Marshal(t *testing.T) {
+	var chlo CHLO
+
+	// Inchoate CHLO
+	in := CHLO{SNI: "www.example.org", PDMD: []MessageTag{TagX509}, VERS: 0x34333051, COPT: []MessageTag{TagRENO}, CGST: []MessageTag{TagINAR, TagQBIC}, ICSL: 30, MSPC: 100, SCLS: true, IRTT: 100000}
+	if err := chlo.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("CHLO.Unmarshal : error %v on inchoate CHLO", err)
+	}
+	if !chlo.IsInchoate() || (chlo.SNI != in.SNI) || (len(chlo.PDMD) != 1) || (chlo.VERS != in.VERS) || (len(chlo.COPT) != 1) || (chlo.COPT[0] != TagRENO) || (len(chlo.CGST) != 2) || (chlo.CGST[0] != TagINAR) ||
+		(chlo.ICSL != 30) || (chlo.MSPC != 100) || !chlo.SCLS || (chlo.IRTT != 100000) || (chlo.SFCW != 0) {
+		t.Errorf("CHLO.Unmarshal : invalid inchoate CHLO %+v", chlo)
+	}
+
+	// Full CHLO, through the serialization
+	in.SCID = make([]byte, serverConfigIDSize)
+	in.AEAD = TagAESG
+	in.KEXS = TagC255
+	in.NONC = make([]byte, clientNonceSize)
+	in.PUBS = []byte{1, 2, 3}
+	msg, err := DecodeMessage(serialize(t, in.Marshal()))
+	if err != nil {
+		t.Fatalf("DecodeMessage : error %v on full CHLO", err)
+	}
+	if err = chlo.Unmarshal(msg); err != nil {
+		t.Fatalf("CHLO.Unmarshal : error %v on full CHLO", err)
+	}
+	if chlo.IsInchoate() || (chlo.AEAD != TagAESG) || (chlo.KEXS != TagC255) || !bytes.Equal(chlo.PUBS, in.PUBS) {
+		t.Errorf("CHLO.Unmarshal : invalid full CHLO %+v", chlo)
+	}
+
+	// Missing and invalid parameters
+	for i, v := range []struct {
+		tag   MessageTag
+		value []byte
+		code  QuicErrorCode
+	}{
+		{TagICSL, nil, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND},
+		{TagMSPC, []byte{1}, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER},
+		{TagNONC, make([]byte, 31), QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER},
+		{TagAEAD, nil, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND},
+		{TagPUBS, []byte{1, 0, 0, 1, 1, 0, 0, 2}, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER},
+		{TagCOPT, []byte{1, 2}, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER},
+	} {
+		msg = in.Marshal()
+		if v.value == nil {
+			// Remove the tag
+			m := NewMessage(TagCHLO)
+			for j, tag := range msg.tags {
+				if tag != v.tag {
+					m.AddTagValue(tag, msg.values[j])
+				}
+			}
+			msg = m
+		} else {
+			msg.SetTagValue(v.tag, v.value)
+		}
+		if err = chlo.Unmarshal(msg); !errors.Is(err, v.code) {
+			t.Errorf("CHLO.Unmarshal : error %v instead of %v in test n°%v", err, v.code, i)
+		}
+	}
+	if err = chlo.Unmarshal(NewMessage(TagSHLO)); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_TYPE) {
+		t.Errorf("CHLO.Unmarshal : error %v on SHLO message", err)
+	}
+}
+
+func Test_SHLO_Marshal(t *testing.T) {
+	var shlo SHLO
+
+	in := SHLO{PUBS: []byte{1, 2}, VERS: []QuicVersion{0x34333051, 0x35333051}, CGST: TagINAR, ICSL: 600, MSPC: 100, SFCW: 1 << 16, CFCW: 1 << 20}
+	if err := shlo.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("SHLO.Unmarshal : error %v", err)
+	}
+	if !bytes.Equal(shlo.PUBS, in.PUBS) || (len(shlo.VERS) != 2) || (shlo.VERS[1] != in.VERS[1]) || (shlo.ICSL != 600) || (shlo.MSPC != 100) || shlo.SCLS ||
+		(shlo.SFCW != in.SFCW) || (shlo.CFCW != in.CFCW) || (shlo.CGST != TagINAR) {
+		t.Errorf("SHLO.Unmarshal : invalid SHLO %+v", shlo)
+	}
+	if err := shlo.Unmarshal(NewMessage(TagSHLO)); !errors.Is(err, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND) {
+		t.Errorf("SHLO.Unmarshal : error %v on empty SHLO", err)
+	}
+}
+
+func Test_REJ_Marshal(t *testing.T) {
+	var rej REJ
+
+	scfg := &SCFG{
+		SCID: make([]byte, serverConfigIDSize),
+		KEXS: []MessageTag{TagC255, TagP256},
+		AEAD: []MessageTag{TagAESG},
+		PUBS: [][]byte{{1}, {2, 3}},
+		ORBT: make([]byte, serverOrbitSize),
+		EXPY: 1700000000,
+		VERS: []QuicVersion{0x34333051}}
+	in := REJ{SCFG: scfg, STK: []byte{4, 5}, SNO: []byte{6}, RREJ: []uint32{1, 2}}
+	msg, err := in.Marshal()
+	if err != nil {
+		t.Fatalf("REJ.Marshal : error %v", err)
+	}
+	if err = rej.Unmarshal(msg); err != nil {
+		t.Fatalf("REJ.Unmarshal : error %v", err)
+	}
+	if (rej.SCFG == nil) || (len(rej.SCFG.KEXS) != 2) || !bytes.Equal(rej.SCFG.PUBS[1], []byte{2, 3}) || (rej.SCFG.EXPY != scfg.EXPY) || (len(rej.SCFG.VERS) != 1) {
+		t.Errorf("REJ.Unmarshal : invalid server config %+v", rej.SCFG)
+	}
+	if !bytes.Equal(rej.STK, in.STK) || !bytes.Equal(rej.SNO, in.SNO) || (len(rej.RREJ) != 2) || (rej.RREJ[1] != 2) {
+		t.Errorf("REJ.Unmarshal : invalid REJ %+v", rej)
+	}
+
+	// Server config with a different number of KEXS and PUBS
+	scfg.PUBS = scfg.PUBS[:1]
+	msg, _ = in.Marshal()
+	if err = rej.Unmarshal(msg); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("REJ.Unmarshal : error %v on invalid server config", err)
+	}
+	// Truncated server config
+	msg.SetTagValue(TagSCFG, []byte{'S', 'C', 'F', 'G', 1, 0})
+	if err = rej.Unmarshal(msg); !errors.Is(err, QUIC_CRYPTO_INVALID_VALUE_LENGTH) {
+		t.Errorf("REJ.Unmarshal : error %v on truncated server config", err)
+	}
+}
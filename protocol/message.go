@@ -1,10 +1,19 @@
 package protocol
 
 import "encoding/binary"
+import "errors"
+import "sort"
+import "strings"
 
 // MessageTag is the type definition for message's tag, and tags in tag-value pairs.
 type MessageTag uint32
 
+// String returns the 4 characters of the tag, without the trailing zero bytes (for example "SNI" or "CHLO").
+func (this MessageTag) String() string {
+	b := []byte{byte(this), byte(this >> 8), byte(this >> 16), byte(this >> 24)}
+	return strings.TrimRight(string(b), "\x00")
+}
+
 // MaxNumEntries is the maximum numer of entries supported in a Message.
 const MaxMessageTagNumEntries = 128
 
@@ -74,6 +83,12 @@ const (
 	TagSWND = ('S') + ('W' << 8) + ('N' << 16) + ('D' << 24) //     Server’s Initial congestion window
 	TagSFCW = ('S') + ('F' << 8) + ('C' << 16) + ('W' << 24) //     Initial stream flow control receive window
 	TagCFCW = ('C') + ('F' << 8) + ('C' << 16) + ('W' << 24) //     Initial session/connection flow control receive window
+	TagQBIC = ('Q') + ('B' << 8) + ('I' << 16) + ('C' << 24) //     CUBIC congestion control (default)
+	TagRENO = ('R') + ('E' << 8) + ('N' << 16) + ('O' << 24) //     NewReno congestion control
+	TagTBBR = ('T') + ('B' << 8) + ('B' << 16) + ('R' << 24) //     BBR congestion control
+
+	TagCGST = ('C') + ('G' << 8) + ('S' << 16) + ('T' << 24) // Congestion control feedback types (TagQBIC = TCP feedback of the receive window) :
+	TagINAR = ('I') + ('N' << 8) + ('A' << 16) + ('R' << 24) //     Inter-arrival feedback
 
 // new Tag = '' + ('' << 8) + ('' << 16) + ('' << 24) //
 )
@@ -87,14 +102,14 @@ type Message struct {
 
 // NewMessage is a Message factory.
 //
-// Only TagCHLO, TagREJ, TagSHLO and TagSCUP are valids 'messageTag' values.
+// Only TagCHLO, TagREJ, TagSHLO, TagSCUP, TagPRST and TagSCFG (server config serialized in a REJ) are valids 'messageTag' values.
 //
 // 'tags' and 'values' must have the same length, and this length must be less or equal than 'MaxNumEntries' value.
 //
 // NewMessage returns a nil value in case of invalid inputs.
 func NewMessage(messageTag MessageTag) *Message {
 	switch messageTag {
-	case TagCHLO, TagREJ, TagSHLO, TagSCUP, TagPRST:
+	case TagCHLO, TagREJ, TagSHLO, TagSCUP, TagPRST, TagSCFG:
 		return &Message{
 			msgTag: messageTag}
 	}
@@ -150,65 +165,283 @@ func (this *Message) AddTagValue(tag MessageTag, value []byte) bool {
 	return true
 }
 
-// GetSerializeSize returns the size in byte of the binary version of the Message.
-func (this *Message) GetSerializeSize() uint32 {
+// SetTagValue sets the tag value pair in the Message: the value is overwritten if the tag does already exist, and the pair is added otherwise.
+func (this *Message) SetTagValue(tag MessageTag, value []byte) {
+	if !this.UpdateTagValue(tag, value) {
+		this.AddTagValue(tag, value)
+	}
+}
+
+// GetTagValue returns the value of the tag, or a QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND error if the tag does not exist in the Message.
+func (this *Message) GetTagValue(tag MessageTag) ([]byte, error) {
+	if ok, v := this.ContainsTag(tag); ok {
+		return v, nil
+	}
+	return nil, NewQuicError(QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND, "Message.GetTagValue : tag "+tag.String()+" not found")
+}
+
+// getFixedSizeValue returns the value of the tag if its length is 'size', the error is QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER.
+func (this *Message) getFixedSizeValue(tag MessageTag, size int) ([]byte, error) {
+	v, err := this.GetTagValue(tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != size {
+		return nil, NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "Message.GetTagValue : invalid length of tag "+tag.String())
+	}
+	return v, nil
+}
+
+// GetUint32 returns the value of the tag as a 32-bit little endian integer.
+// The error is QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND if the tag does not exist, or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER if the value is not 4 bytes long.
+func (this *Message) GetUint32(tag MessageTag) (uint32, error) {
+	v, err := this.getFixedSizeValue(tag, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(v), nil
+}
+
+// SetUint32 sets the value of the tag as a 32-bit little endian integer.
+func (this *Message) SetUint32(tag MessageTag, value uint32) {
+	v := make([]byte, 4)
+	binary.LittleEndian.PutUint32(v, value)
+	this.SetTagValue(tag, v)
+}
+
+// GetUint64 returns the value of the tag as a 64-bit little endian integer.
+// The error is QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND if the tag does not exist, or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER if the value is not 8 bytes long.
+func (this *Message) GetUint64(tag MessageTag) (uint64, error) {
+	v, err := this.getFixedSizeValue(tag, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(v), nil
+}
+
+// SetUint64 sets the value of the tag as a 64-bit little endian integer.
+func (this *Message) SetUint64(tag MessageTag, value uint64) {
+	v := make([]byte, 8)
+	binary.LittleEndian.PutUint64(v, value)
+	this.SetTagValue(tag, v)
+}
+
+// GetTagList returns the value of the tag as a list of 4-bytes little endian tags (KEXS, AEAD, COPT, PDMD, ...).
+// The error is QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND if the tag does not exist, or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER if the length is not a multiple of 4.
+func (this *Message) GetTagList(tag MessageTag) ([]MessageTag, error) {
+	v, err := this.GetTagValue(tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(v)%4 != 0 {
+		return nil, NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "Message.GetTagList : invalid length of tag "+tag.String())
+	}
+	list := make([]MessageTag, len(v)/4)
+	for i := range list {
+		list[i] = MessageTag(binary.LittleEndian.Uint32(v[4*i:]))
+	}
+	return list, nil
+}
+
+// SetTagList sets the value of the tag as a list of 4-bytes little endian tags.
+func (this *Message) SetTagList(tag MessageTag, list []MessageTag) {
+	v := make([]byte, 4*len(list))
+	for i, t := range list {
+		binary.LittleEndian.PutUint32(v[4*i:], uint32(t))
+	}
+	this.SetTagValue(tag, v)
+}
+
+// GetUint64List returns the value of the tag as a list of 64-bit little endian integers (CCS and CCRT certificate hashes).
+// The error is QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND if the tag does not exist, or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER if the length is not a multiple of 8.
+func (this *Message) GetUint64List(tag MessageTag) ([]uint64, error) {
+	v, err := this.GetTagValue(tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(v)%8 != 0 {
+		return nil, NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "Message.GetUint64List : invalid length of tag "+tag.String())
+	}
+	list := make([]uint64, len(v)/8)
+	for i := range list {
+		list[i] = binary.LittleEndian.Uint64(v[8*i:])
+	}
+	return list, nil
+}
+
+// SetUint64List sets the value of the tag as a list of 64-bit little endian integers.
+func (this *Message) SetUint64List(tag MessageTag, list []uint64) {
+	v := make([]byte, 8*len(list))
+	for i, u := range list {
+		binary.LittleEndian.PutUint64(v[8*i:], u)
+	}
+	this.SetTagValue(tag, v)
+}
+
+// MaxPUBSValueSize is the maximum size of a public value in a PUBS list (24-bit length prefix).
+const MaxPUBSValueSize = 1<<24 - 1
+
+// GetPUBS returns the value of TagPUBS as a list of public values, each value is prefixed by its 24-bit little endian length.
+// The error is QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND if the tag does not exist, or QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER if a length exceeds the value.
+func (this *Message) GetPUBS() ([][]byte, error) {
+	v, err := this.GetTagValue(TagPUBS)
+	if err != nil {
+		return nil, err
+	}
+	var list [][]byte
+	for len(v) > 0 {
+		if len(v) < 3 {
+			return nil, NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "Message.GetPUBS : truncated length prefix")
+		}
+		l := int(v[0]) | int(v[1])<<8 | int(v[2])<<16
+		if len(v) < 3+l {
+			return nil, NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "Message.GetPUBS : truncated public value")
+		}
+		list = append(list, v[3:3+l])
+		v = v[3+l:]
+	}
+	return list, nil
+}
+
+// SetPUBS sets the value of TagPUBS as a list of public values prefixed by their 24-bit little endian length.
+// It returns an error if a public value is larger than MaxPUBSValueSize.
+func (this *Message) SetPUBS(list [][]byte) error {
+	size := 0
+	for _, p := range list {
+		if len(p) > MaxPUBSValueSize {
+			return errors.New("Message.SetPUBS : public value too large")
+		}
+		size += 3 + len(p)
+	}
+	v := make([]byte, 0, size)
+	for _, p := range list {
+		v = append(v, byte(len(p)), byte(len(p)>>8), byte(len(p)>>16))
+		v = append(v, p...)
+	}
+	this.SetTagValue(TagPUBS, v)
+	return nil
+}
+
+// MinClientHelloSize is the minimum size in bytes of a serialized inchoate CHLO (CHLO without SCID), a smaller CHLO is padded with a TagPAD value.
+const MinClientHelloSize = 1024
+
+// getPaddingSize returns the size of the TagPAD value added by the serialization to reach MinClientHelloSize, or -1 if no TagPAD is added:
+// only an inchoate CHLO without TagPAD and smaller than MinClientHelloSize is padded.
+func (this *Message) getPaddingSize() int {
+	if this.msgTag != TagCHLO {
+		return -1
+	}
+	if ok, _ := this.ContainsTag(TagSCID); ok {
+		return -1
+	}
+	if ok, _ := this.ContainsTag(TagPAD); ok {
+		return -1
+	}
+	size := int(this.getUnpaddedSize())
+	if size >= MinClientHelloSize {
+		return -1
+	}
+	if size+messageEntrySize >= MinClientHelloSize {
+		// The tag-offset pair of TagPAD is enough
+		return 0
+	}
+	return MinClientHelloSize - size - messageEntrySize
+}
+
+// getUnpaddedSize returns the size in byte of the binary version of the Message without the padding.
+func (this *Message) getUnpaddedSize() uint32 {
 	var l uint32
 
 	for _, v := range this.values {
 		l += uint32(len(v))
 	}
-	return uint32(len(this.tags))*8 + l + 8
+	return uint32(len(this.tags))*messageEntrySize + l + messageHeaderSize
+}
+
+// GetSerializeSize returns the size in byte of the binary version of the Message, including the padding of an inchoate CHLO.
+func (this *Message) GetSerializeSize() uint32 {
+	size := this.getUnpaddedSize()
+	if pad := this.getPaddingSize(); pad >= 0 {
+		size += messageEntrySize + uint32(pad)
+	}
+	return size
+}
+
+// tagValues sorts the tag-value pairs of a Message by tag value.
+type tagValues struct {
+	tags   []MessageTag
+	values [][]byte
 }
 
-// GetSerialize returns []byte containing the binary serialization of the Message.
-func (this *Message) GetSerialize() []byte {
+func (this *tagValues) Len() int {
+	return len(this.tags)
+}
+
+func (this *tagValues) Less(i, j int) bool {
+	return this.tags[i] < this.tags[j]
+}
+
+func (this *tagValues) Swap(i, j int) {
+	this.tags[i], this.tags[j] = this.tags[j], this.tags[i]
+	this.values[i], this.values[j] = this.values[j], this.values[i]
+}
+
+// GetSerialize returns []byte containing the binary serialization of the Message, the Message itself is not modified.
+//
+// The tag-value pairs are ordered by tag value, and an inchoate CHLO is padded with a TagPAD value up to MinClientHelloSize.
+// The error is a QUIC_CRYPTO_TOO_MANY_ENTRIES error if the Message has more than MaxMessageTagNumEntries entries,
+// or a QUIC_CRYPTO_INVALID_VALUE_LENGTH error if the serialized Message is larger than MaxMessageSize.
+func (this *Message) GetSerialize() ([]byte, error) {
 	var offset, endoffset uint32
 
-	msg := make([]byte, this.GetSerializeSize())
-	// Order the pairs by tag value
-	if len(this.tags) > 1 {
-		haveswap := true
-		for haveswap {
-			haveswap = false
-			for i := 0; i < len(this.tags)-1; i++ {
-				if this.tags[i] > this.tags[i+1] {
-					// Swap the two
-					t := this.tags[i]
-					v := this.values[i]
-					this.tags[i] = this.tags[i+1]
-					this.tags[i+1] = t
-					this.values[i] = this.values[i+1]
-					this.values[i+1] = v
-					haveswap = true
-				}
-			}
+	// Order a copy of the pairs by tag value
+	pairs := &tagValues{
+		tags:   make([]MessageTag, len(this.tags), len(this.tags)+1),
+		values: make([][]byte, len(this.values), len(this.values)+1)}
+	copy(pairs.tags, this.tags)
+	copy(pairs.values, this.values)
+	if pad := this.getPaddingSize(); pad >= 0 {
+		value := make([]byte, pad)
+		for i := range value {
+			value[i] = '-'
 		}
+		pairs.tags = append(pairs.tags, TagPAD)
+		pairs.values = append(pairs.values, value)
+	}
+	if len(pairs.tags) > MaxMessageTagNumEntries {
+		return nil, NewQuicError(QUIC_CRYPTO_TOO_MANY_ENTRIES, "Message.GetSerialize : too many entries")
 	}
+	size := this.GetSerializeSize()
+	if size > MaxMessageSize {
+		return nil, NewQuicError(QUIC_CRYPTO_INVALID_VALUE_LENGTH, "Message.GetSerialize : message too long")
+	}
+	sort.Sort(pairs)
+	msg := make([]byte, size)
 	// Write the message tag
 	binary.LittleEndian.PutUint32(msg, uint32(this.msgTag))
 	offset += 4
 	// Write the number of tags
-	binary.LittleEndian.PutUint16(msg[offset:], uint16(len(this.tags)))
+	binary.LittleEndian.PutUint16(msg[offset:], uint16(len(pairs.tags)))
 	offset += 2
 	// Write the padding
 	//   padding = 0x0000
 	offset += 2
 	// Write the tag/offset pairs
-	for i, t := range this.tags {
+	for i, t := range pairs.tags {
 		// Write the tag
 		binary.LittleEndian.PutUint32(msg[offset:], uint32(t))
 		offset += 4
 		// Write the value end offset
-		endoffset += uint32(len(this.values[i]))
+		endoffset += uint32(len(pairs.values[i]))
 		binary.LittleEndian.PutUint32(msg[offset:], endoffset)
 		offset += 4
 	}
 	// Write the values pairs
-	for _, v := range this.values {
+	for _, v := range pairs.values {
 		copy(msg[offset:], v)
 		offset += uint32(len(v))
 	}
-	return msg
+	return msg, nil
 }
 
 // IsValid verifies that the message type associated tag-value pairs are valids and returns true in that case, otherwise returns false.
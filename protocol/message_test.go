@@ -2,6 +2,7 @@ package protocol
 
 import "testing"
 import "bytes"
+import "errors"
 
 func Test_NewMessage(t *testing.T) {
 	var msg *Message
@@ -205,7 +206,7 @@ func Test_AddTagValue(t *testing.T) {
 }
 
 func Test_GetSerializeSize(t *testing.T) {
-	msg := NewMessage(TagCHLO)
+	msg := NewMessage(TagREJ)
 	if msg.GetSerializeSize() != 8 {
 		t.Error("GetSerializeSize: bad size")
 	}
@@ -224,31 +225,144 @@ func Test_GetSerializeSize(t *testing.T) {
 }
 
 func Test_GetSerialize(t *testing.T) {
-	msg := NewMessage(TagCHLO)
-	s := msg.GetSerialize()
-	r := []byte{'C', 'H', 'L', 'O', 0, 0, 0, 0}
+	msg := NewMessage(TagREJ)
+	s := serialize(t, msg)
+	r := []byte{'R', 'E', 'J', 0, 0, 0, 0, 0}
 	if !bytes.Equal(s, r) {
 		t.Error("GetSerialize: bad binary string")
 	}
 
 	msg.AddTagValue(TagSNI, []byte{1})
-	s = msg.GetSerialize()
-	r = []byte{'C', 'H', 'L', 'O', 1, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 1}
+	s = serialize(t, msg)
+	r = []byte{'R', 'E', 'J', 0, 1, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 1}
 	if !bytes.Equal(s, r) {
 		t.Error("GetSerialize: bad binary string")
 	}
 
 	msg.AddTagValue(TagCETV, []byte{2, 3})
-	s = msg.GetSerialize()
-	r = []byte{'C', 'H', 'L', 'O', 2, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 'C', 'E', 'T', 'V', 3, 0, 0, 0, 1, 2, 3}
+	s = serialize(t, msg)
+	r = []byte{'R', 'E', 'J', 0, 2, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 'C', 'E', 'T', 'V', 3, 0, 0, 0, 1, 2, 3}
 	if !bytes.Equal(s, r) {
 		t.Error("GetSerialize: bad binary string")
 	}
 
 	msg.AddTagValue(TagAEAD, []byte{4, 5, 6})
-	s = msg.GetSerialize()
-	r = []byte{'C', 'H', 'L', 'O', 3, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 'A', 'E', 'A', 'D', 4, 0, 0, 0, 'C', 'E', 'T', 'V', 6, 0, 0, 0, 1, 4, 5, 6, 2, 3}
+	s = serialize(t, msg)
+	r = []byte{'R', 'E', 'J', 0, 3, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 'A', 'E', 'A', 'D', 4, 0, 0, 0, 'C', 'E', 'T', 'V', 6, 0, 0, 0, 1, 4, 5, 6, 2, 3}
 	if !bytes.Equal(s, r) {
 		t.Error("GetSerialize: bad binary string")
 	}
+	// The Message itself is not reordered
+	if (msg.tags[1] != TagCETV) || (msg.tags[2] != TagAEAD) {
+		t.Error("GetSerialize: Message modified")
+	}
+
+	// Inchoate CHLO padded with TagPAD to MinClientHelloSize, before the tags greater than PAD
+	msg = NewMessage(TagCHLO)
+	msg.AddTagValue(TagSNI, []byte{1})
+	msg.AddTagValue(TagVERS, []byte{1, 2, 3, 4})
+	s = serialize(t, msg)
+	if (len(s) != MinClientHelloSize) || (int(msg.GetSerializeSize()) != len(s)) || (msg.GetNumEntries() != 2) {
+		t.Errorf("GetSerialize: invalid size %v of inchoate CHLO", len(s))
+	}
+	if !bytes.Equal(s[4:8], []byte{3, 0, 0, 0}) || !bytes.Equal(s[8:12], []byte{'P', 'A', 'D', 0}) || !bytes.Equal(s[16:20], []byte{'S', 'N', 'I', 0}) {
+		t.Errorf("GetSerialize: invalid tags of inchoate CHLO %v", s[:32])
+	}
+	// No padding of a full CHLO, or of an inchoate CHLO already large enough
+	msg.AddTagValue(TagSCID, make([]byte, 16))
+	if s = serialize(t, msg); len(s) != 8+3*8+21 {
+		t.Errorf("GetSerialize: full CHLO padded to %v bytes", len(s))
+	}
+	msg = NewMessage(TagCHLO)
+	msg.AddTagValue(TagSNI, make([]byte, MinClientHelloSize-8-8-4))
+	if s = serialize(t, msg); (len(s) != MinClientHelloSize+4) || (s[4] != 2) {
+		t.Errorf("GetSerialize: invalid size %v of inchoate CHLO with an empty PAD", len(s))
+	}
+
+	// Too many entries and message too long
+	msg = NewMessage(TagSHLO)
+	for i := 0; i <= MaxMessageTagNumEntries; i++ {
+		msg.AddTagValue(MessageTag(i), nil)
+	}
+	if _, err := msg.GetSerialize(); !errors.Is(err, QUIC_CRYPTO_TOO_MANY_ENTRIES) {
+		t.Errorf("GetSerialize: error %v with too many entries", err)
+	}
+	msg = NewMessage(TagSHLO)
+	msg.AddTagValue(TagSNO, make([]byte, MaxMessageSize))
+	if _, err := msg.GetSerialize(); !errors.Is(err, QUIC_CRYPTO_INVALID_VALUE_LENGTH) {
+		t.Errorf("GetSerialize: error %v with a message too long", err)
+	}
+}
+
+// serialize returns the serialization of the Message, the test fails on error.
+func serialize(t *testing.T, msg *Message) []byte {
+	s, err := msg.GetSerialize()
+	if err != nil {
+		t.Fatalf("GetSerialize: error %v", err)
+	}
+	return s
+}
+
+func Test_Message_TypedValues(t *testing.T) {
+	msg := NewMessage(TagCHLO)
+	msg.SetUint32(TagICSL, 30)
+	msg.SetUint64(TagEXPY, 0x0102030405060708)
+	msg.SetTagList(TagCOPT, []MessageTag{TagRENO, TagTBBR})
+	msg.SetUint64List(TagCCS, []uint64{1, 2})
+	if err := msg.SetPUBS([][]byte{{1, 2, 3}, {}, {4}}); err != nil {
+		t.Errorf("Message.SetPUBS : error %v", err)
+	}
+	if v, err := msg.GetUint32(TagICSL); (err != nil) || (v != 30) {
+		t.Errorf("Message.GetUint32 : invalid value %v (error %v)", v, err)
+	}
+	if v, err := msg.GetUint64(TagEXPY); (err != nil) || (v != 0x0102030405060708) {
+		t.Errorf("Message.GetUint64 : invalid value %x (error %v)", v, err)
+	}
+	if v, err := msg.GetTagList(TagCOPT); (err != nil) || (len(v) != 2) || (v[0] != TagRENO) || (v[1] != TagTBBR) {
+		t.Errorf("Message.GetTagList : invalid value %v (error %v)", v, err)
+	}
+	if v, err := msg.GetUint64List(TagCCS); (err != nil) || (len(v) != 2) || (v[0] != 1) || (v[1] != 2) {
+		t.Errorf("Message.GetUint64List : invalid value %v (error %v)", v, err)
+	}
+	if v, err := msg.GetPUBS(); (err != nil) || (len(v) != 3) || !bytes.Equal(v[0], []byte{1, 2, 3}) || (len(v[1]) != 0) || !bytes.Equal(v[2], []byte{4}) {
+		t.Errorf("Message.GetPUBS : invalid value %v (error %v)", v, err)
+	}
+	// SetTagValue overwrites an existing tag
+	msg.SetUint32(TagICSL, 60)
+	if v, _ := msg.GetUint32(TagICSL); (v != 60) || (msg.GetNumEntries() != 5) {
+		t.Errorf("Message.SetUint32 : value %v not overwritten", v)
+	}
+	if MessageTag(TagCHLO).String() != "CHLO" || MessageTag(TagSNI).String() != "SNI" {
+		t.Errorf("MessageTag.String : invalid strings %v %v", MessageTag(TagCHLO), MessageTag(TagSNI))
+	}
+
+	// Missing tags and malformed lengths
+	msg = NewMessage(TagCHLO)
+	msg.AddTagValue(TagICSL, []byte{1, 2, 3})
+	msg.AddTagValue(TagEXPY, []byte{1, 2, 3, 4})
+	msg.AddTagValue(TagCOPT, []byte{1, 2, 3, 4, 5})
+	msg.AddTagValue(TagCCS, []byte{1, 2, 3, 4})
+	msg.AddTagValue(TagPUBS, []byte{4, 0, 0, 1, 2, 3})
+	if _, err := msg.GetUint32(TagMSPC); !errors.Is(err, QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND) {
+		t.Errorf("Message.GetUint32 : error %v on missing tag", err)
+	}
+	if _, err := msg.GetUint32(TagICSL); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("Message.GetUint32 : error %v on invalid length", err)
+	}
+	if _, err := msg.GetUint64(TagEXPY); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("Message.GetUint64 : error %v on invalid length", err)
+	}
+	if _, err := msg.GetTagList(TagCOPT); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("Message.GetTagList : error %v on invalid length", err)
+	}
+	if _, err := msg.GetUint64List(TagCCS); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("Message.GetUint64List : error %v on invalid length", err)
+	}
+	if _, err := msg.GetPUBS(); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("Message.GetPUBS : error %v on truncated public value", err)
+	}
+	msg.UpdateTagValue(TagPUBS, []byte{1, 0})
+	if _, err := msg.GetPUBS(); !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+		t.Errorf("Message.GetPUBS : error %v on truncated length prefix", err)
+	}
 }
@@ -0,0 +1,156 @@
+package protocol
+
+import "encoding/binary"
+
+// MaxMessageSize is the maximum size in bytes of a Message accepted by the MessageDecoder and by Message.GetSerialize (header, tag-offset pairs and values).
+const MaxMessageSize = 16 * 1024
+
+// Size of the Message header: message tag (4 bytes), number of entries (2 bytes) and padding (2 bytes).
+const messageHeaderSize = 8
+
+// Size of a tag-offset pair: tag (4 bytes) and end offset (4 bytes).
+const messageEntrySize = 8
+
+// MessageDecoder is a synchronous and incremental Message decoder.
+//
+// Bytes are given to the decoder with the Feed method, a Message can be split over several calls of Feed and a call of Feed can contain several Messages.
+//
+// The decoder verifies the message tag, the number of entries, that the tags are in strictly increasing order and that the end offsets are increasing and in bounds.
+// Once an invalid Message is detected the decoder is in error and all the next calls of Feed return the same error.
+type MessageDecoder struct {
+	// bytes received and not yet decoded
+	data []byte
+	// first decoding error, the decoder is unusable after an error
+	err error
+}
+
+// NewMessageDecoder is a MessageDecoder factory.
+func NewMessageDecoder() *MessageDecoder {
+	return &MessageDecoder{}
+}
+
+// Feed appends 'data' to the bytes already received and returns all the Messages that are now complete.
+//
+// The error returned is a *QuicError with one of the crypto error codes (QUIC_INVALID_CRYPTO_MESSAGE_TYPE, QUIC_CRYPTO_TOO_MANY_ENTRIES, QUIC_CRYPTO_TAGS_OUT_OF_ORDER,
+// QUIC_CRYPTO_DUPLICATE_TAG or QUIC_CRYPTO_INVALID_VALUE_LENGTH) that can be sent in a CONNECTION_CLOSE frame.
+// The Messages decoded before the invalid Message are returned with the error.
+func (this *MessageDecoder) Feed(data []byte) ([]*Message, error) {
+	var msgs []*Message
+
+	if this.err != nil {
+		return nil, this.err
+	}
+	this.data = append(this.data, data...)
+	for {
+		msg, size, err := decodeMessage(this.data)
+		if err != nil {
+			this.err = err
+			this.data = nil
+			return msgs, err
+		}
+		if msg == nil {
+			break
+		}
+		msgs = append(msgs, msg)
+		this.data = this.data[size:]
+	}
+	if len(this.data) == 0 {
+		// Release the decoded bytes
+		this.data = nil
+	}
+	return msgs, nil
+}
+
+// Buffered returns the number of bytes received and not yet decoded.
+func (this *MessageDecoder) Buffered() int {
+	return len(this.data)
+}
+
+// Err returns the decoding error, or nil if the decoder is not in error.
+func (this *MessageDecoder) Err() error {
+	return this.err
+}
+
+// DecodeMessage decodes a Message serialized in 'data', such as the server config (SCFG) in a REJ or the client encrypted tag-values (CETV) in a CHLO.
+// 'data' must contain exactly one complete Message, the errors are the same as MessageDecoder.Feed.
+func DecodeMessage(data []byte) (*Message, error) {
+	msg, size, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	if (msg == nil) || (size != len(data)) {
+		return nil, NewQuicError(QUIC_CRYPTO_INVALID_VALUE_LENGTH, "DecodeMessage : invalid message length")
+	}
+	return msg, nil
+}
+
+// decodeMessage decodes the Message at the beginning of 'data' and returns it with its size in bytes.
+//
+// It returns a nil Message and no error if 'data' doesn't contain a complete Message yet.
+// The header and the tag-offset pairs are verified as soon as they are received, so that an invalid Message is rejected without waiting for its values.
+func decodeMessage(data []byte) (*Message, int, error) {
+	if len(data) < 4 {
+		return nil, 0, nil
+	}
+	msgTag := MessageTag(binary.LittleEndian.Uint32(data))
+	switch msgTag {
+	case TagCHLO, TagREJ, TagSHLO, TagSCUP, TagPRST, TagSCFG:
+	default:
+		return nil, 0, NewQuicError(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "MessageDecoder.Feed : invalid message tag")
+	}
+	if len(data) < messageHeaderSize {
+		return nil, 0, nil
+	}
+	numEntries := int(binary.LittleEndian.Uint16(data[4:]))
+	if numEntries > MaxMessageTagNumEntries {
+		return nil, 0, NewQuicError(QUIC_CRYPTO_TOO_MANY_ENTRIES, "MessageDecoder.Feed : too many entries")
+	}
+	// Verify the tag-offset pairs already received
+	valuesOffset := messageHeaderSize + numEntries*messageEntrySize
+	var prevTag MessageTag
+	var prevEndOffset uint32
+	for i := 0; i < numEntries; i++ {
+		pos := messageHeaderSize + i*messageEntrySize
+		if len(data) < pos+messageEntrySize {
+			return nil, 0, nil
+		}
+		tag := MessageTag(binary.LittleEndian.Uint32(data[pos:]))
+		endOffset := binary.LittleEndian.Uint32(data[pos+4:])
+		if i > 0 {
+			if tag == prevTag {
+				return nil, 0, NewQuicError(QUIC_CRYPTO_DUPLICATE_TAG, "MessageDecoder.Feed : duplicate tag")
+			}
+			if tag < prevTag {
+				return nil, 0, NewQuicError(QUIC_CRYPTO_TAGS_OUT_OF_ORDER, "MessageDecoder.Feed : tags out of order")
+			}
+		}
+		if endOffset < prevEndOffset {
+			return nil, 0, NewQuicError(QUIC_CRYPTO_INVALID_VALUE_LENGTH, "MessageDecoder.Feed : end offsets out of order")
+		}
+		if uint64(valuesOffset)+uint64(endOffset) > MaxMessageSize {
+			return nil, 0, NewQuicError(QUIC_CRYPTO_INVALID_VALUE_LENGTH, "MessageDecoder.Feed : message too long")
+		}
+		prevTag = tag
+		prevEndOffset = endOffset
+	}
+	size := valuesOffset + int(prevEndOffset)
+	if len(data) < size {
+		return nil, 0, nil
+	}
+	// The Message owns a copy of its values, so that the decoder buffer can be reused
+	values := make([]byte, prevEndOffset)
+	copy(values, data[valuesOffset:size])
+	msg := &Message{
+		msgTag: msgTag,
+		tags:   make([]MessageTag, numEntries),
+		values: make([][]byte, numEntries)}
+	var start uint32
+	for i := 0; i < numEntries; i++ {
+		pos := messageHeaderSize + i*messageEntrySize
+		end := binary.LittleEndian.Uint32(data[pos+4:])
+		msg.tags[i] = MessageTag(binary.LittleEndian.Uint32(data[pos:]))
+		msg.values[i] = values[start:end:end]
+		start = end
+	}
+	return msg, size, nil
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import "testing"
+import "bytes"
+import "errors"
+
+type testMessageDecoder struct {
+	data []byte
+	err  QuicErrorCode
+}
+
+var tests_messagedecoder = []testMessageDecoder{
+	// Invalid message tag
+	{[]byte{'X', 'X', 'X', 'X', 0, 0, 0, 0}, QUIC_INVALID_CRYPTO_MESSAGE_TYPE},
+	// Too many entries
+	{[]byte{'C', 'H', 'L', 'O', MaxMessageTagNumEntries + 1, 0, 0, 0}, QUIC_CRYPTO_TOO_MANY_ENTRIES},
+	// Duplicate tag
+	{[]byte{'C', 'H', 'L', 'O', 2, 0, 0, 0, 'S', 'N', 'I', 0, 1, 0, 0, 0, 'S', 'N', 'I', 0, 2, 0, 0, 0, 1, 2}, QUIC_CRYPTO_DUPLICATE_TAG},
+	// Tags out of order (tags are sorted by their uint32 value, so SNI is lower than CETV)
+	{[]byte{'C', 'H', 'L', 'O', 2, 0, 0, 0, 'C', 'E', 'T', 'V', 1, 0, 0, 0, 'S', 'N', 'I', 0, 2, 0, 0, 0, 1, 2}, QUIC_CRYPTO_TAGS_OUT_OF_ORDER},
+	// End offsets out of order
+	{[]byte{'C', 'H', 'L', 'O', 2, 0, 0, 0, 'S', 'N', 'I', 0, 2, 0, 0, 0, 'C', 'E', 'T', 'V', 1, 0, 0, 0, 1, 2}, QUIC_CRYPTO_INVALID_VALUE_LENGTH},
+	// Message too long
+	{[]byte{'C', 'H', 'L', 'O', 1, 0, 0, 0, 'S', 'N', 'I', 0, 0xff, 0xff, 0xff, 0xff}, QUIC_CRYPTO_INVALID_VALUE_LENGTH},
+}
+
+func Test_MessageDecoder_Feed(t *testing.T) {
+	var data []byte
+	var msgs []*Message
+
+	// Serialize some valid messages
+	for i := 0; i < 4; i++ {
+		msg := NewMessage(TagSHLO)
+		if i > 0 {
+			msg.AddTagValue(TagSNI, []byte{1})
+		}
+		if i > 1 {
+			msg.AddTagValue(TagCETV, []byte{2, 3})
+		}
+		if i > 2 {
+			msg.AddTagValue(TagAEAD, []byte{4, 5, 6})
+		}
+		data = append(data, serialize(t, msg)...)
+	}
+
+	// Feed in one call
+	decoder := NewMessageDecoder()
+	msgs, err := decoder.Feed(data)
+	if err != nil {
+		t.Errorf("MessageDecoder.Feed : error %v", err)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("MessageDecoder.Feed : %v messages instead of 4", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.GetMessageTag() != TagSHLO {
+			t.Errorf("MessageDecoder.Feed : invalid message tag in message %v", i)
+		}
+		if msg.GetNumEntries() != uint16(i) {
+			t.Errorf("MessageDecoder.Feed : invalid number of entries %v in message %v", msg.GetNumEntries(), i)
+		}
+	}
+	if b, v := msgs[3].ContainsTag(TagAEAD); !b || !bytes.Equal(v, []byte{4, 5, 6}) {
+		t.Errorf("MessageDecoder.Feed : invalid AEAD value %v", v)
+	}
+	if decoder.Buffered() != 0 {
+		t.Errorf("MessageDecoder.Feed : %v bytes still buffered", decoder.Buffered())
+	}
+
+	// Feed byte per byte
+	decoder = NewMessageDecoder()
+	msgs = nil
+	for i := range data {
+		m, err := decoder.Feed(data[i : i+1])
+		if err != nil {
+			t.Fatalf("MessageDecoder.Feed : error %v at byte %v", err, i)
+		}
+		msgs = append(msgs, m...)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("MessageDecoder.Feed : %v messages instead of 4 when feeding byte per byte", len(msgs))
+	}
+	if b, v := msgs[2].ContainsTag(TagCETV); !b || !bytes.Equal(v, []byte{2, 3}) {
+		t.Errorf("MessageDecoder.Feed : invalid CETV value %v", v)
+	}
+
+	// The decoded values must not alias the fed bytes
+	decoder = NewMessageDecoder()
+	buf := append([]byte(nil), data...)
+	msgs, _ = decoder.Feed(buf)
+	for i := range buf {
+		buf[i] = 0
+	}
+	if b, v := msgs[1].ContainsTag(TagSNI); !b || !bytes.Equal(v, []byte{1}) {
+		t.Errorf("MessageDecoder.Feed : value modified by the caller %v", v)
+	}
+
+	// Invalid messages after a valid one
+	for i, v := range tests_messagedecoder {
+		decoder = NewMessageDecoder()
+		msgs, err = decoder.Feed(append(append([]byte(nil), data[:8]...), v.data...))
+		if len(msgs) != 1 {
+			t.Errorf("MessageDecoder.Feed : %v messages instead of 1 in test n°%v", len(msgs), i)
+		}
+		if !errors.Is(err, v.err) {
+			t.Errorf("MessageDecoder.Feed : error %v instead of %v in test n°%v", err, v.err, i)
+		}
+		// The error is sticky
+		msgs, err = decoder.Feed(data)
+		if len(msgs) != 0 || !errors.Is(err, v.err) {
+			t.Errorf("MessageDecoder.Feed : decoder not in error after test n°%v", i)
+		}
+	}
+}
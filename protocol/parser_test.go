@@ -2,6 +2,7 @@ package protocol
 
 import "testing"
 import "bytes"
+import "time"
 
 func tests(in chan<- []byte) {
 	in <- []byte{'C', 'H', 'L', 'O', 0, 0, 0, 0}
@@ -88,3 +89,44 @@ func Test_Parser(t *testing.T) {
 	}
 	parser.Stop()
 }
+
+func Test_Parser_Stop(t *testing.T) {
+	parser := NewParser()
+	if !parser.Start() {
+		t.Error("Parser.Start : parser already started")
+	}
+	if parser.Start() {
+		t.Error("Parser.Start : parser started twice")
+	}
+	// Stop must return while the Parser is waiting for input
+	if !parser.Stop() {
+		t.Error("Parser.Stop : parser not started")
+	}
+	if parser.Stop() {
+		t.Error("Parser.Stop : parser stopped twice")
+	}
+
+	// A message split over a Stop/Start is decoded
+	parser.Start()
+	parser.GetInput() <- []byte{'C', 'H', 'L', 'O', 1, 0, 0, 0}
+	parser.Stop()
+	parser.Start()
+	parser.GetInput() <- []byte{'S', 'N', 'I', 0, 1, 0, 0, 0, 1}
+	if msg := <-parser.GetOutput(); msg == nil || msg.GetNumEntries() != 1 {
+		t.Error("Parser.Start : message not decoded after restart")
+	}
+
+	// A nil value is sent on an invalid message, and then the Parser sends nothing more
+	parser.GetInput() <- []byte{'C', 'H', 'L', 'O', MaxMessageTagNumEntries + 1, 0, 0, 0}
+	if msg := <-parser.GetOutput(); msg != nil {
+		t.Error("Parser : no nil value on invalid message")
+	}
+	select {
+	case parser.GetInput() <- []byte{'C', 'H', 'L', 'O', 0, 0, 0, 0}:
+		t.Error("Parser : input read after an invalid message")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if !parser.Stop() {
+		t.Error("Parser.Stop : parser not started")
+	}
+}
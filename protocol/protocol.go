@@ -10,3 +10,6 @@ type DataSerialize interface {
 	GetSerializedSize() int
 	GetSerializedData() (data []byte, err error)
 }
+
+// QuicByteCount is a number of bytes (congestion window, bytes in flight, flow control window, ...)
+type QuicByteCount uint64
@@ -2,15 +2,42 @@ package protocol
 
 import "errors"
 
-const MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM = 0x80000 // = 524288
+// MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM is the maximum number of consecutive sequence numbers stored in an EntropyHashRingBuffer (about 9MB).
+const MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM = 0x4000000 // = 67108864
+
+const (
+	// entropySegmentSize is the number of sequence numbers of a segment of the EntropyHashRingBuffer
+	entropySegmentSize = 4096
+	// entropyWordSize is the number of sequence numbers stored in a word of a segment, a checkpoint is stored for each word
+	entropyWordSize = 64
+	// maxEntropySpareSegments is the number of released segments kept for reuse
+	maxEntropySpareSegments = 16
+)
 
 type QuicEntropyHash byte
 
+// GetPacketEntropyHash returns the entropy hash of a packet with the given entropy flag: the bit 'seqnum' modulo 8 if the flag is set, zero otherwise.
+func GetPacketEntropyHash(seqnum QuicPacketSequenceNumber, entropy bool) QuicEntropyHash {
+	if !entropy {
+		return 0
+	}
+	return QuicEntropyHash(1 << (seqnum & 0x7))
+}
+
+// entropySegment stores the entropy bits of 'entropySegmentSize' consecutive sequence numbers,
+// with the cumulative entropy hash of the packets before each word of bits.
+type entropySegment struct {
+	bits        [entropySegmentSize / entropyWordSize]uint64
+	checkpoints [entropySegmentSize / entropyWordSize]QuicEntropyHash
+}
+
 type EntropyHashRingBuffer struct {
-	largestKnownSeqNum      QuicPacketSequenceNumber // start of ring (=read)
-	largestKnownEntropyHash QuicEntropyHash          // Cumulative entropy hash since first packet
-	nextSeqNum              QuicPacketSequenceNumber // end of ring (=write)
-	hashes                  [0x10000]byte            // = 65536 bytes
+	largestKnownSeqNum QuicPacketSequenceNumber // start of ring (=read)
+	nextSeqNum         QuicPacketSequenceNumber // end of ring (=write)
+	nextEntropyHash    QuicEntropyHash          // Cumulative entropy hash of the packets before nextSeqNum
+	firstSeqNum        QuicPacketSequenceNumber // sequence number of the first bit of the first segment
+	segments           []*entropySegment
+	spare              []*entropySegment
 }
 
 // NewEntropyHashRingBuffer is a factory that returns an EntropyHashRingBuffer and its associated size.
@@ -18,33 +45,50 @@ type EntropyHashRingBuffer struct {
 // Ring buffer left capacity is decreasing each time 'GetNewPacket()' is called.
 // Ring buffer left capacity is increasing each time 'SetLargestKnownPacket()' is called.
 // It is possible to generate and manage 2^46 packets sequence number and associated entropy bit,
-// but the current limitation is that there must be no more than MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM packets in flight.
-// At this time, MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM = 524.288 consecutive sequence numbers with a memory footprint of only 64KB,
-// if considering a conservative mean stream frame size of 512 bytes, then a theoretical maximum congestion window of 268.435.456 bytes can be managed.
+// but there must be no more than MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM packets between the largest known packet and the next packet.
+// The entropy bits are stored in segments of 4096 packets (576 bytes) allocated on demand and released when the largest known packet advances,
+// so the memory footprint follows the number of packets in flight: 72KB for 524.288 packets, 9MB for 67.108.864 packets.
+// The cumulative entropy hashes are computed in constant time thanks to a checkpoint every 64 packets.
 func NewEntropyHashRingBuffer() (entropymanager *EntropyHashRingBuffer, size int) {
 	entropymanager = &EntropyHashRingBuffer{largestKnownSeqNum: 1, nextSeqNum: 1}
 	size = MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM
 	return
 }
 
+// locate returns the segment, the word index and the bit index of a Sequence Number.
+// Note: no boundary check is make on the sequence number.
+func (this *EntropyHashRingBuffer) locate(seqnum QuicPacketSequenceNumber) (segment *entropySegment, word, bit uint) {
+	index := seqnum - this.firstSeqNum
+	segment = this.segments[index/entropySegmentSize]
+	word = uint((index % entropySegmentSize) / entropyWordSize)
+	bit = uint(index % entropyWordSize)
+	return
+}
+
+// foldEntropyBits returns the entropy hash of the packets of a word of bits: the first bit of the word is a multiple of 8,
+// so the bit 'i' of each byte is the entropy hash bit of its packet.
+func foldEntropyBits(bits uint64) QuicEntropyHash {
+	bits ^= bits >> 32
+	bits ^= bits >> 16
+	bits ^= bits >> 8
+	return QuicEntropyHash(bits)
+}
+
 // getEntropy returns the entropy bit status for a given Sequence Number.
 // Note: no boundary check is make on the sequence number: for debugging and 'go test' purpose only.
 func (this *EntropyHashRingBuffer) getEntropy(seqnum QuicPacketSequenceNumber) bool {
-	if QuicEntropyHash(this.hashes[(seqnum>>3)&0xffff]&(1<<(seqnum&0x7))) == 0 {
-		return false
-	}
-	return true
+	segment, word, bit := this.locate(seqnum)
+	return (segment.bits[word] & (1 << bit)) != 0
 }
 
-// setEntropy sets the entropy bit status for a given Sequence Number.
-// Note: no boundary check is make on the sequence number: for debugging and 'go test' purpose only.
-func (this *EntropyHashRingBuffer) setEntropy(seqnum QuicPacketSequenceNumber, entropy bool) {
-	if entropy {
-		this.hashes[(seqnum>>3)&0xffff] |= (1 << (seqnum & 0x7))
-	} else {
-		this.hashes[(seqnum>>3)&0xffff] &= (0xff ^ (1 << (seqnum & 0x7)))
+// getCumulativeEntropyHash returns the cumulative entropy hash of the packets before the given Sequence Number.
+// Note: no boundary check is make on the sequence number.
+func (this *EntropyHashRingBuffer) getCumulativeEntropyHash(seqnum QuicPacketSequenceNumber) QuicEntropyHash {
+	if seqnum == this.nextSeqNum {
+		return this.nextEntropyHash
 	}
-	return
+	segment, word, bit := this.locate(seqnum)
+	return segment.checkpoints[word] ^ foldEntropyBits(segment.bits[word]&((1<<bit)-1))
 }
 
 // GetEntropyHash returns the non cumulative entropy hash for the requested sequence number.
@@ -54,35 +98,30 @@ func (this *EntropyHashRingBuffer) GetEntropyHash(seqnum QuicPacketSequenceNumbe
 		err = errors.New("EntropyHashRingBuffer.GetEntropyHash : invalid Packet Sequence Number")
 		return
 	}
-	hash = QuicEntropyHash(this.hashes[(seqnum>>3)&0xffff] & (1 << (seqnum & 0x7)))
+	hash = GetPacketEntropyHash(seqnum, this.getEntropy(seqnum))
 	return
 }
 
-// GetCumulativeEntropyHash returns the cumulative entropy hash for the requested sequence number since the very first packet.
+// GetCumulativeEntropyHash returns the cumulative entropy hash of the packets since the very first packet and before the requested sequence number.
+// The requested sequence number can be the next packet, to get the cumulative entropy hash of all the packets.
 func (this *EntropyHashRingBuffer) GetCumulativeEntropyHash(seqnum QuicPacketSequenceNumber) (hash QuicEntropyHash, err error) {
-	if (seqnum < this.largestKnownSeqNum) || (seqnum >= this.nextSeqNum) {
+	if (seqnum < this.largestKnownSeqNum) || (seqnum > this.nextSeqNum) {
 		err = errors.New("EntropyHashRingBuffer.GetCumulativeEntropyHash : invalid Packet Sequence Number")
+		return
 	}
-	hash = this.largestKnownEntropyHash
-	if seqnum > this.largestKnownSeqNum {
-		for i := this.largestKnownSeqNum; i < seqnum; i++ {
-			hash ^= QuicEntropyHash(this.hashes[(i>>3)&0xffff] & (1 << (i & 0x7)))
-		}
-	}
+	hash = this.getCumulativeEntropyHash(seqnum)
 	return
 }
 
-// GetCumulativeEntropyHashFromTo returns the cumulative entropy hash 'from' a starting sequence number 'to' a ending sequence number,
+// GetCumulativeEntropyHashFromTo returns the cumulative entropy hash 'from' a starting sequence number 'to' a ending sequence number (included),
 // and returns an error if the given sequence numbers are out of scope of the ring buffer.
 // Note that the 'from' sequence number must be less than or equal to the 'to' sequence number.
 func (this *EntropyHashRingBuffer) GetCumulativeEntropyHashFromTo(from, to QuicPacketSequenceNumber) (hash QuicEntropyHash, err error) {
 	if (from > to) || (from < this.largestKnownSeqNum) || (to >= this.nextSeqNum) {
 		err = errors.New("EntropyHashRingBuffer.GetCumulativeEntropyHashFromTo : invalid 'from' and 'to' Packet Sequence Number")
+		return
 	}
-	hash = QuicEntropyHash(this.hashes[(from>>3)&0xffff] & (1 << (from & 0x7)))
-	for i := from; i <= to; i++ {
-		hash ^= QuicEntropyHash(this.hashes[(i>>3)&0xffff] & (1 << (i & 0x7)))
-	}
+	hash = this.getCumulativeEntropyHash(from) ^ this.getCumulativeEntropyHash(to+1)
 	return
 }
 
@@ -90,39 +129,58 @@ func (this *EntropyHashRingBuffer) GetCumulativeEntropyHashFromTo(from, to QuicP
 // GetNewPacket is typically called for creating/sending a new QUIC packet.
 func (this *EntropyHashRingBuffer) GetNewPacket(entropy bool) (seqnum QuicPacketSequenceNumber, err error) {
 	// Check if ring buffer is full
-	if int(this.nextSeqNum-this.largestKnownSeqNum) >= MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM {
+	if this.nextSeqNum-this.largestKnownSeqNum >= MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM {
 		err = errors.New("EntropyHashRingBuffer.GetNewPacket : ring buffer full, can't store new packet entropy")
 		return
 	}
 	seqnum = this.nextSeqNum
+	// Allocate a new segment at the end of the ring
+	if (seqnum-this.firstSeqNum)/entropySegmentSize == QuicPacketSequenceNumber(len(this.segments)) {
+		var segment *entropySegment
+		if l := len(this.spare); l > 0 {
+			segment = this.spare[l-1]
+			this.spare = this.spare[:l-1]
+			*segment = entropySegment{}
+		} else {
+			segment = new(entropySegment)
+		}
+		this.segments = append(this.segments, segment)
+	}
+	segment, word, bit := this.locate(seqnum)
+	if bit == 0 {
+		// Checkpoint of the new word
+		segment.checkpoints[word] = this.nextEntropyHash
+	}
 	if entropy {
-		// Set the correct bit in the correct byte of the ring buffer
-		this.hashes[(seqnum>>3)&0xffff] |= (1 << (seqnum & 0x7))
-	} else {
-		// Clear the correct bit in the correct byte of the ring buffer
-		this.hashes[(seqnum>>3)&0xffff] &= (0xff ^ (1 << (seqnum & 0x7)))
+		// Set the correct bit in the correct word of the ring buffer
+		segment.bits[word] |= 1 << bit
 	}
+	this.nextEntropyHash ^= GetPacketEntropyHash(seqnum, entropy)
 	this.nextSeqNum++
 	return
 }
 
-// SetLargestKnownPacket removes the begining hashes from the ring buffer up to and including the given Sequence Number,
-// it returns the cumulative entropy hash for this new largest known sequence number and an error if the given sequence number is out of scope of the ring buffer.
+// SetLargestKnownPacket removes the begining hashes from the ring buffer up to (and excluding) the given Sequence Number,
+// it returns the cumulative entropy hash of the packets before this new largest known sequence number and an error if the given sequence number is out of scope of the ring buffer.
+// The segments of the ring buffer that only contain removed hashes are released.
 func (this *EntropyHashRingBuffer) SetLargestKnownPacket(seqnum QuicPacketSequenceNumber) (hash QuicEntropyHash, err error) {
 	// Check sequence number validity towards current bounds of the ring buffer
 	if (seqnum < this.largestKnownSeqNum) || (seqnum >= this.nextSeqNum) {
 		err = errors.New("EntropyHashRingBuffer.SetLargestKnownPacket : invalid Packet Sequence Number")
+		return
 	}
 	// Compute and return the hash for this new largest known sequence number
-	hash = this.largestKnownEntropyHash
-	if seqnum > this.largestKnownSeqNum {
-		for i := this.largestKnownSeqNum; i < seqnum; i++ {
-			hash ^= QuicEntropyHash(this.hashes[(i>>3)&0xffff] & (1 << (i & 0x7)))
+	hash = this.getCumulativeEntropyHash(seqnum)
+	// Update Largest Known Sequence Number
+	this.largestKnownSeqNum = seqnum
+	// Release the segments before the largest known sequence number
+	for seqnum-this.firstSeqNum >= entropySegmentSize {
+		if len(this.spare) < maxEntropySpareSegments {
+			this.spare = append(this.spare, this.segments[0])
 		}
-		// Update Largest Known Entropy Hash
-		this.largestKnownEntropyHash = hash
-		// Update Largest Known Sequence Number
-		this.largestKnownSeqNum = seqnum
+		this.segments[0] = nil
+		this.segments = this.segments[1:]
+		this.firstSeqNum += entropySegmentSize
 	}
 	return
 }
@@ -24,10 +24,109 @@ func Test_GetEntropyHash(t *testing.T) {
 		}
 		fmt.Printf("get hash[%v] = %x\n", seqnum, hash)
 	}
-	for i := range rb.hashes {
-		if rb.hashes[i] != 0xff {
+	for i := QuicPacketSequenceNumber(1); i <= seqnum; i++ {
+		if !rb.getEntropy(i) {
 			t.Error("bad hashes initialization with GetNewPacket")
 			return
 		}
 	}
 }
+
+// testentropy returns a pseudo random entropy flag for a sequence number.
+func testentropy(seqnum QuicPacketSequenceNumber) bool {
+	return ((seqnum * 0x9e3779b97f4a7c15) >> 61 & 1) == 1
+}
+
+func Test_EntropyHashRingBuffer_Grow(t *testing.T) {
+	const count = 3*MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM/64 + 1000
+
+	rb, _ := NewEntropyHashRingBuffer()
+	cumulative := make([]QuicEntropyHash, count+2)
+	for i := 1; i <= count; i++ {
+		seqnum, err := rb.GetNewPacket(testentropy(QuicPacketSequenceNumber(i)))
+		if err != nil {
+			t.Fatalf("EntropyHashRingBuffer.GetNewPacket : error %v after %v packets", err, i)
+		}
+		cumulative[i+1] = cumulative[i] ^ GetPacketEntropyHash(seqnum, testentropy(seqnum))
+	}
+	if l := len(rb.segments); l != (count+1+entropySegmentSize-1)/entropySegmentSize {
+		t.Errorf("EntropyHashRingBuffer.GetNewPacket : invalid number of segments %v", l)
+	}
+	for _, seqnum := range []QuicPacketSequenceNumber{1, 2, 63, 64, 65, 4095, 4096, 4097, 524288, 524289, count, count + 1} {
+		if hash, err := rb.GetCumulativeEntropyHash(seqnum); (err != nil) || (hash != cumulative[seqnum]) {
+			t.Errorf("EntropyHashRingBuffer.GetCumulativeEntropyHash : invalid hash 0x%02x for packet %v (0x%02x expected, %v)", hash, seqnum, cumulative[seqnum], err)
+		}
+	}
+	if hash, err := rb.GetCumulativeEntropyHashFromTo(100, 600000); (err != nil) || (hash != cumulative[600001]^cumulative[100]) {
+		t.Errorf("EntropyHashRingBuffer.GetCumulativeEntropyHashFromTo : invalid hash 0x%02x (0x%02x expected, %v)", hash, cumulative[600001]^cumulative[100], err)
+	}
+	// Shrink
+	if hash, err := rb.SetLargestKnownPacket(count - 5000); (err != nil) || (hash != cumulative[count-5000]) {
+		t.Errorf("EntropyHashRingBuffer.SetLargestKnownPacket : invalid hash 0x%02x (0x%02x expected, %v)", hash, cumulative[count-5000], err)
+	}
+	if l := len(rb.segments); l > 3 {
+		t.Errorf("EntropyHashRingBuffer.SetLargestKnownPacket : %v segments kept for 5000 packets", l)
+	}
+	if _, err := rb.GetCumulativeEntropyHash(count - 5001); err == nil {
+		t.Error("EntropyHashRingBuffer.GetCumulativeEntropyHash : must return an error before the largest known packet")
+	}
+	for _, seqnum := range []QuicPacketSequenceNumber{count - 5000, count - 4000, count} {
+		if hash, _ := rb.GetCumulativeEntropyHash(seqnum); hash != cumulative[seqnum] {
+			t.Errorf("EntropyHashRingBuffer.GetCumulativeEntropyHash : invalid hash 0x%02x for packet %v after shrink", hash, seqnum)
+		}
+	}
+	// Released segments are reused
+	for i := count + 1; i <= count+10000; i++ {
+		seqnum, _ := rb.GetNewPacket(testentropy(QuicPacketSequenceNumber(i)))
+		cumulative = append(cumulative, cumulative[seqnum]^GetPacketEntropyHash(seqnum, testentropy(seqnum)))
+	}
+	if hash, _ := rb.GetCumulativeEntropyHash(count + 10001); hash != cumulative[count+10001] {
+		t.Errorf("EntropyHashRingBuffer.GetCumulativeEntropyHash : invalid hash 0x%02x after segments reuse (0x%02x expected)", hash, cumulative[count+10001])
+	}
+	// Ring buffer full
+	rb, _ = NewEntropyHashRingBuffer()
+	rb.nextSeqNum = MAXIMUM_ENTROPY_CONSECUTIVE_SEQNUM + 1
+	if _, err := rb.GetNewPacket(true); err == nil {
+		t.Error("EntropyHashRingBuffer.GetNewPacket : must return an error when the ring buffer is full")
+	}
+}
+
+// benchmarkEntropyHashRingBuffer returns a ring buffer with 'inflight' packets after the largest known packet.
+func benchmarkEntropyHashRingBuffer(inflight int) *EntropyHashRingBuffer {
+	rb, _ := NewEntropyHashRingBuffer()
+	for i := 1; i <= inflight; i++ {
+		rb.GetNewPacket(testentropy(QuicPacketSequenceNumber(i)))
+	}
+	return rb
+}
+
+// 10 Gbps during 200 ms with packets of 1350 bytes: about 185.000 packets in flight
+func Benchmark_EntropyHashRingBuffer_GetNewPacket(b *testing.B) {
+	rb := benchmarkEntropyHashRingBuffer(185000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		seqnum, _ := rb.GetNewPacket(true)
+		// Sliding window of 185.000 packets in flight
+		rb.SetLargestKnownPacket(seqnum - 185000)
+	}
+}
+
+func Benchmark_EntropyHashRingBuffer_GetCumulativeEntropyHash(b *testing.B) {
+	const inflight = 4 * 1024 * 1024
+	rb := benchmarkEntropyHashRingBuffer(inflight)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.GetCumulativeEntropyHash(QuicPacketSequenceNumber(1 + (i*7919)%inflight))
+	}
+}
+
+func Benchmark_EntropyHashRingBuffer_GetCumulativeEntropyHashFromTo(b *testing.B) {
+	const inflight = 4 * 1024 * 1024
+	rb := benchmarkEntropyHashRingBuffer(inflight)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		from := QuicPacketSequenceNumber(1 + (i*7919)%(inflight/2))
+		rb.GetCumulativeEntropyHashFromTo(from, from+inflight/4)
+	}
+}
@@ -1,3 +1,300 @@
 package protocol
 
+import "fmt"
+
+// QuicErrorCode is the error code of the CONNECTION_CLOSE, RST_STREAM and GOAWAY frames.
+// QuicErrorCode implements the error interface, so that a QuicError can be compared to an error code with errors.Is.
 type QuicErrorCode uint32
+
+const (
+	// No error, used for a normal close of the connection
+	QUIC_NO_ERROR QuicErrorCode = 0
+	// Connection has reached an invalid state
+	QUIC_INTERNAL_ERROR QuicErrorCode = 1
+	// There were data frames after the a fin or reset
+	QUIC_STREAM_DATA_AFTER_TERMINATION QuicErrorCode = 2
+	// Packet header is malformed
+	QUIC_INVALID_PACKET_HEADER QuicErrorCode = 3
+	// Frame data is malformed
+	QUIC_INVALID_FRAME_DATA QuicErrorCode = 4
+	// The packet contained no payload
+	QUIC_MISSING_PAYLOAD QuicErrorCode = 48
+	// FEC data is malformed
+	QUIC_INVALID_FEC_DATA QuicErrorCode = 5
+	// STREAM frame data is malformed
+	QUIC_INVALID_STREAM_DATA QuicErrorCode = 46
+	// STREAM frame data is not encrypted
+	QUIC_UNENCRYPTED_STREAM_DATA QuicErrorCode = 61
+	// RST_STREAM frame data is malformed
+	QUIC_INVALID_RST_STREAM_DATA QuicErrorCode = 6
+	// CONNECTION_CLOSE frame data is malformed
+	QUIC_INVALID_CONNECTION_CLOSE_DATA QuicErrorCode = 7
+	// GOAWAY frame data is malformed
+	QUIC_INVALID_GOAWAY_DATA QuicErrorCode = 8
+	// WINDOW_UPDATE frame data is malformed
+	QUIC_INVALID_WINDOW_UPDATE_DATA QuicErrorCode = 57
+	// BLOCKED frame data is malformed
+	QUIC_INVALID_BLOCKED_DATA QuicErrorCode = 58
+	// STOP_WAITING frame data is malformed
+	QUIC_INVALID_STOP_WAITING_DATA QuicErrorCode = 60
+	// Ack data is malformed
+	QUIC_INVALID_ACK_DATA QuicErrorCode = 9
+	// Congestion feedback data is malformed
+	QUIC_INVALID_CONGESTION_FEEDBACK_DATA QuicErrorCode = 47
+	// Version negotiation packet is malformed
+	QUIC_INVALID_VERSION_NEGOTIATION_PACKET QuicErrorCode = 10
+	// Public RST packet is malformed
+	QUIC_INVALID_PUBLIC_RST_PACKET QuicErrorCode = 11
+	// There was an error decrypting
+	QUIC_DECRYPTION_FAILURE QuicErrorCode = 12
+	// There was an error encrypting
+	QUIC_ENCRYPTION_FAILURE QuicErrorCode = 13
+	// The packet exceeded kMaxPacketSize
+	QUIC_PACKET_TOO_LARGE QuicErrorCode = 14
+	// Data was sent for a stream which did not exist
+	QUIC_PACKET_FOR_NONEXISTENT_STREAM QuicErrorCode = 15
+	// The peer is going away. May be a client or server
+	QUIC_PEER_GOING_AWAY QuicErrorCode = 16
+	// A stream ID was invalid
+	QUIC_INVALID_STREAM_ID QuicErrorCode = 17
+	// A priority was invalid
+	QUIC_INVALID_PRIORITY QuicErrorCode = 49
+	// Too many streams already open
+	QUIC_TOO_MANY_OPEN_STREAMS QuicErrorCode = 18
+	// The peer must send a FIN/RST for each stream, and has not been doing so
+	QUIC_TOO_MANY_UNFINISHED_STREAMS QuicErrorCode = 66
+	// Received public reset for this connection
+	QUIC_PUBLIC_RESET QuicErrorCode = 19
+	// Invalid protocol version
+	QUIC_INVALID_VERSION QuicErrorCode = 20
+	// Stream reset before headers decompressed (deprecated)
+	QUIC_STREAM_RST_BEFORE_HEADERS_DECOMPRESSED QuicErrorCode = 21
+	// The Header ID for a stream was too far from the previous
+	QUIC_INVALID_HEADER_ID QuicErrorCode = 22
+	// Negotiable parameter received during handshake had invalid value
+	QUIC_INVALID_NEGOTIATED_VALUE QuicErrorCode = 23
+	// There was an error decompressing data
+	QUIC_DECOMPRESSION_FAILURE QuicErrorCode = 24
+	// We hit our prenegotiated (or default) timeout
+	QUIC_CONNECTION_TIMED_OUT QuicErrorCode = 25
+	// We hit our overall connection timeout
+	QUIC_CONNECTION_OVERALL_TIMED_OUT QuicErrorCode = 67
+	// There was an error encountered migrating addresses
+	QUIC_ERROR_MIGRATING_ADDRESS QuicErrorCode = 26
+	// There was an error while writing to the socket
+	QUIC_PACKET_WRITE_ERROR QuicErrorCode = 27
+	// There was an error while reading from the socket
+	QUIC_PACKET_READ_ERROR QuicErrorCode = 51
+	// We received a STREAM_FRAME with no data and no fin flag set
+	QUIC_INVALID_STREAM_FRAME QuicErrorCode = 50
+	// We received invalid data on the headers stream
+	QUIC_INVALID_HEADERS_STREAM_DATA QuicErrorCode = 56
+	// Receive window was exceeded by the peer
+	QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA QuicErrorCode = 59
+	// Send window was exceeded by the local endpoint
+	QUIC_FLOW_CONTROL_SENT_TOO_MUCH_DATA QuicErrorCode = 63
+	// Flow control window is not valid
+	QUIC_FLOW_CONTROL_INVALID_WINDOW QuicErrorCode = 64
+	// The connection has been IP pooled into an existing connection
+	QUIC_CONNECTION_IP_POOLED QuicErrorCode = 62
+	// The connection has too many outstanding sent packets
+	QUIC_TOO_MANY_OUTSTANDING_SENT_PACKETS QuicErrorCode = 68
+	// The connection has too many outstanding received packets
+	QUIC_TOO_MANY_OUTSTANDING_RECEIVED_PACKETS QuicErrorCode = 69
+	// The QUIC connection has been cancelled
+	QUIC_CONNECTION_CANCELLED QuicErrorCode = 70
+	// Disabled QUIC because of high packet loss rate
+	QUIC_BAD_PACKET_LOSS_RATE QuicErrorCode = 71
+
+	// Crypto errors
+
+	// Hanshake failed
+	QUIC_HANDSHAKE_FAILED QuicErrorCode = 28
+	// Handshake message contained out of order tags
+	QUIC_CRYPTO_TAGS_OUT_OF_ORDER QuicErrorCode = 29
+	// Handshake message contained too many entries
+	QUIC_CRYPTO_TOO_MANY_ENTRIES QuicErrorCode = 30
+	// Handshake message contained an invalid value length
+	QUIC_CRYPTO_INVALID_VALUE_LENGTH QuicErrorCode = 31
+	// A crypto message was received after the handshake was complete
+	QUIC_CRYPTO_MESSAGE_AFTER_HANDSHAKE_COMPLETE QuicErrorCode = 32
+	// A crypto message was received with an illegal message tag
+	QUIC_INVALID_CRYPTO_MESSAGE_TYPE QuicErrorCode = 33
+	// A crypto message was received with an illegal parameter
+	QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER QuicErrorCode = 34
+	// An invalid channel id signature was supplied
+	QUIC_INVALID_CHANNEL_ID_SIGNATURE QuicErrorCode = 52
+	// A crypto message was received with a mandatory parameter missing
+	QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND QuicErrorCode = 35
+	// A crypto message was received with a parameter that has no overlap with the local parameter
+	QUIC_CRYPTO_MESSAGE_PARAMETER_NO_OVERLAP QuicErrorCode = 36
+	// A crypto message was received that contained a parameter with too few values
+	QUIC_CRYPTO_MESSAGE_INDEX_NOT_FOUND QuicErrorCode = 37
+	// An internal error occured in crypto processing
+	QUIC_CRYPTO_INTERNAL_ERROR QuicErrorCode = 38
+	// A crypto handshake message specified an unsupported version
+	QUIC_CRYPTO_VERSION_NOT_SUPPORTED QuicErrorCode = 39
+	// There was no intersection between the crypto primitives supported by the peer and ourselves
+	QUIC_CRYPTO_NO_SUPPORT QuicErrorCode = 40
+	// The server rejected our client hello messages too many times
+	QUIC_CRYPTO_TOO_MANY_REJECTS QuicErrorCode = 41
+	// The client rejected the server's certificate chain or signature
+	QUIC_PROOF_INVALID QuicErrorCode = 42
+	// A crypto message was received with a duplicate tag
+	QUIC_CRYPTO_DUPLICATE_TAG QuicErrorCode = 43
+	// A crypto message was received with the wrong encryption level (i.e. it should have been encrypted but was not)
+	QUIC_CRYPTO_ENCRYPTION_LEVEL_INCORRECT QuicErrorCode = 44
+	// The server config for a server has expired
+	QUIC_CRYPTO_SERVER_CONFIG_EXPIRED QuicErrorCode = 45
+	// We failed to setup the symmetric keys for a connection
+	QUIC_CRYPTO_SYMMETRIC_KEY_SETUP_FAILED QuicErrorCode = 53
+	// A handshake message arrived, but we are still validating the previous handshake message
+	QUIC_CRYPTO_MESSAGE_WHILE_VALIDATING_CLIENT_HELLO QuicErrorCode = 54
+	// A server config update arrived before the handshake is complete
+	QUIC_CRYPTO_UPDATE_BEFORE_HANDSHAKE_COMPLETE QuicErrorCode = 65
+	// This connection involved a version negotiation which appears to have been tampered with
+	QUIC_VERSION_NEGOTIATION_MISMATCH QuicErrorCode = 55
+
+	// Private errors (outside of the Chromium range)
+
+	// Entropy hash of an ACK frame doesn't match the acknowledged packets
+	QUIC_INVALID_ENTROPY_HASH QuicErrorCode = 0x80000001
+)
+
+var quicErrorCodeNames = map[QuicErrorCode]string{
+	QUIC_NO_ERROR:                                     "QUIC_NO_ERROR",
+	QUIC_INTERNAL_ERROR:                               "QUIC_INTERNAL_ERROR",
+	QUIC_STREAM_DATA_AFTER_TERMINATION:                "QUIC_STREAM_DATA_AFTER_TERMINATION",
+	QUIC_INVALID_PACKET_HEADER:                        "QUIC_INVALID_PACKET_HEADER",
+	QUIC_INVALID_FRAME_DATA:                           "QUIC_INVALID_FRAME_DATA",
+	QUIC_MISSING_PAYLOAD:                              "QUIC_MISSING_PAYLOAD",
+	QUIC_INVALID_FEC_DATA:                             "QUIC_INVALID_FEC_DATA",
+	QUIC_INVALID_STREAM_DATA:                          "QUIC_INVALID_STREAM_DATA",
+	QUIC_UNENCRYPTED_STREAM_DATA:                      "QUIC_UNENCRYPTED_STREAM_DATA",
+	QUIC_INVALID_RST_STREAM_DATA:                      "QUIC_INVALID_RST_STREAM_DATA",
+	QUIC_INVALID_CONNECTION_CLOSE_DATA:                "QUIC_INVALID_CONNECTION_CLOSE_DATA",
+	QUIC_INVALID_GOAWAY_DATA:                          "QUIC_INVALID_GOAWAY_DATA",
+	QUIC_INVALID_WINDOW_UPDATE_DATA:                   "QUIC_INVALID_WINDOW_UPDATE_DATA",
+	QUIC_INVALID_BLOCKED_DATA:                         "QUIC_INVALID_BLOCKED_DATA",
+	QUIC_INVALID_STOP_WAITING_DATA:                    "QUIC_INVALID_STOP_WAITING_DATA",
+	QUIC_INVALID_ACK_DATA:                             "QUIC_INVALID_ACK_DATA",
+	QUIC_INVALID_CONGESTION_FEEDBACK_DATA:             "QUIC_INVALID_CONGESTION_FEEDBACK_DATA",
+	QUIC_INVALID_VERSION_NEGOTIATION_PACKET:           "QUIC_INVALID_VERSION_NEGOTIATION_PACKET",
+	QUIC_INVALID_PUBLIC_RST_PACKET:                    "QUIC_INVALID_PUBLIC_RST_PACKET",
+	QUIC_DECRYPTION_FAILURE:                           "QUIC_DECRYPTION_FAILURE",
+	QUIC_ENCRYPTION_FAILURE:                           "QUIC_ENCRYPTION_FAILURE",
+	QUIC_PACKET_TOO_LARGE:                             "QUIC_PACKET_TOO_LARGE",
+	QUIC_PACKET_FOR_NONEXISTENT_STREAM:                "QUIC_PACKET_FOR_NONEXISTENT_STREAM",
+	QUIC_PEER_GOING_AWAY:                              "QUIC_PEER_GOING_AWAY",
+	QUIC_INVALID_STREAM_ID:                            "QUIC_INVALID_STREAM_ID",
+	QUIC_INVALID_PRIORITY:                             "QUIC_INVALID_PRIORITY",
+	QUIC_TOO_MANY_OPEN_STREAMS:                        "QUIC_TOO_MANY_OPEN_STREAMS",
+	QUIC_TOO_MANY_UNFINISHED_STREAMS:                  "QUIC_TOO_MANY_UNFINISHED_STREAMS",
+	QUIC_PUBLIC_RESET:                                 "QUIC_PUBLIC_RESET",
+	QUIC_INVALID_VERSION:                              "QUIC_INVALID_VERSION",
+	QUIC_STREAM_RST_BEFORE_HEADERS_DECOMPRESSED:       "QUIC_STREAM_RST_BEFORE_HEADERS_DECOMPRESSED",
+	QUIC_INVALID_HEADER_ID:                            "QUIC_INVALID_HEADER_ID",
+	QUIC_INVALID_NEGOTIATED_VALUE:                     "QUIC_INVALID_NEGOTIATED_VALUE",
+	QUIC_DECOMPRESSION_FAILURE:                        "QUIC_DECOMPRESSION_FAILURE",
+	QUIC_CONNECTION_TIMED_OUT:                         "QUIC_CONNECTION_TIMED_OUT",
+	QUIC_CONNECTION_OVERALL_TIMED_OUT:                 "QUIC_CONNECTION_OVERALL_TIMED_OUT",
+	QUIC_ERROR_MIGRATING_ADDRESS:                      "QUIC_ERROR_MIGRATING_ADDRESS",
+	QUIC_PACKET_WRITE_ERROR:                           "QUIC_PACKET_WRITE_ERROR",
+	QUIC_PACKET_READ_ERROR:                            "QUIC_PACKET_READ_ERROR",
+	QUIC_INVALID_STREAM_FRAME:                         "QUIC_INVALID_STREAM_FRAME",
+	QUIC_INVALID_HEADERS_STREAM_DATA:                  "QUIC_INVALID_HEADERS_STREAM_DATA",
+	QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA:          "QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA",
+	QUIC_FLOW_CONTROL_SENT_TOO_MUCH_DATA:              "QUIC_FLOW_CONTROL_SENT_TOO_MUCH_DATA",
+	QUIC_FLOW_CONTROL_INVALID_WINDOW:                  "QUIC_FLOW_CONTROL_INVALID_WINDOW",
+	QUIC_CONNECTION_IP_POOLED:                         "QUIC_CONNECTION_IP_POOLED",
+	QUIC_TOO_MANY_OUTSTANDING_SENT_PACKETS:            "QUIC_TOO_MANY_OUTSTANDING_SENT_PACKETS",
+	QUIC_TOO_MANY_OUTSTANDING_RECEIVED_PACKETS:        "QUIC_TOO_MANY_OUTSTANDING_RECEIVED_PACKETS",
+	QUIC_CONNECTION_CANCELLED:                         "QUIC_CONNECTION_CANCELLED",
+	QUIC_BAD_PACKET_LOSS_RATE:                         "QUIC_BAD_PACKET_LOSS_RATE",
+	QUIC_HANDSHAKE_FAILED:                             "QUIC_HANDSHAKE_FAILED",
+	QUIC_CRYPTO_TAGS_OUT_OF_ORDER:                     "QUIC_CRYPTO_TAGS_OUT_OF_ORDER",
+	QUIC_CRYPTO_TOO_MANY_ENTRIES:                      "QUIC_CRYPTO_TOO_MANY_ENTRIES",
+	QUIC_CRYPTO_INVALID_VALUE_LENGTH:                  "QUIC_CRYPTO_INVALID_VALUE_LENGTH",
+	QUIC_CRYPTO_MESSAGE_AFTER_HANDSHAKE_COMPLETE:      "QUIC_CRYPTO_MESSAGE_AFTER_HANDSHAKE_COMPLETE",
+	QUIC_INVALID_CRYPTO_MESSAGE_TYPE:                  "QUIC_INVALID_CRYPTO_MESSAGE_TYPE",
+	QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER:             "QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER",
+	QUIC_INVALID_CHANNEL_ID_SIGNATURE:                 "QUIC_INVALID_CHANNEL_ID_SIGNATURE",
+	QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND:           "QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND",
+	QUIC_CRYPTO_MESSAGE_PARAMETER_NO_OVERLAP:          "QUIC_CRYPTO_MESSAGE_PARAMETER_NO_OVERLAP",
+	QUIC_CRYPTO_MESSAGE_INDEX_NOT_FOUND:               "QUIC_CRYPTO_MESSAGE_INDEX_NOT_FOUND",
+	QUIC_CRYPTO_INTERNAL_ERROR:                        "QUIC_CRYPTO_INTERNAL_ERROR",
+	QUIC_CRYPTO_VERSION_NOT_SUPPORTED:                 "QUIC_CRYPTO_VERSION_NOT_SUPPORTED",
+	QUIC_CRYPTO_NO_SUPPORT:                            "QUIC_CRYPTO_NO_SUPPORT",
+	QUIC_CRYPTO_TOO_MANY_REJECTS:                      "QUIC_CRYPTO_TOO_MANY_REJECTS",
+	QUIC_PROOF_INVALID:                                "QUIC_PROOF_INVALID",
+	QUIC_CRYPTO_DUPLICATE_TAG:                         "QUIC_CRYPTO_DUPLICATE_TAG",
+	QUIC_CRYPTO_ENCRYPTION_LEVEL_INCORRECT:            "QUIC_CRYPTO_ENCRYPTION_LEVEL_INCORRECT",
+	QUIC_CRYPTO_SERVER_CONFIG_EXPIRED:                 "QUIC_CRYPTO_SERVER_CONFIG_EXPIRED",
+	QUIC_CRYPTO_SYMMETRIC_KEY_SETUP_FAILED:            "QUIC_CRYPTO_SYMMETRIC_KEY_SETUP_FAILED",
+	QUIC_CRYPTO_MESSAGE_WHILE_VALIDATING_CLIENT_HELLO: "QUIC_CRYPTO_MESSAGE_WHILE_VALIDATING_CLIENT_HELLO",
+	QUIC_CRYPTO_UPDATE_BEFORE_HANDSHAKE_COMPLETE:      "QUIC_CRYPTO_UPDATE_BEFORE_HANDSHAKE_COMPLETE",
+	QUIC_VERSION_NEGOTIATION_MISMATCH:                 "QUIC_VERSION_NEGOTIATION_MISMATCH",
+	QUIC_INVALID_ENTROPY_HASH:                         "QUIC_INVALID_ENTROPY_HASH"}
+
+// String returns the name of the error code, or "QUIC_ERROR_<code>" for an unknown error code.
+func (this QuicErrorCode) String() string {
+	if name, ok := quicErrorCodeNames[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("QUIC_ERROR_%d", uint32(this))
+}
+
+// Error implements the error interface, it returns the name of the error code.
+func (this QuicErrorCode) Error() string {
+	return this.String()
+}
+
+// QuicError is the error of a connection or of a stream: the error code and the reason phrase sent or received in a CONNECTION_CLOSE, RST_STREAM or GOAWAY frame.
+//
+// errors.Is(err, code) returns true if 'err' is a QuicError with the error code 'code'.
+type QuicError struct {
+	ErrorCode    QuicErrorCode
+	ReasonPhrase string
+	// Remote is true if the error has been received from the peer
+	Remote bool
+}
+
+// NewQuicError is a QuicError factory for a local error.
+func NewQuicError(code QuicErrorCode, reason string) *QuicError {
+	return &QuicError{
+		ErrorCode:    code,
+		ReasonPhrase: reason}
+}
+
+// Error implements the error interface.
+func (this *QuicError) Error() string {
+	s := this.ErrorCode.String()
+	if len(this.ReasonPhrase) > 0 {
+		s += " : " + this.ReasonPhrase
+	}
+	if this.Remote {
+		s += " (remote)"
+	}
+	return s
+}
+
+// Timeout returns true if the connection has been closed after a timeout, so that a QuicError satisfies the net.Error interface.
+func (this *QuicError) Timeout() bool {
+	return (this.ErrorCode == QUIC_CONNECTION_TIMED_OUT) || (this.ErrorCode == QUIC_CONNECTION_OVERALL_TIMED_OUT)
+}
+
+// Temporary returns false: a closed connection can't be reused.
+func (this *QuicError) Temporary() bool {
+	return false
+}
+
+// Is returns true if the target is the error code of the QuicError, or a QuicError with the same error code.
+func (this *QuicError) Is(target error) bool {
+	switch t := target.(type) {
+	case QuicErrorCode:
+		return t == this.ErrorCode
+	case *QuicError:
+		return t.ErrorCode == this.ErrorCode
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import "errors"
+import "fmt"
+import "testing"
+
+func Test_QuicErrorCode_String(t *testing.T) {
+	for code, name := range map[QuicErrorCode]string{
+		QUIC_NO_ERROR:                            "QUIC_NO_ERROR",
+		QUIC_INVALID_STREAM_DATA:                 "QUIC_INVALID_STREAM_DATA",
+		QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA: "QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA",
+		QUIC_CRYPTO_TAGS_OUT_OF_ORDER:            "QUIC_CRYPTO_TAGS_OUT_OF_ORDER",
+		QUIC_VERSION_NEGOTIATION_MISMATCH:        "QUIC_VERSION_NEGOTIATION_MISMATCH",
+		QuicErrorCode(1000):                      "QUIC_ERROR_1000"} {
+		if s := code.String(); s != name {
+			t.Errorf("QuicErrorCode.String : invalid name %v for error code %d (%v expected)", s, uint32(code), name)
+		}
+	}
+	// Each error code has its own name
+	names := make(map[string]QuicErrorCode)
+	for code, name := range quicErrorCodeNames {
+		if other, ok := names[name]; ok {
+			t.Errorf("QuicErrorCode.String : error codes %d and %d have the same name %v", uint32(code), uint32(other), name)
+		}
+		names[name] = code
+	}
+}
+
+func Test_QuicError_Is(t *testing.T) {
+	frame := new(QuicFrame)
+	frame.SetFrameType(QUICFRAMETYPE_GOAWAY)
+	frame.SetErrorCode(QUIC_PEER_GOING_AWAY)
+	frame.SetReasonPhrase("server shutdown")
+	data := make([]byte, frame.GetSerializedSize())
+	frame.GetSerializedData(data)
+	received := new(QuicFrame)
+	if _, err := received.ParseData(data); err != nil {
+		t.Fatalf("QuicFrame.ParseData : %v", err)
+	}
+
+	var err error = fmt.Errorf("wrapped : %w", received.GetQuicError())
+	if !errors.Is(err, QUIC_PEER_GOING_AWAY) || !errors.Is(err, NewQuicError(QUIC_PEER_GOING_AWAY, "")) {
+		t.Errorf("QuicError.Is : %v must match QUIC_PEER_GOING_AWAY", err)
+	}
+	if errors.Is(err, QUIC_NO_ERROR) {
+		t.Errorf("QuicError.Is : %v must not match QUIC_NO_ERROR", err)
+	}
+	var e *QuicError
+	if !errors.As(err, &e) {
+		t.Fatalf("QuicError : errors.As must return the QuicError of %v", err)
+	}
+	if (e.ErrorCode != QUIC_PEER_GOING_AWAY) || (e.ReasonPhrase != "server shutdown") || !e.Remote {
+		t.Errorf("QuicFrame.GetQuicError : invalid error %+v", e)
+	}
+	if s := e.Error(); s != "QUIC_PEER_GOING_AWAY : server shutdown (remote)" {
+		t.Errorf("QuicError.Error : invalid message %q", s)
+	}
+	if e.Timeout() || !NewQuicError(QUIC_CONNECTION_TIMED_OUT, "").Timeout() {
+		t.Error("QuicError.Timeout : must return true only for the timeout error codes")
+	}
+	frame.SetFrameType(QUICFRAMETYPE_PING)
+	if frame.GetQuicError() != nil {
+		t.Error("QuicFrame.GetQuicError : must return nil for a PING frame")
+	}
+}
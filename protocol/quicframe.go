@@ -1,6 +1,7 @@
 package protocol
 
 import "errors"
+import "time"
 
 /*
 
@@ -50,6 +51,21 @@ FLAGS    Largest      Missing Packet Sequence Number
 
 -----------------------------------------------------------
 
+CONGESTION_FEEDBACK Frame flags:
++---+---+---+---+---+---+---+---+
+| 0 | 0 | 1 | 0 | 0 | 0 | 0 | 0 |
++---+---+---+---+---+---+---+---+
+
+Followed by the Congestion Feedback Type (8-bit):
+
+0  TCP            Receive Window (16-bit, in units of 16 bytes)
+1  Inter-arrival  Num Received Packets (8-bit), and if not zero:
+                  Smallest Received Sequence Number (48-bit), Time Received in microseconds since UNIX epoch (64-bit),
+                  then for each following packet:
+                  Sequence Number Delta from the Smallest (16-bit), Time Delta in microseconds from the Smallest (signed 32-bit)
+
+-----------------------------------------------------------
+
 STREAM Frame flags:
 +---+---+---+---+---+---+---+---+
 | 1 |FIN|Len| Offset Len| Stream|
@@ -150,7 +166,45 @@ type QuicFrameType byte
 type QuicStreamID uint32
 type QuicByteOffset uint64
 
+// QuicCongestionFeedbackType is the type of feedback of a CONGESTION_FEEDBACK frame.
+type QuicCongestionFeedbackType byte
+
+const (
+	// Receive window of the connection, negotiated with TagQBIC in TagCGST
+	QUICCONGESTIONFEEDBACK_TCP QuicCongestionFeedbackType = 0
+	// Reception time of the packets received, negotiated with TagINAR in TagCGST
+	QUICCONGESTIONFEEDBACK_INTERARRIVAL QuicCongestionFeedbackType = 1
+)
+
+// Maximum number of received packets in an inter-arrival CONGESTION_FEEDBACK frame.
+const MaxCongestionFeedbackReceivedPackets = 255
+
+// QuicReceivedPacket is a packet received by the peer with its reception time, as reported by an inter-arrival CONGESTION_FEEDBACK frame.
+type QuicReceivedPacket struct {
+	SequenceNumber QuicPacketSequenceNumber
+	ReceiveTime    time.Time
+}
+
+// ackTimestamp is a timestamp of an ACK frame following the first one.
+type ackTimestamp struct {
+	deltaLargestObserved byte
+	timeSincePrevious    uint16
+}
+
+// ackMissingRange is a range of missing packets of an ACK frame as serialized:
+// the delta from the reference packet to the last missing packet of the range, and the number of missing packets minus one.
+type ackMissingRange struct {
+	delta  QuicPacketSequenceNumber
+	length byte
+}
+
+// QuicFrame is a single struct shared by all the frame types: it holds the fields of every frame type, only the ones of its type are used.
+// The variable parts (the ACK missing ranges, revived packets and timestamps, the received packets of CONGESTION_FEEDBACK) are slices.
+//
+// ParseData doesn't copy the data of the STREAM frames and the reason phrase of the CONNECTION_CLOSE and GOAWAY frames, they reference the parsed buffer.
+// The slices of the ACK and CONGESTION_FEEDBACK frames are kept by Erase, so that a QuicFrame reused for several frames doesn't allocate memory once they are large enough.
 type QuicFrame struct {
+	// TODO: split into per-type frame structs or a compact union, the frames are handled as a *QuicFrame by the session, the packet managers and QuicPacket
 	frameType QuicFrameType
 
 	// STREAM Frame fields:
@@ -173,14 +227,13 @@ type QuicFrame struct {
 	numTimestamp                             byte
 	deltaFromLargestObserved                 byte
 	timeSinceLargestObserved                 uint32
-	timestampsDeltaLargestObserved           [255]byte
-	timestampsTimeSincePrevious              [255]uint16
-	numMissingRanges                         byte
-	missingPacketsSequenceNumberDelta        [255]QuicPacketSequenceNumber
-	missingRangeLength                       [255]byte
-	numRevived                               byte
-	revivedPackets                           [255]QuicPacketSequenceNumber
-	// CONGESTION_FEEDBACK Frame --> (no fields)
+	timestamps                               []ackTimestamp // the timestamps following the first one (numTimestamp-1 entries)
+	missingRanges                            []ackMissingRange
+	revivedPackets                           []QuicPacketSequenceNumber
+	// CONGESTION_FEEDBACK Frame fields:
+	congestionFeedbackType QuicCongestionFeedbackType
+	receiveWindow          QuicByteCount        // TCP feedback
+	receivedPackets        []QuicReceivedPacket // Inter-arrival feedback, in ascending order of sequence number
 	// PADDING Frame fields --> re-used of 'frameLength'
 	// RST_STREAM Frame fields --> re-used of 'streamID' and 'byteOffset'
 	errorCode QuicErrorCode
@@ -194,53 +247,23 @@ type QuicFrame struct {
 	// PING Frame --> (no fields)
 }
 
-// Erase
+// Erase resets all the fields of the frame, the memory of the ACK and CONGESTION_FEEDBACK frame slices is kept to be reused.
 func (this *QuicFrame) Erase() {
-	this.frameType = 0
-	// STREAM Frame fields
-	this.flagFIN = false
-	this.flagDataLength = false
-	this.streamId = 0
-	this.streamIdByteSize = 0
-	this.byteOffset = 0
-	this.byteOffsetByteSize = 0
-	this.frameLength = 0
-	this.frameData = nil
-	// ACK Frame
-	this.flagNack = false
-	this.flagTruncated = false
-	this.entropyHash = 0
-	this.largestObserved = 0
-	this.largestObservedByteSize = 0
-	this.missingPacketSequenceNumberDeltaByteSize = 0
-	this.numTimestamp = 0
-	this.deltaFromLargestObserved = 0
-	this.timeSinceLargestObserved = 0
-	this.numMissingRanges = 0
-	this.numRevived = 0
-	for i := range this.timestampsTimeSincePrevious {
-		this.timestampsTimeSincePrevious[i] = 0
-		this.timestampsDeltaLargestObserved[i] = 0
-		this.missingPacketsSequenceNumberDelta[i] = 0
-		this.missingRangeLength[i] = 0
-		this.revivedPackets[i] = 0
-	}
-	// CONGESTION_FEEDBACK Frame fields
-	// PADDING Frame fields
-	// RST_STREAM Frame fields
-	this.errorCode = 0
-	// CONNECTION_CLOSE Frame fields
-	// GOAWAY Frame fields
-	// WINDOW_UPDATE Frame fields
-	// BLOCKED Frame fields
-	// STOP_WAITING Frame fields
-	this.leastUnackedDelta = 0
-	this.leastUnackedDeltaByteSize = 0
-	// PING Frame fields
-}
-
-// ParseData
+	*this = QuicFrame{
+		timestamps:      this.timestamps[:0],
+		missingRanges:   this.missingRanges[:0],
+		revivedPackets:  this.revivedPackets[:0],
+		receivedPackets: this.receivedPackets[:0]}
+}
+
+// ParseData parses the frame at the beginning of 'data' and returns its size, the previous content of the frame is erased.
+// The Least Unacked Delta size of a STOP_WAITING frame must be set before with SetLeastUnackedDeltaByteSize.
+//
+// The data of a STREAM frame and the reason phrase of a CONNECTION_CLOSE or GOAWAY frame are not copied: they reference 'data'.
 func (this *QuicFrame) ParseData(data []byte) (size int, err error) {
+	leastUnackedDeltaByteSize := this.leastUnackedDeltaByteSize
+	this.Erase()
+	this.leastUnackedDeltaByteSize = leastUnackedDeltaByteSize
 	l := len(data)
 	if l == 0 {
 		err = errors.New("QuicFrame.ParseData : no data to parse")
@@ -299,6 +322,13 @@ func (this *QuicFrame) ParseData(data []byte) (size int, err error) {
 				this.frameLength |= uint16(data[size]) << (i << 3)
 				size++
 			}
+		} else {
+			// Without Data Length the stream data extend to the end of the packet
+			if l-size > 0xffff {
+				err = errors.New("QuicFrame.ParseData : too much data for STREAM frame")
+				return
+			}
+			this.frameLength = uint16(l - size)
 		}
 		// Check data length
 		if l < (size + int(this.frameLength)) {
@@ -367,15 +397,16 @@ func (this *QuicFrame) ParseData(data []byte) (size int, err error) {
 				size++
 			}
 			for j := byte(1); j < this.numTimestamp; j++ {
+				var timestamp ackTimestamp
 				// Parse Delta Largest Observed
-				this.timestampsDeltaLargestObserved[j] = data[size]
+				timestamp.deltaLargestObserved = data[size]
 				size++
 				// Parse Time Since Previous Timestamp
-				this.timestampsTimeSincePrevious[j] = 0
 				for i := uint(0); i < 2; i++ {
-					this.timestampsTimeSincePrevious[j] |= uint16(data[size]) << (i << 3)
+					timestamp.timeSincePrevious |= uint16(data[size]) << (i << 3)
 					size++
 				}
+				this.timestamps = append(this.timestamps, timestamp)
 			}
 		}
 		// Parse Missing Packets and Revived Packets
@@ -386,46 +417,126 @@ func (this *QuicFrame) ParseData(data []byte) (size int, err error) {
 				return
 			}
 			// Parse Num Missing Packets
-			this.numMissingRanges = data[size]
+			numMissingRanges := int(data[size])
 			size++
-			if this.numMissingRanges > 0 {
+			if numMissingRanges > 0 {
 				// Check data length
-				if l < (1 + size + (int(this.numMissingRanges) * int(1+this.missingPacketSequenceNumberDeltaByteSize))) {
+				if l < (1 + size + (numMissingRanges * int(1+this.missingPacketSequenceNumberDeltaByteSize))) {
 					err = errors.New("QuicFrame.ParseData : not enough data to parse for ACK frame")
 					return
 				}
-				for j := byte(0); j < this.numMissingRanges; j++ {
+				for j := 0; j < numMissingRanges; j++ {
+					var missing ackMissingRange
 					// Parse Missing Packet Sequence Number Delta
-					this.missingPacketsSequenceNumberDelta[j] = 0
 					for i := uint(0); i < uint(this.missingPacketSequenceNumberDeltaByteSize); i++ {
-						this.missingPacketsSequenceNumberDelta[j] |= QuicPacketSequenceNumber(data[size]) << (i << 3)
+						missing.delta |= QuicPacketSequenceNumber(data[size]) << (i << 3)
 						size++
 					}
 					// Parse Missing Packet Range Length
-					this.missingRangeLength[j] = data[size]
+					missing.length = data[size]
 					size++
+					this.missingRanges = append(this.missingRanges, missing)
 				}
 			}
 			// Parse Num Revived Packets
-			this.numRevived = data[size]
+			numRevived := int(data[size])
 			size++
-			if this.numRevived > 0 {
+			if numRevived > 0 {
 				// Check data length
-				if l < (size + (int(this.numRevived) * int(this.largestObservedByteSize))) {
+				if l < (size + (numRevived * int(this.largestObservedByteSize))) {
 					err = errors.New("QuicFrame.ParseData : not enough data to parse for ACK frame")
 					return
 				}
 				// Parse Revived Packets
-				for j := byte(0); j < this.numRevived; j++ {
+				for j := 0; j < numRevived; j++ {
 					// Parse Revived Packet
-					this.revivedPackets[j] = 0
+					var revived QuicPacketSequenceNumber
 					for i := uint(0); i < this.largestObservedByteSize; i++ {
-						this.revivedPackets[j] |= QuicPacketSequenceNumber(data[size]) << (i << 3)
+						revived |= QuicPacketSequenceNumber(data[size]) << (i << 3)
 						size++
 					}
+					this.revivedPackets = append(this.revivedPackets, revived)
+				}
+			}
+		}
+		return
+	} else if (ft & QUICFRAMETYPE_CONGESTION_FEEDBACK_MASK) == QUICFRAMETYPE_CONGESTION_FEEDBACK {
+		// This is a CONGESTION_FEEDBACK Frame
+		this.frameType = QUICFRAMETYPE_CONGESTION_FEEDBACK
+		if ft != QUICFRAMETYPE_CONGESTION_FEEDBACK {
+			err = NewQuicError(QUIC_INVALID_CONGESTION_FEEDBACK_DATA, "QuicFrame.ParseData : unused bits must be set to 0 for CONGESTION_FEEDBACK frame")
+			return
+		}
+		// Check data length
+		if l < 2 {
+			err = NewQuicError(QUIC_INVALID_CONGESTION_FEEDBACK_DATA, "QuicFrame.ParseData : not enough data for CONGESTION_FEEDBACK frame")
+			return
+		}
+		// Parse Congestion Feedback Type (8-bit)
+		this.congestionFeedbackType = QuicCongestionFeedbackType(data[size])
+		size++
+		switch this.congestionFeedbackType {
+		case QUICCONGESTIONFEEDBACK_TCP:
+			// Check data length
+			if l < 4 {
+				err = NewQuicError(QUIC_INVALID_CONGESTION_FEEDBACK_DATA, "QuicFrame.ParseData : not enough data for TCP CONGESTION_FEEDBACK frame")
+				return
+			}
+			// Parse Receive Window (16-bit, in units of 16 bytes)
+			for i := uint(0); i < 2; i++ {
+				this.receiveWindow |= QuicByteCount(data[size]) << (i << 3)
+				size++
+			}
+			this.receiveWindow <<= 4
+			return
+		case QUICCONGESTIONFEEDBACK_INTERARRIVAL:
+			// Check data length
+			if l < 3 {
+				err = NewQuicError(QUIC_INVALID_CONGESTION_FEEDBACK_DATA, "QuicFrame.ParseData : not enough data for inter-arrival CONGESTION_FEEDBACK frame")
+				return
+			}
+			// Parse Num Received Packets (8-bit)
+			numReceived := int(data[size])
+			size++
+			if numReceived == 0 {
+				return
+			}
+			// Check data length
+			if l < (size + 14 + (numReceived-1)*6) {
+				err = NewQuicError(QUIC_INVALID_CONGESTION_FEEDBACK_DATA, "QuicFrame.ParseData : not enough data for inter-arrival CONGESTION_FEEDBACK frame")
+				return
+			}
+			// Parse Smallest Received Sequence Number (48-bit)
+			var smallest QuicPacketSequenceNumber
+			for i := uint(0); i < 6; i++ {
+				smallest |= QuicPacketSequenceNumber(data[size]) << (i << 3)
+				size++
+			}
+			// Parse Time Received in microseconds (64-bit)
+			var received uint64
+			for i := uint(0); i < 8; i++ {
+				received |= uint64(data[size]) << (i << 3)
+				size++
+			}
+			this.receivedPackets = append(this.receivedPackets, QuicReceivedPacket{smallest, time.UnixMicro(int64(received))})
+			for j := 1; j < numReceived; j++ {
+				// Parse Sequence Number Delta (16-bit)
+				var delta QuicPacketSequenceNumber
+				for i := uint(0); i < 2; i++ {
+					delta |= QuicPacketSequenceNumber(data[size]) << (i << 3)
+					size++
+				}
+				// Parse Time Delta in microseconds (signed 32-bit)
+				var timeDelta uint32
+				for i := uint(0); i < 4; i++ {
+					timeDelta |= uint32(data[size]) << (i << 3)
+					size++
 				}
+				this.receivedPackets = append(this.receivedPackets, QuicReceivedPacket{smallest + delta, time.UnixMicro(int64(received) + int64(int32(timeDelta)))})
 			}
+			return
 		}
+		err = NewQuicError(QUIC_INVALID_CONGESTION_FEEDBACK_DATA, "QuicFrame.ParseData : unknown congestion feedback type")
 		return
 	} else {
 		switch ft {
@@ -581,15 +692,27 @@ func (this *QuicFrame) GetSerializedSize() (size int) {
 		return
 	case QUICFRAMETYPE_ACK: // variable length
 		size = 5 + int(this.largestObservedByteSize) +
-			int(this.numTimestamp*3) +
-			int(this.numMissingRanges)*int(this.missingPacketSequenceNumberDeltaByteSize+1) +
-			int(this.numRevived)*int(this.largestObservedByteSize)
+			int(this.numTimestamp)*3 +
+			len(this.missingRanges)*int(this.missingPacketSequenceNumberDeltaByteSize+1) +
+			len(this.revivedPackets)*int(this.largestObservedByteSize)
 		if this.numTimestamp > 0 {
 			size += 2
 		}
+		if this.flagNack {
+			// Num Missing Ranges and Num Revived
+			size += 2
+		}
 		return
-	case QUICFRAMETYPE_CONGESTION_FEEDBACK: // unknow length ...
-		size = 1
+	case QUICFRAMETYPE_CONGESTION_FEEDBACK: // variable length
+		switch this.congestionFeedbackType {
+		case QUICCONGESTIONFEEDBACK_TCP:
+			size = 4
+		case QUICCONGESTIONFEEDBACK_INTERARRIVAL:
+			size = 3
+			if n := len(this.receivedPackets); n > 0 {
+				size += 14 + (n-1)*6
+			}
+		}
 		return
 	case QUICFRAMETYPE_PADDING: // variable length
 		size = int(1 + this.frameLength)
@@ -707,12 +830,15 @@ func (this *QuicFrame) GetSerializedData(data []byte) (size int, err error) {
 	case QUICFRAMETYPE_ACK: // variable length
 		// Check data length
 		size = 5 + int(this.largestObservedByteSize) +
-			int(this.numTimestamp*3) +
-			int(this.numMissingRanges)*int(this.missingPacketSequenceNumberDeltaByteSize+1) +
-			int(this.numRevived)*int(this.largestObservedByteSize)
+			int(this.numTimestamp)*3 +
+			len(this.missingRanges)*int(this.missingPacketSequenceNumberDeltaByteSize+1) +
+			len(this.revivedPackets)*int(this.largestObservedByteSize)
 		if this.numTimestamp > 0 {
 			size += 2
 		}
+		if this.flagNack {
+			size += 2
+		}
 		if l < size {
 			err = errors.New("QuicFrame.GetSerializedData : not enough data for ACK Frame size")
 			size = 0
@@ -780,49 +906,98 @@ func (this *QuicFrame) GetSerializedData(data []byte) (size int, err error) {
 				size++
 			}
 			// Serialize Timestamps
-			for j := byte(1); j < this.numTimestamp; j++ {
+			for _, timestamp := range this.timestamps {
 				// Serialize Delta Largest Observed
-				data[size] = this.timestampsDeltaLargestObserved[j]
+				data[size] = timestamp.deltaLargestObserved
 				size++
 				// Serialize Time Since Previous Timestamp
 				for i := uint(0); i < 2; i++ {
-					data[size] = byte(this.timestampsTimeSincePrevious[j] >> (i << 3))
+					data[size] = byte(timestamp.timeSincePrevious >> (i << 3))
 					size++
 				}
 			}
 		}
 		if this.flagNack {
 			// Serialize Number of Missing Packets
-			data[size] = this.numMissingRanges
+			data[size] = byte(len(this.missingRanges))
 			size++
 			// Serialize Missing Packets
-			if this.numMissingRanges > 0 {
-				for j := byte(0); j < this.numMissingRanges; j++ {
-					// Serialize Missing Packet Sequence Number Delta
-					for i := uint(0); i < this.missingPacketSequenceNumberDeltaByteSize; i++ {
-						data[size] = byte(this.missingPacketsSequenceNumberDelta[j] >> (i << 3))
-						size++
-					}
-					// Serialize Missing Packets Range Length
-					data[size] = this.missingRangeLength[j]
+			for _, missing := range this.missingRanges {
+				// Serialize Missing Packet Sequence Number Delta
+				for i := uint(0); i < this.missingPacketSequenceNumberDeltaByteSize; i++ {
+					data[size] = byte(missing.delta >> (i << 3))
 					size++
 				}
+				// Serialize Missing Packets Range Length
+				data[size] = missing.length
+				size++
 			}
 			// Serialize Number of Revived Packets
-			data[size] = this.numRevived
+			data[size] = byte(len(this.revivedPackets))
 			size++
 			// Serialize Revived Packets
-			if this.numRevived > 0 {
-				for j := byte(0); j < this.numRevived; j++ {
-					// Serialize Revived Packet Sequence Number
-					for i := uint(0); i < this.largestObservedByteSize; i++ {
-						data[size] = byte(this.revivedPackets[j] >> (i << 3))
-						size++
-					}
+			for _, revived := range this.revivedPackets {
+				// Serialize Revived Packet Sequence Number
+				for i := uint(0); i < this.largestObservedByteSize; i++ {
+					data[size] = byte(revived >> (i << 3))
+					size++
 				}
 			}
 		}
 		return
+	case QUICFRAMETYPE_CONGESTION_FEEDBACK: // variable length
+		// Check data length
+		if size = this.GetSerializedSize(); (size == 0) || (l < size) {
+			err = errors.New("QuicFrame.GetSerializedData : not enough data for CONGESTION_FEEDBACK Frame size")
+			size = 0
+			return
+		}
+		// Serialize frame type (8-bit)
+		data[0] = QUICFRAMETYPE_CONGESTION_FEEDBACK
+		// Serialize Congestion Feedback Type (8-bit)
+		data[1] = byte(this.congestionFeedbackType)
+		size = 2
+		if this.congestionFeedbackType == QUICCONGESTIONFEEDBACK_TCP {
+			// Serialize Receive Window (16-bit, in units of 16 bytes)
+			for i := uint(0); i < 2; i++ {
+				data[size] = byte(this.receiveWindow >> (4 + (i << 3)))
+				size++
+			}
+			return
+		}
+		// Serialize Num Received Packets (8-bit)
+		data[size] = byte(len(this.receivedPackets))
+		size++
+		if len(this.receivedPackets) == 0 {
+			return
+		}
+		// Serialize Smallest Received Sequence Number (48-bit)
+		smallest := this.receivedPackets[0]
+		for i := uint(0); i < 6; i++ {
+			data[size] = byte(smallest.SequenceNumber >> (i << 3))
+			size++
+		}
+		// Serialize Time Received in microseconds (64-bit)
+		received := uint64(smallest.ReceiveTime.UnixMicro())
+		for i := uint(0); i < 8; i++ {
+			data[size] = byte(received >> (i << 3))
+			size++
+		}
+		for _, p := range this.receivedPackets[1:] {
+			// Serialize Sequence Number Delta (16-bit)
+			delta := p.SequenceNumber - smallest.SequenceNumber
+			for i := uint(0); i < 2; i++ {
+				data[size] = byte(delta >> (i << 3))
+				size++
+			}
+			// Serialize Time Delta in microseconds (signed 32-bit)
+			timeDelta := uint32(p.ReceiveTime.UnixMicro() - smallest.ReceiveTime.UnixMicro())
+			for i := uint(0); i < 4; i++ {
+				data[size] = byte(timeDelta >> (i << 3))
+				size++
+			}
+		}
+		return
 	case QUICFRAMETYPE_PADDING: // variable length
 		// Check data length
 		if l < int(1+this.frameLength) {
@@ -1003,3 +1178,264 @@ func (this *QuicFrame) SetLeastUnackedDeltaByteSize(leastunackedsize uint) {
 func (this *QuicFrame) GetLeastUnackedDeltaByteSize() uint {
 	return this.leastUnackedDeltaByteSize
 }
+
+// GetLeastUnackedDelta returns the delta from the sequence number of the packet to the least unacked packet of a STOP_WAITING frame.
+func (this *QuicFrame) GetLeastUnackedDelta() QuicPacketSequenceNumber {
+	return this.leastUnackedDelta
+}
+
+// SetLeastUnackedDelta sets the delta from the sequence number of the packet to the least unacked packet of a STOP_WAITING frame.
+func (this *QuicFrame) SetLeastUnackedDelta(delta QuicPacketSequenceNumber) {
+	this.leastUnackedDelta = delta
+}
+
+// QuicPacketRange is a range of consecutive packet sequence numbers, from First to Last (included).
+type QuicPacketRange struct {
+	First QuicPacketSequenceNumber
+	Last  QuicPacketSequenceNumber
+}
+
+// minimalByteSize returns the smallest size in 'sizes' that can contain the value.
+func minimalByteSize(value uint64, sizes []uint) uint {
+	for _, s := range sizes {
+		if (s >= 8) || (value < (uint64(1) << (s << 3))) {
+			return s
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// GetStreamID
+func (this *QuicFrame) GetStreamID() QuicStreamID {
+	return this.streamId
+}
+
+// SetStreamID sets the Stream ID and the smallest Stream ID size for STREAM frame serialization.
+func (this *QuicFrame) SetStreamID(streamId QuicStreamID) {
+	this.streamId = streamId
+	this.streamIdByteSize = minimalByteSize(uint64(streamId), []uint{1, 2, 3, 4})
+}
+
+// GetByteOffset
+func (this *QuicFrame) GetByteOffset() QuicByteOffset {
+	return this.byteOffset
+}
+
+// SetByteOffset sets the Byte Offset and the smallest Byte Offset size for STREAM frame serialization.
+func (this *QuicFrame) SetByteOffset(offset QuicByteOffset) {
+	this.byteOffset = offset
+	if offset == 0 {
+		this.byteOffsetByteSize = 0
+	} else {
+		this.byteOffsetByteSize = minimalByteSize(uint64(offset), []uint{2, 3, 4, 5, 6, 7, 8})
+	}
+}
+
+// GetFinFlag
+func (this *QuicFrame) GetFinFlag() bool {
+	return this.flagFIN
+}
+
+// SetFinFlag
+func (this *QuicFrame) SetFinFlag(state bool) {
+	this.flagFIN = state
+}
+
+// GetData returns the stream data of a STREAM frame.
+func (this *QuicFrame) GetData() []byte {
+	return this.frameData
+}
+
+// SetData sets the stream data of a STREAM frame, the Data Length is always serialized.
+func (this *QuicFrame) SetData(data []byte) {
+	this.flagDataLength = true
+	this.frameLength = uint16(len(data))
+	this.frameData = data
+}
+
+// GetErrorCode
+func (this *QuicFrame) GetErrorCode() QuicErrorCode {
+	return this.errorCode
+}
+
+// SetErrorCode
+func (this *QuicFrame) SetErrorCode(errorCode QuicErrorCode) {
+	this.errorCode = errorCode
+}
+
+// GetQuicError returns the error received in a CONNECTION_CLOSE, RST_STREAM or GOAWAY frame, or nil for the other frames.
+func (this *QuicFrame) GetQuicError() *QuicError {
+	switch this.frameType {
+	case QUICFRAMETYPE_CONNECTION_CLOSE, QUICFRAMETYPE_GOAWAY:
+		return &QuicError{ErrorCode: this.errorCode, ReasonPhrase: this.GetReasonPhrase(), Remote: true}
+	case QUICFRAMETYPE_RST_STREAM:
+		return &QuicError{ErrorCode: this.errorCode, Remote: true}
+	}
+	return nil
+}
+
+// GetReasonPhrase returns the reason phrase of a CONNECTION_CLOSE or GOAWAY frame.
+func (this *QuicFrame) GetReasonPhrase() string {
+	return string(this.frameData)
+}
+
+// SetReasonPhrase sets the reason phrase of a CONNECTION_CLOSE or GOAWAY frame.
+func (this *QuicFrame) SetReasonPhrase(reason string) {
+	this.frameLength = uint16(len(reason))
+	this.frameData = []byte(reason)
+}
+
+// SetPaddingLength sets the number of zero bytes following the PADDING frame type.
+func (this *QuicFrame) SetPaddingLength(length int) {
+	this.frameLength = uint16(length)
+}
+
+// GetEntropyHash
+func (this *QuicFrame) GetEntropyHash() QuicEntropyHash {
+	return this.entropyHash
+}
+
+// SetEntropyHash
+func (this *QuicFrame) SetEntropyHash(entropy QuicEntropyHash) {
+	this.entropyHash = entropy
+}
+
+// GetLargestObserved
+func (this *QuicFrame) GetLargestObserved() QuicPacketSequenceNumber {
+	return this.largestObserved
+}
+
+// SetLargestObserved sets the Largest Observed sequence number and the smallest Largest Observed size for ACK frame serialization.
+func (this *QuicFrame) SetLargestObserved(seqnum QuicPacketSequenceNumber) {
+	this.largestObserved = seqnum
+	this.largestObservedByteSize = minimalByteSize(uint64(seqnum), []uint{1, 2, 4, 6})
+	if this.missingPacketSequenceNumberDeltaByteSize == 0 {
+		this.missingPacketSequenceNumberDeltaByteSize = 1
+	}
+}
+
+// GetLargestObservedDeltaTime returns the time elapsed in microseconds (16-bit unsigned float) from the reception of the Largest Observed to the sending of the ACK frame.
+func (this *QuicFrame) GetLargestObservedDeltaTime() uint16 {
+	return this.largestObservedDeltaTime
+}
+
+// SetLargestObservedDeltaTime
+func (this *QuicFrame) SetLargestObservedDeltaTime(delta uint16) {
+	this.largestObservedDeltaTime = delta
+}
+
+// GetMissingRanges returns the ranges of missing packets of an ACK frame, in descending order.
+//
+// The Missing Packet Sequence Number Delta of the first range is the delta from the Largest Observed to the last missing packet of the range,
+// and for the next ranges the delta from the first missing packet of the previous range. The Range Length is the number of missing packets minus one.
+func (this *QuicFrame) GetMissingRanges() (ranges []QuicPacketRange) {
+	reference := this.largestObserved
+	for _, missing := range this.missingRanges {
+		last := reference - missing.delta
+		first := last - QuicPacketSequenceNumber(missing.length)
+		ranges = append(ranges, QuicPacketRange{first, last})
+		reference = first
+	}
+	return
+}
+
+// AddMissingRange adds a range of missing packets to an ACK frame, ranges must be added in descending order and below the Largest Observed.
+// Ranges of more than 256 packets are split.
+func (this *QuicFrame) AddMissingRange(missing QuicPacketRange) error {
+	// The reference is the first missing packet of the previous range
+	reference := this.largestObserved
+	for _, missing := range this.missingRanges {
+		reference -= missing.delta + QuicPacketSequenceNumber(missing.length)
+	}
+	if (missing.First > missing.Last) || (missing.Last >= reference) {
+		return errors.New("QuicFrame.AddMissingRange : missing range must be in descending order and below the Largest Observed")
+	}
+	for last := missing.Last; ; {
+		if len(this.missingRanges) == 255 {
+			this.flagTruncated = true
+			return errors.New("QuicFrame.AddMissingRange : too many missing ranges")
+		}
+		first := missing.First
+		if last-first > 255 {
+			first = last - 255
+		}
+		delta := reference - last
+		this.missingRanges = append(this.missingRanges, ackMissingRange{delta, byte(last - first)})
+		if s := minimalByteSize(uint64(delta), []uint{1, 2, 4, 6}); s > this.missingPacketSequenceNumberDeltaByteSize {
+			this.missingPacketSequenceNumberDeltaByteSize = s
+		}
+		this.flagNack = true
+		reference = first
+		if first == missing.First {
+			return nil
+		}
+		last = first - 1
+	}
+}
+
+// GetRevivedPackets returns the packets revived by FEC that are reported in an ACK frame.
+func (this *QuicFrame) GetRevivedPackets() (revived []QuicPacketSequenceNumber) {
+	return append(revived, this.revivedPackets...)
+}
+
+// AddRevivedPacket adds a packet revived by FEC to an ACK frame.
+func (this *QuicFrame) AddRevivedPacket(seqnum QuicPacketSequenceNumber) error {
+	if len(this.revivedPackets) == 255 {
+		return errors.New("QuicFrame.AddRevivedPacket : too many revived packets")
+	}
+	this.revivedPackets = append(this.revivedPackets, seqnum)
+	this.flagNack = true
+	return nil
+}
+
+// GetCongestionFeedbackType returns the type of feedback of a CONGESTION_FEEDBACK frame.
+func (this *QuicFrame) GetCongestionFeedbackType() QuicCongestionFeedbackType {
+	return this.congestionFeedbackType
+}
+
+// SetCongestionFeedbackType sets the type of feedback of a CONGESTION_FEEDBACK frame.
+func (this *QuicFrame) SetCongestionFeedbackType(feedback QuicCongestionFeedbackType) {
+	this.congestionFeedbackType = feedback
+}
+
+// GetReceiveWindow returns the receive window of a TCP CONGESTION_FEEDBACK frame.
+func (this *QuicFrame) GetReceiveWindow() QuicByteCount {
+	return this.receiveWindow
+}
+
+// SetReceiveWindow sets the receive window of a TCP CONGESTION_FEEDBACK frame.
+// The receive window is serialized in units of 16 bytes: it is rounded down to a multiple of 16 bytes, and limited to 0xffff units.
+func (this *QuicFrame) SetReceiveWindow(window QuicByteCount) {
+	if window > 0xffff<<4 {
+		window = 0xffff << 4
+	}
+	this.receiveWindow = window &^ 0xf
+}
+
+// GetReceivedPackets returns the packets received with their reception time of an inter-arrival CONGESTION_FEEDBACK frame, in ascending order of sequence number.
+func (this *QuicFrame) GetReceivedPackets() (packets []QuicReceivedPacket) {
+	return append(packets, this.receivedPackets...)
+}
+
+// AddReceivedPacket adds a packet received at 'received' to an inter-arrival CONGESTION_FEEDBACK frame, the packets must be added in ascending order of sequence number.
+// The sequence numbers must be less than 65536 packets after the first one, and the reception times within 35 minutes of the first one.
+func (this *QuicFrame) AddReceivedPacket(seqnum QuicPacketSequenceNumber, received time.Time) error {
+	n := len(this.receivedPackets)
+	if n == MaxCongestionFeedbackReceivedPackets {
+		return errors.New("QuicFrame.AddReceivedPacket : too many received packets")
+	}
+	if n > 0 {
+		smallest := this.receivedPackets[0]
+		if seqnum <= this.receivedPackets[n-1].SequenceNumber {
+			return errors.New("QuicFrame.AddReceivedPacket : packets must be added in ascending order")
+		}
+		if seqnum-smallest.SequenceNumber > 0xffff {
+			return errors.New("QuicFrame.AddReceivedPacket : sequence number too far from the first packet")
+		}
+		if delta := received.UnixMicro() - smallest.ReceiveTime.UnixMicro(); (delta < -1<<31) || (delta >= 1<<31) {
+			return errors.New("QuicFrame.AddReceivedPacket : reception time too far from the first packet")
+		}
+	}
+	this.receivedPackets = append(this.receivedPackets, QuicReceivedPacket{seqnum, received})
+	return nil
+}
@@ -2,6 +2,8 @@ package protocol
 
 import "testing"
 import "bytes"
+import "reflect"
+import "time"
 
 type testquicframe struct {
 	positiveTest              bool
@@ -214,7 +216,60 @@ var tests_quicframe = []testquicframe{
 			leastUnackedDeltaByteSize: 6,
 			leastUnackedDelta:         0x0000060504030201,
 		}},
+	// CONGESTION_FEEDBACK Frame
+	{true, 0, // TCP
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK, 0x00, 0x34, 0x12},
+		QuicFrame{
+			frameType:              QUICFRAMETYPE_CONGESTION_FEEDBACK,
+			congestionFeedbackType: QUICCONGESTIONFEEDBACK_TCP,
+			receiveWindow:          0x12340,
+		}},
+	{true, 0, // Inter-arrival without packets
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK, 0x01, 0x00},
+		QuicFrame{
+			frameType:              QUICFRAMETYPE_CONGESTION_FEEDBACK,
+			congestionFeedbackType: QUICCONGESTIONFEEDBACK_INTERARRIVAL,
+		}},
+	{true, 0, // Inter-arrival
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK, 0x01, 0x03,
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+			0x00, 0x80, 0xd7, 0x3a, 0x7e, 0xaf, 0x05, 0x00,
+			0x01, 0x00, 0xe8, 0x03, 0x00, 0x00,
+			0x03, 0x00, 0xfb, 0xff, 0xff, 0xff},
+		QuicFrame{
+			frameType:              QUICFRAMETYPE_CONGESTION_FEEDBACK,
+			congestionFeedbackType: QUICCONGESTIONFEEDBACK_INTERARRIVAL,
+			receivedPackets: []QuicReceivedPacket{
+				{0x060504030201, time.UnixMicro(0x05af7e3ad78000)},
+				{0x060504030202, time.UnixMicro(0x05af7e3ad78000 + 1000)},
+				{0x060504030204, time.UnixMicro(0x05af7e3ad78000 - 5)}},
+		}},
+	{false, 0, // unused bits
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK | 0x01, 0x00, 0x34, 0x12},
+		QuicFrame{}},
+	{false, 0, // unknown feedback type
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK, 0x02, 0x34, 0x12},
+		QuicFrame{}},
+	{false, 0, // not enough data
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK, 0x00, 0x34},
+		QuicFrame{}},
+	{false, 0, // not enough data
+		[]byte{QUICFRAMETYPE_CONGESTION_FEEDBACK, 0x01, 0x02,
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+			0x00, 0x80, 0xd7, 0x3a, 0x7e, 0xaf, 0x05, 0x00,
+			0x01, 0x00, 0xe8, 0x03, 0x00},
+		QuicFrame{}},
 	// STREAM Frame
+	{true, 0,
+		[]byte{QUICFRAMETYPE_STREAM | QUICFLAG_STREAMID_8bit, 0x12,
+			0x42, 0x17, 0x89},
+		QuicFrame{
+			frameType:        QUICFRAMETYPE_STREAM,
+			streamIdByteSize: 1,
+			streamId:         0x12,
+			frameLength:      3,
+			frameData:        []byte{0x42, 0x17, 0x89},
+		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_STREAM | QUICFLAG_DATALENGTH | QUICFLAG_STREAMID_32bit | QUICFLAG_BYTEOFFSET_64bit, 0x12, 0x34, 0x56, 0x78,
 			0x0a, 0x0b, 0x0c, 0x0d, 0xaa, 0xbb, 0xcc, 0xdd,
@@ -489,8 +544,7 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             2,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}},
 			missingPacketSequenceNumberDeltaByteSize: 2,
 		}},
 	{true, 0,
@@ -511,8 +565,7 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
 		}},
 	{true, 0,
@@ -537,13 +590,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         1,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x00000000000000aa},
-			missingRangeLength:                       [255]byte{0x55},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x00000000000000aa, 0x55}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_48bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_16bit,
@@ -568,13 +617,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 2,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x000000000000bbaa, 0x000000000000ddcc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x000000000000bbaa, 0x55}, {0x000000000000ddcc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_48bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_32bit,
@@ -599,13 +644,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 4,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x000000000b0abbaa, 0x000000000d0cddcc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x000000000b0abbaa, 0x55}, {0x000000000d0cddcc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_48bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_48bit,
@@ -630,13 +671,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 6,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x0000b0a00b0abbaa, 0x0000d0c00d0cddcc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x0000b0a00b0abbaa, 0x55}, {0x0000d0c00d0cddcc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_48bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -661,13 +698,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x00000000000000aa, 0x00000000000000cc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x00000000000000aa, 0x55}, {0x00000000000000cc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_32bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -692,13 +725,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x00000000000000aa, 0x00000000000000cc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x00000000000000aa, 0x55}, {0x00000000000000cc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_16bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -723,13 +752,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x00000000000000aa, 0x00000000000000cc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x00000000000000aa, 0x55}, {0x00000000000000cc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_8bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -754,13 +779,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x00000000000000aa, 0x00000000000000cc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               0,
+			missingRanges:                            []ackMissingRange{{0x00000000000000aa, 0x55}, {0x00000000000000cc, 0x44}},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_16bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -786,14 +807,10 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         2,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{0x00000000000000aa, 0x00000000000000cc},
-			missingRangeLength:                       [255]byte{0x55, 0x44},
-			numRevived:                               3,
-			revivedPackets:                           [255]QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
+			missingRanges:                            []ackMissingRange{{0x00000000000000aa, 0x55}, {0x00000000000000cc, 0x44}},
+			revivedPackets:                           []QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_16bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -817,14 +834,9 @@ var tests_quicframe = []testquicframe{
 			numTimestamp:                             3,
 			deltaFromLargestObserved:                 0x66,
 			timeSinceLargestObserved:                 0x0d0c0b0a,
-			timestampsDeltaLargestObserved:           [255]byte{0, 0x67, 0x68},
-			timestampsTimeSincePrevious:              [255]uint16{0, 0x1789, 0x1984},
+			timestamps:                               []ackTimestamp{{0x67, 0x1789}, {0x68, 0x1984}},
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         0,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{},
-			missingRangeLength:                       [255]byte{},
-			numRevived:                               3,
-			revivedPackets:                           [255]QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
+			revivedPackets:                           []QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
 		}},
 	{true, 0,
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_16bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -844,11 +856,7 @@ var tests_quicframe = []testquicframe{
 			largestObservedDeltaTime:                 0xfeca,
 			numTimestamp:                             0,
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         0,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{},
-			missingRangeLength:                       [255]byte{},
-			numRevived:                               3,
-			revivedPackets:                           [255]QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
+			revivedPackets:                           []QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
 		}},
 	{false, 0, // not enough data
 		[]byte{QUICFRAMETYPE_ACK | QUICFLAG_NACK | QUICFLAG_LARGESTOBSERVED_16bit | QUICFLAG_MISSINGPACKETSEQNUMDELTA_8bit,
@@ -868,11 +876,7 @@ var tests_quicframe = []testquicframe{
 			largestObservedDeltaTime:                 0xfeca,
 			numTimestamp:                             0,
 			missingPacketSequenceNumberDeltaByteSize: 1,
-			numMissingRanges:                         0,
-			missingPacketsSequenceNumberDelta:        [255]QuicPacketSequenceNumber{},
-			missingRangeLength:                       [255]byte{},
-			numRevived:                               3,
-			revivedPackets:                           [255]QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
+			revivedPackets:                           []QuicPacketSequenceNumber{0x000000000000a2a1, 0x000000000000b2b1, 0x000000000000c2c1},
 		}},
 }
 
@@ -952,28 +956,26 @@ func Test_QuicFrame_ParseData(t *testing.T) {
 			if v.frame.missingPacketSequenceNumberDeltaByteSize != f.missingPacketSequenceNumberDeltaByteSize {
 				t.Errorf("QuicFrame.ParseData : invalid Missing Packet Sequence Number Delta size %v in test %v with data[%v]%x", f.missingPacketSequenceNumberDeltaByteSize, i, len(v.data), v.data)
 			}
-			for j := range v.frame.timestampsDeltaLargestObserved {
-				if v.frame.timestampsDeltaLargestObserved[j] != f.timestampsDeltaLargestObserved[j] {
-					t.Errorf("QuicFrame.ParseData : invalid Timestamp Delta Largest Observed [%v]%x in test %v with data[%v]%x", j, f.timestampsDeltaLargestObserved[j], i, len(v.data), v.data)
-				}
-				if v.frame.timestampsTimeSincePrevious[j] != f.timestampsTimeSincePrevious[j] {
-					t.Errorf("QuicFrame.ParseData : invalid Timestamp Time Since Previous [%v]%x in test %v with data[%v]%x", j, f.timestampsTimeSincePrevious[j], i, len(v.data), v.data)
-				}
-				if v.frame.missingPacketsSequenceNumberDelta[j] != f.missingPacketsSequenceNumberDelta[j] {
-					t.Errorf("QuicFrame.ParseData : invalid Missing Packet Sequence Number Delta [%v]%x in test %v with data[%v]%x", j, f.missingPacketsSequenceNumberDelta[j], i, len(v.data), v.data)
-				}
-				if v.frame.missingRangeLength[j] != f.missingRangeLength[j] {
-					t.Errorf("QuicFrame.ParseData : invalid Missing Range Length [%v]%x in test %v with data[%v]%x", j, f.missingRangeLength[j], i, len(v.data), v.data)
-				}
-				if v.frame.revivedPackets[j] != f.revivedPackets[j] {
-					t.Errorf("QuicFrame.ParseData : invalid Revived Packet [%v]%x in test %v with data[%v]%x", j, f.revivedPackets[j], i, len(v.data), v.data)
-				}
+			if !reflect.DeepEqual(append([]ackTimestamp(nil), v.frame.timestamps...), append([]ackTimestamp(nil), f.timestamps...)) {
+				t.Errorf("QuicFrame.ParseData : invalid Timestamps %v in test %v with data[%v]%x", f.timestamps, i, len(v.data), v.data)
+			}
+			if !reflect.DeepEqual(append([]ackMissingRange(nil), v.frame.missingRanges...), append([]ackMissingRange(nil), f.missingRanges...)) {
+				t.Errorf("QuicFrame.ParseData : invalid Missing Ranges %v in test %v with data[%v]%x", f.missingRanges, i, len(v.data), v.data)
+			}
+			if (v.frame.congestionFeedbackType != f.congestionFeedbackType) || (v.frame.receiveWindow != f.receiveWindow) {
+				t.Errorf("QuicFrame.ParseData : invalid Congestion Feedback type %v or Receive Window %v in test %v with data[%v]%x", f.congestionFeedbackType, f.receiveWindow, i, len(v.data), v.data)
 			}
-			if v.frame.numMissingRanges != f.numMissingRanges {
-				t.Errorf("QuicFrame.ParseData : invalid Number of Missing Ranges %v in test %v with data[%v]%x", f.numMissingRanges, i, len(v.data), v.data)
+			if len(v.frame.receivedPackets) != len(f.receivedPackets) {
+				t.Errorf("QuicFrame.ParseData : invalid Received Packets %v in test %v with data[%v]%x", f.receivedPackets, i, len(v.data), v.data)
+			} else {
+				for j, p := range v.frame.receivedPackets {
+					if (p.SequenceNumber != f.receivedPackets[j].SequenceNumber) || !p.ReceiveTime.Equal(f.receivedPackets[j].ReceiveTime) {
+						t.Errorf("QuicFrame.ParseData : invalid Received Packet [%v]%v in test %v with data[%v]%x", j, f.receivedPackets[j], i, len(v.data), v.data)
+					}
+				}
 			}
-			if v.frame.numRevived != f.numRevived {
-				t.Errorf("QuicFrame.ParseData : invalid Number of Revived Packets %v in test %v with data[%v]%x", f.numRevived, i, len(v.data), v.data)
+			if !reflect.DeepEqual(v.frame.GetRevivedPackets(), f.GetRevivedPackets()) {
+				t.Errorf("QuicFrame.ParseData : invalid Revived Packets %v in test %v with data[%v]%x", f.revivedPackets, i, len(v.data), v.data)
 			}
 		} else if err == nil {
 			t.Errorf("QuicFrame.ParseData : missing error in test %v with data[%v]%x", i, len(v.data), v.data)
@@ -1002,3 +1004,177 @@ func Test_QuicFrame_GetSerializedData(t *testing.T) {
 	}
 
 }
+
+func Test_QuicFrame_MissingRanges(t *testing.T) {
+	var f, p QuicFrame
+	data := make([]byte, 100)
+
+	ranges := []QuicPacketRange{{95, 98}, {90, 90}, {10, 20}, {1, 5}}
+	f.SetFrameType(QUICFRAMETYPE_ACK)
+	f.SetLargestObserved(100)
+	for _, r := range ranges {
+		if err := f.AddMissingRange(r); err != nil {
+			t.Errorf("QuicFrame.AddMissingRange : error %s with range %v", err, r)
+		}
+	}
+	if err := f.AddMissingRange(QuicPacketRange{2, 3}); err == nil {
+		t.Error("QuicFrame.AddMissingRange : missing error for a range not in descending order")
+	}
+	if err := f.AddRevivedPacket(97); err != nil {
+		t.Errorf("QuicFrame.AddRevivedPacket : error %s", err)
+	}
+	s, err := f.GetSerializedData(data)
+	if err != nil {
+		t.Fatalf("QuicFrame.GetSerializedData : error %s", err)
+	}
+	if s != f.GetSerializedSize() {
+		t.Errorf("QuicFrame.GetSerializedSize : invalid size %v (%v serialized)", f.GetSerializedSize(), s)
+	}
+	if _, err = p.ParseData(data[:s]); err != nil {
+		t.Fatalf("QuicFrame.ParseData : error %s", err)
+	}
+	got := p.GetMissingRanges()
+	if len(got) != len(ranges) {
+		t.Fatalf("QuicFrame.GetMissingRanges : invalid ranges %v (%v expected)", got, ranges)
+	}
+	for i := range ranges {
+		if got[i] != ranges[i] {
+			t.Errorf("QuicFrame.GetMissingRanges : invalid range %v (%v expected)", got[i], ranges[i])
+		}
+	}
+	if r := p.GetRevivedPackets(); (len(r) != 1) || (r[0] != 97) {
+		t.Errorf("QuicFrame.GetRevivedPackets : invalid revived packets %v", r)
+	}
+	// Ranges of more than 256 packets are split
+	f.Erase()
+	f.SetFrameType(QUICFRAMETYPE_ACK)
+	f.SetLargestObserved(1000)
+	f.AddMissingRange(QuicPacketRange{1, 999})
+	if got = f.GetMissingRanges(); (len(got) != 4) || (got[0].Last != 999) || (got[3].First != 1) {
+		t.Errorf("QuicFrame.AddMissingRange : invalid split of a large range %v", got)
+	}
+}
+
+func Test_QuicFrame_Reuse(t *testing.T) {
+	var f QuicFrame
+	data := []byte{QUICFRAMETYPE_STREAM | QUICFLAG_DATALENGTH | QUICFLAG_STREAMID_8bit, 0x12, 0x03, 0x00, 0x42, 0x17, 0x89}
+
+	// A frame parsed over a larger ACK frame doesn't keep its fields
+	for i, v := range tests_quicframe {
+		if !v.positiveTest || (v.frame.frameType != QUICFRAMETYPE_ACK) {
+			continue
+		}
+		f.SetLeastUnackedDeltaByteSize(v.leastUnackedDeltaByteSize)
+		if _, err := f.ParseData(v.data); err != nil {
+			t.Fatalf("QuicFrame.ParseData : error %s in test %v", err, i)
+		}
+		if _, err := f.ParseData(data); err != nil {
+			t.Fatalf("QuicFrame.ParseData : error %s after test %v", err, i)
+		}
+		if (f.frameType != QUICFRAMETYPE_STREAM) || (f.largestObserved != 0) || (len(f.timestamps) != 0) || (len(f.missingRanges) != 0) || (len(f.revivedPackets) != 0) {
+			t.Errorf("QuicFrame.ParseData : fields of the ACK frame kept after test %v", i)
+		}
+		if f.GetSerializedSize() != len(data) {
+			t.Errorf("QuicFrame.GetSerializedSize : invalid size %v after test %v", f.GetSerializedSize(), i)
+		}
+	}
+	// The data of a STREAM frame reference the parsed buffer
+	data[4] = 0x24
+	if f.frameData[0] != 0x24 {
+		t.Error("QuicFrame.ParseData : the STREAM frame data are copied")
+	}
+}
+
+func Test_QuicFrame_CongestionFeedback(t *testing.T) {
+	var f, p QuicFrame
+	data := make([]byte, 2000)
+	now := time.UnixMicro(time.Now().UnixMicro())
+
+	f.SetFrameType(QUICFRAMETYPE_CONGESTION_FEEDBACK)
+	f.SetCongestionFeedbackType(QUICCONGESTIONFEEDBACK_TCP)
+	if f.SetReceiveWindow(0x12345); f.GetReceiveWindow() != 0x12340 {
+		t.Errorf("QuicFrame.SetReceiveWindow : receive window %x not rounded to 16 bytes", f.GetReceiveWindow())
+	}
+	if f.SetReceiveWindow(1 << 32); f.GetReceiveWindow() != 0xffff0 {
+		t.Errorf("QuicFrame.SetReceiveWindow : receive window %x not limited", f.GetReceiveWindow())
+	}
+
+	f.SetCongestionFeedbackType(QUICCONGESTIONFEEDBACK_INTERARRIVAL)
+	for i := 0; i < MaxCongestionFeedbackReceivedPackets; i++ {
+		if err := f.AddReceivedPacket(QuicPacketSequenceNumber(1000+2*i), now.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			t.Fatalf("QuicFrame.AddReceivedPacket : error %s for packet %v", err, i)
+		}
+	}
+	if err := f.AddReceivedPacket(5000, now); err == nil {
+		t.Error("QuicFrame.AddReceivedPacket : missing error with too many packets")
+	}
+	s, err := f.GetSerializedData(data)
+	if (err != nil) || (s != f.GetSerializedSize()) {
+		t.Fatalf("QuicFrame.GetSerializedData : error %v with size %v (%v expected)", err, s, f.GetSerializedSize())
+	}
+	if _, err = p.ParseData(data[:s]); err != nil {
+		t.Fatalf("QuicFrame.ParseData : error %s", err)
+	}
+	got := p.GetReceivedPackets()
+	if len(got) != MaxCongestionFeedbackReceivedPackets {
+		t.Fatalf("QuicFrame.GetReceivedPackets : %v packets", len(got))
+	}
+	for i, r := range got {
+		if (r.SequenceNumber != QuicPacketSequenceNumber(1000+2*i)) || !r.ReceiveTime.Equal(now.Add(time.Duration(i)*time.Millisecond)) {
+			t.Errorf("QuicFrame.GetReceivedPackets : invalid packet %v at index %v", r, i)
+		}
+	}
+
+	f.Erase()
+	f.AddReceivedPacket(10, now)
+	if err := f.AddReceivedPacket(10, now); err == nil {
+		t.Error("QuicFrame.AddReceivedPacket : missing error for a packet not in ascending order")
+	}
+	if err := f.AddReceivedPacket(10+0x10000, now); err == nil {
+		t.Error("QuicFrame.AddReceivedPacket : missing error for a sequence number too far")
+	}
+	if err := f.AddReceivedPacket(11, now.Add(time.Hour)); err == nil {
+		t.Error("QuicFrame.AddReceivedPacket : missing error for a reception time too far")
+	}
+}
+
+// A full ACK frame with timestamps, missing ranges and revived packets
+func benchmarkAckFrame() []byte {
+	for _, v := range tests_quicframe {
+		if v.positiveTest && (len(v.frame.missingRanges) > 0) && (len(v.frame.revivedPackets) > 0) {
+			return v.data
+		}
+	}
+	return nil
+}
+
+func Benchmark_QuicFrame_ParseData_Stream(b *testing.B) {
+	var f QuicFrame
+	data := make([]byte, 1350)
+	data[0] = QUICFRAMETYPE_STREAM | QUICFLAG_STREAMID_32bit | QUICFLAG_BYTEOFFSET_64bit
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		f.ParseData(data)
+	}
+}
+
+func Benchmark_QuicFrame_ParseData_Ack(b *testing.B) {
+	var f QuicFrame
+	data := benchmarkAckFrame()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		f.ParseData(data)
+	}
+}
+
+func Benchmark_QuicFrame_GetSerializedData_Ack(b *testing.B) {
+	var f QuicFrame
+	f.ParseData(benchmarkAckFrame())
+	data := make([]byte, 1350)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.GetSerializedData(data)
+	}
+}
@@ -26,10 +26,10 @@ type QuicPacket struct {
 	// If PublicResetPacket only
 	publicReset QuicPublicResetPacket
 	// If Frames Packet only
-	framesSet []QuicFrame // framesSet uses frameBuffer array for the first cFRAMEBUFFERSIZE frames and after need making bigger slices
+	frames []QuicFrame // frames uses frameBuffer array for the first cFRAMEBUFFERSIZE frames and grows with append after, its memory is kept by Erase
 	// internal buffers
 	buffer      [1472]byte                  // internal buffer to store serialized QUIC Packet at reception or before encryption and transmit
-	frameBuffer [cFRAMEBUFFERSIZE]QuicFrame // array used by framesSet for the first cFRAMEBUFFERSIZE frames only
+	frameBuffer [cFRAMEBUFFERSIZE]QuicFrame // array used by frames for the first cFRAMEBUFFERSIZE frames only
 }
 
 // Erase
@@ -39,7 +39,7 @@ func (this *QuicPacket) Erase() {
 	this.fecPacket.Erase()
 	this.publicReset.Erase()
 	this.packetType = QUICPACKETTYPE_UNKNOW
-	this.framesSet = nil
+	this.frames = this.frames[:0]
 	for i, _ := range this.buffer {
 		this.buffer[i] = 0
 	}
@@ -99,22 +99,25 @@ func (this *QuicPacket) ParseData(data []byte) (size int, err error) {
 				this.packetType = QUICPACKETTYPE_FRAME
 			}
 			// Parse Frames vector
-			i := 0
-			for left := l - size; left > 0; i++ {
-				if i == 0 { // initialize the frames set to use frameBuffer array
-					this.framesSet = this.frameBuffer[:1]
-				} else if (i % cFRAMEBUFFERSIZE) > 0 { // grow the frame set by using existing slice capacity (+1)
-					this.framesSet = this.framesSet[:i]
-				} else { // grow the frame set with make (+ccFRAMEBUFFERSIZE) and copy
-					fs := make([]QuicFrame, i, ((i/cFRAMEBUFFERSIZE)+1)*cFRAMEBUFFERSIZE)
-					copy(fs, this.framesSet)
+			if this.frames == nil { // initialize the frames to use frameBuffer array
+				this.frames = this.frameBuffer[:0]
+			}
+			this.frames = this.frames[:0]
+			for i := 0; size < l; i++ {
+				if i < cap(this.frames) { // use existing slice capacity, the ACK frame slices of the previous frames are reused
+					this.frames = this.frames[:i+1]
+				} else { // grow the frames with append
+					this.frames = append(this.frames, QuicFrame{})
 				}
+				frame := &this.frames[i]
+				// The Least Unacked Delta of a STOP_WAITING frame has the size of the packet Sequence Number
+				frame.SetLeastUnackedDeltaByteSize(uint(this.publicHeader.seqNumByteSize))
 				// Parse next QuicFrame
-				if s, err = this.framesSet[i].ParseData(data[size:]); err != nil {
+				if s, err = frame.ParseData(data[size:]); err != nil {
+					this.frames = this.frames[:i]
 					return
 				}
 				size += s
-				left -= s
 			}
 		}
 	}
@@ -176,6 +179,12 @@ func (this *QuicPacket) GetSerializedData() (data []byte, err error) {
 	return
 }
 
+// Frames returns the frames parsed in a Frame or Protected Frame packet, in packet order.
+// The slice references the memory of the packet: it is only valid until the next call of ParseData or Erase.
+func (this *QuicPacket) Frames() []QuicFrame {
+	return this.frames
+}
+
 // GetPacketType
 func (this *QuicPacket) GetPacketType() (packettype QuicPacketType) {
 	return this.packetType
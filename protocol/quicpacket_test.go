@@ -2,6 +2,7 @@ package protocol
 
 import "testing"
 import "bytes"
+import "fmt"
 
 type testquicpacket struct {
 	positiveTest         bool
@@ -384,3 +385,158 @@ func Test_QuicPacket_SerializeData(t *testing.T) {
 		packet.Erase()
 	}
 }
+
+// A packet of 1350 bytes with an ACK frame and a STREAM frame, parsed in a new QuicPacket as done for each received packet
+func Benchmark_QuicPacket_ParseData(b *testing.B) {
+	data := []byte{
+		QUICFLAG_CONNID_64bit | QUICFLAG_SEQNUM_8bit, // Public flags
+		0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11, // Connection ID (64-bit)
+		0x42, // Sequence Number (8-bit)
+		0x00} // Private flags
+	data = append(data, benchmarkAckFrame()...)
+	data = append(data, QUICFRAMETYPE_STREAM|QUICFLAG_STREAMID_32bit|QUICFLAG_BYTEOFFSET_64bit)
+	data = append(data, make([]byte, 1350-len(data))...)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		packet := new(QuicPacket)
+		if _, err := packet.ParseData(data); err != nil {
+			b.Fatalf("QuicPacket.ParseData : error %s", err)
+		}
+	}
+}
+
+// Frame packet with 6 frames: more than the cFRAMEBUFFERSIZE frames stored in the packet
+var tests_quicpacket_frames = []byte{
+	QUICFLAG_CONNID_64bit | QUICFLAG_SEQNUM_16bit, // Public flags
+	0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11, // Connection ID (64-bit)
+	0x42, 0x00, // Sequence Number (16-bit)
+	0x00,                                         // Private flags
+	QUICFRAMETYPE_PING,                           // PING frame
+	QUICFRAMETYPE_STOP_WAITING, 0x11, 0x02, 0x00, // STOP_WAITING frame with entropy 0x11 and 16-bit Least Unacked Delta
+	QUICFRAMETYPE_BLOCKED, 0x05, 0x00, 0x00, 0x00, // BLOCKED frame
+	QUICFRAMETYPE_STREAM | QUICFLAG_DATALENGTH | QUICFLAG_STREAMID_8bit, 0x05, 0x02, 0x00, 'h', 'i', // STREAM frame
+	QUICFRAMETYPE_WINDOW_UPDATE, 0x05, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // WINDOW_UPDATE frame
+	QUICFRAMETYPE_PADDING, 0x00, 0x00, 0x00} // PADDING frame up to the end of the packet
+
+func Test_QuicPacket_Frames(t *testing.T) {
+	var packet QuicPacket
+	types := []QuicFrameType{QUICFRAMETYPE_PING, QUICFRAMETYPE_STOP_WAITING, QUICFRAMETYPE_BLOCKED, QUICFRAMETYPE_STREAM, QUICFRAMETYPE_WINDOW_UPDATE, QUICFRAMETYPE_PADDING}
+
+	for n := 0; n < 2; n++ {
+		s, err := packet.ParseData(tests_quicpacket_frames)
+		if err != nil {
+			t.Fatalf("QuicPacket.ParseData : error %s in pass %v", err, n)
+		}
+		if s != len(tests_quicpacket_frames) {
+			t.Errorf("QuicPacket.ParseData : invalid size %v in pass %v", s, n)
+		}
+		frames := packet.Frames()
+		if len(frames) != len(types) {
+			t.Fatalf("QuicPacket.Frames : %v frames in pass %v", len(frames), n)
+		}
+		for i := range frames {
+			if frames[i].GetFrameType() != types[i] {
+				t.Errorf("QuicPacket.Frames : invalid frame type %x for frame %v in pass %v", frames[i].GetFrameType(), i, n)
+			}
+		}
+		if frames[1].GetLeastUnackedDelta() != 2 {
+			t.Errorf("QuicPacket.Frames : invalid Least Unacked Delta %x in pass %v", frames[1].GetLeastUnackedDelta(), n)
+		}
+		if !bytes.Equal(frames[3].GetData(), []byte("hi")) {
+			t.Errorf("QuicPacket.Frames : invalid STREAM frame data %q in pass %v", frames[3].GetData(), n)
+		}
+		packet.Erase()
+		if len(packet.Frames()) != 0 {
+			t.Errorf("QuicPacket.Erase : %v frames left in pass %v", len(packet.Frames()), n)
+		}
+	}
+	// The memory of the frames is reused
+	allocs := testing.AllocsPerRun(10, func() {
+		packet.ParseData(tests_quicpacket_frames)
+		packet.Erase()
+	})
+	if allocs != 0 {
+		t.Errorf("QuicPacket.ParseData : %v allocations with frames reused", allocs)
+	}
+	// A shorter packet replaces all the frames
+	if _, err := packet.ParseData(tests_quicpacket_frames[:13]); (err != nil) || (len(packet.Frames()) != 1) {
+		t.Errorf("QuicPacket.ParseData : error %v with %v frames for a packet with a single frame", err, len(packet.Frames()))
+	}
+}
+
+// Inputs found by Fuzz_QuicPacket_ParseData
+var tests_quicpacket_fuzz = [][]byte{
+	// Public Reset packet with end offsets in descending order
+	[]byte("200000000PRST\x02\x0000000000000000\x10\x00\x00\x000000000000000000"),
+	// Frame packets with 5 and 9 PING frames, more than cFRAMEBUFFERSIZE frames
+	{QUICFLAG_CONNID_8bit, 0x11, 0x42, 0x00, 0x07, 0x07, 0x07, 0x07, 0x07},
+	{QUICFLAG_CONNID_8bit, 0x11, 0x42, 0x00, 0x07, 0x07, 0x07, 0x07, 0x07, 0x07, 0x07, 0x07, 0x07},
+	// STREAM frame without Data Length after a PING frame
+	{QUICFLAG_CONNID_8bit, 0x11, 0x42, 0x00, 0x07, 0x80, 0x05, 'h', 'i'},
+}
+
+func Test_QuicPacket_ParseData_Fuzz(t *testing.T) {
+	var packet QuicPacket
+	for i, v := range tests_quicpacket_fuzz {
+		if err := checkQuicPacketParseData(&packet, v); err != nil {
+			t.Errorf("QuicPacket.ParseData : %s in test n°%v with data[%v]%x", err, i, len(v), v)
+		}
+	}
+}
+
+// checkQuicPacketParseData parses the data twice in the same packet, and checks the size and the frames are consistent.
+func checkQuicPacketParseData(packet *QuicPacket, data []byte) error {
+	s, err := packet.ParseData(data)
+	if err != nil {
+		return nil
+	}
+	if s != len(data) {
+		return fmt.Errorf("invalid size %v", s)
+	}
+	if (packet.GetPacketType() != QUICPACKETTYPE_FRAME) && (packet.GetPacketType() != QUICPACKETTYPE_PROTECTEDFRAME) && (packet.GetPacketType() != QUICPACKETTYPE_VERSION) {
+		return nil
+	}
+	size := packet.publicHeader.GetSerializedSize() + packet.privateHeader.GetSerializedSize()
+	types := make([]QuicFrameType, 0, len(packet.Frames()))
+	for i := range packet.Frames() {
+		frame := &packet.Frames()[i]
+		size += frame.GetSerializedSize()
+		types = append(types, frame.GetFrameType())
+	}
+	if size != len(data) {
+		return fmt.Errorf("frames serialized size %v", size)
+	}
+	packet.Erase()
+	if _, err = packet.ParseData(data); err != nil {
+		return fmt.Errorf("error %s when parsed again", err)
+	}
+	if len(packet.Frames()) != len(types) {
+		return fmt.Errorf("%v frames when parsed again instead of %v", len(packet.Frames()), len(types))
+	}
+	for i := range types {
+		if packet.Frames()[i].GetFrameType() != types[i] {
+			return fmt.Errorf("frame type %x when parsed again instead of %x", packet.Frames()[i].GetFrameType(), types[i])
+		}
+	}
+	return nil
+}
+
+func Fuzz_QuicPacket_ParseData(f *testing.F) {
+	for _, v := range tests_quicpacket {
+		f.Add(v.data)
+	}
+	for _, v := range tests_quicframe {
+		f.Add(append([]byte{QUICFLAG_CONNID_8bit, 0x11, 0x42, 0x00}, v.data...))
+	}
+	f.Add(tests_quicpacket_frames)
+	for _, v := range tests_quicpacket_fuzz {
+		f.Add(v)
+	}
+	var packet QuicPacket
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := checkQuicPacketParseData(&packet, data); err != nil {
+			t.Errorf("QuicPacket.ParseData : %s with data[%v]%x", err, len(data), data)
+		}
+	})
+}
@@ -87,6 +87,10 @@ func (this *QuicPublicResetPacket) ParseData(data []byte) (size int, err error)
 		// Read uint32 offset
 		endOffsets[i] = uint32(binary.LittleEndian.Uint32(data[size:]))
 		size += 4
+		if (i > 0) && (endOffsets[i] < endOffsets[i-1]) {
+			err = errors.New("QuicPublicResetPacket.ParseData : invalid Public Reset packet, end offsets not in ascending order")
+			return
+		}
 	}
 	// Ask for next data size
 	needMoreData += int(endOffsets[numEntries-1])
@@ -143,7 +147,11 @@ func (this *QuicPublicResetPacket) GetSerializedData(data []byte) (size int, err
 		err = errors.New("QuicPublicResetPacket.GetSerializedData : data size too small to contain Public Reset packet")
 		size = 0
 	}
-	copy(data, this.msg.GetSerialize())
+	msg, e := this.msg.GetSerialize()
+	if e != nil {
+		return 0, e
+	}
+	copy(data, msg)
 	return
 }
 
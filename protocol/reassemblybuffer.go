@@ -0,0 +1,158 @@
+package protocol
+
+import "sort"
+
+// reassemblySegment is a block of contiguous data received at an offset and not yet read.
+type reassemblySegment struct {
+	offset QuicByteOffset
+	data   []byte
+}
+
+// end returns the offset following the last byte of the segment.
+func (this *reassemblySegment) end() QuicByteOffset {
+	return this.offset + QuicByteOffset(len(this.data))
+}
+
+// ReassemblyBuffer reorders the data of a stream received at arbitrary offsets, with overlaps and duplicates, and gives them back in stream order.
+//
+// The data received are kept in an interval set: a list of non-overlapping segments sorted by offset, the gaps between the segments are the data not yet received.
+// Insert only copies the bytes that fill a gap, so the retransmissions overlapping data already received are trimmed.
+//
+// Data can only be inserted up to 'window' bytes after the read offset, so that the buffer never holds more than the flow control receive window.
+//
+// ReassemblyBuffer is not safe for concurrent use.
+type ReassemblyBuffer struct {
+	segments   []reassemblySegment
+	readOffset QuicByteOffset
+	window     QuicByteCount
+	buffered   int
+}
+
+// NewReassemblyBuffer is a ReassemblyBuffer factory, 'window' is the maximum number of bytes accepted after the read offset.
+func NewReassemblyBuffer(window QuicByteCount) *ReassemblyBuffer {
+	return &ReassemblyBuffer{
+		window: window}
+}
+
+// SetWindow sets the maximum number of bytes accepted after the read offset.
+func (this *ReassemblyBuffer) SetWindow(window QuicByteCount) {
+	this.window = window
+}
+
+// GetReadOffset returns the offset of the next byte to read.
+func (this *ReassemblyBuffer) GetReadOffset() QuicByteOffset {
+	return this.readOffset
+}
+
+// GetBufferedSize returns the number of bytes received and not yet read, contiguous or not.
+func (this *ReassemblyBuffer) GetBufferedSize() int {
+	return this.buffered
+}
+
+// CanRead returns the number of contiguous bytes that can be read at the read offset.
+func (this *ReassemblyBuffer) CanRead() (n int) {
+	next := this.readOffset
+	for i := range this.segments {
+		if this.segments[i].offset != next {
+			break
+		}
+		n += len(this.segments[i].data)
+		next = this.segments[i].end()
+	}
+	return
+}
+
+// Insert copies the data received at 'offset' in the buffer: the bytes before the read offset or already received are ignored.
+// The error is a QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA error if the data exceed the window, nothing is inserted in that case.
+func (this *ReassemblyBuffer) Insert(offset QuicByteOffset, data []byte) error {
+	end := offset + QuicByteOffset(len(data))
+	if end > this.readOffset+QuicByteOffset(this.window) {
+		return NewQuicError(QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "ReassemblyBuffer.Insert : flow control window exceeded")
+	}
+	if end <= this.readOffset {
+		return nil
+	}
+	if offset < this.readOffset {
+		data = data[this.readOffset-offset:]
+		offset = this.readOffset
+	}
+	// First segment that ends after the offset
+	i := sort.Search(len(this.segments), func(i int) bool { return this.segments[i].end() > offset })
+	for len(data) > 0 {
+		if (i < len(this.segments)) && (this.segments[i].offset <= offset) {
+			// Bytes already received
+			skip := int(this.segments[i].end() - offset)
+			if skip >= len(data) {
+				break
+			}
+			data = data[skip:]
+			offset = this.segments[i].end()
+			i++
+			continue
+		}
+		// Fill the gap up to the next segment
+		n := len(data)
+		if (i < len(this.segments)) && (this.segments[i].offset < offset+QuicByteOffset(n)) {
+			n = int(this.segments[i].offset - offset)
+		}
+		this.segments = append(this.segments, reassemblySegment{})
+		copy(this.segments[i+1:], this.segments[i:])
+		this.segments[i] = reassemblySegment{offset, append([]byte(nil), data[:n]...)}
+		this.buffered += n
+		data = data[n:]
+		offset += QuicByteOffset(n)
+		i++
+	}
+	return nil
+}
+
+// Read copies the contiguous data at the read offset into p and returns the number of bytes copied (0 <= n <= len(p)).
+// It never blocks and returns 0 if the data at the read offset are not yet received, the error is always nil.
+func (this *ReassemblyBuffer) Read(p []byte) (n int, err error) {
+	for (len(this.segments) > 0) && (n < len(p)) {
+		segment := &this.segments[0]
+		if segment.offset != this.readOffset {
+			break
+		}
+		m := copy(p[n:], segment.data)
+		n += m
+		segment.data = segment.data[m:]
+		segment.offset += QuicByteOffset(m)
+		this.readOffset += QuicByteOffset(m)
+		if len(segment.data) == 0 {
+			this.segments[0] = reassemblySegment{}
+			this.segments = this.segments[1:]
+		}
+	}
+	this.buffered -= n
+	if len(this.segments) == 0 {
+		// Release the memory of the segments already read
+		this.segments = nil
+	}
+	return
+}
+
+// Skip moves the read offset forward to 'offset', the data received before 'offset' are dropped without being read.
+func (this *ReassemblyBuffer) Skip(offset QuicByteOffset) {
+	if offset <= this.readOffset {
+		return
+	}
+	this.readOffset = offset
+	for len(this.segments) > 0 {
+		segment := &this.segments[0]
+		if segment.offset >= offset {
+			break
+		}
+		if segment.end() > offset {
+			this.buffered -= int(offset - segment.offset)
+			segment.data = segment.data[offset-segment.offset:]
+			segment.offset = offset
+			break
+		}
+		this.buffered -= len(segment.data)
+		this.segments = this.segments[1:]
+	}
+	if len(this.segments) == 0 {
+		this.segments = nil
+	}
+}
@@ -0,0 +1,133 @@
+package protocol
+
+import "testing"
+import "testing/quick"
+import "bytes"
+import "errors"
+import "math/rand"
+
+func Test_ReassemblyBuffer_Insert(t *testing.T) {
+	b := make([]byte, 16)
+	rb := NewReassemblyBuffer(10)
+
+	rb.Insert(4, []byte("efg"))
+	rb.Insert(0, []byte("ab"))
+	if (rb.CanRead() != 2) || (rb.GetBufferedSize() != 5) {
+		t.Errorf("ReassemblyBuffer.Insert : %v bytes readable and %v bytes buffered", rb.CanRead(), rb.GetBufferedSize())
+	}
+	// Overlap on both sides of the gap: only the missing bytes are copied
+	rb.Insert(1, []byte("bcdefgh"))
+	if (rb.CanRead() != 8) || (rb.GetBufferedSize() != 8) || (len(rb.segments) != 4) {
+		t.Errorf("ReassemblyBuffer.Insert : %v bytes readable, %v bytes buffered in %v segments after overlap", rb.CanRead(), rb.GetBufferedSize(), len(rb.segments))
+	}
+	if n, _ := rb.Read(b[:5]); !bytes.Equal(b[:n], []byte("abcde")) || (rb.GetReadOffset() != 5) {
+		t.Errorf("ReassemblyBuffer.Read : invalid data %q", b[:n])
+	}
+	// Duplicate before the read offset
+	rb.Insert(0, []byte("abcde"))
+	if rb.GetBufferedSize() != 3 {
+		t.Errorf("ReassemblyBuffer.Insert : %v bytes buffered after duplicate", rb.GetBufferedSize())
+	}
+	// Flow control window
+	if err := rb.Insert(15, []byte("p")); !errors.Is(err, QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA) {
+		t.Errorf("ReassemblyBuffer.Insert : error %v beyond the window", err)
+	}
+	if err := rb.Insert(15, nil); err != nil {
+		t.Errorf("ReassemblyBuffer.Insert : error %v at the end of the window", err)
+	}
+	// Skip the data not yet read
+	rb.Insert(10, []byte("klm"))
+	rb.Skip(11)
+	if (rb.GetReadOffset() != 11) || (rb.GetBufferedSize() != 2) || (rb.CanRead() != 2) {
+		t.Errorf("ReassemblyBuffer.Skip : read offset %v with %v bytes buffered", rb.GetReadOffset(), rb.GetBufferedSize())
+	}
+	if n, _ := rb.Read(b); !bytes.Equal(b[:n], []byte("lm")) {
+		t.Errorf("ReassemblyBuffer.Read : invalid data %q after Skip", b[:n])
+	}
+}
+
+// checkReassemblyBuffer verifies the invariants of the interval set: segments sorted by offset, not overlapping, after the read offset and within the window.
+func checkReassemblyBuffer(rb *ReassemblyBuffer) bool {
+	size := 0
+	next := rb.readOffset
+	for i := range rb.segments {
+		if (len(rb.segments[i].data) == 0) || (rb.segments[i].offset < next) {
+			return false
+		}
+		size += len(rb.segments[i].data)
+		next = rb.segments[i].end()
+	}
+	return (size == rb.buffered) && (next <= rb.readOffset+QuicByteOffset(rb.window))
+}
+
+func Test_ReassemblyBuffer_Property(t *testing.T) {
+	// For any arrival order of frames with overlaps and duplicates, the data read are the data sent
+	property := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		size := 1 + r.Intn(4096)
+		window := 1 + r.Intn(1024)
+		data := make([]byte, size)
+		r.Read(data)
+
+		// Frames covering all the data, with random retransmissions overlapping the previous frames
+		type frame struct {
+			offset int
+			length int
+		}
+		var frames []frame
+		for offset := 0; offset < size; {
+			length := 1 + r.Intn(100)
+			if offset+length > size {
+				length = size - offset
+			}
+			frames = append(frames, frame{offset, length})
+			if r.Intn(4) == 0 {
+				start := r.Intn(offset + 1)
+				frames = append(frames, frame{start, offset + length - start})
+			}
+			offset += length
+		}
+
+		rb := NewReassemblyBuffer(QuicByteCount(window))
+		var received []byte
+		buf := make([]byte, 256)
+		for len(frames) > 0 {
+			// Deliver the part of a random frame that fits in the window, as a sender respecting the flow control does
+			i := r.Intn(len(frames))
+			f := frames[i]
+			if limit := int(rb.GetReadOffset()) + window; f.offset+f.length > limit {
+				if f.offset >= limit {
+					f.length = 0
+				} else {
+					f.length = limit - f.offset
+				}
+				frames[i] = frame{f.offset + f.length, frames[i].length - f.length}
+			} else {
+				frames = append(frames[:i], frames[i+1:]...)
+			}
+			if f.length > 0 {
+				if err := rb.Insert(QuicByteOffset(f.offset), data[f.offset:f.offset+f.length]); err != nil {
+					return false
+				}
+				if !checkReassemblyBuffer(rb) {
+					return false
+				}
+			}
+			if r.Intn(2) == 0 {
+				n, _ := rb.Read(buf[:1+r.Intn(len(buf))])
+				received = append(received, buf[:n]...)
+			}
+		}
+		for {
+			n, _ := rb.Read(buf)
+			if n == 0 {
+				break
+			}
+			received = append(received, buf[:n]...)
+		}
+		return bytes.Equal(received, data) && (rb.GetBufferedSize() == 0)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Errorf("ReassemblyBuffer : %v", err)
+	}
+}
@@ -2,6 +2,11 @@ package protocol
 
 import "testing"
 import "bytes"
+import "context"
+import "fmt"
+import "io"
+import "math/rand"
+import "time"
 
 func TestRingBuffer(t *testing.T) {
 	var n int
@@ -222,3 +227,162 @@ func TestRingBuffer(t *testing.T) {
 		t.Errorf("Read data %v different from Write data %v", string(readData[:24]), string(writeData[:24]))
 	}
 }
+
+func Test_RingBuffer_Resize(t *testing.T) {
+	buf := make([]byte, 8)
+	_, rb := NewRingBuffer(6)
+
+	if err := rb.Resize(0); err == nil {
+		t.Error("RingBuffer.Resize : no error on invalid size")
+	}
+	// Immediate growth
+	rb.Write([]byte("abcd"))
+	rb.Read(buf[:2])
+	rb.Resize(8)
+	if (rb.GetBufferSize() != 8) || (rb.CanRead() != 2) || (rb.CanWrite() != 6) {
+		t.Errorf("RingBuffer.Resize : invalid immediate growth %v %v %v", rb.GetBufferSize(), rb.CanRead(), rb.CanWrite())
+	}
+	// Growth deferred until the data are not half cut on the end and the beginning of the buffer
+	rb.Write([]byte("efghij"))
+	rb.Read(buf[:4])
+	rb.Write([]byte("kl"))
+	rb.Resize(16)
+	if rb.GetBufferSize() != 8 {
+		t.Errorf("RingBuffer.Resize : growth of a half cut buffer")
+	}
+	rb.Read(buf[:4])
+	if (rb.GetBufferSize() != 16) || (rb.CanRead() != 2) || (rb.CanWrite() != 14) {
+		t.Errorf("RingBuffer.Resize : invalid deferred growth %v %v %v", rb.GetBufferSize(), rb.CanRead(), rb.CanWrite())
+	}
+	if n, _ := rb.Read(buf); !bytes.Equal(buf[:n], []byte("kl")) {
+		t.Errorf("RingBuffer.Resize : invalid data %q after growth", buf[:n])
+	}
+	// Diminish deferred until enough data are read, the writes are limited to the new size
+	rb.Write([]byte("mnopqrst"))
+	rb.Resize(4)
+	if (rb.GetBufferSize() != 16) || (rb.CanWrite() != 0) {
+		t.Errorf("RingBuffer.Resize : invalid pending diminish %v %v", rb.GetBufferSize(), rb.CanWrite())
+	}
+	rb.Read(buf[:4])
+	if (rb.GetBufferSize() != 4) || (rb.CanRead() != 4) || (rb.CanWrite() != 0) {
+		t.Errorf("RingBuffer.Resize : invalid deferred diminish %v %v %v", rb.GetBufferSize(), rb.CanRead(), rb.CanWrite())
+	}
+	if n, _ := rb.Read(buf); !bytes.Equal(buf[:n], []byte("qrst")) {
+		t.Errorf("RingBuffer.Resize : invalid data %q after diminish", buf[:n])
+	}
+}
+
+func Test_RingBuffer_ReadWriteContext(t *testing.T) {
+	buf := make([]byte, 8)
+	_, rb := NewRingBuffer(4)
+
+	// Deadline of a blocked reader and of a blocked writer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if n, err := rb.ReadContext(ctx, buf); (n != 0) || (err != context.DeadlineExceeded) {
+		t.Errorf("RingBuffer.ReadContext : %v bytes read with error %v on an empty buffer", n, err)
+	}
+	if n, err := rb.WriteContext(ctx, []byte("abcdef")); (n != 4) || (err != context.DeadlineExceeded) {
+		t.Errorf("RingBuffer.WriteContext : %v bytes written with error %v on a full buffer", n, err)
+	}
+
+	// The reader is woken up by Close once the buffer is empty
+	done := make(chan error)
+	go func() {
+		var err error
+		for err == nil {
+			_, err = rb.ReadContext(context.Background(), buf)
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rb.Close()
+	if err := <-done; err != io.EOF {
+		t.Errorf("RingBuffer.ReadContext : error %v after Close", err)
+	}
+	if _, err := rb.Write([]byte("a")); err != ErrRingBufferClosed {
+		t.Errorf("RingBuffer.Write : error %v after Close", err)
+	}
+}
+
+func Test_RingBuffer_Stress(t *testing.T) {
+	const size = 1 << 20
+	data := make([]byte, size)
+	rand.Read(data)
+	_, rb := NewRingBuffer(64)
+
+	// One writer with random write sizes and resizes, one reader with random read sizes
+	go func() {
+		for n := 0; n < size; {
+			l := 1 + rand.Intn(200)
+			if n+l > size {
+				l = size - n
+			}
+			m, err := rb.WriteContext(context.Background(), data[n:n+l])
+			if err != nil {
+				t.Errorf("RingBuffer.WriteContext : error %v", err)
+				return
+			}
+			n += m
+			if rand.Intn(100) == 0 {
+				rb.Resize(16 + rand.Intn(512))
+			}
+		}
+		rb.Close()
+	}()
+	received := make([]byte, 0, size)
+	buf := make([]byte, 300)
+	for {
+		n, err := rb.ReadContext(context.Background(), buf[:1+rand.Intn(len(buf))])
+		received = append(received, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("RingBuffer.ReadContext : error %v", err)
+		}
+	}
+	if !bytes.Equal(received, data) {
+		t.Errorf("RingBuffer : %v bytes received out of %v, or data corrupted", len(received), size)
+	}
+}
+
+func Benchmark_RingBuffer_WriteRead(b *testing.B) {
+	// Write and read in the same goroutine: the cost of the mutex is not contended
+	for _, size := range []int{16, 1350} {
+		b.Run(fmt.Sprintf("%vbytes", size), func(b *testing.B) {
+			_, rb := NewRingBuffer(64 * 1024)
+			data := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rb.Write(data)
+				rb.Read(data)
+			}
+		})
+	}
+}
+
+func Benchmark_RingBuffer_Concurrent(b *testing.B) {
+	// One writer and one reader in separate goroutines: the mutex is contended
+	for _, size := range []int{16, 1350} {
+		b.Run(fmt.Sprintf("%vbytes", size), func(b *testing.B) {
+			_, rb := NewRingBuffer(64 * 1024)
+			data := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					rb.WriteContext(context.Background(), data)
+				}
+				rb.Close()
+			}()
+			buf := make([]byte, size)
+			for {
+				if _, err := rb.ReadContext(context.Background(), buf); err != nil {
+					break
+				}
+			}
+		})
+	}
+}
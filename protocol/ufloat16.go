@@ -0,0 +1,52 @@
+package protocol
+
+/*
+
+16-bit unsigned float (used for the Largest Observed Delta Time of ACK frame, in microseconds):
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|     Exponent      |                 Mantissa                  |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+
+* 5-bit exponent and 11-bit mantissa with an implicit leading bit (12-bit effective mantissa)
+* values below 4096 are encoded as is (exponent = 0 or 1 are equivalent to a 12-bit integer)
+* the maximum value is 0x7FF << 30 (about 4.4 * 10^12 microseconds)
+
+*/
+
+const (
+	ufloat16MantissaBits          = 11
+	ufloat16MantissaEffectiveBits = 12
+	ufloat16MaxExponent           = 30
+	ufloat16MaxValue              = ((uint64(1) << ufloat16MantissaEffectiveBits) - 1) << ufloat16MaxExponent
+)
+
+// Uint64ToUfloat16 encodes an unsigned integer into a 16-bit unsigned float, the value is rounded down and saturates at the maximum value.
+func Uint64ToUfloat16(value uint64) uint16 {
+	if value < (uint64(1) << ufloat16MantissaEffectiveBits) {
+		return uint16(value)
+	}
+	if value >= ufloat16MaxValue {
+		return 0xffff
+	}
+	// Binary search of the exponent, so that value is in [2^11, 2^12)
+	exponent := uint64(0)
+	for offset := uint64(16); offset > 0; offset /= 2 {
+		if value >= (uint64(1) << (ufloat16MantissaBits + offset)) {
+			exponent += offset
+			value >>= offset
+		}
+	}
+	// The implicit leading bit of the mantissa increments the exponent by one
+	return uint16(value + (exponent << ufloat16MantissaBits))
+}
+
+// Ufloat16ToUint64 decodes a 16-bit unsigned float.
+func Ufloat16ToUint64(ufloat uint16) uint64 {
+	value := uint64(ufloat)
+	if value < (uint64(1) << ufloat16MantissaEffectiveBits) {
+		return value
+	}
+	exponent := value >> ufloat16MantissaBits
+	value -= (exponent - 1) << ufloat16MantissaBits
+	return value << (exponent - 1)
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import "testing"
+
+type testufloat16 struct {
+	value  uint64
+	ufloat uint16
+	decode uint64
+}
+
+var tests_ufloat16 = []testufloat16{
+	{0, 0, 0},
+	{1, 1, 1},
+	{4095, 4095, 4095},
+	{4096, 4096, 4096},
+	{4097, 4096, 4096},
+	{8191, 6143, 8190},
+	{8192, 6144, 8192},
+	{0x0fff << 30, 0xffff, 0x0fff << 30},
+	{1 << 62, 0xffff, 0x0fff << 30},
+}
+
+func Test_Ufloat16(t *testing.T) {
+	for i, v := range tests_ufloat16 {
+		if f := Uint64ToUfloat16(v.value); f != v.ufloat {
+			t.Errorf("Uint64ToUfloat16 : invalid encoding %x of %v in test n°%v (%x expected)", f, v.value, i, v.ufloat)
+		}
+		if d := Ufloat16ToUint64(v.ufloat); d != v.decode {
+			t.Errorf("Ufloat16ToUint64 : invalid decoding %v of %x in test n°%v (%v expected)", d, v.ufloat, i, v.decode)
+		}
+	}
+	// Decoding is monotonic and encoding of a decoded value is the identity
+	previous := uint64(0)
+	for f := 0; f <= 0xffff; f++ {
+		d := Ufloat16ToUint64(uint16(f))
+		if (f > 0) && (d <= previous) {
+			t.Errorf("Ufloat16ToUint64 : decoding is not monotonic at %x", f)
+		}
+		if Uint64ToUfloat16(d) != uint16(f) {
+			t.Errorf("Uint64ToUfloat16 : encoding of %v is not %x", d, f)
+		}
+		previous = d
+	}
+}
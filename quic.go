@@ -3,16 +3,171 @@
 // See https://www.chromium.org/quic
 package quic
 
+import "context"
+import "crypto/rand"
+import "encoding/binary"
+import "errors"
+import "io"
 import "net"
+import "sync"
 import "time"
+import "github.com/romain-jacotin/quic/congestion"
+import "github.com/romain-jacotin/quic/crypto"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// maxAcceptQueue is the number of new sessions waiting for AcceptQUIC before the listener ignores the new clients
+	maxAcceptQueue = 64
+)
+
+// Config contains the options of the sessions created by DialQUICConfig and ListenQUICConfig, a nil Config selects the default options.
+type Config struct {
+	// ConnectionOptions are the connection options (value of TagCOPT) of the handshake, they select the congestion control algorithm
+	// of the session, see congestion.NewSendAlgorithm. The client sends them in its CHLO and applies them locally,
+	// the server applies the connection options of the client, or its own ones if the client doesn't send any.
+	ConnectionOptions []protocol.MessageTag
+}
 
 type QUICListener struct {
+	mutex    sync.Mutex
+	conn     *net.UDPConn
+	config   *Config
+	sessions map[protocol.QuicConnectionID]*QUICSession
+	accept   chan *QUICSession
+	closed   bool
+	closing  chan struct{}
+	drained  chan struct{}
+	deadline time.Time
 }
 
+// QUICSession is a QUIC connection multiplexing Stream connections.
+// Once the session is closed, its methods and the methods of its streams return a *protocol.QuicError with the error code and the reason phrase
+// of the closure: errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) tests the error code, errors.As gives the reason phrase and the origin of the error.
 type QUICSession struct {
+	mutex sync.Mutex
+	// cond is signaled on each change of the streams or of the session state, for the blocking calls
+	cond            *sync.Cond
+	conn            packetConn
+	laddr           net.Addr
+	raddr           net.Addr
+	connectionID    protocol.QuicConnectionID
+	isClient        bool
+	aead            crypto.AEAD
+	rttStats        *congestion.RTTStats
+	sendAlgorithm   congestion.SendAlgorithm
+	pacer           *congestion.Pacer
+	writer          *packetWriter
+	sentPackets     *sentPacketManager
+	receivedPackets *receivedPacketManager
+	// retransmissions are the frames of the lost packets, controlFrames are the new control frames
+	retransmissions []*protocol.QuicFrame
+	controlFrames   []*protocol.QuicFrame
+	sendAlarm       time.Time
+	// Streams
+	streams             map[protocol.QuicStreamID]*StreamConn
+	streamIDs           []protocol.QuicStreamID
+	nextStreamID        protocol.QuicStreamID
+	largestPeerStreamID protocol.QuicStreamID
+	acceptQueue         []*StreamConn
+	// Crypto handshake: Messages on the reserved crypto stream, the session is closed if the handshake is not complete after handshakeTimeout
+	crypto            *cryptoStream
+	handshakeComplete bool
+	handshakeStart    time.Time
+	// congestionFeedback is the type of the CONGESTION_FEEDBACK frames sent with the ACK frames negotiated in the handshake (value of TagCGST), zero if none
+	congestionFeedback protocol.MessageTag
+	// Maximum number of open streams in each direction: local and peer values of MSPC (the peer value is known after the handshake)
+	maxStreams     int
+	peerMaxStreams int
+	// GOAWAY frames: goAway is the error of the GOAWAY sent (the session is closed with it once drained), peerGoAway is the error of the GOAWAY received
+	goAway     *protocol.QuicError
+	peerGoAway *protocol.QuicError
+	// Connection flow control
+	sendWindow        protocol.QuicByteCount
+	bytesSent         protocol.QuicByteCount
+	receiveWindow     protocol.QuicByteCount
+	receiveWindowSize protocol.QuicByteCount
+	bytesReceived     protocol.QuicByteCount
+	bytesRead         protocol.QuicByteCount
+	// Forward Error Correction: open group on sender side, groups being received on receiver side
+	fecGroupSize int
+	fecTimeout   time.Duration
+	fecGroup     *fecGroup
+	fecGroups    map[protocol.QuicPacketSequenceNumber]*fecGroup
+	// Duplicate packets: copies waiting for their send time
+	duplicateCount int
+	duplicates     []*pendingDuplicate
+	stats          sessionStats
+	// Idle timeout: local and peer values of ICSL and SCLS (the peer values are known after the handshake), and time of the last network activity
+	idleTimeout     time.Duration
+	peerIdleTimeout time.Duration
+	silentClose     bool
+	peerSilentClose bool
+	lastActivity    time.Time
+	// activitySent is true once a packet in flight has been sent since the last packet received
+	activitySent bool
+	// Keep-alive: a PING frame is sent after 'keepAlivePeriod' without network activity, pings are the PING frames sent by Ping waiting for their ACK
+	keepAlive       bool
+	keepAlivePeriod time.Duration
+	lastPing        time.Time
+	pings           map[*protocol.QuicFrame]chan time.Duration
+	// Event loop
+	incoming   chan []byte
+	sendSignal chan struct{}
+	closing    chan struct{}
+	closed     bool
+	closeErr   error
+	onClose    func()
+}
+
+// SessionStats are the statistics of a session.
+type SessionStats struct {
+	PacketsSent          uint64
+	PacketsRetransmitted uint64
+	PacketsRevived       uint64
+	PacketsDuplicated    uint64
+	// OpenStreams is the number of streams initiated locally and not yet finished, MaxStreams is the maximum negotiated with the peer
+	OpenStreams int
+	MaxStreams  int
+	// OpenPeerStreams is the number of streams initiated by the peer and not yet finished, MaxPeerStreams is the number of open streams of the peer
+	// above which its new streams are refused (the negotiated maximum with a tolerance)
+	OpenPeerStreams int
+	MaxPeerStreams  int
+}
+
+// sessionStats are the packet counters of a session.
+type sessionStats struct {
+	packetsSent          uint64
+	packetsRetransmitted uint64
+	packetsRevived       uint64
+	packetsDuplicated    uint64
 }
 
 type StreamConn struct {
+	session *QUICSession
+	id      protocol.QuicStreamID
+	// Send side
+	chunks      []streamChunk
+	buffered    int
+	sendOffset  protocol.QuicByteOffset
+	sendWindow  protocol.QuicByteOffset
+	writeClosed bool
+	finSent     bool
+	rstSent     bool
+	// writeErr is the error returned by Write once the peer has asked to stop sending
+	writeErr error
+	// Receive side
+	received          *protocol.ReassemblyBuffer
+	readClosed        bool
+	highestReceived   protocol.QuicByteOffset
+	receiveWindow     protocol.QuicByteOffset
+	receiveWindowSize protocol.QuicByteOffset
+	finReceived       bool
+	finOffset         protocol.QuicByteOffset
+	rstReceived       bool
+	// err is the error of a stream reset or abandoned by the session, returned by Read and Write
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
 }
 
 // ListenUDP listens for incoming UDP packets addressed to the local address laddr.
@@ -20,97 +175,597 @@ type StreamConn struct {
 // If laddr has a port of 0, ListenUDP will choose an available port.
 // The LocalAddr method of the returned QUICSession can be used to discover the port.
 // The returned connection's ReadFrom and WriteTo methods can be used to receive and send UDP packets with per-packet addressing.
-func ListenQUIC(net string, laddr *net.UDPAddr) (*QUICListener, error) {
-	return nil, nil
+func ListenQUIC(network string, laddr *net.UDPAddr) (*QUICListener, error) {
+	return ListenQUICConfig(network, laddr, nil)
+}
+
+// ListenQUICConfig is like ListenQUIC but the sessions of the clients use the options of 'config'.
+func ListenQUICConfig(network string, laddr *net.UDPAddr, config *Config) (*QUICListener, error) {
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	l := &QUICListener{
+		conn:     conn,
+		config:   config,
+		sessions: make(map[protocol.QuicConnectionID]*QUICSession),
+		accept:   make(chan *QUICSession, maxAcceptQueue),
+		closing:  make(chan struct{}),
+		drained:  make(chan struct{})}
+	go l.run()
+	return l, nil
+}
+
+// run reads the UDP packets of the listener and dispatches them to the sessions by Connection ID, a new session is created for an unknown Connection ID.
+func (l *QUICListener) run() {
+	var publicHeader protocol.QuicPublicHeader
+
+	buffer := make([]byte, maxReceivedPacketSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		data := append([]byte(nil), buffer[:n]...)
+		publicHeader.Erase()
+		if _, err = publicHeader.ParseData(data); err != nil {
+			continue
+		}
+		connID := publicHeader.GetConnectionID()
+		l.mutex.Lock()
+		s, ok := l.sessions[connID]
+		if !ok && !l.closed && !publicHeader.GetPublicResetFlag() && (len(l.accept) < maxAcceptQueue) {
+			s = newQUICSession(l.conn, l.conn.LocalAddr(), addr, connID, false, l.config, func() { l.removeSession(connID) })
+			l.sessions[connID] = s
+			l.accept <- s
+		}
+		l.mutex.Unlock()
+		if s != nil {
+			s.receive(data)
+		}
+	}
+}
+
+// removeSession forgets a closed session, the UDP socket of a closed listener is closed with its last session.
+func (l *QUICListener) removeSession(connID protocol.QuicConnectionID) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.sessions[connID]; !ok {
+		return
+	}
+	delete(l.sessions, connID)
+	if l.closed && (len(l.sessions) == 0) {
+		l.conn.Close()
+		close(l.drained)
+	}
 }
 
 // AcceptQUIC accepts the next incoming new QUIC call and returns the new session.
 func (l *QUICListener) AcceptQUIC() (*QUICSession, error) {
-	return nil, nil
+	var timeout <-chan time.Time
+
+	l.mutex.Lock()
+	deadline := l.deadline
+	l.mutex.Unlock()
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case s := <-l.accept:
+		return s, nil
+	case <-l.closing:
+		return nil, errors.New("QUICListener.AcceptQUIC : listener closed")
+	case <-timeout:
+		return nil, timeoutError{}
+	}
 }
 
 // Addr returns the listener's network address, a *UDPAddr.
 func (l *QUICListener) Addr() (a net.UDPAddr) {
-	return
+	return *l.conn.LocalAddr().(*net.UDPAddr)
 }
 
 // Close stops listening on the QUIC address.
 // Already Accepted sessions are not closed.
 func (l *QUICListener) Close() error {
-	return nil
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return errors.New("QUICListener.Close : listener already closed")
+	}
+	l.closed = true
+	close(l.closing)
+	if len(l.sessions) == 0 {
+		l.conn.Close()
+		close(l.drained)
+	}
+	l.mutex.Unlock()
+	// The sessions not yet accepted are closed
+	for {
+		select {
+		case s := <-l.accept:
+			s.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Shutdown gracefully shuts down the listener: it stops listening on the QUIC address, sends a GOAWAY frame with QUIC_PEER_GOING_AWAY on all the sessions
+// and waits until all the sessions are drained and closed, see QUICSession.GoAway.
+// If the context expires first, Shutdown returns the context's error and the remaining sessions are left draining.
+func (l *QUICListener) Shutdown(ctx context.Context) error {
+	l.Close()
+	l.mutex.Lock()
+	sessions := make([]*QUICSession, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		sessions = append(sessions, s)
+	}
+	l.mutex.Unlock()
+	for _, s := range sessions {
+		s.GoAway(protocol.QUIC_PEER_GOING_AWAY, "server shutdown")
+	}
+	select {
+	case <-l.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // SetDeadline sets the deadline associated with the listener.
 // A zero time value disables the deadline.
 func (l *QUICListener) SetDeadline(t time.Time) error {
+	l.mutex.Lock()
+	l.deadline = t
+	l.mutex.Unlock()
 	return nil
 }
 
 // DialQUIC connects to the remote address raddr on the network net, which must be "udp", "udp4", or "udp6".
 // If laddr is not nil, it is used as the local address for the connection.
-func DialQUIC(net string, laddr, raddr *net.UDPAddr) (*QUICSession, error) {
-	return nil, nil
+func DialQUIC(network string, laddr, raddr *net.UDPAddr) (*QUICSession, error) {
+	return DialQUICConfig(network, laddr, raddr, nil)
+}
+
+// DialQUICConfig is like DialQUIC but the session uses the options of 'config'.
+func DialQUICConfig(network string, laddr, raddr *net.UDPAddr, config *Config) (*QUICSession, error) {
+	var id [8]byte
+
+	if raddr == nil {
+		return nil, errors.New("DialQUIC : missing remote address")
+	}
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	s := newQUICSession(conn, conn.LocalAddr(), raddr, protocol.QuicConnectionID(binary.LittleEndian.Uint64(id[:])), true, config, func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, maxReceivedPacketSize)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			s.receive(append([]byte(nil), buffer[:n]...))
+		}
+	}()
+	return s, nil
+}
+
+// setConnectionOptions applies the connection options (value of TagCOPT) of the handshake to the session:
+// the congestion control algorithm of the session is selected here.
+func (s *QUICSession) setConnectionOptions(tags []protocol.MessageTag) {
+	if s.rttStats == nil {
+		s.rttStats = congestion.NewRTTStats()
+	}
+	s.sendAlgorithm = congestion.NewSendAlgorithm(tags, s.rttStats)
+	// The pacer follows the pacing rate of the new congestion controller, the minimum pacing of the session is kept
+	pacer := congestion.NewPacer(s.sendAlgorithm)
+	if s.pacer != nil {
+		pacer.SetMinimumGap(s.pacer.GetMinimumGap())
+	}
+	s.pacer = pacer
+	if s.writer != nil {
+		s.writer.pacer = pacer
+	}
+	if s.sentPackets != nil {
+		s.sentPackets.sendAlgorithm = s.sendAlgorithm
+	}
+}
+
+// setIdleTimeout applies the idle connection state lifetime in seconds (value of TagICSL)
+// and the silently close on timeout flag (value of TagSCLS) of the peer's handshake to the session.
+func (s *QUICSession) setIdleTimeout(icsl uint32, scls bool) {
+	s.peerIdleTimeout = time.Duration(icsl) * time.Second
+	s.peerSilentClose = scls
+	s.signal()
+}
+
+// setMaxStreams applies the maximum number of streams per connection (value of TagMSPC) of the peer's handshake to the session.
+func (s *QUICSession) setMaxStreams(mspc uint32) {
+	s.peerMaxStreams = int(mspc)
+	s.cond.Broadcast()
 }
 
 // Close closes the session.
 func (s *QUICSession) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return errors.New("QUICSession.Close : session already closed")
+	}
+	s.closeWithError(protocol.NewQuicError(protocol.QUIC_NO_ERROR, ""), true)
+	return nil
+}
+
+// GoAway sends a GOAWAY frame with the error code and the reason phrase to the peer: the new streams initiated by the peer after the last accepted stream are refused,
+// the existing streams are finished normally and the session is closed once all of them are finished in both directions and all the data are acknowledged.
+// After GoAway, NewStream returns an error on both sides.
+func (s *QUICSession) GoAway(code protocol.QuicErrorCode, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return s.closeErr
+	}
+	if s.goAway != nil {
+		return errors.New("QUICSession.GoAway : GOAWAY already sent")
+	}
+	s.goAway = protocol.NewQuicError(code, reason)
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_GOAWAY)
+	frame.SetErrorCode(code)
+	frame.SetStreamID(s.largestPeerStreamID)
+	frame.SetReasonPhrase(reason)
+	s.controlFrames = append(s.controlFrames, frame)
+	s.signal()
 	return nil
 }
 
 // PublicReset closes immediatly the session.
 func (s *QUICSession) PublicReset() error {
-	return nil
+	var publicHeader protocol.QuicPublicHeader
+	var publicReset protocol.QuicPublicResetPacket
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return errors.New("QUICSession.PublicReset : session already closed")
+	}
+	publicHeader.SetPublicResetFlag(true)
+	publicHeader.SetConnectionID(s.connectionID)
+	publicReset.SetNonceProof(0)
+	publicReset.SetRejectedSequenceNumber(s.receivedPackets.largestObserved)
+	buffer := make([]byte, MaxPacketSize)
+	n, _ := publicHeader.GetSerializedData(buffer)
+	m, err := publicReset.GetSerializedData(buffer[n:])
+	if err == nil {
+		_, err = s.writer.WritePacket(buffer[:n+m], false)
+	}
+	s.closeWithError(protocol.NewQuicError(protocol.QUIC_PUBLIC_RESET, ""), false)
+	return err
 }
 
 // LocalAddr returns the local network address.
 func (s *QUICSession) LocalAddr() (l net.UDPAddr) {
+	if a, ok := s.laddr.(*net.UDPAddr); ok {
+		l = *a
+	}
 	return
 }
 
 // RemoteAddr returns the remote network address.
 func (s *QUICSession) RemoteAddr() (r net.UDPAddr) {
+	if a, ok := s.raddr.(*net.UDPAddr); ok {
+		r = *a
+	}
 	return
 }
 
-// SetKeepAlive sets whether the QUIC session should send PING frames on the connection.
+// SetKeepAlive sets whether the QUIC session should send PING frames on the connection when there is no network activity,
+// so that the session doesn't reach its idle timeout and the NAT bindings stay alive. The default is false.
 func (s *QUICSession) SetKeepAlive(keepalive bool) error {
+	s.mutex.Lock()
+	s.keepAlive = keepalive
+	s.mutex.Unlock()
+	s.signal()
 	return nil
 }
 
-// SetKeepAlivePeriod sets period between QUIC PING frames.
+// SetKeepAlivePeriod sets period between QUIC PING frames, the period must be smaller than the idle timeout.
+// The default is DefaultKeepAlivePeriod.
 func (s *QUICSession) SetKeepAlivePeriod(d time.Duration) error {
+	if d <= 0 {
+		return errors.New("QUICSession.SetKeepAlivePeriod : invalid keep-alive period")
+	}
+	s.mutex.Lock()
+	s.keepAlivePeriod = d
+	s.mutex.Unlock()
+	s.signal()
+	return nil
+}
+
+// SetIdleTimeout sets the idle connection state lifetime proposed to the peer during the handshake, from 1 second to MaxIdleTimeout.
+// The session is closed with QUIC_CONNECTION_TIMED_OUT when no packet is received during the idle timeout negotiated with the peer,
+// the smallest of both proposals. The default is DefaultIdleTimeout.
+// The value proposed to the peer is the value at the time of the handshake message, a later change is only applied locally.
+func (s *QUICSession) SetIdleTimeout(d time.Duration) error {
+	if (d < time.Second) || (d > MaxIdleTimeout) {
+		return errors.New("QUICSession.SetIdleTimeout : invalid idle timeout")
+	}
+	s.mutex.Lock()
+	s.idleTimeout = d
+	s.mutex.Unlock()
+	s.signal()
+	return nil
+}
+
+// SetSilentClose sets whether the session is closed silently on idle timeout, without sending a CONNECTION_CLOSE frame to the peer.
+// The session is closed silently if it is requested by one of the peers.
+func (s *QUICSession) SetSilentClose(silent bool) error {
+	s.mutex.Lock()
+	s.silentClose = silent
+	s.mutex.Unlock()
+	return nil
+}
+
+// SetMaxStreams sets the maximum number of open streams in each direction proposed to the peer during the handshake.
+// The session uses the smallest of both proposals, the default is DefaultMaxStreams.
+// The value proposed to the peer is the value at the time of the handshake message, a later change is only applied locally.
+func (s *QUICSession) SetMaxStreams(streams int) error {
+	if streams < 1 {
+		return errors.New("QUICSession.SetMaxStreams : invalid maximum number of streams")
+	}
+	s.mutex.Lock()
+	s.maxStreams = streams
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	return nil
+}
+
+// Stats returns the statistics of the session.
+func (s *QUICSession) Stats() SessionStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SessionStats{
+		PacketsSent:          s.stats.packetsSent,
+		PacketsRetransmitted: s.stats.packetsRetransmitted,
+		PacketsRevived:       s.stats.packetsRevived,
+		PacketsDuplicated:    s.stats.packetsDuplicated,
+		OpenStreams:          s.countStreams(false),
+		MaxStreams:           s.getMaxStreams(),
+		OpenPeerStreams:      s.countStreams(true),
+		MaxPeerStreams:       s.getMaxIncomingStreams()}
+}
+
+// SetMinimumPacing sets the minimum duration between two consecutive QUIC packets sent on the session, whatever the pacing rate of the congestion control.
+// A zero duration disables the minimum pacing (the default).
+func (s *QUICSession) SetMinimumPacing(gap time.Duration) error {
+	if gap < 0 {
+		return errors.New("QUICSession.SetMinimumPacing : negative minimum gap")
+	}
+	s.mutex.Lock()
+	s.pacer.SetMinimumGap(gap)
+	s.mutex.Unlock()
 	return nil
 }
 
-// PING is a blocking function that send a PING frame and waits for the associated ACK
-func (s *QUICSession) Ping(keepalive bool) error {
+// SetFECGroupSize sets the number of packets protected by a FEC packet for the data written with StreamConn.WriteFEC, from 1 to MaxFECGroupSize.
+// The default is DefaultFECGroupSize.
+func (s *QUICSession) SetFECGroupSize(packets int) error {
+	if (packets < 1) || (packets > MaxFECGroupSize) {
+		return errors.New("QUICSession.SetFECGroupSize : invalid FEC group size")
+	}
+	s.mutex.Lock()
+	s.fecGroupSize = packets
+	s.mutex.Unlock()
 	return nil
 }
 
+// SetFECTimeout sets the maximum duration between the first protected packet of a FEC group and its FEC packet,
+// the FEC packet of an incomplete group is sent when the timer expires.
+// A zero duration sets the timer to half the smoothed RTT (the default).
+func (s *QUICSession) SetFECTimeout(d time.Duration) error {
+	if d < 0 {
+		return errors.New("QUICSession.SetFECTimeout : negative FEC timeout")
+	}
+	s.mutex.Lock()
+	s.fecTimeout = d
+	s.mutex.Unlock()
+	s.signal()
+	return nil
+}
+
+// SetDuplicateCount sets the number of copies of the packets sent for the data written with StreamConn.WriteDuplicate, from 1 to MaxDuplicateCount.
+// The default is DefaultDuplicateCount.
+func (s *QUICSession) SetDuplicateCount(copies int) error {
+	if (copies < 1) || (copies > MaxDuplicateCount) {
+		return errors.New("QUICSession.SetDuplicateCount : invalid number of copies")
+	}
+	s.mutex.Lock()
+	s.duplicateCount = copies
+	s.mutex.Unlock()
+	return nil
+}
+
+// Ping is a blocking function that sends a PING frame and waits for the associated ACK, it returns the round trip time measured.
+// Ping returns the context's error if the ACK is not received before the context expires, see context.WithDeadline.
+func (s *QUICSession) Ping(ctx context.Context) (time.Duration, error) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return 0, s.closeErr
+	}
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_PING)
+	done := make(chan time.Duration, 1)
+	s.pings[frame] = done
+	s.controlFrames = append(s.controlFrames, frame)
+	s.mutex.Unlock()
+	s.signal()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pings, frame)
+		s.mutex.Unlock()
+	}()
+	select {
+	case rtt := <-done:
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-s.closing:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return 0, s.closeErr
+	}
+}
+
 // NewStrem creates and add a new Stream connection on the QUIC session.
+// NewStream returns a *StreamLimitError if the maximum number of open streams is reached, see OpenStream.
 func (s *QUICSession) NewStream() (*StreamConn, error) {
-	return nil, nil
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.newStream()
+}
+
+// OpenStream creates and add a new Stream connection on the QUIC session, it blocks while the maximum number of open streams is reached.
+// OpenStream returns the context's error if no stream can be created before the context expires.
+func (s *QUICSession) OpenStream(ctx context.Context) (*StreamConn, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mutex.Lock()
+			s.cond.Broadcast()
+			s.mutex.Unlock()
+		case <-stop:
+		}
+	}()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		c, err := s.newStream()
+		if _, ok := err.(*StreamLimitError); !ok {
+			return c, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		s.cond.Wait()
+	}
+}
+
+// newStream creates a new stream initiated locally.
+func (s *QUICSession) newStream() (*StreamConn, error) {
+	if s.closed {
+		return nil, s.closeErr
+	}
+	if s.peerGoAway != nil {
+		return nil, s.peerGoAway
+	}
+	if s.goAway != nil {
+		return nil, s.goAway
+	}
+	if limit := s.getMaxStreams(); s.countStreams(false) >= limit {
+		return nil, &StreamLimitError{Limit: limit}
+	}
+	c := s.addStream(s.nextStreamID)
+	s.nextStreamID += 2
+	return c, nil
 }
 
 // AcceptStream accepts the next incoming stream and returns the new connection.
 func (s *QUICSession) AcceptStream() (*StreamConn, error) {
-	return nil, nil
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.acceptQueue) == 0 {
+		if s.closed {
+			return nil, s.closeErr
+		}
+		s.cond.Wait()
+	}
+	c := s.acceptQueue[0]
+	s.acceptQueue = s.acceptQueue[1:]
+	return c, nil
 }
 
-// Close closes the connection.
+// Close closes the connection: the sides of the Stream connection not yet shut down are closed, see CloseRead and CloseWrite.
 func (c *StreamConn) Close() error {
+	s := c.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c.writeClosed && c.readClosed {
+		return errors.New("StreamConn.Close : stream already closed")
+	}
+	if s.closed {
+		return s.closeErr
+	}
+	if !c.writeClosed {
+		s.closeWrite(c)
+	}
+	if !c.readClosed {
+		s.closeRead(c)
+	}
+	return nil
+}
+
+// Reset resets the Stream connection with the error code: a RST_STREAM frame is sent to the peer with the final byte offset of the stream,
+// the data not yet sent or not yet acknowledged are abandoned and the data received and not yet read are discarded.
+// The next calls of Read and Write return a *StreamResetError, on both sides.
+func (c *StreamConn) Reset(code protocol.QuicErrorCode) error {
+	s := c.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return s.closeErr
+	}
+	if c.rstSent {
+		return errors.New("StreamConn.Reset : stream already reset")
+	}
+	if code == protocol.QUIC_NO_ERROR {
+		return errors.New("StreamConn.Reset : QUIC_NO_ERROR is reserved to stop the peer sending after CloseRead")
+	}
+	s.resetStream(c, &StreamResetError{StreamID: c.id, Err: protocol.NewQuicError(code, "")})
 	return nil
 }
 
-// CloseRead shuts down the reading side of the Stream connection.
+// CloseRead shuts down the reading side of the Stream connection: the data received and not yet read are discarded, as well as the next data received.
+// Once the writing side is shut down, the peer is asked to stop sending with a RST_STREAM frame with QUIC_NO_ERROR.
 // Most callers should just use Close.
 func (c *StreamConn) CloseRead() error {
+	s := c.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c.readClosed {
+		return errors.New("StreamConn.CloseRead : stream already closed for reading")
+	}
+	if s.closed {
+		return s.closeErr
+	}
+	s.closeRead(c)
 	return nil
 }
 
-// CloseWrite shuts down the writing side of the Stream connection.
+// CloseWrite shuts down the writing side of the Stream connection: a FIN is sent after the data already written,
+// and the peer's Read returns io.EOF after the last byte.
 // Most callers should just use Close.
 func (c *StreamConn) CloseWrite() error {
+	s := c.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c.writeClosed {
+		return errors.New("StreamConn.CloseWrite : stream already closed for writing")
+	}
+	if s.closed {
+		return s.closeErr
+	}
+	s.closeWrite(c)
 	return nil
 }
 
@@ -119,7 +774,34 @@ func (c *StreamConn) CloseWrite() error {
 // Read can be made to time out and return a Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetReadDeadline.
 func (c *StreamConn) Read(b []byte) (int, error) {
-	return 0, nil
+	s := c.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		if n := c.read(b); n > 0 {
+			s.onStreamDataRead(c, n)
+			return n, nil
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.readClosed {
+			return 0, errors.New("StreamConn.Read : read on closed stream")
+		}
+		if c.finReceived && (c.received.GetReadOffset() >= c.finOffset) {
+			return 0, io.EOF
+		}
+		if len(b) == 0 {
+			return 0, nil
+		}
+		if s.closed {
+			return 0, s.closeErr
+		}
+		if !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline) {
+			return 0, timeoutError{}
+		}
+		s.cond.Wait()
+	}
 }
 
 // Write implements the net.Conn Write method.
@@ -127,25 +809,31 @@ func (c *StreamConn) Read(b []byte) (int, error) {
 // Write can be made to time out and return a Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 func (c *StreamConn) Write(b []byte) (int, error) {
-	return 0, nil
+	return c.write(b, writeStandard)
 }
 
 // Write writes data to the Stream connection with Forward Error Correction (FEC).
+// The data are sent in FEC groups of packets followed by a FEC packet, one lost packet per group is revived by the receiver without retransmission;
+// see QUICSession.SetFECGroupSize and QUICSession.SetFECTimeout.
 // Write can be made to time out and return a Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 func (c *StreamConn) WriteFEC(b []byte) (int, error) {
-	return 0, nil
+	return c.write(b, writeFEC)
 }
 
 // Write writes important data to the Stream connection by sending duplicate QUIC packet with pacing.
+// Each packet is sent in several copies with distinct sequence numbers, spaced by the pacing interval; see QUICSession.SetDuplicateCount.
+// The loss of a copy is not a congestion signal as long as another copy is received.
 // Write can be made to time out and return a Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 func (c *StreamConn) WriteDuplicate(b []byte) (int, error) {
-	return 0, nil
+	return c.write(b, writeDuplicate)
 }
 
 // SetDeadline implements the net.Conn SetDeadline method.
 func (c *StreamConn) SetDeadline(t time.Time) error {
+	c.setDeadline(&c.readDeadline, t)
+	c.setDeadline(&c.writeDeadline, t)
 	return nil
 }
 
@@ -169,10 +857,12 @@ func (c *StreamConn) SetNoDelay(noDelay bool) error {
 
 // SetReadDeadline implements the net.Conn SetReadDeadline method.
 func (c *StreamConn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(&c.readDeadline, t)
 	return nil
 }
 
 // SetWriteDeadline implements the net.Conn SetWriteDeadline method.
 func (c *StreamConn) SetWriteDeadline(t time.Time) error {
+	c.setDeadline(&c.writeDeadline, t)
 	return nil
 }
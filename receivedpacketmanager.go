@@ -0,0 +1,318 @@
+package quic
+
+import "errors"
+import "sort"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// maxAckDelay is the maximum delay before acknowledging a retransmittable packet
+	maxAckDelay = 25 * time.Millisecond
+	// maxTrackedMissingRanges is the maximum number of missing ranges tracked by the receiver until a STOP_WAITING frame releases them
+	maxTrackedMissingRanges = 1000
+	// maxAckFrameMissingRanges is the maximum number of missing ranges in an ACK frame
+	maxAckFrameMissingRanges = 255
+	// maxCongestionFeedbackPackets is the maximum number of packets reported by an inter-arrival CONGESTION_FEEDBACK frame,
+	// the packets received after are not reported
+	maxCongestionFeedbackPackets = 64
+)
+
+// receivedPacketManager tracks the packets received by a session and builds the ACK frames.
+//
+// The entropy hash of an ACK frame is the cumulative entropy hash of the received packets up to the Largest Observed,
+// the missing and revived packets don't contribute to the hash. The packets before the least unacked packet of a STOP_WAITING frame
+// are never missing, their cumulative entropy hash is the Sent Entropy of the frame.
+type receivedPacketManager struct {
+	largestObserved     protocol.QuicPacketSequenceNumber
+	largestObservedTime time.Time
+	// missing are the ranges of missing packets below the largest observed, in ascending order
+	missing []protocol.QuicPacketRange
+	// revived are the missing packets revived by FEC
+	revived []protocol.QuicPacketSequenceNumber
+	// leastUnacked is the least unacked packet of the last STOP_WAITING frame received: the packets before are no longer awaited
+	leastUnacked         protocol.QuicPacketSequenceNumber
+	ackQueued            bool
+	ackAlarm             time.Time
+	retransmittableCount int
+	// entropyHash is the cumulative entropy hash of the packets before entropyLeast (the first missing packet),
+	// entropyHashes are the entropy hashes of the packets received from entropyLeast
+	entropyHash   protocol.QuicEntropyHash
+	entropyLeast  protocol.QuicPacketSequenceNumber
+	entropyHashes map[protocol.QuicPacketSequenceNumber]protocol.QuicEntropyHash
+	// receivedTimes are the packets received since the last CONGESTION_FEEDBACK frame with their reception time
+	receivedTimes []protocol.QuicReceivedPacket
+}
+
+var errTooManyMissingRanges = errors.New("receivedPacketManager.OnPacketReceived : too many missing packet ranges")
+
+// newReceivedPacketManager is a receivedPacketManager factory.
+func newReceivedPacketManager() *receivedPacketManager {
+	return &receivedPacketManager{
+		leastUnacked:  1,
+		entropyLeast:  1,
+		entropyHashes: make(map[protocol.QuicPacketSequenceNumber]protocol.QuicEntropyHash)}
+}
+
+// isMissing returns the index of the missing range that contains the packet, or -1.
+func (this *receivedPacketManager) isMissing(seqnum protocol.QuicPacketSequenceNumber) int {
+	for i := len(this.missing) - 1; i >= 0; i-- {
+		r := this.missing[i]
+		if seqnum > r.Last {
+			return -1
+		}
+		if seqnum >= r.First {
+			return i
+		}
+	}
+	return -1
+}
+
+// isRevived returns true if the packet has been revived by FEC.
+func (this *receivedPacketManager) isRevived(seqnum protocol.QuicPacketSequenceNumber) bool {
+	for _, r := range this.revived {
+		if r == seqnum {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDuplicate returns true if the packet has already been received or revived.
+func (this *receivedPacketManager) IsDuplicate(seqnum protocol.QuicPacketSequenceNumber) bool {
+	if seqnum > this.largestObserved {
+		return false
+	}
+	if this.isMissing(seqnum) < 0 {
+		return true
+	}
+	return this.isRevived(seqnum)
+}
+
+// OnPacketReceived records a packet received at 'now' with its entropy flag, 'retransmittable' is true if the packet must be acknowledged.
+//
+// The missing ranges are kept until they are received or released by a STOP_WAITING frame: an error is returned
+// once more than maxTrackedMissingRanges ranges are tracked, the connection must then be closed.
+func (this *receivedPacketManager) OnPacketReceived(seqnum protocol.QuicPacketSequenceNumber, entropy bool, now time.Time, retransmittable bool) error {
+	if entropy {
+		this.entropyHashes[seqnum] = protocol.GetPacketEntropyHash(seqnum, true)
+	}
+	outOfOrder := false
+	if seqnum > this.largestObserved {
+		first := this.largestObserved + 1
+		if first < this.leastUnacked {
+			first = this.leastUnacked
+		}
+		if seqnum > first {
+			this.missing = append(this.missing, protocol.QuicPacketRange{First: first, Last: seqnum - 1})
+			outOfOrder = true
+		}
+		this.largestObserved = seqnum
+		this.largestObservedTime = now
+	} else if i := this.isMissing(seqnum); i >= 0 {
+		outOfOrder = true
+		r := this.missing[i]
+		switch {
+		case r.First == r.Last:
+			this.missing = append(this.missing[:i], this.missing[i+1:]...)
+		case seqnum == r.First:
+			this.missing[i].First++
+		case seqnum == r.Last:
+			this.missing[i].Last--
+		default:
+			this.missing = append(this.missing, protocol.QuicPacketRange{})
+			copy(this.missing[i+2:], this.missing[i+1:])
+			this.missing[i] = protocol.QuicPacketRange{First: r.First, Last: seqnum - 1}
+			this.missing[i+1] = protocol.QuicPacketRange{First: seqnum + 1, Last: r.Last}
+		}
+	}
+	if len(this.receivedTimes) < maxCongestionFeedbackPackets {
+		this.receivedTimes = append(this.receivedTimes, protocol.QuicReceivedPacket{SequenceNumber: seqnum, ReceiveTime: now})
+	}
+	this.updateEntropyHash()
+	this.ackQueued = true
+	if retransmittable {
+		this.retransmittableCount++
+		if outOfOrder || (this.retransmittableCount >= 2) {
+			this.ackAlarm = now
+		} else if this.ackAlarm.IsZero() {
+			this.ackAlarm = now.Add(maxAckDelay)
+		}
+	}
+	if len(this.missing) > maxTrackedMissingRanges {
+		return errTooManyMissingRanges
+	}
+	return nil
+}
+
+// OnStopWaiting processes a STOP_WAITING frame: the packets before 'leastUnacked' are no longer awaited nor reported as missing,
+// and 'entropyHash' (the cumulative entropy hash of the packets sent before 'leastUnacked') becomes the entropy hash of all these packets.
+// An older STOP_WAITING frame received out of order is ignored.
+func (this *receivedPacketManager) OnStopWaiting(leastUnacked protocol.QuicPacketSequenceNumber, entropyHash protocol.QuicEntropyHash) {
+	if leastUnacked <= this.leastUnacked {
+		return
+	}
+	this.leastUnacked = leastUnacked
+	i := 0
+	for (i < len(this.missing)) && (this.missing[i].Last < leastUnacked) {
+		i++
+	}
+	this.missing = append(this.missing[:0], this.missing[i:]...)
+	if (len(this.missing) > 0) && (this.missing[0].First < leastUnacked) {
+		this.missing[0].First = leastUnacked
+	}
+	revived := this.revived[:0]
+	for _, seqnum := range this.revived {
+		if seqnum >= leastUnacked {
+			revived = append(revived, seqnum)
+		}
+	}
+	this.revived = revived
+	if leastUnacked <= this.entropyLeast {
+		// All the packets before the least unacked packet have been received
+		return
+	}
+	for seqnum := range this.entropyHashes {
+		if seqnum < leastUnacked {
+			delete(this.entropyHashes, seqnum)
+		}
+	}
+	this.entropyHash = entropyHash
+	this.entropyLeast = leastUnacked
+	this.updateEntropyHash()
+}
+
+// updateEntropyHash accumulates the entropy hashes of the packets before the first missing packet.
+func (this *receivedPacketManager) updateEntropyHash() {
+	least := this.largestObserved + 1
+	if len(this.missing) > 0 {
+		least = this.missing[0].First
+	}
+	if least <= this.entropyLeast {
+		return
+	}
+	for seqnum, hash := range this.entropyHashes {
+		if seqnum < least {
+			this.entropyHash ^= hash
+			delete(this.entropyHashes, seqnum)
+		}
+	}
+	this.entropyLeast = least
+}
+
+// getEntropyHash returns the cumulative entropy hash of the packets received up to 'largest', 'largest' must not be before the first missing packet.
+func (this *receivedPacketManager) getEntropyHash(largest protocol.QuicPacketSequenceNumber) protocol.QuicEntropyHash {
+	hash := this.entropyHash
+	for seqnum, h := range this.entropyHashes {
+		if seqnum <= largest {
+			hash ^= h
+		}
+	}
+	return hash
+}
+
+// OnPacketRevived records a missing packet revived by FEC, the packet stays missing but is reported as revived in the ACK frames.
+func (this *receivedPacketManager) OnPacketRevived(seqnum protocol.QuicPacketSequenceNumber, now time.Time) {
+	if (this.isMissing(seqnum) < 0) || this.isRevived(seqnum) {
+		return
+	}
+	this.revived = append(this.revived, seqnum)
+	this.ackQueued = true
+	this.ackAlarm = now
+}
+
+// SetAckDue requests an ACK frame at 'now' for the packets already received: the handshake packets are acknowledged without delay.
+func (this *receivedPacketManager) SetAckDue(now time.Time) {
+	if this.ackQueued {
+		this.ackAlarm = now
+	}
+}
+
+// HasAckQueued returns true if packets have been received since the last ACK frame.
+func (this *receivedPacketManager) HasAckQueued() bool {
+	return this.ackQueued
+}
+
+// IsAckDue returns true if an ACK frame must be sent now.
+func (this *receivedPacketManager) IsAckDue(now time.Time) bool {
+	return this.ackQueued && !this.ackAlarm.IsZero() && !now.Before(this.ackAlarm)
+}
+
+// GetAckAlarm returns the time at which an ACK frame must be sent, or zero time if no ACK frame is pending.
+func (this *receivedPacketManager) GetAckAlarm() time.Time {
+	if !this.ackQueued {
+		return time.Time{}
+	}
+	return this.ackAlarm
+}
+
+// GetAckFrame returns the ACK frame to send at 'now' and resets the ACK alarm.
+//
+// When the missing ranges don't fit in the ACK frame, the Largest Observed is lowered so that only the oldest ranges are reported.
+func (this *receivedPacketManager) GetAckFrame(now time.Time) *protocol.QuicFrame {
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_ACK)
+	largest := this.largestObserved
+	ranges := this.missing
+	entries := 0
+	for i, r := range this.missing {
+		// A range of more than 256 packets uses more than one entry in the ACK frame
+		if entries += int((r.Last-r.First)/256) + 1; entries > maxAckFrameMissingRanges {
+			ranges = this.missing[:i]
+			largest = r.First - 1
+			break
+		}
+	}
+	if largest == this.largestObserved {
+		frame.SetLargestObservedDeltaTime(protocol.Uint64ToUfloat16(uint64(now.Sub(this.largestObservedTime) / time.Microsecond)))
+	}
+	frame.SetLargestObserved(largest)
+	frame.SetEntropyHash(this.getEntropyHash(largest))
+	for i := len(ranges) - 1; i >= 0; i-- {
+		frame.AddMissingRange(ranges[i])
+	}
+	// Forget the revived packets that are no longer missing
+	revived := this.revived[:0]
+	for _, seqnum := range this.revived {
+		if this.isMissing(seqnum) >= 0 {
+			revived = append(revived, seqnum)
+			if seqnum < largest {
+				frame.AddRevivedPacket(seqnum)
+			}
+		}
+	}
+	this.revived = revived
+	this.ackQueued = false
+	this.ackAlarm = time.Time{}
+	this.retransmittableCount = 0
+	return frame
+}
+
+// GetCongestionFeedbackFrame returns the CONGESTION_FEEDBACK frame of type 'feedback' to send before the next ACK frame, or nil if there is nothing to report.
+//
+// The TCP feedback reports the available receive window, the inter-arrival feedback reports the packets received since the last CONGESTION_FEEDBACK frame.
+// The packets too far from the first one to be encoded in the frame are kept for the next inter-arrival feedback.
+func (this *receivedPacketManager) GetCongestionFeedbackFrame(feedback protocol.QuicCongestionFeedbackType, receiveWindow protocol.QuicByteCount) *protocol.QuicFrame {
+	received := this.receivedTimes
+	this.receivedTimes = this.receivedTimes[:0]
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_CONGESTION_FEEDBACK)
+	frame.SetCongestionFeedbackType(feedback)
+	switch feedback {
+	case protocol.QUICCONGESTIONFEEDBACK_TCP:
+		frame.SetReceiveWindow(receiveWindow)
+	case protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL:
+		if len(received) == 0 {
+			return nil
+		}
+		sort.Slice(received, func(i, j int) bool { return received[i].SequenceNumber < received[j].SequenceNumber })
+		for i, p := range received {
+			if err := frame.AddReceivedPacket(p.SequenceNumber, p.ReceiveTime); err != nil {
+				this.receivedTimes = append(this.receivedTimes, received[i:]...)
+				break
+			}
+		}
+	default:
+		return nil
+	}
+	return frame
+}
@@ -0,0 +1,279 @@
+package quic
+
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+func Test_receivedPacketManager_GetAckFrame(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// First packet: the ACK frame is delayed
+	manager.OnPacketReceived(1, false, now, true)
+	if manager.IsAckDue(now) {
+		t.Error("receivedPacketManager.IsAckDue : ACK frame must be delayed for one packet")
+	}
+	if !manager.IsAckDue(now.Add(maxAckDelay)) {
+		t.Error("receivedPacketManager.IsAckDue : ACK frame must be sent after the maximum ACK delay")
+	}
+	// Handshake packet: the ACK frame is sent immediately
+	manager.SetAckDue(now)
+	if !manager.IsAckDue(now) {
+		t.Error("receivedPacketManager.SetAckDue : ACK frame must be sent immediately for a handshake packet")
+	}
+	// Packets out of order: the ACK frame is sent immediately
+	for _, seqnum := range []protocol.QuicPacketSequenceNumber{2, 5, 6, 9, 13, 11} {
+		manager.OnPacketReceived(seqnum, false, now, true)
+	}
+	if !manager.IsAckDue(now) {
+		t.Error("receivedPacketManager.IsAckDue : ACK frame must be sent immediately after a missing packet")
+	}
+	for _, seqnum := range []protocol.QuicPacketSequenceNumber{1, 5, 9, 13} {
+		if !manager.IsDuplicate(seqnum) {
+			t.Errorf("receivedPacketManager.IsDuplicate : packet n°%v is a duplicate", seqnum)
+		}
+	}
+	for _, seqnum := range []protocol.QuicPacketSequenceNumber{3, 8, 10, 12, 14} {
+		if manager.IsDuplicate(seqnum) {
+			t.Errorf("receivedPacketManager.IsDuplicate : packet n°%v is not a duplicate", seqnum)
+		}
+	}
+	// Packet revived by FEC
+	manager.OnPacketRevived(7, now)
+	if !manager.IsDuplicate(7) {
+		t.Error("receivedPacketManager.IsDuplicate : revived packet must be a duplicate")
+	}
+	frame := manager.GetAckFrame(now.Add(time.Millisecond))
+	if frame.GetLargestObserved() != 13 {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid largest observed %v", frame.GetLargestObserved())
+	}
+	if d := protocol.Ufloat16ToUint64(frame.GetLargestObservedDeltaTime()); d != 1000 {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid largest observed delta time %vµs", d)
+	}
+	expected := []protocol.QuicPacketRange{{First: 12, Last: 12}, {First: 10, Last: 10}, {First: 7, Last: 8}, {First: 3, Last: 4}}
+	ranges := frame.GetMissingRanges()
+	if len(ranges) != len(expected) {
+		t.Fatalf("receivedPacketManager.GetAckFrame : invalid missing ranges %v (%v expected)", ranges, expected)
+	}
+	for i := range ranges {
+		if ranges[i] != expected[i] {
+			t.Errorf("receivedPacketManager.GetAckFrame : invalid missing ranges %v (%v expected)", ranges, expected)
+		}
+	}
+	if revived := frame.GetRevivedPackets(); (len(revived) != 1) || (revived[0] != 7) {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid revived packets %v", revived)
+	}
+	if manager.HasAckQueued() || manager.IsAckDue(now.Add(time.Hour)) {
+		t.Error("receivedPacketManager.GetAckFrame : ACK frame must not be pending after GetAckFrame")
+	}
+	// The ACK frame of non retransmittable packets is never due but it is sent with the next packet
+	manager.OnPacketReceived(14, false, now, false)
+	if manager.IsAckDue(now.Add(time.Hour)) || !manager.HasAckQueued() {
+		t.Error("receivedPacketManager.IsAckDue : ACK frame must not be due for a non retransmittable packet")
+	}
+	// The revived packet is forgotten once received
+	manager.OnPacketReceived(7, false, now, true)
+	frame = manager.GetAckFrame(now)
+	if revived := frame.GetRevivedPackets(); len(revived) != 0 {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid revived packets %v", revived)
+	}
+}
+
+func Test_receivedPacketManager_EntropyHash(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// Packets 3 and 4 are missing, the entropy flag is set on packets 1, 2, 5 and 3
+	manager.OnPacketReceived(1, true, now, true)
+	manager.OnPacketReceived(2, true, now, true)
+	manager.OnPacketReceived(5, true, now, true)
+	manager.OnPacketReceived(6, false, now, true)
+	if hash := manager.GetAckFrame(now).GetEntropyHash(); hash != 0x26 {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid entropy hash 0x%02x (0x26 expected)", hash)
+	}
+	manager.OnPacketReceived(3, true, now, true)
+	if hash := manager.GetAckFrame(now).GetEntropyHash(); hash != 0x2e {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid entropy hash 0x%02x (0x2e expected)", hash)
+	}
+	manager.OnPacketReceived(4, false, now, true)
+	if hash := manager.GetAckFrame(now).GetEntropyHash(); hash != 0x2e {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid entropy hash 0x%02x (0x2e expected)", hash)
+	}
+	if len(manager.entropyHashes) != 0 {
+		t.Errorf("receivedPacketManager.OnPacketReceived : %v entropy hashes kept without missing packet", len(manager.entropyHashes))
+	}
+}
+
+func Test_receivedPacketManager_GetAckFrame_Truncated(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// 200 missing ranges of 300 packets need 400 entries in the ACK frame
+	seqnum := protocol.QuicPacketSequenceNumber(1)
+	for i := 0; i < 200; i++ {
+		manager.OnPacketReceived(seqnum, true, now, true)
+		seqnum += 301
+	}
+	frame := manager.GetAckFrame(now)
+	ranges := frame.GetMissingRanges()
+	if len(ranges) != maxAckFrameMissingRanges-1 {
+		t.Fatalf("receivedPacketManager.GetAckFrame : %v missing ranges (%v expected)", len(ranges), maxAckFrameMissingRanges-1)
+	}
+	if last := ranges[len(ranges)-1]; last.First != 2 {
+		t.Errorf("receivedPacketManager.GetAckFrame : oldest missing range %v must be reported", last)
+	}
+	if largest := frame.GetLargestObserved(); largest != 1+127*301 {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid largest observed %v (%v expected)", largest, 1+127*301)
+	}
+	// Entropy hash of the packets up to the largest observed
+	var expected protocol.QuicEntropyHash
+	for i := 0; i <= 127; i++ {
+		expected ^= protocol.GetPacketEntropyHash(protocol.QuicPacketSequenceNumber(1+i*301), true)
+	}
+	if hash := frame.GetEntropyHash(); hash != expected {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid entropy hash 0x%02x (0x%02x expected)", hash, expected)
+	}
+}
+
+func Test_receivedPacketManager_GetCongestionFeedbackFrame(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// The inter-arrival feedback reports the packets received since the last feedback in ascending order
+	for i, seqnum := range []protocol.QuicPacketSequenceNumber{1, 3, 2} {
+		manager.OnPacketReceived(seqnum, false, now.Add(time.Duration(i)*time.Millisecond), true)
+	}
+	frame := manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0)
+	if frame == nil {
+		t.Fatal("receivedPacketManager.GetCongestionFeedbackFrame : inter-arrival feedback expected")
+	}
+	expected := []protocol.QuicReceivedPacket{{SequenceNumber: 1, ReceiveTime: now}, {SequenceNumber: 2, ReceiveTime: now.Add(2 * time.Millisecond)}, {SequenceNumber: 3, ReceiveTime: now.Add(time.Millisecond)}}
+	packets := frame.GetReceivedPackets()
+	if len(packets) != len(expected) {
+		t.Fatalf("receivedPacketManager.GetCongestionFeedbackFrame : invalid received packets %v (%v expected)", packets, expected)
+	}
+	for i := range packets {
+		if (packets[i].SequenceNumber != expected[i].SequenceNumber) || !packets[i].ReceiveTime.Equal(expected[i].ReceiveTime) {
+			t.Errorf("receivedPacketManager.GetCongestionFeedbackFrame : invalid received packets %v (%v expected)", packets, expected)
+		}
+	}
+	if manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0) != nil {
+		t.Error("receivedPacketManager.GetCongestionFeedbackFrame : no inter-arrival feedback expected without new packet")
+	}
+	// The number of packets reported is limited
+	for seqnum := protocol.QuicPacketSequenceNumber(4); seqnum < 4+2*maxCongestionFeedbackPackets; seqnum++ {
+		manager.OnPacketReceived(seqnum, false, now, false)
+	}
+	frame = manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0)
+	if n := len(frame.GetReceivedPackets()); n != maxCongestionFeedbackPackets {
+		t.Errorf("receivedPacketManager.GetCongestionFeedbackFrame : invalid number of received packets %v", n)
+	}
+	// The TCP feedback reports the receive window
+	frame = manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_TCP, 1024)
+	if (frame == nil) || (frame.GetCongestionFeedbackType() != protocol.QUICCONGESTIONFEEDBACK_TCP) || (frame.GetReceiveWindow() != 1024) {
+		t.Error("receivedPacketManager.GetCongestionFeedbackFrame : invalid TCP feedback")
+	}
+}
+
+func Test_receivedPacketManager_GetCongestionFeedbackFrame_TooFar(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// The packet 0x10002 is too far from the packet 1 to be encoded in the frame: it is kept for the next feedback
+	for _, seqnum := range []protocol.QuicPacketSequenceNumber{1, 2, 0x10002} {
+		manager.OnPacketReceived(seqnum, false, now, true)
+	}
+	frame := manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0)
+	if packets := frame.GetReceivedPackets(); (len(packets) != 2) || (packets[1].SequenceNumber != 2) {
+		t.Errorf("receivedPacketManager.GetCongestionFeedbackFrame : invalid received packets %v", packets)
+	}
+	// The packet 0x10004 is received too late after the packet 0x10002: the next packets are kept for the next feedback too
+	manager.OnPacketReceived(0x10003, false, now, true)
+	manager.OnPacketReceived(0x10004, false, now.Add(time.Hour), true)
+	manager.OnPacketReceived(0x10005, false, now, true)
+	frame = manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0)
+	if packets := frame.GetReceivedPackets(); (len(packets) != 2) || (packets[0].SequenceNumber != 0x10002) || (packets[1].SequenceNumber != 0x10003) {
+		t.Errorf("receivedPacketManager.GetCongestionFeedbackFrame : invalid received packets %v", packets)
+	}
+	frame = manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0)
+	if packets := frame.GetReceivedPackets(); (len(packets) != 1) || (packets[0].SequenceNumber != 0x10004) {
+		t.Errorf("receivedPacketManager.GetCongestionFeedbackFrame : invalid received packets %v", packets)
+	}
+	if packets := manager.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0).GetReceivedPackets(); (len(packets) != 1) || (packets[0].SequenceNumber != 0x10005) {
+		t.Errorf("receivedPacketManager.GetCongestionFeedbackFrame : invalid received packets %v", packets)
+	}
+}
+
+func Test_receivedPacketManager_OnPacketReceived_TooManyMissingRanges(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// One packet out of two is missing: packets 2, 4, ..., 2000 are 1000 missing ranges
+	seqnum := protocol.QuicPacketSequenceNumber(1)
+	for i := 0; i <= maxTrackedMissingRanges; i++ {
+		if err := manager.OnPacketReceived(seqnum, true, now, true); err != nil {
+			t.Fatalf("receivedPacketManager.OnPacketReceived : unexpected error with %v missing ranges", len(manager.missing))
+		}
+		seqnum += 2
+	}
+	if err := manager.OnPacketReceived(seqnum, true, now, true); err == nil {
+		t.Errorf("receivedPacketManager.OnPacketReceived : error expected with %v missing ranges", len(manager.missing))
+	}
+	// The oldest missing packets are still reported as missing
+	frame := manager.GetAckFrame(now)
+	if ranges := frame.GetMissingRanges(); (len(ranges) == 0) || (ranges[len(ranges)-1] != protocol.QuicPacketRange{First: 2, Last: 2}) {
+		t.Error("receivedPacketManager.GetAckFrame : the oldest missing packet n°2 must be reported")
+	}
+	if !manager.IsDuplicate(1) || manager.IsDuplicate(2) {
+		t.Error("receivedPacketManager.IsDuplicate : the oldest missing packet n°2 must be awaited")
+	}
+	// A STOP_WAITING frame releases the missing ranges before its least unacked packet
+	manager.OnStopWaiting(seqnum-10, 0)
+	seqnum += 2
+	if err := manager.OnPacketReceived(seqnum, true, now, true); err != nil {
+		t.Errorf("receivedPacketManager.OnPacketReceived : unexpected error with %v missing ranges", len(manager.missing))
+	}
+}
+
+func Test_receivedPacketManager_OnStopWaiting(t *testing.T) {
+	now := time.Now()
+	manager := newReceivedPacketManager()
+
+	// Packets 3, 4, 6 and 7 are missing, packet 3 is revived
+	for _, seqnum := range []protocol.QuicPacketSequenceNumber{1, 2, 5, 8} {
+		manager.OnPacketReceived(seqnum, true, now, true)
+	}
+	manager.OnPacketRevived(3, now)
+	// The packets before 7 are no longer awaited: the Sent Entropy replaces the entropy hash of all these packets
+	manager.OnStopWaiting(7, 0x55)
+	frame := manager.GetAckFrame(now)
+	if ranges := frame.GetMissingRanges(); (len(ranges) != 1) || (ranges[0] != protocol.QuicPacketRange{First: 7, Last: 7}) {
+		t.Errorf("receivedPacketManager.OnStopWaiting : invalid missing ranges %v", ranges)
+	}
+	if revived := frame.GetRevivedPackets(); len(revived) != 0 {
+		t.Errorf("receivedPacketManager.OnStopWaiting : invalid revived packets %v", revived)
+	}
+	if hash := frame.GetEntropyHash(); hash != 0x55^protocol.GetPacketEntropyHash(8, true) {
+		t.Errorf("receivedPacketManager.OnStopWaiting : invalid entropy hash 0x%02x", hash)
+	}
+	for _, seqnum := range []protocol.QuicPacketSequenceNumber{3, 4, 6} {
+		if !manager.IsDuplicate(seqnum) {
+			t.Errorf("receivedPacketManager.IsDuplicate : packet n°%v before the least unacked packet must be a duplicate", seqnum)
+		}
+	}
+	// An older STOP_WAITING frame is ignored
+	manager.OnStopWaiting(5, 0xaa)
+	if frame = manager.GetAckFrame(now); len(frame.GetMissingRanges()) != 1 {
+		t.Error("receivedPacketManager.OnStopWaiting : an older STOP_WAITING frame must be ignored")
+	}
+	// STOP_WAITING frame in the packet 12 with a least unacked packet after the largest observed: the packets 9 to 11 are not missing
+	manager.OnStopWaiting(12, 0x0f)
+	manager.OnPacketReceived(12, false, now, true)
+	frame = manager.GetAckFrame(now)
+	if ranges := frame.GetMissingRanges(); len(ranges) != 0 {
+		t.Errorf("receivedPacketManager.OnStopWaiting : invalid missing ranges %v", ranges)
+	}
+	if hash := frame.GetEntropyHash(); hash != 0x0f {
+		t.Errorf("receivedPacketManager.OnStopWaiting : invalid entropy hash 0x%02x (0x0f expected)", hash)
+	}
+}
@@ -0,0 +1,432 @@
+package quic
+
+import "crypto/rand"
+import "encoding/binary"
+import "errors"
+import "time"
+import "github.com/romain-jacotin/quic/congestion"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// numberOfNacksBeforeRetransmission is the number of packets received after a missing packet before declaring it lost
+	numberOfNacksBeforeRetransmission = 3
+	// defaultRetransmissionTime is the retransmission timeout used before the first RTT measurement
+	defaultRetransmissionTime = 500 * time.Millisecond
+	// minRetransmissionTime is the minimum retransmission timeout
+	minRetransmissionTime = 200 * time.Millisecond
+	// maxRetransmissionTime is the maximum retransmission timeout
+	maxRetransmissionTime = 60 * time.Second
+	// maxRetransmissionBackoff is the maximum number of doublings of the retransmission timeout
+	maxRetransmissionBackoff = 10
+	// minHandshakeRetransmissionTime is the minimum retransmission timeout of the handshake packets
+	minHandshakeRetransmissionTime = 10 * time.Millisecond
+)
+
+// errEntropyHashMismatch is returned by sentPacketManager.OnAckFrame when the entropy hash doesn't match the acknowledged packets.
+var errEntropyHashMismatch = errors.New("sentPacketManager.OnAckFrame : invalid entropy hash")
+
+// sentPacket is a packet sent in flight and not yet acknowledged.
+type sentPacket struct {
+	seqnum   protocol.QuicPacketSequenceNumber
+	sentTime time.Time
+	bytes    protocol.QuicByteCount
+	// frames are the retransmittable frames of the packet, empty for a FEC packet
+	frames []*protocol.QuicFrame
+	// duplicates is the set of copies of a packet sent with StreamConn.WriteDuplicate, nil for the other packets
+	duplicates *duplicateSet
+	// pings receive the round trip time of the packet when it is acknowledged, for the PING frames sent by QUICSession.Ping
+	pings []chan time.Duration
+	// handshake is true if the packet carries data of the crypto stream, it is retransmitted more aggressively
+	handshake bool
+}
+
+// duplicateSet tracks the copies of a packet sent with StreamConn.WriteDuplicate.
+// Only the last copy of a set is a congestion signal when lost, the other lost copies are neutered.
+type duplicateSet struct {
+	// outstanding is the number of copies neither acknowledged nor lost, including the copies not yet sent
+	outstanding int
+	// acked is true once a copy has been acknowledged
+	acked bool
+}
+
+// isRedundant returns true if a lost copy of the set doesn't need to be retransmitted nor reported to the congestion controller:
+// another copy has been acknowledged or may still be.
+func (this *duplicateSet) isRedundant() bool {
+	return this.acked || (this.outstanding > 1)
+}
+
+// stopWaitingSent is a STOP_WAITING frame sent in the packet 'seqnum'.
+type stopWaitingSent struct {
+	seqnum       protocol.QuicPacketSequenceNumber
+	leastUnacked protocol.QuicPacketSequenceNumber
+}
+
+// sentPacketManager tracks the packets in flight of a session: it processes the ACK frames, detects the lost packets,
+// updates the RTT statistics and informs the congestion controller.
+//
+// It also allocates the sequence numbers and the random entropy flags of the packets, the entropy hash of each ACK frame is checked
+// against the acknowledged packets so that a peer can't acknowledge packets it has not received (optimistic ACK attack).
+type sentPacketManager struct {
+	rttStats      *congestion.RTTStats
+	sendAlgorithm congestion.SendAlgorithm
+	// entropy stores the entropy flags of the packets that the peer may still report as missing or acknowledge
+	entropy             *protocol.EntropyHashRingBuffer
+	entropyBits         uint64
+	entropyBitsCount    uint
+	nextSequenceNumber  protocol.QuicPacketSequenceNumber
+	packets             []*sentPacket
+	bytesInFlight       protocol.QuicByteCount
+	largestSent         protocol.QuicPacketSequenceNumber
+	largestAcked        protocol.QuicPacketSequenceNumber
+	lastSentTime        time.Time
+	consecutiveRTOCount uint
+	// stopWaitingSent are the STOP_WAITING frames sent and not yet acknowledged, in ascending order
+	stopWaitingSent []stopWaitingSent
+	// leastUnackedAcked is the least unacked packet of the last STOP_WAITING frame acknowledged by the peer
+	leastUnackedAcked protocol.QuicPacketSequenceNumber
+	// Handshake mode: while handshake packets are in flight, only them are retransmitted on timeout
+	lastHandshakeSentTime               time.Time
+	consecutiveHandshakeRetransmissions uint
+}
+
+// newSentPacketManager is a sentPacketManager factory.
+func newSentPacketManager(rttStats *congestion.RTTStats, sendAlgorithm congestion.SendAlgorithm) *sentPacketManager {
+	entropy, _ := protocol.NewEntropyHashRingBuffer()
+	return &sentPacketManager{
+		rttStats:           rttStats,
+		sendAlgorithm:      sendAlgorithm,
+		entropy:            entropy,
+		nextSequenceNumber: 1,
+		leastUnackedAcked:  1}
+}
+
+// GetNextSequenceNumber returns the sequence number of the next packet.
+func (this *sentPacketManager) GetNextSequenceNumber() protocol.QuicPacketSequenceNumber {
+	return this.nextSequenceNumber
+}
+
+// GetNewPacket returns the sequence number and the random entropy flag of a new packet.
+func (this *sentPacketManager) GetNewPacket() (seqnum protocol.QuicPacketSequenceNumber, entropy bool, err error) {
+	if this.entropyBitsCount == 0 {
+		var b [8]byte
+		if _, err = rand.Read(b[:]); err != nil {
+			return
+		}
+		this.entropyBits = binary.LittleEndian.Uint64(b[:])
+		this.entropyBitsCount = 64
+	}
+	entropy = (this.entropyBits & 1) == 1
+	if seqnum, err = this.entropy.GetNewPacket(entropy); err != nil {
+		return
+	}
+	this.entropyBits >>= 1
+	this.entropyBitsCount--
+	this.nextSequenceNumber++
+	return
+}
+
+// GetBytesInFlight returns the number of bytes sent and not yet acknowledged or declared lost.
+func (this *sentPacketManager) GetBytesInFlight() protocol.QuicByteCount {
+	return this.bytesInFlight
+}
+
+// OnPacketSent registers a packet sent, packets must be registered in ascending order of sequence number.
+// The packets without retransmittable frames and not protected by FEC (ACK only packets) are not in flight.
+func (this *sentPacketManager) OnPacketSent(packet *sentPacket, inFlight bool) {
+	this.largestSent = packet.seqnum
+	if !inFlight {
+		return
+	}
+	this.sendAlgorithm.OnPacketSent(packet.sentTime, this.bytesInFlight, packet.seqnum, packet.bytes, len(packet.frames) > 0)
+	this.packets = append(this.packets, packet)
+	this.bytesInFlight += packet.bytes
+	this.lastSentTime = packet.sentTime
+	if packet.handshake {
+		this.lastHandshakeSentTime = packet.sentTime
+	}
+}
+
+// OnAckFrame processes an ACK frame received at 'now' and returns the retransmittable frames of the lost packets.
+//
+// A packet is lost when 'numberOfNacksBeforeRetransmission' packets with a greater sequence number are acknowledged.
+// The packets revived by FEC on receiver side are lost for the congestion controller, but their frames are not retransmitted.
+// The other copies of a duplicate packet are neutered as soon as a copy is acknowledged, only the loss of all the copies is a loss.
+func (this *sentPacketManager) OnAckFrame(frame *protocol.QuicFrame, now time.Time) (retransmissions []*protocol.QuicFrame, err error) {
+	largest := frame.GetLargestObserved()
+	if largest > this.largestSent {
+		err = errors.New("sentPacketManager.OnAckFrame : largest observed packet never sent")
+		return
+	}
+	if largest < this.largestAcked {
+		// Reordered ACK frame
+		return
+	}
+	missing := frame.GetMissingRanges()
+	if !this.isValidEntropyHash(frame.GetEntropyHash(), largest, missing) {
+		err = errEntropyHashMismatch
+		return
+	}
+	revived := frame.GetRevivedPackets()
+	isMissing := func(seqnum protocol.QuicPacketSequenceNumber) bool {
+		for _, r := range missing {
+			if (seqnum >= r.First) && (seqnum <= r.Last) {
+				return true
+			}
+		}
+		return false
+	}
+	isRevived := func(seqnum protocol.QuicPacketSequenceNumber) bool {
+		for _, r := range revived {
+			if seqnum == r {
+				return true
+			}
+		}
+		return false
+	}
+	// The copies of a duplicate packet acknowledged by this frame make the other copies redundant
+	for _, p := range this.packets {
+		if (p.duplicates != nil) && (p.seqnum <= largest) && !isMissing(p.seqnum) {
+			p.duplicates.acked = true
+		}
+	}
+	var acked, lost []congestion.PacketInfo
+	priorInFlight := this.bytesInFlight
+	remaining := this.packets[:0]
+	for _, p := range this.packets {
+		switch {
+		case (p.seqnum <= largest) && !isMissing(p.seqnum):
+			ackDelay := time.Duration(protocol.Ufloat16ToUint64(frame.GetLargestObservedDeltaTime())) * time.Microsecond
+			if (p.seqnum == largest) && (largest > this.largestAcked) {
+				this.rttStats.UpdateRTT(now.Sub(p.sentTime), ackDelay)
+			}
+			if len(p.pings) > 0 {
+				rtt := now.Sub(p.sentTime)
+				if (p.seqnum == largest) && (rtt > ackDelay) {
+					rtt -= ackDelay
+				}
+				for _, done := range p.pings {
+					select {
+					case done <- rtt:
+					default:
+					}
+				}
+			}
+			acked = append(acked, congestion.PacketInfo{SequenceNumber: p.seqnum, Bytes: p.bytes})
+			this.bytesInFlight -= p.bytes
+			if p.duplicates != nil {
+				p.duplicates.outstanding--
+			}
+		case (p.duplicates != nil) && p.duplicates.acked:
+			this.neuter(p)
+		case p.seqnum > largest:
+			remaining = append(remaining, p)
+		case isRevived(p.seqnum):
+			lost = append(lost, congestion.PacketInfo{SequenceNumber: p.seqnum, Bytes: p.bytes})
+			this.bytesInFlight -= p.bytes
+		case largest-p.seqnum >= numberOfNacksBeforeRetransmission:
+			if (p.duplicates != nil) && p.duplicates.isRedundant() {
+				this.neuter(p)
+				break
+			}
+			lost = append(lost, congestion.PacketInfo{SequenceNumber: p.seqnum, Bytes: p.bytes})
+			this.bytesInFlight -= p.bytes
+			retransmissions = append(retransmissions, p.frames...)
+			if p.duplicates != nil {
+				p.duplicates.outstanding--
+			}
+		default:
+			remaining = append(remaining, p)
+		}
+	}
+	for i := len(remaining); i < len(this.packets); i++ {
+		this.packets[i] = nil
+	}
+	this.packets = remaining
+	this.largestAcked = largest
+	// The STOP_WAITING frames reported as missing are forgotten: the next packets carry a new one until it is acknowledged
+	i := 0
+	for ; (i < len(this.stopWaitingSent)) && (this.stopWaitingSent[i].seqnum <= largest); i++ {
+		if sw := this.stopWaitingSent[i]; !isMissing(sw.seqnum) && (sw.leastUnacked > this.leastUnackedAcked) {
+			this.leastUnackedAcked = sw.leastUnacked
+		}
+	}
+	this.stopWaitingSent = append(this.stopWaitingSent[:0], this.stopWaitingSent[i:]...)
+	// The next ACK frames can't report as missing a packet below the oldest missing packet of this frame
+	least := largest
+	if len(missing) > 0 {
+		least = missing[len(missing)-1].First
+	}
+	this.entropy.SetLargestKnownPacket(least)
+	if len(acked) > 0 {
+		this.consecutiveRTOCount = 0
+		this.consecutiveHandshakeRetransmissions = 0
+	}
+	if (len(acked) > 0) || (len(lost) > 0) {
+		this.sendAlgorithm.OnCongestionEvent(now, priorInFlight, acked, lost)
+	}
+	return
+}
+
+// isValidEntropyHash returns true if 'hash' is the cumulative entropy hash of the packets up to 'largest' that are not in the missing ranges.
+func (this *sentPacketManager) isValidEntropyHash(hash protocol.QuicEntropyHash, largest protocol.QuicPacketSequenceNumber, missing []protocol.QuicPacketRange) bool {
+	expected, err := this.entropy.GetCumulativeEntropyHash(largest + 1)
+	if err != nil {
+		return false
+	}
+	for _, r := range missing {
+		h, err := this.entropy.GetCumulativeEntropyHashFromTo(r.First, r.Last)
+		if err != nil {
+			return false
+		}
+		expected ^= h
+	}
+	return hash == expected
+}
+
+// HasStreamFrames returns true if a STREAM or RST_STREAM frame of the stream is in flight.
+func (this *sentPacketManager) HasStreamFrames(id protocol.QuicStreamID) bool {
+	for _, p := range this.packets {
+		if hasStreamFrame(p.frames, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStreamFrame returns true if one of the frames is a STREAM or RST_STREAM frame of the stream.
+func hasStreamFrame(frames []*protocol.QuicFrame, id protocol.QuicStreamID) bool {
+	for _, frame := range frames {
+		if t := frame.GetFrameType(); ((t == protocol.QUICFRAMETYPE_STREAM) || (t == protocol.QUICFRAMETYPE_RST_STREAM)) && (frame.GetStreamID() == id) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLeastUnacked returns the sequence number of the oldest packet in flight, or of the next packet if there is no packet in flight.
+func (this *sentPacketManager) GetLeastUnacked() protocol.QuicPacketSequenceNumber {
+	if len(this.packets) > 0 {
+		return this.packets[0].seqnum
+	}
+	return this.nextSequenceNumber
+}
+
+// IsStopWaitingDue returns true if the least unacked packet advanced since the last STOP_WAITING frame acknowledged by the peer:
+// a STOP_WAITING frame is sent in each packet until one of them is acknowledged, as a lost STOP_WAITING frame is not retransmitted.
+func (this *sentPacketManager) IsStopWaitingDue() bool {
+	return this.GetLeastUnacked() > this.leastUnackedAcked
+}
+
+// GetStopWaitingFrame returns the STOP_WAITING frame to send in the packet 'seqnum',
+// its Sent Entropy is the cumulative entropy hash of the packets before the least unacked packet.
+func (this *sentPacketManager) GetStopWaitingFrame(seqnum protocol.QuicPacketSequenceNumber) (*protocol.QuicFrame, error) {
+	least := this.GetLeastUnacked()
+	hash, err := this.entropy.GetCumulativeEntropyHash(least)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(this.stopWaitingSent); (n > 0) && (this.stopWaitingSent[n-1].seqnum == seqnum) {
+		this.stopWaitingSent = this.stopWaitingSent[:n-1]
+	}
+	this.stopWaitingSent = append(this.stopWaitingSent, stopWaitingSent{seqnum: seqnum, leastUnacked: least})
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_STOP_WAITING)
+	frame.SetLeastUnackedDeltaByteSize(sequenceNumberSize)
+	frame.SetEntropyHash(hash)
+	frame.SetLeastUnackedDelta(seqnum - least)
+	return frame, nil
+}
+
+// neuter removes a redundant copy of a duplicate packet from the bytes in flight, without loss signal nor retransmission.
+func (this *sentPacketManager) neuter(p *sentPacket) {
+	this.bytesInFlight -= p.bytes
+	p.duplicates.outstanding--
+	this.sendAlgorithm.OnPacketNeutered(p.seqnum)
+}
+
+// hasHandshakePackets returns true if handshake packets are in flight.
+func (this *sentPacketManager) hasHandshakePackets() bool {
+	for _, p := range this.packets {
+		if p.handshake {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRetransmissionTime returns the time of the retransmission timeout, or zero time if there is no packet in flight.
+//
+// In handshake mode (handshake packets in flight), the timeout is max(minHandshakeRetransmissionTime, 1.5*SRTT) after the last handshake packet sent,
+// doubled for each consecutive handshake retransmission: the handshake messages are acknowledged without delay.
+func (this *sentPacketManager) GetRetransmissionTime() time.Time {
+	if len(this.packets) == 0 {
+		return time.Time{}
+	}
+	if this.hasHandshakePackets() {
+		delay := this.rttStats.GetSmoothedRTT() * 3 / 2
+		if delay < minHandshakeRetransmissionTime {
+			delay = minHandshakeRetransmissionTime
+		}
+		backoff := this.consecutiveHandshakeRetransmissions
+		if backoff > maxRetransmissionBackoff {
+			backoff = maxRetransmissionBackoff
+		}
+		return this.lastHandshakeSentTime.Add(delay << backoff)
+	}
+	delay := defaultRetransmissionTime
+	if this.rttStats.HasMeasurement() {
+		delay = this.rttStats.GetSmoothedRTT() + 4*this.rttStats.GetMeanDeviation()
+		if delay < minRetransmissionTime {
+			delay = minRetransmissionTime
+		}
+	}
+	backoff := this.consecutiveRTOCount
+	if backoff > maxRetransmissionBackoff {
+		backoff = maxRetransmissionBackoff
+	}
+	delay <<= backoff
+	if delay > maxRetransmissionTime {
+		delay = maxRetransmissionTime
+	}
+	return this.lastSentTime.Add(delay)
+}
+
+// OnRetransmissionTimeout declares lost all the packets in flight and returns their retransmittable frames.
+// The frames of a duplicate packet are retransmitted once, when no other copy is outstanding.
+//
+// In handshake mode, only the handshake packets are retransmitted: they leave the bytes in flight without congestion signal.
+func (this *sentPacketManager) OnRetransmissionTimeout() (retransmissions []*protocol.QuicFrame) {
+	if this.hasHandshakePackets() {
+		remaining := this.packets[:0]
+		for _, p := range this.packets {
+			if !p.handshake {
+				remaining = append(remaining, p)
+				continue
+			}
+			retransmissions = append(retransmissions, p.frames...)
+			this.bytesInFlight -= p.bytes
+			this.sendAlgorithm.OnPacketNeutered(p.seqnum)
+		}
+		for i := len(remaining); i < len(this.packets); i++ {
+			this.packets[i] = nil
+		}
+		this.packets = remaining
+		this.consecutiveHandshakeRetransmissions++
+		return
+	}
+	for _, p := range this.packets {
+		if p.duplicates != nil {
+			redundant := p.duplicates.isRedundant()
+			p.duplicates.outstanding--
+			if redundant {
+				continue
+			}
+		}
+		retransmissions = append(retransmissions, p.frames...)
+	}
+	this.packets = nil
+	this.bytesInFlight = 0
+	this.consecutiveRTOCount++
+	this.sendAlgorithm.OnRetransmissionTimeout(len(retransmissions) > 0)
+	return
+}
@@ -0,0 +1,186 @@
+package quic
+
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/congestion"
+import "github.com/romain-jacotin/quic/protocol"
+
+func Test_sentPacketManager_OnAckFrame_EntropyHash(t *testing.T) {
+	now := time.Now()
+	rttStats := congestion.NewRTTStats()
+	sender := newSentPacketManager(rttStats, congestion.NewCubicSender(rttStats, congestion.DefaultInitialCongestionWindow, congestion.DefaultMaxCongestionWindow))
+	receiver := newReceivedPacketManager()
+
+	// Packets 4 and 9 are lost
+	entropy := make(map[protocol.QuicPacketSequenceNumber]bool)
+	for i := 0; i < 10; i++ {
+		seqnum, flag, err := sender.GetNewPacket()
+		if err != nil {
+			t.Fatalf("sentPacketManager.GetNewPacket : %v", err)
+		}
+		entropy[seqnum] = flag
+		frame := new(protocol.QuicFrame)
+		frame.SetFrameType(protocol.QUICFRAMETYPE_PING)
+		sender.OnPacketSent(&sentPacket{seqnum: seqnum, sentTime: now, bytes: 100, frames: []*protocol.QuicFrame{frame}}, true)
+		if (seqnum != 4) && (seqnum != 9) {
+			receiver.OnPacketReceived(seqnum, flag, now, true)
+		}
+	}
+	ack := receiver.GetAckFrame(now)
+
+	// A forged ACK frame that acknowledges the lost packet 4 has the wrong entropy hash if the flag of the packet is not guessed
+	forged := new(protocol.QuicFrame)
+	forged.SetFrameType(protocol.QUICFRAMETYPE_ACK)
+	forged.SetLargestObserved(10)
+	forged.AddMissingRange(protocol.QuicPacketRange{First: 9, Last: 9})
+	forged.SetEntropyHash(ack.GetEntropyHash() ^ protocol.GetPacketEntropyHash(4, !entropy[4]))
+	if _, err := sender.OnAckFrame(forged, now); err != errEntropyHashMismatch {
+		t.Errorf("sentPacketManager.OnAckFrame : invalid error %v for a forged ACK frame", err)
+	}
+	if sender.GetBytesInFlight() != 1000 {
+		t.Errorf("sentPacketManager.OnAckFrame : forged ACK frame must be ignored")
+	}
+	// Valid ACK frame
+	if _, err := sender.OnAckFrame(ack, now); err != nil {
+		t.Errorf("sentPacketManager.OnAckFrame : %v", err)
+	}
+	if least := sender.GetLeastUnacked(); least != 9 {
+		t.Errorf("sentPacketManager.GetLeastUnacked : invalid least unacked %v (9 expected)", least)
+	}
+	// The Sent Entropy of the STOP_WAITING frame is the cumulative entropy hash of the packets before the least unacked
+	var expected protocol.QuicEntropyHash
+	for seqnum := protocol.QuicPacketSequenceNumber(1); seqnum < 9; seqnum++ {
+		expected ^= protocol.GetPacketEntropyHash(seqnum, entropy[seqnum])
+	}
+	frame, err := sender.GetStopWaitingFrame(11)
+	if err != nil {
+		t.Fatalf("sentPacketManager.GetStopWaitingFrame : %v", err)
+	}
+	if frame.GetEntropyHash() != expected {
+		t.Errorf("sentPacketManager.GetStopWaitingFrame : invalid sent entropy 0x%02x (0x%02x expected)", frame.GetEntropyHash(), expected)
+	}
+	if frame.GetLeastUnackedDelta() != 2 {
+		t.Errorf("sentPacketManager.GetStopWaitingFrame : invalid least unacked delta %v (2 expected)", frame.GetLeastUnackedDelta())
+	}
+	if !sender.IsStopWaitingDue() {
+		t.Error("sentPacketManager.IsStopWaitingDue : STOP_WAITING frame must be sent until it is acknowledged")
+	}
+	// The receiver of the STOP_WAITING frame no longer reports the lost packet 4 as missing, and its entropy hash stays valid
+	receiver.OnStopWaiting(11-frame.GetLeastUnackedDelta(), frame.GetEntropyHash())
+	for i := 0; i < 2; i++ {
+		seqnum, flag, _ := sender.GetNewPacket()
+		sender.OnPacketSent(&sentPacket{seqnum: seqnum, sentTime: now, bytes: 100}, false)
+		receiver.OnPacketReceived(seqnum, flag, now, false)
+	}
+	ack = receiver.GetAckFrame(now)
+	if ranges := ack.GetMissingRanges(); (len(ranges) != 1) || (ranges[0] != protocol.QuicPacketRange{First: 9, Last: 9}) {
+		t.Errorf("receivedPacketManager.GetAckFrame : invalid missing ranges %v after the STOP_WAITING frame", ranges)
+	}
+	if _, err = sender.OnAckFrame(ack, now); err != nil {
+		t.Errorf("sentPacketManager.OnAckFrame : %v after the STOP_WAITING frame", err)
+	}
+	if sender.leastUnackedAcked != 9 {
+		t.Errorf("sentPacketManager.OnAckFrame : invalid least unacked %v of the acknowledged STOP_WAITING frame (9 expected)", sender.leastUnackedAcked)
+	}
+	if !sender.IsStopWaitingDue() {
+		t.Error("sentPacketManager.IsStopWaitingDue : the least unacked packet advanced after the loss of packet 9")
+	}
+	// A valid ACK frame with a wrong entropy hash
+	ack.SetEntropyHash(ack.GetEntropyHash() ^ 0x01)
+	if _, err = sender.OnAckFrame(ack, now); err != errEntropyHashMismatch {
+		t.Errorf("sentPacketManager.OnAckFrame : invalid error %v for a wrong entropy hash", err)
+	}
+}
+
+func Test_sentPacketManager_IsStopWaitingDue_Lost(t *testing.T) {
+	now := time.Now()
+	rttStats := congestion.NewRTTStats()
+	sender := newSentPacketManager(rttStats, congestion.NewCubicSender(rttStats, congestion.DefaultInitialCongestionWindow, congestion.DefaultMaxCongestionWindow))
+	receiver := newReceivedPacketManager()
+
+	// The packets 1 to 4 are acknowledged, then the STOP_WAITING frame is sent in the packets 5 and 6
+	send := func(received bool) protocol.QuicPacketSequenceNumber {
+		seqnum, flag, _ := sender.GetNewPacket()
+		frame := new(protocol.QuicFrame)
+		frame.SetFrameType(protocol.QUICFRAMETYPE_PING)
+		sender.OnPacketSent(&sentPacket{seqnum: seqnum, sentTime: now, bytes: 100, frames: []*protocol.QuicFrame{frame}}, true)
+		if received {
+			receiver.OnPacketReceived(seqnum, flag, now, true)
+		}
+		return seqnum
+	}
+	for i := 0; i < 4; i++ {
+		send(true)
+	}
+	if _, err := sender.OnAckFrame(receiver.GetAckFrame(now), now); err != nil {
+		t.Fatalf("sentPacketManager.OnAckFrame : %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if !sender.IsStopWaitingDue() {
+			t.Fatalf("sentPacketManager.IsStopWaitingDue : STOP_WAITING frame must be sent in the packet n°%v", sender.GetNextSequenceNumber())
+		}
+		if _, err := sender.GetStopWaitingFrame(sender.GetNextSequenceNumber()); err != nil {
+			t.Fatalf("sentPacketManager.GetStopWaitingFrame : %v", err)
+		}
+		send(false)
+	}
+	// The packets 5 and 6 carrying the STOP_WAITING frame are lost: it must be sent again
+	send(true)
+	if _, err := sender.OnAckFrame(receiver.GetAckFrame(now), now); err != nil {
+		t.Fatalf("sentPacketManager.OnAckFrame : %v", err)
+	}
+	if !sender.IsStopWaitingDue() {
+		t.Error("sentPacketManager.IsStopWaitingDue : STOP_WAITING frame must be sent again after the loss of its packets")
+	}
+	// Acknowledged in the packet 8
+	frame, _ := sender.GetStopWaitingFrame(sender.GetNextSequenceNumber())
+	send(true)
+	if _, err := sender.OnAckFrame(receiver.GetAckFrame(now), now); err != nil {
+		t.Fatalf("sentPacketManager.OnAckFrame : %v", err)
+	}
+	if least := 8 - frame.GetLeastUnackedDelta(); sender.leastUnackedAcked != least {
+		t.Errorf("sentPacketManager.OnAckFrame : invalid least unacked %v of the acknowledged STOP_WAITING frame (%v expected)", sender.leastUnackedAcked, least)
+	}
+	if len(sender.stopWaitingSent) != 0 {
+		t.Errorf("sentPacketManager.OnAckFrame : %v STOP_WAITING frames still tracked", len(sender.stopWaitingSent))
+	}
+}
+
+func Test_sentPacketManager_OnRetransmissionTimeout_Handshake(t *testing.T) {
+	now := time.Now()
+	rttStats := congestion.NewRTTStats()
+	sender := newSentPacketManager(rttStats, congestion.NewCubicSender(rttStats, congestion.DefaultInitialCongestionWindow, congestion.DefaultMaxCongestionWindow))
+
+	// A handshake packet followed by a standard packet
+	for i := 0; i < 2; i++ {
+		seqnum, _, _ := sender.GetNewPacket()
+		frame := new(protocol.QuicFrame)
+		frame.SetFrameType(protocol.QUICFRAMETYPE_STREAM)
+		frame.SetStreamID(protocol.QuicStreamID(cryptoStreamID + 2*i))
+		frame.SetData([]byte{1})
+		sender.OnPacketSent(&sentPacket{seqnum: seqnum, sentTime: now, bytes: 100, frames: []*protocol.QuicFrame{frame}, handshake: i == 0}, true)
+	}
+	// The handshake retransmission timeout is 1.5 times the initial RTT, instead of the default retransmission timeout
+	if rto := sender.GetRetransmissionTime().Sub(now); rto != congestion.DefaultInitialRTT*3/2 {
+		t.Errorf("sentPacketManager.GetRetransmissionTime : invalid handshake timeout %v", rto)
+	}
+	// Only the handshake packet is retransmitted
+	frames := sender.OnRetransmissionTimeout()
+	if (len(frames) != 1) || (frames[0].GetStreamID() != cryptoStreamID) {
+		t.Errorf("sentPacketManager.OnRetransmissionTimeout : %v frames retransmitted in handshake mode", len(frames))
+	}
+	if sender.GetBytesInFlight() != 100 {
+		t.Errorf("sentPacketManager.OnRetransmissionTimeout : %v bytes in flight (100 expected)", sender.GetBytesInFlight())
+	}
+	// The handshake timeout is doubled for the next retransmission
+	seqnum, _, _ := sender.GetNewPacket()
+	sender.OnPacketSent(&sentPacket{seqnum: seqnum, sentTime: now, bytes: 100, frames: frames, handshake: true}, true)
+	if rto := sender.GetRetransmissionTime().Sub(now); rto != congestion.DefaultInitialRTT*3 {
+		t.Errorf("sentPacketManager.GetRetransmissionTime : invalid handshake timeout %v after a retransmission", rto)
+	}
+	// Standard retransmission timeout once no handshake packet is in flight
+	sender.OnRetransmissionTimeout()
+	if rto := sender.GetRetransmissionTime().Sub(now); rto != defaultRetransmissionTime {
+		t.Errorf("sentPacketManager.GetRetransmissionTime : invalid timeout %v without handshake packet", rto)
+	}
+}
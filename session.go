@@ -0,0 +1,1072 @@
+package quic
+
+import "net"
+import "sort"
+import "sync"
+import "time"
+import "github.com/romain-jacotin/quic/crypto"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// MaxPacketSize is the maximum size of the QUIC packets sent on the UDP socket
+	MaxPacketSize = 1350
+	// maxReceivedPacketSize is the size of the buffer used to read the UDP packets
+	maxReceivedPacketSize = 1500
+	// connectionIDSize is the size in bytes of the Connection ID sent in the public header
+	connectionIDSize = 8
+	// sequenceNumberSize is the size in bytes of the Sequence Number sent in the public header
+	sequenceNumberSize = 6
+	// initialConnectionFlowControlWindow is the flow control receive window of a session in bytes
+	initialConnectionFlowControlWindow = 384 * 1024
+	// maxIncomingPackets is the number of received packets queued before the session drops them
+	maxIncomingPackets = 1024
+	// cryptoStreamID is the reserved Stream ID of the crypto handshake
+	cryptoStreamID = 1
+	// DefaultIdleTimeout is the default idle connection state lifetime proposed to the peer
+	DefaultIdleTimeout = 30 * time.Second
+	// MaxIdleTimeout is the maximum idle connection state lifetime
+	MaxIdleTimeout = 600 * time.Second
+	// DefaultKeepAlivePeriod is the default duration without network activity before a keep-alive PING frame
+	DefaultKeepAlivePeriod = 15 * time.Second
+	// DefaultMaxStreams is the default maximum number of open streams in each direction proposed to the peer
+	DefaultMaxStreams = 100
+	// maxStreamsMultiplier and maxStreamsMinimumIncrement give the number of streams opened by the peer above the maximum before the new streams are refused,
+	// so that a stream finished by the peer but not yet collected locally doesn't refuse the next stream of the peer
+	maxStreamsMultiplier       = 1.1
+	maxStreamsMinimumIncrement = 10
+)
+
+// newQUICSession is a QUICSession factory that starts the event loop of the session, 'onClose' is called by the event loop once the session is closed.
+func newQUICSession(conn packetConn, laddr, raddr net.Addr, connectionID protocol.QuicConnectionID, isClient bool, config *Config, onClose func()) *QUICSession {
+	var options []protocol.MessageTag
+
+	if config != nil {
+		options = config.ConnectionOptions
+	}
+	s := &QUICSession{
+		conn:              conn,
+		laddr:             laddr,
+		raddr:             raddr,
+		connectionID:      connectionID,
+		isClient:          isClient,
+		aead:              crypto.NewAEAD_NullFNV1A128(),
+		receivedPackets:   newReceivedPacketManager(),
+		streams:           make(map[protocol.QuicStreamID]*StreamConn),
+		sendWindow:        initialConnectionFlowControlWindow,
+		receiveWindow:     initialConnectionFlowControlWindow,
+		receiveWindowSize: initialConnectionFlowControlWindow,
+		fecGroupSize:      DefaultFECGroupSize,
+		duplicateCount:    DefaultDuplicateCount,
+		idleTimeout:       DefaultIdleTimeout,
+		keepAlivePeriod:   DefaultKeepAlivePeriod,
+		maxStreams:        DefaultMaxStreams,
+		pings:             make(map[*protocol.QuicFrame]chan time.Duration),
+		lastActivity:      time.Now(),
+		handshakeStart:    time.Now(),
+		fecGroups:         make(map[protocol.QuicPacketSequenceNumber]*fecGroup),
+		incoming:          make(chan []byte, maxIncomingPackets),
+		sendSignal:        make(chan struct{}, 1),
+		closing:           make(chan struct{}),
+		onClose:           onClose}
+	s.cond = sync.NewCond(&s.mutex)
+	s.crypto = newCryptoStream(s)
+	if isClient {
+		s.nextStreamID = 3
+	} else {
+		s.nextStreamID = 2
+	}
+	s.setConnectionOptions(options)
+	s.writer = newPacketWriter(conn, raddr, s.pacer)
+	s.sentPackets = newSentPacketManager(s.rttStats, s.sendAlgorithm)
+	if isClient {
+		// The client starts the handshake
+		chlo := protocol.CHLO{}
+		chlo.ICSL, chlo.MSPC, chlo.SCLS = s.newTransportParameters()
+		chlo.CGST = supportedCongestionFeedback
+		chlo.COPT = options
+		s.sendHandshakeMessage(chlo.Marshal())
+	}
+	go s.run()
+	return s
+}
+
+// receive queues a packet received on the UDP socket for the event loop, the packet is dropped if the queue is full.
+func (s *QUICSession) receive(data []byte) {
+	select {
+	case s.incoming <- data:
+	case <-s.closing:
+	default:
+	}
+}
+
+// signal wakes up the event loop to send packets.
+func (s *QUICSession) signal() {
+	select {
+	case s.sendSignal <- struct{}{}:
+	default:
+	}
+}
+
+// run is the event loop of the session: it processes the received packets and the timers, and sends the packets until the session is closed.
+func (s *QUICSession) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		select {
+		case data := <-s.incoming:
+			s.mutex.Lock()
+			s.handlePacket(data, time.Now())
+			// Process all the packets already received before sending
+			for more := true; more && !s.closed; {
+				select {
+				case data = <-s.incoming:
+					s.handlePacket(data, time.Now())
+				default:
+					more = false
+				}
+			}
+			s.mutex.Unlock()
+		case <-s.sendSignal:
+		case <-timer.C:
+			s.mutex.Lock()
+			s.onTimer(time.Now())
+			s.mutex.Unlock()
+		case <-s.closing:
+			if s.onClose != nil {
+				s.onClose()
+			}
+			return
+		}
+		s.mutex.Lock()
+		var deadline time.Time
+		if !s.closed {
+			s.sendPackets(time.Now())
+			deadline = s.nextAlarm()
+		}
+		if !s.closed {
+			s.collectStreams()
+		}
+		if !s.closed && s.isDrained() {
+			s.closeWithError(s.goAway, true)
+		}
+		s.mutex.Unlock()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if deadline.IsZero() {
+			timer.Reset(time.Hour)
+		} else {
+			timer.Reset(time.Until(deadline))
+		}
+	}
+}
+
+// nextAlarm returns the earliest time at which the event loop must wake up, or zero time.
+func (s *QUICSession) nextAlarm() (alarm time.Time) {
+	for _, t := range []time.Time{s.receivedPackets.GetAckAlarm(), s.sentPackets.GetRetransmissionTime(), s.getFECAlarm(), s.getDuplicateAlarm(), s.getIdleAlarm(), s.getKeepAliveAlarm(), s.getHandshakeAlarm(), s.sendAlarm} {
+		if !t.IsZero() && (alarm.IsZero() || t.Before(alarm)) {
+			alarm = t
+		}
+	}
+	return
+}
+
+// getIdleTimeout returns the idle timeout negotiated with the peer: the smallest of both proposals, and at most handshakeIdleTimeout during the handshake.
+func (s *QUICSession) getIdleTimeout() time.Duration {
+	timeout := s.idleTimeout
+	if (s.peerIdleTimeout > 0) && (s.peerIdleTimeout < timeout) {
+		timeout = s.peerIdleTimeout
+	}
+	if !s.handshakeComplete && (handshakeIdleTimeout < timeout) {
+		timeout = handshakeIdleTimeout
+	}
+	return timeout
+}
+
+// getIdleAlarm returns the time at which the session is closed if there is no network activity.
+func (s *QUICSession) getIdleAlarm() time.Time {
+	return s.lastActivity.Add(s.getIdleTimeout())
+}
+
+// getKeepAliveAlarm returns the time at which a keep-alive PING frame must be sent: after the keep-alive period without network activity nor PING frame.
+// It returns zero time if the keep-alive is disabled.
+func (s *QUICSession) getKeepAliveAlarm() time.Time {
+	if !s.keepAlive {
+		return time.Time{}
+	}
+	last := s.lastActivity
+	if s.lastPing.After(last) {
+		last = s.lastPing
+	}
+	return last.Add(s.keepAlivePeriod)
+}
+
+// onTimer processes the expired handshake, idle, keep-alive and retransmission alarms, the other alarms are processed by sendPackets.
+// On idle timeout, the session is closed without CONNECTION_CLOSE frame if a silent close is requested by one of the peers.
+func (s *QUICSession) onTimer(now time.Time) {
+	if t := s.getHandshakeAlarm(); !t.IsZero() && !now.Before(t) {
+		s.connectionError(protocol.QUIC_CONNECTION_OVERALL_TIMED_OUT, "handshake timeout")
+		return
+	}
+	if !now.Before(s.getIdleAlarm()) {
+		s.closeWithError(protocol.NewQuicError(protocol.QUIC_CONNECTION_TIMED_OUT, "no network activity"), !s.silentClose && !s.peerSilentClose)
+		return
+	}
+	if t := s.getKeepAliveAlarm(); !t.IsZero() && !now.Before(t) {
+		frame := new(protocol.QuicFrame)
+		frame.SetFrameType(protocol.QUICFRAMETYPE_PING)
+		s.controlFrames = append(s.controlFrames, frame)
+		s.lastPing = now
+	}
+	if t := s.sentPackets.GetRetransmissionTime(); !t.IsZero() && !now.Before(t) {
+		s.retransmissions = append(s.retransmissions, s.sentPackets.OnRetransmissionTimeout()...)
+	}
+}
+
+// closeWithError closes the session with the error returned by the next calls, a CONNECTION_CLOSE frame is sent to the peer if 'sendClose' is true.
+func (s *QUICSession) closeWithError(err *protocol.QuicError, sendClose bool) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.closeErr = err
+	if sendClose {
+		frame := new(protocol.QuicFrame)
+		frame.SetFrameType(protocol.QUICFRAMETYPE_CONNECTION_CLOSE)
+		frame.SetErrorCode(err.ErrorCode)
+		frame.SetReasonPhrase(err.ReasonPhrase)
+		s.writeFrames(time.Now(), []*protocol.QuicFrame{frame}, nil)
+	}
+	s.cond.Broadcast()
+	close(s.closing)
+}
+
+// connectionError closes the session after a protocol violation of the peer.
+func (s *QUICSession) connectionError(code protocol.QuicErrorCode, reason string) {
+	s.closeWithError(protocol.NewQuicError(code, reason), true)
+}
+
+// handlePacket processes a packet received at 'now'.
+func (s *QUICSession) handlePacket(data []byte, now time.Time) {
+	var publicHeader protocol.QuicPublicHeader
+	var privateHeader protocol.QuicPrivateHeader
+
+	n, err := publicHeader.ParseData(data)
+	if err != nil {
+		return
+	}
+	if publicHeader.GetPublicResetFlag() {
+		s.closeWithError(&protocol.QuicError{ErrorCode: protocol.QUIC_PUBLIC_RESET, Remote: true}, false)
+		return
+	}
+	seqnum := publicHeader.GetSequenceNumber()
+	if s.receivedPackets.IsDuplicate(seqnum) {
+		return
+	}
+	plaintext := make([]byte, len(data)-n)
+	l, err := s.aead.Open(seqnum, plaintext, data[:n], data[n:])
+	if err != nil {
+		// Undecryptable packets are dropped
+		return
+	}
+	plaintext = plaintext[:l]
+	s.lastActivity = now
+	s.activitySent = false
+	m, err := privateHeader.ParseData(plaintext)
+	if err != nil {
+		s.connectionError(protocol.QUIC_INVALID_PACKET_HEADER, err.Error())
+		return
+	}
+	payload := plaintext[m:]
+	offset, _ := privateHeader.GetFecGroupNumberOffset()
+	if protocol.QuicPacketSequenceNumber(offset) >= seqnum {
+		// The first packet of the FEC group would be before the first packet of the session
+		s.connectionError(protocol.QUIC_INVALID_FEC_DATA, "FEC group offset before the first packet")
+		return
+	}
+	if privateHeader.GetFecPacketFlag() {
+		if offset == 0 {
+			s.connectionError(protocol.QUIC_INVALID_FEC_DATA, "FEC packet without protected packet")
+			return
+		}
+		if err = s.receivedPackets.OnPacketReceived(seqnum, privateHeader.GetEntropyFlag(), now, true); err != nil {
+			s.connectionError(protocol.QUIC_TOO_MANY_OUTSTANDING_RECEIVED_PACKETS, err.Error())
+			return
+		}
+		if g := s.getFECGroup(seqnum, offset, now); g != nil {
+			g.OnFECPacket(seqnum, payload)
+			s.updateFECGroup(g, now)
+		}
+		return
+	}
+	retransmittable, handshake := s.processFrames(seqnum, payload, now)
+	if s.closed {
+		return
+	}
+	if err = s.receivedPackets.OnPacketReceived(seqnum, privateHeader.GetEntropyFlag(), now, retransmittable); err != nil {
+		s.connectionError(protocol.QUIC_TOO_MANY_OUTSTANDING_RECEIVED_PACKETS, err.Error())
+		return
+	}
+	if handshake {
+		s.receivedPackets.SetAckDue(now)
+	}
+	if privateHeader.GetFecGroupFlag() {
+		if g := s.getFECGroup(seqnum, offset, now); (g != nil) && g.OnProtectedPacket(seqnum, payload) {
+			s.updateFECGroup(g, now)
+		}
+	}
+}
+
+// getFECGroup returns the FEC group of a received packet, or nil if the group has been forgotten.
+// The 'offset' of the packet in its group must not be before the first packet of the session.
+func (s *QUICSession) getFECGroup(seqnum protocol.QuicPacketSequenceNumber, offset protocol.QuicFecGroupNumberOffset, now time.Time) *fecGroup {
+	first := seqnum - protocol.QuicPacketSequenceNumber(offset)
+	if g, ok := s.fecGroups[first]; ok {
+		return g
+	}
+	if first+maxFECGroupDistance < s.receivedPackets.largestObserved {
+		return nil
+	}
+	// Forget the incomplete groups that can no longer be revived
+	for k := range s.fecGroups {
+		if k+maxFECGroupDistance < seqnum {
+			delete(s.fecGroups, k)
+		}
+	}
+	g := newFECGroup(first, now)
+	s.fecGroups[first] = g
+	return g
+}
+
+// updateFECGroup revives the missing packet of a FEC group if possible, complete groups are forgotten.
+func (s *QUICSession) updateFECGroup(g *fecGroup, now time.Time) {
+	if g.CanRevive() {
+		seqnum, payload := g.Revive()
+		if s.receivedPackets.IsDuplicate(seqnum) {
+			// The packet has been received but is not part of the group: the FEC data is inconsistent
+			s.connectionError(protocol.QUIC_INVALID_FEC_DATA, "inconsistent FEC group")
+			return
+		}
+		s.stats.packetsRevived++
+		s.receivedPackets.OnPacketRevived(seqnum, now)
+		s.processFrames(seqnum, payload, now)
+	}
+	if g.IsComplete() {
+		delete(s.fecGroups, g.first)
+	}
+}
+
+// processFrames processes the frames of the payload of the packet 'seqnum' and returns true if the packet contains retransmittable frames,
+// 'handshake' is true if the packet contains data of the crypto stream.
+func (s *QUICSession) processFrames(seqnum protocol.QuicPacketSequenceNumber, payload []byte, now time.Time) (retransmittable, handshake bool) {
+	for (len(payload) > 0) && !s.closed {
+		frame := new(protocol.QuicFrame)
+		frame.SetLeastUnackedDeltaByteSize(sequenceNumberSize)
+		n, err := frame.ParseData(payload)
+		if e, ok := err.(*protocol.QuicError); ok {
+			s.closeWithError(e, true)
+			return
+		} else if err != nil {
+			s.connectionError(protocol.QUIC_INVALID_FRAME_DATA, err.Error())
+			return
+		}
+		payload = payload[n:]
+		switch frame.GetFrameType() {
+		case protocol.QUICFRAMETYPE_STREAM:
+			retransmittable = true
+			if frame.GetStreamID() == cryptoStreamID {
+				handshake = true
+				s.onCryptoStreamFrame(frame)
+				break
+			}
+			s.onStreamFrame(frame)
+		case protocol.QUICFRAMETYPE_ACK:
+			retransmissions, err := s.sentPackets.OnAckFrame(frame, now)
+			if err == errEntropyHashMismatch {
+				s.connectionError(protocol.QUIC_INVALID_ENTROPY_HASH, err.Error())
+				return
+			} else if err != nil {
+				s.connectionError(protocol.QUIC_INVALID_ACK_DATA, err.Error())
+				return
+			}
+			s.retransmissions = append(s.retransmissions, retransmissions...)
+		case protocol.QUICFRAMETYPE_CONGESTION_FEEDBACK:
+			s.sendAlgorithm.OnIncomingCongestionFeedback(frame, now)
+		case protocol.QUICFRAMETYPE_STOP_WAITING:
+			if delta := frame.GetLeastUnackedDelta(); delta < seqnum {
+				s.receivedPackets.OnStopWaiting(seqnum-delta, frame.GetEntropyHash())
+			} else {
+				s.connectionError(protocol.QUIC_INVALID_STOP_WAITING_DATA, "least unacked packet before the first packet")
+				return
+			}
+		case protocol.QUICFRAMETYPE_CONNECTION_CLOSE:
+			s.closeWithError(frame.GetQuicError(), false)
+		case protocol.QUICFRAMETYPE_WINDOW_UPDATE:
+			retransmittable = true
+			s.onWindowUpdateFrame(frame)
+		case protocol.QUICFRAMETYPE_GOAWAY:
+			retransmittable = true
+			s.onGoAwayFrame(frame)
+		case protocol.QUICFRAMETYPE_RST_STREAM:
+			retransmittable = true
+			s.onRstStreamFrame(frame)
+		case protocol.QUICFRAMETYPE_PADDING:
+		default:
+			retransmittable = true
+		}
+	}
+	return
+}
+
+// isPeerStream returns true if the Stream ID is of a stream initiated by the peer (even Stream IDs are initiated by the server).
+func (s *QUICSession) isPeerStream(id protocol.QuicStreamID) bool {
+	return ((id & 1) == 0) == s.isClient
+}
+
+// getMaxStreams returns the maximum number of open streams in each direction negotiated with the peer: the smallest of both proposals.
+func (s *QUICSession) getMaxStreams() int {
+	if (s.peerMaxStreams > 0) && (s.peerMaxStreams < s.maxStreams) {
+		return s.peerMaxStreams
+	}
+	return s.maxStreams
+}
+
+// getMaxIncomingStreams returns the number of open streams initiated by the peer above which the new streams of the peer are refused.
+func (s *QUICSession) getMaxIncomingStreams() int {
+	limit := s.getMaxStreams()
+	if n := int(float64(limit) * maxStreamsMultiplier); n > limit+maxStreamsMinimumIncrement {
+		return n
+	}
+	return limit + maxStreamsMinimumIncrement
+}
+
+// countStreams returns the number of open streams initiated by the peer if 'peer' is true, or initiated locally.
+func (s *QUICSession) countStreams(peer bool) (n int) {
+	for id := range s.streams {
+		if s.isPeerStream(id) == peer {
+			n++
+		}
+	}
+	return
+}
+
+// addStream registers a new stream of the session.
+func (s *QUICSession) addStream(id protocol.QuicStreamID) *StreamConn {
+	c := newStreamConn(s, id)
+	s.streams[id] = c
+	s.streamIDs = append(s.streamIDs, id)
+	sort.Slice(s.streamIDs, func(i, j int) bool { return s.streamIDs[i] < s.streamIDs[j] })
+	return c
+}
+
+// removeStream forgets a stream of the session.
+func (s *QUICSession) removeStream(id protocol.QuicStreamID) {
+	delete(s.streams, id)
+	i := sort.Search(len(s.streamIDs), func(i int) bool { return s.streamIDs[i] >= id })
+	if (i < len(s.streamIDs)) && (s.streamIDs[i] == id) {
+		s.streamIDs = append(s.streamIDs[:i], s.streamIDs[i+1:]...)
+	}
+	// Streams blocked by the maximum number of open streams
+	s.cond.Broadcast()
+}
+
+// onStreamFrame delivers the data of a STREAM frame to its stream, a new stream is opened for the first frame of a stream initiated by the peer.
+func (s *QUICSession) onStreamFrame(frame *protocol.QuicFrame) {
+	id := frame.GetStreamID()
+	c, ok := s.streams[id]
+	if !ok {
+		if !s.isPeerStream(id) || (id <= s.largestPeerStreamID) || (s.goAway != nil) {
+			// Data of a closed stream, or of a new stream refused after the GOAWAY frame
+			return
+		}
+		s.largestPeerStreamID = id
+		refused := s.countStreams(true) >= s.getMaxIncomingStreams()
+		c = s.addStream(id)
+		if refused {
+			// The stream is reset, so that the peer credits its connection flow control window with the data of the stream
+			s.resetStream(c, &StreamResetError{StreamID: id, Err: protocol.NewQuicError(protocol.QUIC_TOO_MANY_OPEN_STREAMS, "")})
+		} else {
+			s.acceptQueue = append(s.acceptQueue, c)
+		}
+	}
+	end := frame.GetByteOffset() + protocol.QuicByteOffset(len(frame.GetData()))
+	if end > c.receiveWindow {
+		s.connectionError(protocol.QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "stream flow control window exceeded")
+		return
+	}
+	if end > c.highestReceived {
+		s.bytesReceived += protocol.QuicByteCount(end - c.highestReceived)
+		c.highestReceived = end
+		if s.bytesReceived > s.receiveWindow {
+			s.connectionError(protocol.QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "connection flow control window exceeded")
+			return
+		}
+	}
+	if (c.err != nil) || c.readClosed {
+		// Data of a reset stream or of a stream closed for reading are discarded
+		if n := c.discard(); n > 0 {
+			s.onStreamDataRead(c, n)
+		}
+		return
+	}
+	if err := c.onStreamFrame(frame); err != nil {
+		s.closeWithError(err.(*protocol.QuicError), true)
+		return
+	}
+	s.cond.Broadcast()
+}
+
+// resetStream resets a stream with the error: the data not yet sent are dropped, the data received and not yet read are discarded,
+// and a RST_STREAM frame is sent with the final byte offset of the stream.
+func (s *QUICSession) resetStream(c *StreamConn, err *StreamResetError) {
+	c.abandon(err)
+	if n := c.discard(); n > 0 {
+		s.onStreamDataRead(c, n)
+	}
+	c.rstSent = true
+	s.queueRstStream(c, err.Err.ErrorCode)
+	s.cond.Broadcast()
+}
+
+// closeWrite shuts down the writing side of a stream, a FIN is sent after the data already written.
+func (s *QUICSession) closeWrite(c *StreamConn) {
+	c.writeClosed = true
+	s.signal()
+	s.cond.Broadcast()
+}
+
+// closeRead shuts down the reading side of a stream: the data not yet read are discarded, and the peer is asked to stop sending if its FIN has not been received.
+func (s *QUICSession) closeRead(c *StreamConn) {
+	c.readClosed = true
+	if n := c.discard(); n > 0 {
+		s.onStreamDataRead(c, n)
+	}
+	if c.finSent {
+		s.stopSending(c)
+	}
+	s.cond.Broadcast()
+}
+
+// stopSending sends a RST_STREAM frame with QUIC_NO_ERROR to ask the peer to stop sending on a stream closed for reading,
+// the final byte offset is the FIN already sent on the stream.
+func (s *QUICSession) stopSending(c *StreamConn) {
+	if c.finReceived || c.rstSent || c.rstReceived || (c.err != nil) {
+		return
+	}
+	c.rstSent = true
+	s.queueRstStream(c, protocol.QUIC_NO_ERROR)
+}
+
+// stopWriting aborts the writing side of a stream at the request of the peer: the data not yet sent or not yet acknowledged are abandoned,
+// and a RST_STREAM frame with QUIC_NO_ERROR is sent in return with the final byte offset of the stream.
+func (s *QUICSession) stopWriting(c *StreamConn, err *StreamResetError) {
+	c.writeErr = err
+	c.chunks = nil
+	c.buffered = 0
+	c.rstSent = true
+	s.queueRstStream(c, protocol.QUIC_NO_ERROR)
+}
+
+// queueRstStream queues a RST_STREAM frame with the final byte offset of the stream.
+func (s *QUICSession) queueRstStream(c *StreamConn, code protocol.QuicErrorCode) {
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_RST_STREAM)
+	frame.SetStreamID(c.id)
+	frame.SetByteOffset(c.sendOffset)
+	frame.SetErrorCode(code)
+	s.controlFrames = append(s.controlFrames, frame)
+	s.signal()
+}
+
+// collectStreams forgets the streams finished in both directions once all their frames are acknowledged.
+func (s *QUICSession) collectStreams() {
+	for i := 0; i < len(s.streamIDs); {
+		id := s.streamIDs[i]
+		if s.streams[id].isFinished() && !s.hasStreamFrames(id) {
+			s.removeStream(id)
+			continue
+		}
+		i++
+	}
+}
+
+// hasStreamFrames returns true if a STREAM or RST_STREAM frame of the stream is waiting to be sent or acknowledged.
+func (s *QUICSession) hasStreamFrames(id protocol.QuicStreamID) bool {
+	if hasStreamFrame(s.controlFrames, id) || hasStreamFrame(s.retransmissions, id) || s.sentPackets.HasStreamFrames(id) {
+		return true
+	}
+	for _, d := range s.duplicates {
+		if hasStreamFrame(d.frames, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// onRstStreamFrame processes a RST_STREAM frame: the connection flow control is credited with the final byte offset of the stream,
+// and the stream is reset in return if it has not already been reset on this side.
+func (s *QUICSession) onRstStreamFrame(frame *protocol.QuicFrame) {
+	id := frame.GetStreamID()
+	final := frame.GetByteOffset()
+	c, ok := s.streams[id]
+	if !ok {
+		if (id == cryptoStreamID) || !s.isPeerStream(id) || (id <= s.largestPeerStreamID) || (s.goAway != nil) {
+			// Closed stream
+			return
+		}
+		// Stream reset by the peer before any data was received
+		s.largestPeerStreamID = id
+		c = s.addStream(id)
+	}
+	if c.rstReceived {
+		return
+	}
+	if (final < c.highestReceived) || (c.finReceived && (final != c.finOffset)) {
+		s.connectionError(protocol.QUIC_INVALID_RST_STREAM_DATA, "invalid final byte offset")
+		return
+	}
+	if final > c.receiveWindow {
+		s.connectionError(protocol.QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "stream flow control window exceeded")
+		return
+	}
+	s.bytesReceived += protocol.QuicByteCount(final - c.highestReceived)
+	c.highestReceived = final
+	if s.bytesReceived > s.receiveWindow {
+		s.connectionError(protocol.QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "connection flow control window exceeded")
+		return
+	}
+	c.rstReceived = true
+	if frame.GetErrorCode() == protocol.QUIC_NO_ERROR {
+		// The peer has closed its reading side after its writing side: the final byte offset is the FIN of the peer, and the writing side is aborted
+		c.finReceived = true
+		c.finOffset = final
+		if c.readClosed {
+			if n := c.discard(); n > 0 {
+				s.onStreamDataRead(c, n)
+			}
+		}
+		if !c.rstSent {
+			s.stopWriting(c, &StreamResetError{StreamID: id, Err: frame.GetQuicError()})
+		}
+		s.cond.Broadcast()
+		return
+	}
+	if c.rstSent {
+		// The data received after the RST_STREAM frame sent are discarded
+		if n := c.discard(); n > 0 {
+			s.onStreamDataRead(c, n)
+		}
+		return
+	}
+	s.resetStream(c, &StreamResetError{StreamID: id, Err: frame.GetQuicError()})
+}
+
+// onWindowUpdateFrame increases the flow control send window of a stream, or of the session for the Stream ID 0.
+func (s *QUICSession) onWindowUpdateFrame(frame *protocol.QuicFrame) {
+	offset := frame.GetByteOffset()
+	if id := frame.GetStreamID(); id == 0 {
+		if protocol.QuicByteCount(offset) > s.sendWindow {
+			s.sendWindow = protocol.QuicByteCount(offset)
+		}
+	} else if id == cryptoStreamID {
+		if offset > s.crypto.sendWindow {
+			s.crypto.sendWindow = offset
+		}
+	} else if c, ok := s.streams[id]; ok && (offset > c.sendWindow) {
+		c.sendWindow = offset
+	}
+}
+
+// onGoAwayFrame processes a GOAWAY frame: no new stream can be created and the streams initiated after the last stream accepted by the peer are abandoned.
+func (s *QUICSession) onGoAwayFrame(frame *protocol.QuicFrame) {
+	if s.peerGoAway != nil {
+		return
+	}
+	s.peerGoAway = frame.GetQuicError()
+	last := frame.GetStreamID()
+	for _, id := range append([]protocol.QuicStreamID(nil), s.streamIDs...) {
+		if !s.isPeerStream(id) && (id > last) {
+			s.streams[id].abandon(s.peerGoAway)
+			s.removeStream(id)
+		}
+	}
+	s.cond.Broadcast()
+}
+
+// isDrained returns true if the session can be closed after the GOAWAY frame sent: all the streams are finished and all the frames are sent and acknowledged.
+func (s *QUICSession) isDrained() bool {
+	if (s.goAway == nil) || (len(s.controlFrames) > 0) || (len(s.retransmissions) > 0) || (len(s.duplicates) > 0) || (s.fecGroup != nil) || (s.sentPackets.GetBytesInFlight() > 0) {
+		return false
+	}
+	for _, c := range s.streams {
+		if !c.isFinished() {
+			return false
+		}
+	}
+	return true
+}
+
+// onStreamDataRead updates the flow control receive windows after 'n' bytes are read on a stream, WINDOW_UPDATE frames are sent when half of a window is consumed.
+func (s *QUICSession) onStreamDataRead(c *StreamConn, n int) {
+	if readOffset := c.received.GetReadOffset(); c.receiveWindow-readOffset < c.receiveWindowSize/2 {
+		c.receiveWindow = readOffset + c.receiveWindowSize
+		s.queueWindowUpdate(c.id, c.receiveWindow)
+	}
+	s.bytesRead += protocol.QuicByteCount(n)
+	if s.receiveWindow-s.bytesRead < s.receiveWindowSize/2 {
+		s.receiveWindow = s.bytesRead + s.receiveWindowSize
+		s.queueWindowUpdate(0, protocol.QuicByteOffset(s.receiveWindow))
+	}
+}
+
+// queueWindowUpdate queues a WINDOW_UPDATE frame.
+func (s *QUICSession) queueWindowUpdate(id protocol.QuicStreamID, offset protocol.QuicByteOffset) {
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_WINDOW_UPDATE)
+	frame.SetStreamID(id)
+	frame.SetByteOffset(offset)
+	s.controlFrames = append(s.controlFrames, frame)
+	s.signal()
+}
+
+// getFECTimeout returns the maximum duration of an open FEC group on sender side.
+func (s *QUICSession) getFECTimeout() time.Duration {
+	if s.fecTimeout > 0 {
+		return s.fecTimeout
+	}
+	return s.rttStats.GetSmoothedRTT() / 2
+}
+
+// getFECAlarm returns the time at which the open FEC group must be closed, or zero time.
+func (s *QUICSession) getFECAlarm() time.Time {
+	if s.fecGroup == nil {
+		return time.Time{}
+	}
+	return s.fecGroup.openTime.Add(s.getFECTimeout())
+}
+
+// isFECDue returns true if the FEC packet of the open FEC group must be sent.
+func (s *QUICSession) isFECDue(now time.Time) bool {
+	return (s.fecGroup != nil) && ((s.fecGroup.count >= s.fecGroupSize) || !now.Before(s.getFECAlarm()))
+}
+
+// hasDataToSend returns true if retransmissions, control frames, handshake messages or stream data in the write mode are waiting to be sent.
+func (s *QUICSession) hasDataToSend(mode writeMode) bool {
+	if (mode == writeStandard) && ((len(s.retransmissions) > 0) || (len(s.controlFrames) > 0) || s.crypto.hasDataToSend(mode, MaxPacketSize)) {
+		return true
+	}
+	for _, id := range s.streamIDs {
+		if s.streams[id].hasDataToSend(mode, s.sendWindow-s.bytesSent) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxPayloadSize returns the maximum size of the frames of a packet with the private header.
+func (s *QUICSession) maxPayloadSize(privateHeader *protocol.QuicPrivateHeader) int {
+	return MaxPacketSize - 1 - connectionIDSize - sequenceNumberSize - s.aead.GetMacSize() - privateHeader.GetSerializedSize()
+}
+
+// sendPackets sends the packets allowed by the congestion controller and the pacer.
+//
+// The FEC packet of the open FEC group is sent first, then the copies of the duplicate packets, the duplicate packets,
+// the standard packets and finally the packets protected by FEC.
+// ACK frames are sent alone when they are due and no other packet can be sent.
+func (s *QUICSession) sendPackets(now time.Time) {
+	s.sendAlarm = time.Time{}
+	for !s.closed {
+		bytesInFlight := s.sentPackets.GetBytesInFlight()
+		fecDue := s.isFECDue(now)
+		copyDue := s.isDuplicateDue(now)
+		canSend := s.sendAlgorithm.CanSend(bytesInFlight)
+		duplicate := canSend && s.hasDataToSend(writeDuplicate)
+		standard := canSend && !duplicate && s.hasDataToSend(writeStandard)
+		protected := canSend && !duplicate && !standard && s.hasDataToSend(writeFEC)
+		if fecDue || copyDue || duplicate || standard || protected {
+			if delay := s.writer.TimeUntilSend(now, bytesInFlight); delay > 0 {
+				s.sendAlarm = now.Add(delay)
+				fecDue, copyDue, duplicate, standard, protected = false, false, false, false, false
+			}
+		}
+		switch {
+		case fecDue:
+			s.sendFECPacket(now)
+		case copyDue:
+			s.sendDuplicateCopy(now)
+		case duplicate:
+			if !s.sendDuplicatePacket(now) {
+				return
+			}
+		case standard:
+			if !s.sendStandardPacket(now) {
+				return
+			}
+		case protected:
+			if !s.sendProtectedPacket(now) {
+				return
+			}
+		case s.receivedPackets.IsAckDue(now):
+			s.writeFrames(now, append(s.getAckFrames(now), s.getStopWaitingFrames()...), nil)
+		default:
+			return
+		}
+		now = time.Now()
+	}
+}
+
+// getAckFrames returns the ACK frame to send at 'now', preceded by the CONGESTION_FEEDBACK frame negotiated in the handshake
+// so that the congestion controller of the peer receives the feedback before the acknowledgement of the packets.
+func (s *QUICSession) getAckFrames(now time.Time) (frames []*protocol.QuicFrame) {
+	var feedback *protocol.QuicFrame
+	switch s.congestionFeedback {
+	case protocol.TagQBIC:
+		feedback = s.receivedPackets.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_TCP, s.receiveWindow-s.bytesReceived)
+	case protocol.TagINAR:
+		feedback = s.receivedPackets.GetCongestionFeedbackFrame(protocol.QUICCONGESTIONFEEDBACK_INTERARRIVAL, 0)
+	}
+	if feedback != nil {
+		frames = append(frames, feedback)
+	}
+	return append(frames, s.receivedPackets.GetAckFrame(now))
+}
+
+// getStopWaitingFrames returns the STOP_WAITING frame to send in the next packet if the least unacked packet advanced since the last one acknowledged,
+// so that the peer stops reporting as missing the packets that are acknowledged or declared lost.
+func (s *QUICSession) getStopWaitingFrames() []*protocol.QuicFrame {
+	if !s.sentPackets.IsStopWaitingDue() {
+		return nil
+	}
+	frame, err := s.sentPackets.GetStopWaitingFrame(s.sentPackets.GetNextSequenceNumber())
+	if err != nil {
+		return nil
+	}
+	return []*protocol.QuicFrame{frame}
+}
+
+// sendStandardPacket sends a packet with an ACK frame and a STOP_WAITING frame if needed, then the handshake messages, the control frames, the retransmissions and the stream data.
+// It returns false if no retransmittable frame can be sent.
+func (s *QUICSession) sendStandardPacket(now time.Time) bool {
+	var frames, retransmittable []*protocol.QuicFrame
+	var privateHeader protocol.QuicPrivateHeader
+
+	room := s.maxPayloadSize(&privateHeader)
+	if s.receivedPackets.HasAckQueued() {
+		frames = append(frames, s.getAckFrames(now)...)
+	}
+	frames = append(frames, s.getStopWaitingFrames()...)
+	for _, frame := range frames {
+		room -= frame.GetSerializedSize()
+	}
+	add := func(frame *protocol.QuicFrame) {
+		frames = append(frames, frame)
+		retransmittable = append(retransmittable, frame)
+		room -= frame.GetSerializedSize()
+	}
+	// The crypto stream is not subject to the connection flow control
+	for room > 0 {
+		frame := s.crypto.popStreamFrame(writeStandard, room, protocol.QuicByteCount(room))
+		if frame == nil {
+			break
+		}
+		add(frame)
+	}
+	for (len(s.controlFrames) > 0) && (s.controlFrames[0].GetSerializedSize() <= room) {
+		add(s.controlFrames[0])
+		s.controlFrames = s.controlFrames[1:]
+	}
+	retransmission := false
+	for len(s.retransmissions) > 0 {
+		frame := s.retransmissions[0]
+		if s.isAbandoned(frame) {
+			s.retransmissions = s.retransmissions[1:]
+			continue
+		}
+		if frame.GetSerializedSize() > room {
+			if frame = splitStreamFrame(frame, room); frame == nil {
+				break
+			}
+		} else {
+			s.retransmissions = s.retransmissions[1:]
+		}
+		add(frame)
+		retransmission = true
+	}
+	s.addStreamFrames(writeStandard, &room, add)
+	if len(retransmittable) == 0 {
+		return false
+	}
+	if retransmission {
+		s.stats.packetsRetransmitted++
+	}
+	s.writeFrames(now, frames, retransmittable)
+	return true
+}
+
+// sendProtectedPacket sends a packet of stream data protected by FEC, a new FEC group is opened if needed.
+// It returns false if no stream data can be sent.
+func (s *QUICSession) sendProtectedPacket(now time.Time) bool {
+	var frames []*protocol.QuicFrame
+	var privateHeader protocol.QuicPrivateHeader
+
+	privateHeader.SetFecGroupFlag(true)
+	room := s.maxPayloadSize(&privateHeader)
+	s.addStreamFrames(writeFEC, &room, func(frame *protocol.QuicFrame) {
+		frames = append(frames, frame)
+		room -= frame.GetSerializedSize()
+	})
+	if len(frames) == 0 {
+		return false
+	}
+	if s.fecGroup == nil {
+		s.fecGroup = newFECGroup(s.sentPackets.GetNextSequenceNumber(), now)
+	}
+	privateHeader.SetFecGroupNumberOffset(protocol.QuicFecGroupNumberOffset(s.sentPackets.GetNextSequenceNumber() - s.fecGroup.first))
+	payload := serializeFrames(frames)
+	s.fecGroup.update(payload)
+	s.fecGroup.count++
+	s.writePacket(now, &privateHeader, payload, frames, nil)
+	return true
+}
+
+// sendFECPacket sends the FEC packet of the open FEC group and closes the group.
+func (s *QUICSession) sendFECPacket(now time.Time) {
+	var privateHeader protocol.QuicPrivateHeader
+
+	privateHeader.SetFecGroupFlag(true)
+	privateHeader.SetFecPacketFlag(true)
+	privateHeader.SetFecGroupNumberOffset(protocol.QuicFecGroupNumberOffset(s.sentPackets.GetNextSequenceNumber() - s.fecGroup.first))
+	redundancy := s.fecGroup.redundancy
+	s.fecGroup = nil
+	s.writePacket(now, &privateHeader, redundancy, []*protocol.QuicFrame{}, nil)
+}
+
+// addStreamFrames adds the STREAM frames with the data in the write mode of the streams while there is room in the packet.
+func (s *QUICSession) addStreamFrames(mode writeMode, room *int, add func(*protocol.QuicFrame)) {
+	for _, id := range s.streamIDs {
+		c := s.streams[id]
+		for *room > 0 {
+			frame := c.popStreamFrame(mode, *room, s.sendWindow-s.bytesSent)
+			if frame == nil {
+				break
+			}
+			s.bytesSent += protocol.QuicByteCount(len(frame.GetData()))
+			add(frame)
+			if frame.GetFinFlag() && c.readClosed {
+				s.stopSending(c)
+			}
+			// Writers blocked on a full send buffer
+			s.cond.Broadcast()
+		}
+	}
+}
+
+// isAbandoned returns true if the frame is a STREAM frame of a reset stream or of a stream that the peer asked to stop sending, its data are not retransmitted.
+func (s *QUICSession) isAbandoned(frame *protocol.QuicFrame) bool {
+	if frame.GetFrameType() != protocol.QUICFRAMETYPE_STREAM {
+		return false
+	}
+	c, ok := s.streams[frame.GetStreamID()]
+	return ok && ((c.err != nil) || (c.writeErr != nil))
+}
+
+// splitStreamFrame returns the first part (of at most 'maxSize' bytes) of a STREAM frame and keeps the remaining data in the frame, or nil if the frame can't be split.
+func splitStreamFrame(frame *protocol.QuicFrame, maxSize int) *protocol.QuicFrame {
+	if frame.GetFrameType() != protocol.QUICFRAMETYPE_STREAM {
+		return nil
+	}
+	first := new(protocol.QuicFrame)
+	first.SetFrameType(protocol.QUICFRAMETYPE_STREAM)
+	first.SetStreamID(frame.GetStreamID())
+	first.SetByteOffset(frame.GetByteOffset())
+	first.SetData(nil)
+	size := maxSize - first.GetSerializedSize()
+	data := frame.GetData()
+	if (size <= 0) || (size >= len(data)) {
+		return nil
+	}
+	first.SetData(data[:size])
+	frame.SetByteOffset(frame.GetByteOffset() + protocol.QuicByteOffset(size))
+	frame.SetData(data[size:])
+	return first
+}
+
+// serializeFrames returns the serialized frames.
+func serializeFrames(frames []*protocol.QuicFrame) []byte {
+	size := 0
+	for _, frame := range frames {
+		size += frame.GetSerializedSize()
+	}
+	payload := make([]byte, size)
+	n := 0
+	for _, frame := range frames {
+		m, _ := frame.GetSerializedData(payload[n:])
+		n += m
+	}
+	return payload
+}
+
+// writeFrames sends a packet with the frames, 'retransmittable' are the frames to retransmit if the packet is lost.
+// A packet without retransmittable frames is not in flight.
+func (s *QUICSession) writeFrames(now time.Time, frames, retransmittable []*protocol.QuicFrame) {
+	var privateHeader protocol.QuicPrivateHeader
+
+	s.writePacket(now, &privateHeader, serializeFrames(frames), retransmittable, nil)
+}
+
+// writePacket seals and writes a packet on the UDP socket.
+// 'retransmittable' are the frames to retransmit if the packet is lost: the packet is in flight if it is not nil.
+// 'duplicates' is the set of copies of a packet sent with StreamConn.WriteDuplicate, or nil.
+func (s *QUICSession) writePacket(now time.Time, privateHeader *protocol.QuicPrivateHeader, payload []byte, retransmittable []*protocol.QuicFrame, duplicates *duplicateSet) {
+	var publicHeader protocol.QuicPublicHeader
+
+	seqnum, entropy, err := s.sentPackets.GetNewPacket()
+	if err != nil {
+		s.closeWithError(protocol.NewQuicError(protocol.QUIC_INTERNAL_ERROR, err.Error()), false)
+		return
+	}
+	privateHeader.SetEntropyFlag(entropy)
+	publicHeader.SetConnectionID(s.connectionID)
+	publicHeader.SetConnectionIdSize(connectionIDSize)
+	publicHeader.SetSequenceNumber(seqnum)
+	publicHeader.SetSequenceNumberSize(sequenceNumberSize)
+	buffer := make([]byte, MaxPacketSize)
+	n, _ := publicHeader.GetSerializedData(buffer)
+	plaintext := make([]byte, privateHeader.GetSerializedSize()+len(payload))
+	m, _ := privateHeader.GetSerializedData(plaintext)
+	copy(plaintext[m:], payload)
+	l, err := s.aead.Seal(seqnum, buffer[n:], buffer[:n], plaintext)
+	if err != nil {
+		return
+	}
+	inFlight := retransmittable != nil
+	sentTime, err := s.writer.WritePacket(buffer[:n+l], inFlight)
+	if err != nil {
+		return
+	}
+	s.stats.packetsSent++
+	if inFlight && !s.activitySent {
+		// The idle timer is restarted by the first packet in flight after a packet received, not by the retransmissions to an unresponsive peer
+		s.lastActivity = sentTime
+		s.activitySent = true
+	}
+	var pings []chan time.Duration
+	if len(s.pings) > 0 {
+		for _, frame := range retransmittable {
+			if done, ok := s.pings[frame]; ok {
+				pings = append(pings, done)
+			}
+		}
+	}
+	s.sentPackets.OnPacketSent(&sentPacket{
+		seqnum:     seqnum,
+		sentTime:   sentTime,
+		bytes:      protocol.QuicByteCount(n + l),
+		frames:     retransmittable,
+		duplicates: duplicates,
+		pings:      pings,
+		handshake:  hasStreamFrame(retransmittable, cryptoStreamID)}, inFlight)
+}
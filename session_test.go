@@ -0,0 +1,918 @@
+package quic
+
+import "bytes"
+import "context"
+import "errors"
+import "io"
+import "net"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "time"
+import "github.com/romain-jacotin/quic/congestion"
+import "github.com/romain-jacotin/quic/protocol"
+
+// testlossyconn is a packetConn that drops the packets selected by a filter, 'n' is the number of the packet written (starting at 1).
+type testlossyconn struct {
+	packetConn
+	mutex sync.Mutex
+	count int
+	drop  func(n int) bool
+}
+
+func (this *testlossyconn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	this.mutex.Lock()
+	this.count++
+	drop := (this.drop != nil) && this.drop(this.count)
+	this.mutex.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return this.packetConn.WriteTo(b, addr)
+}
+
+// testDialQUIC returns a listener and a client session connected to it on the loopback interface, the client packets sent after the handshake go through a testlossyconn.
+func testDialQUIC(t *testing.T, drop func(n int) bool) (*QUICListener, *QUICSession) {
+	listener, err := ListenQUIC("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenQUIC : %v", err)
+	}
+	addr := listener.Addr()
+	client, err := DialQUIC("udp4", nil, &addr)
+	if err != nil {
+		t.Fatalf("DialQUIC : %v", err)
+	}
+	testHandshake(t, client)
+	client.mutex.Lock()
+	client.writer.conn = &testlossyconn{packetConn: client.writer.conn, drop: drop}
+	client.mutex.Unlock()
+	return listener, client
+}
+
+// testHandshake waits for the end of the handshake of the session, including the ACK frame of the last handshake message.
+func testHandshake(t *testing.T, s *QUICSession) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mutex.Lock()
+		complete := s.handshakeComplete && !s.receivedPackets.IsAckDue(time.Now())
+		s.mutex.Unlock()
+		if complete {
+			return
+		}
+	}
+	t.Fatal("QUICSession : handshake not complete")
+}
+
+// testpattern returns 'size' bytes of test data.
+func testpattern(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	return data
+}
+
+// testAcceptStream accepts the session and the first stream of the client, then reads 'size' bytes.
+func testAcceptStream(t *testing.T, listener *QUICListener, size int) (*QUICSession, *StreamConn, []byte) {
+	listener.SetDeadline(time.Now().Add(2 * time.Second))
+	server, err := listener.AcceptQUIC()
+	if err != nil {
+		t.Fatalf("QUICListener.AcceptQUIC : %v", err)
+	}
+	stream, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("QUICSession.AcceptStream : %v", err)
+	}
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data := make([]byte, size)
+	if _, err = io.ReadFull(stream, data); err != nil {
+		t.Fatalf("StreamConn.Read : %v", err)
+	}
+	return server, stream, data
+}
+
+func Test_QUICSession_Stream(t *testing.T) {
+	// One packet out of 20 is lost
+	listener, client := testDialQUIC(t, func(n int) bool { return n%20 == 0 })
+	defer listener.Close()
+	defer client.Close()
+
+	stream, err := client.NewStream()
+	if err != nil {
+		t.Fatalf("QUICSession.NewStream : %v", err)
+	}
+	if id := stream.StreamID(); id != 3 {
+		t.Errorf("QUICSession.NewStream : invalid Stream ID %v for the first client stream", id)
+	}
+	sent := testpattern(500 * 1024)
+	go stream.Write(sent)
+	server, serverStream, received := testAcceptStream(t, listener, len(sent))
+	if !bytes.Equal(sent, received) {
+		t.Error("StreamConn.Read : received data are different from sent data")
+	}
+	client.mutex.Lock()
+	if client.stats.packetsRetransmitted == 0 {
+		t.Error("QUICSession : lost packets must be retransmitted")
+	}
+	client.mutex.Unlock()
+	// Answer on the same stream
+	if _, err = serverStream.Write([]byte("pong")); err != nil {
+		t.Fatalf("StreamConn.Write : %v", err)
+	}
+	answer := make([]byte, 4)
+	stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = io.ReadFull(stream, answer); (err != nil) || (string(answer) != "pong") {
+		t.Errorf("StreamConn.Read : invalid answer %q (%v)", answer, err)
+	}
+	// Read deadline
+	stream.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err = stream.Read(answer); err == nil {
+		t.Error("StreamConn.Read : must return an error after the read deadline")
+	} else if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("StreamConn.Read : invalid error %v after the read deadline", err)
+	}
+	// Close
+	client.Close()
+	serverStream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = serverStream.Read(answer); err == nil {
+		t.Error("StreamConn.Read : must return an error when the session is closed by the peer")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Error("StreamConn.Read : CONNECTION_CLOSE frame not received")
+	} else if e := (*protocol.QuicError)(nil); !errors.Is(err, protocol.QUIC_NO_ERROR) || !errors.As(err, &e) || !e.Remote {
+		t.Errorf("StreamConn.Read : invalid error %v after the CONNECTION_CLOSE frame", err)
+	}
+	if _, err = server.NewStream(); !errors.Is(err, protocol.QUIC_NO_ERROR) {
+		t.Errorf("QUICSession.NewStream : invalid error %v on a closed session", err)
+	}
+	if _, err = stream.Write(answer); !errors.Is(err, protocol.QUIC_NO_ERROR) {
+		t.Errorf("StreamConn.Write : invalid error %v on a closed session", err)
+	}
+}
+
+// testSendAlgorithm returns the result of 'check' on the congestion control algorithm of the session, the one that processes the ACK frames must be the same.
+func testSendAlgorithm(t *testing.T, s *QUICSession, check func(congestion.SendAlgorithm) bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sentPackets.sendAlgorithm != s.sendAlgorithm {
+		t.Error("QUICSession : the sent packet manager doesn't use the congestion control of the session")
+	}
+	return check(s.sendAlgorithm)
+}
+
+func Test_QUICSession_ConnectionOptions(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		options []protocol.MessageTag
+		check   func(congestion.SendAlgorithm) bool
+	}{
+		{"NewReno", []protocol.MessageTag{protocol.TagRENO}, func(a congestion.SendAlgorithm) bool { _, ok := a.(*congestion.RenoSender); return ok }},
+		{"BBR", []protocol.MessageTag{protocol.TagTBBR}, func(a congestion.SendAlgorithm) bool {
+			b, ok := a.(*congestion.BbrSender)
+			return ok && (b.GetBandwidthEstimate() > 0)
+		}},
+		{"CUBIC", []protocol.MessageTag{protocol.TagQBIC}, func(a congestion.SendAlgorithm) bool { _, ok := a.(*congestion.CubicSender); return ok }},
+	} {
+		listener, err := ListenQUIC("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("ListenQUIC : %v", err)
+		}
+		addr := listener.Addr()
+		client, err := DialQUICConfig("udp4", nil, &addr, &Config{ConnectionOptions: test.options})
+		if err != nil {
+			t.Fatalf("DialQUICConfig : %v", err)
+		}
+		testHandshake(t, client)
+		stream, err := client.NewStream()
+		if err != nil {
+			t.Fatalf("QUICSession.NewStream : %v", err)
+		}
+		sent := testpattern(100 * 1024)
+		go stream.Write(sent)
+		server, _, received := testAcceptStream(t, listener, len(sent))
+		if !bytes.Equal(sent, received) {
+			t.Errorf("StreamConn.Read : received data are different from sent data with %v", test.name)
+		}
+		// The client applies its connection options, the server applies the ones of the client CHLO (BBR must have bandwidth samples of the transfer)
+		if !testSendAlgorithm(t, client, test.check) {
+			t.Errorf("DialQUICConfig : the client session must use %v", test.name)
+		}
+		if !testSendAlgorithm(t, server, test.check) {
+			t.Errorf("ListenQUIC : the server session must use %v of the client connection options", test.name)
+		}
+		client.Close()
+		listener.Close()
+	}
+	// The connection options of the listener apply when the client doesn't send any
+	listener, err := ListenQUICConfig("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{ConnectionOptions: []protocol.MessageTag{protocol.TagRENO}})
+	if err != nil {
+		t.Fatalf("ListenQUICConfig : %v", err)
+	}
+	defer listener.Close()
+	addr := listener.Addr()
+	client, err := DialQUIC("udp4", nil, &addr)
+	if err != nil {
+		t.Fatalf("DialQUIC : %v", err)
+	}
+	defer client.Close()
+	testHandshake(t, client)
+	listener.SetDeadline(time.Now().Add(2 * time.Second))
+	server, err := listener.AcceptQUIC()
+	if err != nil {
+		t.Fatalf("QUICListener.AcceptQUIC : %v", err)
+	}
+	if !testSendAlgorithm(t, client, func(a congestion.SendAlgorithm) bool { _, ok := a.(*congestion.CubicSender); return ok }) {
+		t.Error("DialQUIC : the client session must use CUBIC by default")
+	}
+	if !testSendAlgorithm(t, server, func(a congestion.SendAlgorithm) bool { _, ok := a.(*congestion.RenoSender); return ok }) {
+		t.Error("ListenQUICConfig : the server session must use the connection options of the listener")
+	}
+}
+
+func Test_StreamConn_WriteFEC(t *testing.T) {
+	// The second protected packet of the FEC group is lost
+	listener, client := testDialQUIC(t, func(n int) bool { return n == 2 })
+	defer listener.Close()
+	defer client.Close()
+
+	if err := client.SetFECGroupSize(0); err == nil {
+		t.Error("QUICSession.SetFECGroupSize : must return an error for an invalid group size")
+	}
+	client.SetFECGroupSize(4)
+	// The RTT measured during the handshake on the loopback interface is too small for the default FEC timeout
+	client.SetFECTimeout(100 * time.Millisecond)
+	stream, _ := client.NewStream()
+	sent := testpattern(4 * 1300)
+	start := time.Now()
+	if _, err := stream.WriteFEC(sent); err != nil {
+		t.Fatalf("StreamConn.WriteFEC : %v", err)
+	}
+	server, _, received := testAcceptStream(t, listener, len(sent))
+	if !bytes.Equal(sent, received) {
+		t.Error("StreamConn.WriteFEC : received data are different from sent data")
+	}
+	if elapsed := time.Since(start); elapsed >= defaultRetransmissionTime {
+		t.Errorf("StreamConn.WriteFEC : data received after %v, the lost packet must be revived", elapsed)
+	}
+	server.mutex.Lock()
+	if server.stats.packetsRevived != 1 {
+		t.Errorf("QUICSession : %v packets revived (1 expected)", server.stats.packetsRevived)
+	}
+	server.mutex.Unlock()
+	// The revived packet is reported in the ACK frame and must not be retransmitted
+	time.Sleep(100 * time.Millisecond)
+	client.mutex.Lock()
+	if client.stats.packetsRetransmitted != 0 {
+		t.Errorf("QUICSession : %v packets retransmitted (0 expected)", client.stats.packetsRetransmitted)
+	}
+	if inflight := client.sentPackets.GetBytesInFlight(); inflight != 0 {
+		t.Errorf("QUICSession : %v bytes in flight after the ACK frames", inflight)
+	}
+	client.mutex.Unlock()
+}
+
+func Test_QUICSession_SetFECTimeout(t *testing.T) {
+	// The only protected packet of the FEC group is lost
+	listener, client := testDialQUIC(t, func(n int) bool { return n == 1 })
+	defer listener.Close()
+	defer client.Close()
+
+	client.SetFECTimeout(20 * time.Millisecond)
+	stream, _ := client.NewStream()
+	sent := testpattern(1000)
+	start := time.Now()
+	stream.WriteFEC(sent)
+	server, _, received := testAcceptStream(t, listener, len(sent))
+	if !bytes.Equal(sent, received) {
+		t.Error("StreamConn.WriteFEC : received data are different from sent data")
+	}
+	if elapsed := time.Since(start); (elapsed < 20*time.Millisecond) || (elapsed >= defaultRetransmissionTime) {
+		t.Errorf("QUICSession.SetFECTimeout : data received after %v with a FEC timeout of 20ms", elapsed)
+	}
+	server.mutex.Lock()
+	if server.stats.packetsRevived != 1 {
+		t.Errorf("QUICSession : %v packets revived (1 expected)", server.stats.packetsRevived)
+	}
+	server.mutex.Unlock()
+}
+
+func Test_QUICSession_InvalidFECGroupOffset(t *testing.T) {
+	var publicHeader protocol.QuicPublicHeader
+	var privateHeader protocol.QuicPrivateHeader
+
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	s := newQUICSession(&testlossyconn{packetConn: client.conn, drop: func(n int) bool { return true }}, client.laddr, client.raddr, 1, false, nil, nil)
+	defer s.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Protected packet n°3 with an offset of 5 in its FEC group
+	publicHeader.SetConnectionID(1)
+	publicHeader.SetConnectionIdSize(connectionIDSize)
+	publicHeader.SetSequenceNumber(3)
+	publicHeader.SetSequenceNumberSize(sequenceNumberSize)
+	privateHeader.SetFecGroupFlag(true)
+	privateHeader.SetFecGroupNumberOffset(5)
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_PING)
+	payload := serializeFrames([]*protocol.QuicFrame{frame})
+	buffer := make([]byte, MaxPacketSize)
+	n, _ := publicHeader.GetSerializedData(buffer)
+	plaintext := make([]byte, privateHeader.GetSerializedSize()+len(payload))
+	m, _ := privateHeader.GetSerializedData(plaintext)
+	copy(plaintext[m:], payload)
+	l, err := s.aead.Seal(3, buffer[n:], buffer[:n], plaintext)
+	if err != nil {
+		t.Fatalf("AEAD.Seal : %v", err)
+	}
+	s.handlePacket(buffer[:n+l], time.Now())
+	if !errors.Is(s.closeErr, protocol.QUIC_INVALID_FEC_DATA) {
+		t.Errorf("QUICSession : invalid error %v for a FEC group before the first packet", s.closeErr)
+	}
+	if len(s.fecGroups) != 0 {
+		t.Errorf("QUICSession : %v FEC groups created for a FEC group before the first packet", len(s.fecGroups))
+	}
+}
+
+func Test_StreamConn_WriteDuplicate(t *testing.T) {
+	// The first two copies of the packet are lost
+	listener, client := testDialQUIC(t, func(n int) bool { return n <= 2 })
+	defer listener.Close()
+	defer client.Close()
+
+	if err := client.SetDuplicateCount(MaxDuplicateCount + 1); err == nil {
+		t.Error("QUICSession.SetDuplicateCount : must return an error for an invalid number of copies")
+	}
+	client.SetDuplicateCount(3)
+	stream, _ := client.NewStream()
+	sent := testpattern(1000)
+	start := time.Now()
+	if _, err := stream.WriteDuplicate(sent); err != nil {
+		t.Fatalf("StreamConn.WriteDuplicate : %v", err)
+	}
+	_, _, received := testAcceptStream(t, listener, len(sent))
+	if !bytes.Equal(sent, received) {
+		t.Error("StreamConn.WriteDuplicate : received data are different from sent data")
+	}
+	if elapsed := time.Since(start); elapsed >= defaultRetransmissionTime {
+		t.Errorf("StreamConn.WriteDuplicate : data received after %v, the last copy must be received", elapsed)
+	}
+	// The lost copies are neither retransmitted nor a congestion signal
+	time.Sleep(100 * time.Millisecond)
+	client.mutex.Lock()
+	if client.stats.packetsDuplicated != 2 {
+		t.Errorf("QUICSession : %v copies sent (2 expected)", client.stats.packetsDuplicated)
+	}
+	if client.stats.packetsRetransmitted != 0 {
+		t.Errorf("QUICSession : %v packets retransmitted (0 expected)", client.stats.packetsRetransmitted)
+	}
+	if inflight := client.sentPackets.GetBytesInFlight(); inflight != 0 {
+		t.Errorf("QUICSession : %v bytes in flight after the ACK frames", inflight)
+	}
+	if client.sendAlgorithm.InRecovery() {
+		t.Error("QUICSession : lost copies must not be a congestion signal")
+	}
+	client.mutex.Unlock()
+}
+
+func Test_StreamConn_WriteDuplicate_Idempotent(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	sent := testpattern(1000)
+	stream.WriteDuplicate(sent)
+	stream.Write([]byte("end"))
+	_, serverStream, received := testAcceptStream(t, listener, len(sent)+3)
+	if !bytes.Equal(sent, received[:len(sent)]) || (string(received[len(sent):]) != "end") {
+		t.Error("StreamConn.WriteDuplicate : received data are different from sent data")
+	}
+	// The data of the other copy are discarded
+	serverStream.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := serverStream.Read(received); n != 0 {
+		t.Errorf("StreamConn.Read : %v duplicate bytes received (%v)", n, err)
+	}
+}
+
+func Test_QUICSession_GoAway(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	stream.Write([]byte("hello"))
+	server, serverStream, _ := testAcceptStream(t, listener, 5)
+	defer server.Close()
+	// Stream created by the client but not yet received by the server
+	unknown, _ := client.NewStream()
+	if err := server.GoAway(protocol.QUIC_PEER_GOING_AWAY, "maintenance"); err != nil {
+		t.Fatalf("QUICSession.GoAway : %v", err)
+	}
+	if err := server.GoAway(protocol.QUIC_PEER_GOING_AWAY, "maintenance"); err == nil {
+		t.Error("QUICSession.GoAway : must return an error when the GOAWAY frame is already sent")
+	}
+	if _, err := server.NewStream(); !errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) {
+		t.Errorf("QUICSession.NewStream : invalid error %v after GoAway", err)
+	}
+	// The peer can't create new streams once the GOAWAY frame is received
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if _, err = client.NewStream(); err != nil {
+			break
+		}
+	}
+	e := (*protocol.QuicError)(nil)
+	if !errors.As(err, &e) || (e.ErrorCode != protocol.QUIC_PEER_GOING_AWAY) || (e.ReasonPhrase != "maintenance") || !e.Remote {
+		t.Fatalf("QUICSession.NewStream : invalid error %v after the GOAWAY frame", err)
+	}
+	// The streams after the last stream accepted by the server are abandoned
+	if _, err = unknown.Write([]byte("lost")); !errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) {
+		t.Errorf("StreamConn.Write : invalid error %v on a stream refused by the GOAWAY frame", err)
+	}
+	// The existing streams are still usable
+	if _, err = stream.Write([]byte("world")); err != nil {
+		t.Fatalf("StreamConn.Write : %v", err)
+	}
+	data := make([]byte, 5)
+	if _, err = io.ReadFull(serverStream, data); (err != nil) || (string(data) != "world") {
+		t.Errorf("StreamConn.Read : invalid data %q (%v) after the GOAWAY frame", data, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	server.mutex.Lock()
+	if server.closed {
+		t.Error("QUICSession.GoAway : the session must not be closed before its streams are finished")
+	}
+	server.mutex.Unlock()
+	// The session is closed once its streams are finished in both directions
+	stream.CloseWrite()
+	serverStream.CloseWrite()
+	stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = stream.Read(data); err != io.EOF {
+		t.Fatalf("StreamConn.Read : invalid error %v after the FIN", err)
+	}
+	if _, err = client.AcceptStream(); !errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) {
+		t.Errorf("QUICSession.AcceptStream : invalid error %v after the drained session is closed", err)
+	}
+}
+
+func Test_QUICListener_Shutdown(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	stream.Write([]byte("hello"))
+	_, serverStream, _ := testAcceptStream(t, listener, 5)
+	// The session is not drained while its stream is open
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("QUICListener.Shutdown : invalid error %v with an open stream", err)
+	}
+	if _, err := client.NewStream(); !errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) {
+		t.Errorf("QUICSession.NewStream : invalid error %v after the listener shutdown", err)
+	}
+	stream.CloseWrite()
+	serverStream.CloseWrite()
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != nil {
+		t.Errorf("QUICListener.Shutdown : %v", err)
+	}
+	listener.mutex.Lock()
+	if n := len(listener.sessions); n != 0 {
+		t.Errorf("QUICListener.Shutdown : %v sessions not closed", n)
+	}
+	listener.mutex.Unlock()
+}
+
+func Test_StreamConn_Reset(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	// More data than the stream flow control window: the end of the data is still queued when the stream is reset
+	sent := testpattern(600 * 1024)
+	written := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(sent)
+		written <- err
+	}()
+	server, serverStream, _ := testAcceptStream(t, listener, 1000)
+	if err := stream.Reset(protocol.QUIC_PEER_GOING_AWAY); err != nil {
+		t.Fatalf("StreamConn.Reset : %v", err)
+	}
+	if err := stream.Reset(protocol.QUIC_PEER_GOING_AWAY); err == nil {
+		t.Error("StreamConn.Reset : must return an error on a stream already reset")
+	}
+	e := (*StreamResetError)(nil)
+	if err := <-written; !errors.As(err, &e) || (e.StreamID != stream.StreamID()) || e.Err.Remote {
+		t.Errorf("StreamConn.Write : invalid error %v on a stream reset", err)
+	}
+	// The peer receives the RST_STREAM frame, the data not yet read are discarded
+	data := make([]byte, len(sent))
+	var err error
+	for err == nil {
+		_, err = serverStream.Read(data)
+	}
+	if !errors.As(err, &e) || !errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) || !e.Err.Remote || (e.StreamID != stream.StreamID()) {
+		t.Fatalf("StreamConn.Read : invalid error %v on a stream reset by the peer", err)
+	}
+	if _, err = serverStream.Write(data[:10]); !errors.Is(err, protocol.QUIC_PEER_GOING_AWAY) {
+		t.Errorf("StreamConn.Write : invalid error %v on a stream reset by the peer", err)
+	}
+	// The connection flow control is credited with the final byte offset on both sides
+	time.Sleep(100 * time.Millisecond)
+	client.mutex.Lock()
+	final, bytesSent, rstReceived := stream.sendOffset, client.bytesSent, stream.rstReceived
+	client.mutex.Unlock()
+	server.mutex.Lock()
+	if (serverStream.highestReceived != final) || (server.bytesReceived != bytesSent) || (server.bytesRead != server.bytesReceived) {
+		t.Errorf("QUICSession : %v bytes received and %v bytes read for %v bytes sent (final byte offset %v)", server.bytesReceived, server.bytesRead, bytesSent, final)
+	}
+	server.mutex.Unlock()
+	if !rstReceived {
+		t.Error("StreamConn.Reset : the peer must reset the stream in return")
+	}
+	// The abandoned data don't consume the connection flow control window
+	other, _ := client.NewStream()
+	go other.Write(sent)
+	next, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("QUICSession.AcceptStream : %v", err)
+	}
+	next.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.ReadFull(next, data); (err != nil) || !bytes.Equal(sent, data) {
+		t.Errorf("StreamConn.Read : invalid data after a stream reset (%v)", err)
+	}
+}
+
+// testStreamCount returns the number of streams of a session not yet garbage collected, after the FIN and RST_STREAM frames are acknowledged.
+func testStreamCount(s *QUICSession) int {
+	time.Sleep(100 * time.Millisecond)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.streams)
+}
+
+func Test_StreamConn_CloseWrite(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	stream.Write([]byte("request"))
+	server, serverStream, data := testAcceptStream(t, listener, 7)
+	if string(data) != "request" {
+		t.Errorf("StreamConn.Read : invalid data %q", data)
+	}
+	// The data are already sent: zero-length FIN
+	time.Sleep(50 * time.Millisecond)
+	if err := stream.CloseWrite(); err != nil {
+		t.Fatalf("StreamConn.CloseWrite : %v", err)
+	}
+	if err := stream.CloseWrite(); err == nil {
+		t.Error("StreamConn.CloseWrite : must return an error on a stream already closed for writing")
+	}
+	if _, err := stream.Write(data); err == nil {
+		t.Error("StreamConn.Write : must return an error on a stream closed for writing")
+	}
+	if n, err := serverStream.Read(data); (n != 0) || (err != io.EOF) {
+		t.Errorf("StreamConn.Read : invalid result %v, %v after the FIN (io.EOF expected)", n, err)
+	}
+	// The other direction is still open
+	serverStream.Write([]byte("response"))
+	serverStream.CloseWrite()
+	stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if received, err := io.ReadAll(stream); (err != nil) || (string(received) != "response") {
+		t.Errorf("StreamConn.Read : invalid data %q (%v) before the FIN", received, err)
+	}
+	// The streams finished in both directions are garbage collected
+	if n := testStreamCount(client); n != 0 {
+		t.Errorf("QUICSession : %v client streams after both directions are finished", n)
+	}
+	if n := testStreamCount(server); n != 0 {
+		t.Errorf("QUICSession : %v server streams after both directions are finished", n)
+	}
+}
+
+func Test_StreamConn_CloseRead(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	// More data than the flow control windows: the client is still sending when the server closes its reading side
+	sent := testpattern(1024 * 1024)
+	written := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(sent)
+		written <- err
+	}()
+	server, serverStream, _ := testAcceptStream(t, listener, 1000)
+	if err := serverStream.CloseRead(); err != nil {
+		t.Fatalf("StreamConn.CloseRead : %v", err)
+	}
+	if _, err := serverStream.Read(sent[:10]); err == nil {
+		t.Error("StreamConn.Read : must return an error on a stream closed for reading")
+	}
+	// The incoming data are discarded without blocking the connection flow control, the peer is asked to stop once the response is sent
+	serverStream.Write([]byte("response"))
+	if err := serverStream.Close(); err != nil {
+		t.Fatalf("StreamConn.Close : %v", err)
+	}
+	if err := serverStream.Close(); err == nil {
+		t.Error("StreamConn.Close : must return an error on a stream already closed")
+	}
+	e := (*StreamResetError)(nil)
+	if err := <-written; !errors.As(err, &e) || !errors.Is(err, protocol.QUIC_NO_ERROR) || !e.Err.Remote {
+		t.Errorf("StreamConn.Write : invalid error %v after the peer stopped reading", err)
+	}
+	stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if received, err := io.ReadAll(stream); (err != nil) || (string(received) != "response") {
+		t.Errorf("StreamConn.Read : invalid data %q (%v) from a peer that stopped reading", received, err)
+	}
+	if n := testStreamCount(client); n != 0 {
+		t.Errorf("QUICSession : %v client streams after both directions are finished", n)
+	}
+	if n := testStreamCount(server); n != 0 {
+		t.Errorf("QUICSession : %v server streams after both directions are finished", n)
+	}
+	server.mutex.Lock()
+	if server.bytesRead != server.bytesReceived {
+		t.Errorf("QUICSession : %v bytes read for %v bytes received, the discarded data must be credited", server.bytesRead, server.bytesReceived)
+	}
+	server.mutex.Unlock()
+}
+
+func Test_QUICSession_IdleTimeout(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		listener, client := testDialQUIC(t, nil)
+
+		if err := client.SetIdleTimeout(0); err == nil {
+			t.Error("QUICSession.SetIdleTimeout : must return an error for an invalid idle timeout")
+		}
+		client.SetSilentClose(silent)
+		stream, _ := client.NewStream()
+		stream.Write([]byte("hello"))
+		server, serverStream, _ := testAcceptStream(t, listener, 5)
+		// The idle timeout proposed by the peer is smaller than the local one
+		client.mutex.Lock()
+		client.setIdleTimeout(1, false)
+		if d := client.getIdleTimeout(); d != time.Second {
+			t.Errorf("QUICSession : negotiated idle timeout %v (1s expected)", d)
+		}
+		client.mutex.Unlock()
+		start := time.Now()
+		stream.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err := stream.Read(make([]byte, 10))
+		if e, ok := err.(net.Error); !ok || !e.Timeout() || !errors.Is(err, protocol.QUIC_CONNECTION_TIMED_OUT) {
+			t.Errorf("StreamConn.Read : invalid error %v after the idle timeout", err)
+		}
+		if elapsed := time.Since(start); (elapsed < 900*time.Millisecond) || (elapsed > 1500*time.Millisecond) {
+			t.Errorf("QUICSession : session closed after %v of inactivity with an idle timeout of 1s", elapsed)
+		}
+		// The peer is notified unless the session is closed silently
+		serverStream.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = serverStream.Read(make([]byte, 10))
+		if silent && errors.Is(err, protocol.QUIC_CONNECTION_TIMED_OUT) {
+			t.Error("QUICSession.SetSilentClose : CONNECTION_CLOSE frame sent on idle timeout")
+		} else if !silent && !errors.Is(err, protocol.QUIC_CONNECTION_TIMED_OUT) {
+			t.Errorf("StreamConn.Read : invalid error %v after the idle timeout of the peer", err)
+		}
+		server.Close()
+		listener.Close()
+	}
+}
+
+func Test_QUICSession_Ping(t *testing.T) {
+	// The client packets are dropped once 'blackhole' is set
+	var blackhole int32
+	listener, client := testDialQUIC(t, func(n int) bool { return atomic.LoadInt32(&blackhole) == 1 })
+	defer listener.Close()
+	defer client.Close()
+
+	stream, _ := client.NewStream()
+	stream.Write([]byte("hello"))
+	server, _, _ := testAcceptStream(t, listener, 5)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rtt, err := client.Ping(ctx)
+	if err != nil {
+		t.Fatalf("QUICSession.Ping : %v", err)
+	}
+	if (rtt <= 0) || (rtt >= 20*time.Millisecond) {
+		t.Errorf("QUICSession.Ping : invalid RTT %v on the loopback interface", rtt)
+	}
+	if rtt, err = server.Ping(ctx); (err != nil) || (rtt <= 0) {
+		t.Errorf("QUICSession.Ping : invalid RTT %v (%v) on server side", rtt, err)
+	}
+	// The PING frame is lost
+	atomic.StoreInt32(&blackhole, 1)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = client.Ping(ctx); err != context.DeadlineExceeded {
+		t.Errorf("QUICSession.Ping : invalid error %v without ACK before the deadline", err)
+	}
+	client.mutex.Lock()
+	if n := len(client.pings); n != 0 {
+		t.Errorf("QUICSession.Ping : %v PING frames still waiting for their ACK", n)
+	}
+	client.mutex.Unlock()
+}
+
+func Test_QUICSession_KeepAlive(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	if err := client.SetKeepAlivePeriod(0); err == nil {
+		t.Error("QUICSession.SetKeepAlivePeriod : must return an error for an invalid period")
+	}
+	client.SetIdleTimeout(time.Second)
+	client.SetKeepAlivePeriod(200 * time.Millisecond)
+	client.SetKeepAlive(true)
+	stream, _ := client.NewStream()
+	stream.Write([]byte("hello"))
+	server, serverStream, _ := testAcceptStream(t, listener, 5)
+	defer server.Close()
+	// The PING frames keep the session alive beyond the idle timeout
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := stream.Write([]byte("world")); err != nil {
+		t.Fatalf("StreamConn.Write : %v after the idle timeout with keep-alive", err)
+	}
+	data := make([]byte, 5)
+	serverStream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(serverStream, data); (err != nil) || (string(data) != "world") {
+		t.Errorf("StreamConn.Read : invalid data %q (%v) after the idle timeout with keep-alive", data, err)
+	}
+	client.mutex.Lock()
+	if client.lastPing.IsZero() {
+		t.Error("QUICSession.SetKeepAlive : no PING frame sent")
+	}
+	client.mutex.Unlock()
+}
+
+func Test_QUICSession_MaxStreams(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	if err := client.SetMaxStreams(0); err == nil {
+		t.Error("QUICSession.SetMaxStreams : must return an error for an invalid maximum")
+	}
+	client.SetMaxStreams(2)
+	first, _ := client.NewStream()
+	if _, err := client.NewStream(); err != nil {
+		t.Fatalf("QUICSession.NewStream : %v", err)
+	}
+	_, err := client.NewStream()
+	e := (*StreamLimitError)(nil)
+	if !errors.As(err, &e) || (e.Limit != 2) || !errors.Is(err, protocol.QUIC_TOO_MANY_OPEN_STREAMS) {
+		t.Errorf("QUICSession.NewStream : invalid error %v when the maximum number of streams is reached", err)
+	}
+	if stats := client.Stats(); (stats.OpenStreams != 2) || (stats.MaxStreams != 2) || (stats.OpenPeerStreams != 0) || (stats.MaxPeerStreams != 2+maxStreamsMinimumIncrement) {
+		t.Errorf("QUICSession.Stats : invalid stream counts %+v", stats)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = client.OpenStream(ctx); err != context.DeadlineExceeded {
+		t.Errorf("QUICSession.OpenStream : invalid error %v when the maximum number of streams is reached", err)
+	}
+	// OpenStream is unblocked once a stream is finished in both directions
+	opened := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err := client.OpenStream(ctx)
+		opened <- err
+	}()
+	first.Write([]byte("hello"))
+	first.CloseWrite()
+	server, serverStream, _ := testAcceptStream(t, listener, 5)
+	defer server.Close()
+	serverStream.CloseWrite()
+	if err = <-opened; err != nil {
+		t.Errorf("QUICSession.OpenStream : %v after a stream is finished", err)
+	}
+	// The streams opened by the peer beyond the maximum are refused
+	server.SetMaxStreams(1)
+	client.SetMaxStreams(100)
+	var streams []*StreamConn
+	for i := 0; i < server.getMaxIncomingStreams()+1; i++ {
+		c, err := client.NewStream()
+		if err != nil {
+			t.Fatalf("QUICSession.NewStream : %v", err)
+		}
+		c.Write([]byte("hello"))
+		streams = append(streams, c)
+	}
+	last := streams[len(streams)-1]
+	last.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = last.Read(make([]byte, 10)); !errors.Is(err, protocol.QUIC_TOO_MANY_OPEN_STREAMS) {
+		t.Errorf("StreamConn.Read : invalid error %v on a stream refused by the peer", err)
+	}
+	if stats := server.Stats(); stats.OpenPeerStreams > server.getMaxIncomingStreams()+1 {
+		t.Errorf("QUICSession.Stats : %v streams opened by the peer", stats.OpenPeerStreams)
+	}
+}
+
+func Test_QUICSession_Handshake(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	listener.SetDeadline(time.Now().Add(2 * time.Second))
+	server, err := listener.AcceptQUIC()
+	if err != nil {
+		t.Fatalf("QUICListener.AcceptQUIC : %v", err)
+	}
+	defer server.Close()
+	testHandshake(t, server)
+	// The parameters of the peer are applied on both sides
+	for _, s := range []*QUICSession{client, server} {
+		s.mutex.Lock()
+		if (s.peerIdleTimeout != DefaultIdleTimeout) || (s.peerMaxStreams != DefaultMaxStreams) || s.peerSilentClose {
+			t.Errorf("QUICSession : invalid parameters of the peer %v %v %v", s.peerIdleTimeout, s.peerMaxStreams, s.peerSilentClose)
+		}
+		if s.congestionFeedback != protocol.TagQBIC {
+			t.Errorf("QUICSession : invalid congestion feedback %v negotiated", s.congestionFeedback)
+		}
+		if s.crypto.hasDataToSend(writeStandard, MaxPacketSize) || s.sentPackets.hasHandshakePackets() {
+			t.Error("QUICSession : handshake messages not acknowledged")
+		}
+		s.mutex.Unlock()
+	}
+
+	// A new handshake message after the handshake is complete, and an invalid handshake message
+	for _, v := range []struct {
+		msg  []byte
+		code protocol.QuicErrorCode
+	}{
+		{[]byte{'C', 'H', 'L', 'O', 0, 0, 0, 0}, protocol.QUIC_CRYPTO_MESSAGE_AFTER_HANDSHAKE_COMPLETE},
+		{[]byte{'C', 'H', 'L', 'O', 2, 0, 0, 0, 'C', 'E', 'T', 'V', 1, 0, 0, 0, 'S', 'N', 'I', 0, 2, 0, 0, 0, 1, 2}, protocol.QUIC_CRYPTO_TAGS_OUT_OF_ORDER},
+	} {
+		s := newQUICSession(&testlossyconn{packetConn: client.conn, drop: func(n int) bool { return true }}, client.laddr, client.raddr, 1, false, nil, nil)
+		s.mutex.Lock()
+		s.handshakeComplete = true
+		frame := new(protocol.QuicFrame)
+		frame.SetFrameType(protocol.QUICFRAMETYPE_STREAM)
+		frame.SetStreamID(cryptoStreamID)
+		frame.SetData(v.msg)
+		s.onCryptoStreamFrame(frame)
+		if !errors.Is(s.closeErr, v.code) {
+			t.Errorf("QUICSession : invalid error %v for an invalid handshake message (%v expected)", s.closeErr, v.code)
+		}
+		s.mutex.Unlock()
+	}
+
+	// Handshake timeouts of a client without server
+	for _, v := range []struct {
+		elapsed time.Duration
+		code    protocol.QuicErrorCode
+	}{
+		{handshakeIdleTimeout, protocol.QUIC_CONNECTION_TIMED_OUT},
+		{handshakeTimeout, protocol.QUIC_CONNECTION_OVERALL_TIMED_OUT},
+	} {
+		s := newQUICSession(&testlossyconn{packetConn: client.conn, drop: func(n int) bool { return true }}, client.laddr, client.raddr, 2, true, nil, nil)
+		s.mutex.Lock()
+		if d := s.getIdleTimeout(); d != handshakeIdleTimeout {
+			t.Errorf("QUICSession : idle timeout %v during the handshake", d)
+		}
+		// The network activity doesn't extend the overall handshake timeout
+		s.lastActivity = s.handshakeStart.Add(v.elapsed - handshakeIdleTimeout)
+		s.onTimer(s.handshakeStart.Add(v.elapsed))
+		if !errors.Is(s.closeErr, v.code) {
+			t.Errorf("QUICSession : invalid error %v after %v of handshake (%v expected)", s.closeErr, v.elapsed, v.code)
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func Test_QUICSession_StopWaiting(t *testing.T) {
+	listener, client := testDialQUIC(t, nil)
+	defer listener.Close()
+	defer client.Close()
+
+	s := newQUICSession(&testlossyconn{packetConn: client.conn, drop: func(n int) bool { return true }}, client.laddr, client.raddr, 1, false, nil, nil)
+	defer s.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_STOP_WAITING)
+	frame.SetLeastUnackedDeltaByteSize(sequenceNumberSize)
+	frame.SetLeastUnackedDelta(2)
+	s.processFrames(5, serializeFrames([]*protocol.QuicFrame{frame}), time.Now())
+	if s.receivedPackets.leastUnacked != 3 {
+		t.Errorf("QUICSession : invalid least unacked packet %v after a STOP_WAITING frame (3 expected)", s.receivedPackets.leastUnacked)
+	}
+	// The least unacked packet can't be before the first packet
+	frame.SetLeastUnackedDelta(6)
+	s.processFrames(6, serializeFrames([]*protocol.QuicFrame{frame}), time.Now())
+	if !errors.Is(s.closeErr, protocol.QUIC_INVALID_STOP_WAITING_DATA) {
+		t.Errorf("QUICSession : invalid error %v for an invalid STOP_WAITING frame", s.closeErr)
+	}
+}
@@ -0,0 +1,230 @@
+package quic
+
+import "errors"
+import "fmt"
+import "time"
+import "github.com/romain-jacotin/quic/protocol"
+
+const (
+	// initialStreamFlowControlWindow is the flow control receive window of a stream in bytes
+	initialStreamFlowControlWindow = 256 * 1024
+	// maxStreamSendBuffer is the number of bytes written on a stream and not yet sent before Write blocks
+	maxStreamSendBuffer = 256 * 1024
+)
+
+// writeMode is the way the data written on a stream are sent.
+type writeMode int
+
+const (
+	// writeStandard data are sent in standard packets
+	writeStandard writeMode = iota
+	// writeFEC data are sent in packets protected by FEC
+	writeFEC
+	// writeDuplicate data are sent in several copies of the same packet
+	writeDuplicate
+)
+
+// streamChunk is a block of data written on a stream and not yet sent.
+type streamChunk struct {
+	data []byte
+	mode writeMode
+}
+
+// timeoutError is the error returned by the blocking calls when their deadline is exceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// StreamResetError is the error returned by Read and Write on a stream reset by a RST_STREAM frame.
+//
+// errors.Is(err, code) returns true if the stream has been reset with the error code 'code'.
+type StreamResetError struct {
+	StreamID protocol.QuicStreamID
+	Err      *protocol.QuicError
+}
+
+func (e *StreamResetError) Error() string {
+	return fmt.Sprintf("stream %d reset : %v", e.StreamID, e.Err)
+}
+
+func (e *StreamResetError) Unwrap() error {
+	return e.Err
+}
+
+// StreamLimitError is the error returned by QUICSession.NewStream when the maximum number of open streams negotiated with the peer is reached.
+//
+// errors.Is(err, protocol.QUIC_TOO_MANY_OPEN_STREAMS) returns true for a StreamLimitError.
+type StreamLimitError struct {
+	Limit int
+}
+
+func (e *StreamLimitError) Error() string {
+	return fmt.Sprintf("too many open streams (maximum %d)", e.Limit)
+}
+
+func (e *StreamLimitError) Temporary() bool {
+	return true
+}
+
+func (e *StreamLimitError) Is(target error) bool {
+	return target == protocol.QUIC_TOO_MANY_OPEN_STREAMS
+}
+
+// newStreamConn is a StreamConn factory.
+func newStreamConn(session *QUICSession, id protocol.QuicStreamID) *StreamConn {
+	return &StreamConn{
+		session:           session,
+		id:                id,
+		sendWindow:        initialStreamFlowControlWindow,
+		received:          protocol.NewReassemblyBuffer(initialStreamFlowControlWindow),
+		receiveWindow:     initialStreamFlowControlWindow,
+		receiveWindowSize: initialStreamFlowControlWindow}
+}
+
+// StreamID returns the Stream ID of the Stream connection.
+func (c *StreamConn) StreamID() protocol.QuicStreamID {
+	return c.id
+}
+
+// hasDataToSend returns true if the stream has data in the write mode (or a FIN) that the flow control allows to send.
+func (c *StreamConn) hasDataToSend(mode writeMode, connectionWindow protocol.QuicByteCount) bool {
+	if len(c.chunks) == 0 {
+		return (mode == writeStandard) && c.writeClosed && !c.finSent && !c.rstSent
+	}
+	return (c.chunks[0].mode == mode) && (c.sendOffset < c.sendWindow) && (connectionWindow > 0)
+}
+
+// popStreamFrame returns a STREAM frame of at most 'maxSize' bytes with the data of the stream in the write mode, or nil if there is nothing to send.
+// 'connectionWindow' is the number of bytes allowed by the connection flow control.
+func (c *StreamConn) popStreamFrame(mode writeMode, maxSize int, connectionWindow protocol.QuicByteCount) *protocol.QuicFrame {
+	if !c.hasDataToSend(mode, connectionWindow) {
+		return nil
+	}
+	frame := new(protocol.QuicFrame)
+	frame.SetFrameType(protocol.QUICFRAMETYPE_STREAM)
+	frame.SetStreamID(c.id)
+	frame.SetByteOffset(c.sendOffset)
+	frame.SetData(nil)
+	size := maxSize - frame.GetSerializedSize()
+	if size < 0 {
+		return nil
+	}
+	if len(c.chunks) > 0 {
+		if size == 0 {
+			return nil
+		}
+		chunk := &c.chunks[0]
+		if size > len(chunk.data) {
+			size = len(chunk.data)
+		}
+		if window := int(c.sendWindow - c.sendOffset); size > window {
+			size = window
+		}
+		if int(connectionWindow) < size {
+			size = int(connectionWindow)
+		}
+		frame.SetData(chunk.data[:size])
+		chunk.data = chunk.data[size:]
+		if len(chunk.data) == 0 {
+			c.chunks = c.chunks[1:]
+		}
+		c.sendOffset += protocol.QuicByteOffset(size)
+		c.buffered -= size
+	}
+	if (len(c.chunks) == 0) && c.writeClosed {
+		frame.SetFinFlag(true)
+		c.finSent = true
+	}
+	return frame
+}
+
+// isFinished returns true if both directions of the stream are finished: a FIN or a RST_STREAM frame has been sent and received.
+func (c *StreamConn) isFinished() bool {
+	return (c.finSent || c.rstSent) && (c.finReceived || c.rstReceived)
+}
+
+// abandon drops the data not yet sent on the stream, the next calls of Read and Write return the error.
+func (c *StreamConn) abandon(err error) {
+	c.err = err
+	c.chunks = nil
+	c.buffered = 0
+}
+
+// discard drops the data received and not yet read, it returns the number of bytes discarded up to the highest offset received.
+func (c *StreamConn) discard() (n int) {
+	n = int(c.highestReceived - c.received.GetReadOffset())
+	c.received.Skip(c.highestReceived)
+	return
+}
+
+// onStreamFrame inserts the data of a STREAM frame in the receive buffer, the data are trimmed of the bytes already received.
+// The error is a flow control violation: the data exceed the receive window of the stream.
+func (c *StreamConn) onStreamFrame(frame *protocol.QuicFrame) error {
+	data := frame.GetData()
+	if frame.GetFinFlag() {
+		c.finReceived = true
+		c.finOffset = frame.GetByteOffset() + protocol.QuicByteOffset(len(data))
+	}
+	return c.received.Insert(frame.GetByteOffset(), data)
+}
+
+// read copies the contiguous data received at the read offset.
+func (c *StreamConn) read(b []byte) int {
+	n, _ := c.received.Read(b)
+	return n
+}
+
+// write appends the data to the send buffer of the stream, it blocks while the send buffer is full.
+func (c *StreamConn) write(b []byte, mode writeMode) (n int, err error) {
+	s := c.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for n < len(b) {
+		if s.closed {
+			return n, s.closeErr
+		}
+		if c.err != nil {
+			return n, c.err
+		}
+		if c.writeErr != nil {
+			return n, c.writeErr
+		}
+		if c.writeClosed {
+			return n, errors.New("StreamConn.Write : write on closed stream")
+		}
+		if !c.writeDeadline.IsZero() && !time.Now().Before(c.writeDeadline) {
+			return n, timeoutError{}
+		}
+		size := maxStreamSendBuffer - c.buffered
+		if size <= 0 {
+			s.cond.Wait()
+			continue
+		}
+		if size > len(b)-n {
+			size = len(b) - n
+		}
+		c.chunks = append(c.chunks, streamChunk{append([]byte(nil), b[n:n+size]...), mode})
+		c.buffered += size
+		n += size
+		s.signal()
+	}
+	return n, nil
+}
+
+// setDeadline sets the deadline pointed by 'deadline' and wakes up the blocked calls when it expires.
+func (c *StreamConn) setDeadline(deadline *time.Time, t time.Time) {
+	s := c.session
+	s.mutex.Lock()
+	*deadline = t
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	if d := time.Until(t); !t.IsZero() && (d > 0) {
+		time.AfterFunc(d, func() {
+			s.mutex.Lock()
+			s.cond.Broadcast()
+			s.mutex.Unlock()
+		})
+	}
+}